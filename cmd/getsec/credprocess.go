@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/client"
+)
+
+// Injectable for tests.
+var (
+	resolveCredentialRefs = resolveRefsWithExpiry
+	credentialTTL         = daemonCacheTTL
+	now                   = time.Now
+)
+
+// awsProcessCredential is the output of an AWS credential_process.
+type awsProcessCredential struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// runAWSCredentialProcess is "getsec aws-credential-process", for
+// credential_process in ~/.aws/config:
+//
+//	credential_process = getsec aws-credential-process --access-key "keepass(&work|/AWS/ci|UserName)" --secret-key "keepass(&work|/AWS/ci)"
+//
+// Long-term keys carry no expiration. With a session token the
+// credentials expire when the daemon's cache of them does (see
+// expiration), so the SDK asks again rather than using a token that
+// has been rotated.
+func runAWSCredentialProcess(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("aws-credential-process", flag.ContinueOnError)
+	accessKey := fs.String("access-key", "", "reference to the access key ID")
+	secretKey := fs.String("secret-key", "", "reference to the secret access key")
+	sessionToken := fs.String("session-token", "", "reference to the session token, for temporary credentials")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *accessKey == "" || *secretKey == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: getsec aws-credential-process --access-key REF --secret-key REF [--session-token REF]")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	values, expires, err := resolveCredentialRefs(ctx, *accessKey, *secretKey, *sessionToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return exitUnresolved
+	}
+	out := awsProcessCredential{
+		Version:         1,
+		AccessKeyID:     values[*accessKey],
+		SecretAccessKey: values[*secretKey],
+	}
+	if *sessionToken != "" {
+		out.SessionToken = values[*sessionToken]
+		exp, err := expiration(ctx, expires)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+			return 1
+		}
+		out.Expiration = exp
+	}
+	if err := json.NewEncoder(stdout).Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+	Token               string `json:"token"`
+}
+
+// runKubeExecCredential is "getsec kube-exec-credential", an exec
+// credential plugin for a kubeconfig user:
+//
+//	exec:
+//	  apiVersion: client.authentication.k8s.io/v1
+//	  command: getsec
+//	  args: [kube-exec-credential, --token, "vault(secret/data/k8s|token)"]
+//	  interactiveMode: IfAvailable
+//
+// The token expires when the daemon's cache of it does (see
+// expiration), so kubectl runs the plugin again instead of holding on
+// to it.
+func runKubeExecCredential(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("kube-exec-credential", flag.ContinueOnError)
+	token := fs.String("token", "", "reference to the bearer token")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if *token == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: getsec kube-exec-credential --token REF")
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	values, expires, err := resolveCredentialRefs(ctx, *token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return exitUnresolved
+	}
+	exp, err := expiration(ctx, expires)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	out := execCredential{
+		APIVersion: "client.authentication.k8s.io/v1",
+		Kind:       "ExecCredential",
+		Status:     execCredentialStatus{ExpirationTimestamp: exp, Token: values[*token]},
+	}
+	if err := json.NewEncoder(stdout).Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// expiration formats expires, the daemon's expiry of the cache entries
+// the values came from (the STS Expiration for awssts), in RFC 3339.
+// Values the daemon does not cache have none; they expire after the
+// daemon's cache TTL from now, or never when it does not expire cached
+// values.
+func expiration(ctx context.Context, expires time.Time) (string, error) {
+	if !expires.IsZero() {
+		return expires.UTC().Format(time.RFC3339), nil
+	}
+	ttl, err := credentialTTL(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ttl <= 0 {
+		return "", nil
+	}
+	return now().Add(ttl).UTC().Format(time.RFC3339), nil
+}
+
+func daemonCacheTTL(ctx context.Context) (time.Duration, error) {
+	st, err := client.EnsureDaemonRunning(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot start or reach daemon: %w", err)
+	}
+	ttl, err := client.CacheTTL(ctx, st)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the daemon's cache TTL: %w", err)
+	}
+	return ttl, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fakeCredentialDaemon(t *testing.T, values map[string]string, expires map[string]time.Time, ttl time.Duration) {
+	t.Helper()
+	oldResolve, oldTTL, oldNow := resolveCredentialRefs, credentialTTL, now
+	t.Cleanup(func() { resolveCredentialRefs, credentialTTL, now = oldResolve, oldTTL, oldNow })
+	resolveCredentialRefs = func(_ context.Context, refs ...string) (map[string]string, time.Time, error) {
+		out := map[string]string{}
+		var earliest time.Time
+		for _, r := range refs {
+			if r != "" {
+				out[r] = values[r]
+				if t, ok := expires[r]; ok && (earliest.IsZero() || t.Before(earliest)) {
+					earliest = t
+				}
+			}
+		}
+		return out, earliest, nil
+	}
+	credentialTTL = func(context.Context) (time.Duration, error) { return ttl, nil }
+	now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+}
+
+func TestAWSCredentialProcess(t *testing.T) {
+	fakeCredentialDaemon(t, map[string]string{"user(ak)": "AKIA1", "user(sk)": "secret", "user(st)": "token"}, nil, 15*time.Minute)
+
+	var out strings.Builder
+	if rc := runAWSCredentialProcess([]string{"--access-key", "user(ak)", "--secret-key", "user(sk)"}, &out); rc != 0 {
+		t.Fatalf("rc=%d", rc)
+	}
+	if want := `{"Version":1,"AccessKeyId":"AKIA1","SecretAccessKey":"secret"}` + "\n"; out.String() != want {
+		t.Fatalf("long-term: got %s", out.String())
+	}
+
+	out.Reset()
+	if rc := runAWSCredentialProcess([]string{"--access-key", "user(ak)", "--secret-key", "user(sk)", "--session-token", "user(st)"}, &out); rc != 0 {
+		t.Fatalf("rc=%d", rc)
+	}
+	var got awsProcessCredential
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SessionToken != "token" || got.Expiration != "2026-01-02T03:19:05Z" {
+		t.Fatalf("temporary: got %+v", got)
+	}
+
+	if rc := runAWSCredentialProcess([]string{"--access-key", "user(ak)"}, &out); rc == 0 {
+		t.Fatal("expected usage error without --secret-key")
+	}
+}
+
+func TestKubeExecCredential(t *testing.T) {
+	fakeCredentialDaemon(t, map[string]string{"vault(k8s|token)": "eyJ"}, nil, time.Hour)
+
+	var out strings.Builder
+	if rc := runKubeExecCredential([]string{"--token", "vault(k8s|token)"}, &out); rc != 0 {
+		t.Fatalf("rc=%d", rc)
+	}
+	want := `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","status":{"expirationTimestamp":"2026-01-02T04:04:05Z","token":"eyJ"}}` + "\n"
+	if out.String() != want {
+		t.Fatalf("got %s\nwant %s", out.String(), want)
+	}
+	if rc := runKubeExecCredential(nil, &out); rc == 0 {
+		t.Fatal("expected usage error without --token")
+	}
+}
+
+func TestCredentialExpiresWithServedEntry(t *testing.T) {
+	sts := "awssts(arn:aws:iam::1:role/ci|%s)"
+	ak, sk, st := fmt.Sprintf(sts, "AccessKeyId"), fmt.Sprintf(sts, "SecretAccessKey"), fmt.Sprintf(sts, "SessionToken")
+	stsExpiry := time.Date(2026, 1, 2, 3, 34, 5, 0, time.UTC)
+	fakeCredentialDaemon(t,
+		map[string]string{ak: "ASIA1", sk: "secret", st: "token", "vault(k8s|token)": "eyJ"},
+		map[string]time.Time{ak: stsExpiry, sk: stsExpiry, st: stsExpiry, "vault(k8s|token)": time.Date(2026, 1, 2, 3, 10, 0, 0, time.UTC)},
+		time.Hour)
+
+	var out strings.Builder
+	if rc := runAWSCredentialProcess([]string{"--access-key", ak, "--secret-key", sk, "--session-token", st}, &out); rc != 0 {
+		t.Fatalf("rc=%d", rc)
+	}
+	var got awsProcessCredential
+	if err := json.Unmarshal([]byte(out.String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Expiration != "2026-01-02T03:34:05Z" {
+		t.Fatalf("Expiration = %q, want the STS expiration", got.Expiration)
+	}
+
+	out.Reset()
+	if rc := runKubeExecCredential([]string{"--token", "vault(k8s|token)"}, &out); rc != 0 {
+		t.Fatalf("rc=%d", rc)
+	}
+	if !strings.Contains(out.String(), `"expirationTimestamp":"2026-01-02T03:10:00Z"`) {
+		t.Fatalf("got %s, want the cache entry's expiry", out.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/gitcred"
+)
+
+// dockerHelperName is the name docker looks for when config.json has
+// "credsStore": "desktopsecrets" or a matching "credHelpers" entry.
+// Installing getsec under this name (a copy or a link) turns it into
+// the helper.
+const dockerHelperName = "docker-credential-desktopsecrets"
+
+// errDockerNotFound is the message docker recognises as "no
+// credentials", as opposed to a helper failure.
+var errDockerNotFound = errors.New("credentials not found in native keychain")
+
+// isDockerHelper reports whether the binary was started as the docker
+// credential helper.
+func isDockerHelper(argv0 string) bool {
+	name := strings.ToLower(filepath.Base(argv0))
+	return strings.TrimSuffix(name, ".exe") == dockerHelperName
+}
+
+type dockerCredential struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// runDockerCredential implements the docker credential-helper
+// protocol from credentials.yaml, matching the registry against the
+// rules as an https URL. Errors are written to stdout, where docker
+// reads them.
+func runDockerCredential(args []string, stdin io.Reader, stdout io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintf(stdout, "usage: %s get|list|store|erase\n", dockerHelperName)
+		return 1
+	}
+	if err := dockerCredentialOp(args[0], stdin, stdout); err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	return 0
+}
+
+func dockerCredentialOp(op string, stdin io.Reader, stdout io.Writer) error {
+	path, err := gitcred.FilePath()
+	if err != nil {
+		return err
+	}
+	rules, err := gitcred.LoadRules(path)
+	if err != nil {
+		return err
+	}
+
+	switch op {
+	case "get":
+		serverURL, err := readServerURL(stdin)
+		if err != nil {
+			return err
+		}
+		rule, ok := findRegistry(rules, serverURL)
+		if !ok {
+			return errDockerNotFound
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+		values, err := resolveRefs(ctx, rule.Username, rule.Password)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rule.Match, err)
+		}
+		return json.NewEncoder(stdout).Encode(dockerCredential{
+			ServerURL: serverURL,
+			Username:  values[rule.Username],
+			Secret:    values[rule.Password],
+		})
+
+	case "list":
+		// Only rules naming a single registry with a literal username
+		// are listed: docker runs list unprompted, so it must not read
+		// references and raise prompts. Secrets are never listed.
+		out := map[string]string{}
+		for _, r := range rules {
+			if u := registryURL(r.Match); u != "" && !isReference(r.Username) {
+				out[u] = strings.TrimSpace(r.Username)
+			}
+		}
+		return json.NewEncoder(stdout).Encode(out)
+
+	case "store":
+		// docker login stores what it logged in with. The providers
+		// are read-only, so that is only accepted for registries the
+		// rules already answer.
+		var c dockerCredential
+		if err := json.NewDecoder(stdin).Decode(&c); err != nil {
+			return fmt.Errorf("invalid credentials: %w", err)
+		}
+		if _, ok := findRegistry(rules, c.ServerURL); !ok {
+			return fmt.Errorf("%s is not mapped in %s; credentials are not stored by %s", c.ServerURL, path, dockerHelperName)
+		}
+		return nil
+
+	case "erase":
+		_, err := readServerURL(stdin)
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", op)
+}
+
+// isReference reports whether s is written as a secret reference,
+// PROVIDER(...), rather than a literal value.
+func isReference(s string) bool {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '(')
+	if open <= 0 || !strings.HasSuffix(s, ")") {
+		return false
+	}
+	for _, c := range s[:open] {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// readServerURL reads the registry docker passes on stdin.
+func readServerURL(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	s := strings.TrimSpace(line)
+	if s == "" {
+		return "", errors.New("no server URL sent")
+	}
+	return s, nil
+}
+
+// findRegistry finds the rule for a docker server URL, which may be a
+// bare host[:port] or a full URL such as https://index.docker.io/v1/.
+func findRegistry(rules []gitcred.Rule, serverURL string) (gitcred.Rule, bool) {
+	req, err := gitcred.ParseURL(serverURL)
+	if err != nil {
+		return gitcred.Rule{}, false
+	}
+	return gitcred.Find(rules, req)
+}
+
+// registryURL is the server URL a rule stands for in docker's list,
+// or "" for rules with wildcards or a scheme docker does not use.
+func registryURL(match string) string {
+	u := strings.TrimSuffix(strings.TrimSpace(match), "/*")
+	if strings.Contains(u, "*") {
+		return ""
+	}
+	scheme, _, ok := strings.Cut(u, "://")
+	if !ok || (scheme != "https" && scheme != "http") {
+		return ""
+	}
+	return u
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/gitcred"
+)
+
+func TestIsDockerHelper(t *testing.T) {
+	for argv0, want := range map[string]bool{
+		"/usr/local/bin/docker-credential-desktopsecrets": true,
+		"docker-credential-desktopsecrets.exe":            true,
+		"/usr/local/bin/getsec":                           false,
+		"docker-credential-desktop":                       false,
+	} {
+		if got := isDockerHelper(filepath.FromSlash(argv0)); got != want {
+			t.Errorf("isDockerHelper(%q) = %v, want %v", argv0, got, want)
+		}
+	}
+}
+
+func TestFindRegistry(t *testing.T) {
+	rules := writeRules(t, "- match: https://registry.corp:5000\n  password: user(a)\n- match: https://index.docker.io/*\n  password: user(b)\n")
+	for url, want := range map[string]string{
+		"registry.corp:5000":          "user(a)",
+		"https://registry.corp:5000":  "user(a)",
+		"https://index.docker.io/v1/": "user(b)",
+		"registry.corp":               "",
+	} {
+		r, _ := findRegistry(rules, url)
+		if r.Password != want {
+			t.Errorf("findRegistry(%q) = %q, want %q", url, r.Password, want)
+		}
+	}
+}
+
+// Operations that need no secret must not start the daemon.
+func TestRunDockerCredentialOffline(t *testing.T) {
+	writeRules(t, "- match: https://registry.corp/*\n  password: user(corp)\n- match: https://*.example.com\n  password: user(x)\n"+
+		"- match: https://ghcr.io\n  username: ci-bot\n  password: user(gh)\n- match: https://quay.io\n  username: keepass(&work|quay|UserName)\n  password: user(q)\n")
+
+	cases := []struct {
+		op, in, want string
+		rc           int
+	}{
+		{"get", "registry.other\n", "credentials not found in native keychain\n", 1},
+		{"store", `{"ServerURL":"registry.corp","Username":"a","Secret":"b"}`, "", 0},
+		{"store", `{"ServerURL":"registry.other","Username":"a","Secret":"b"}`, "registry.other is not mapped", 1},
+		{"erase", "registry.corp\n", "", 0},
+		// A username read from a reference is not listed.
+		{"list", "", "{\"https://ghcr.io\":\"ci-bot\",\"https://registry.corp\":\"\"}\n", 0},
+		{"bogus", "", "unknown operation", 1},
+	}
+	for _, c := range cases {
+		var out strings.Builder
+		rc := runDockerCredential([]string{c.op}, strings.NewReader(c.in), &out)
+		if rc != c.rc || !strings.HasPrefix(out.String(), c.want) || (c.want == "" && out.Len() != 0) {
+			t.Errorf("%s: rc=%d, output %q", c.op, rc, out.String())
+		}
+	}
+}
+
+func writeRules(t *testing.T, yml string) []gitcred.Rule {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(path, []byte(yml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DESKTOP_SECRETS_CREDENTIALS_FILE", path)
+	rules, err := gitcred.LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rules
+}
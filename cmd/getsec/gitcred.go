@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/client"
+	"github.com/it-atelier-gn/desktop-secrets/internal/gitcred"
+)
+
+// runGitCredential is "getsec git-credential get|store|erase", a git
+// credential helper:
+//
+//	git config --global credential.helper "/path/to/getsec git-credential"
+//
+// get answers from the first credentials.yaml rule matching the
+// request. When git names a user, rules for someone else are passed
+// over. The references are resolved by the daemon, so the usual
+// approval applies, shown for the git process that ran the helper.
+// The providers are read-only: store and erase are accepted and
+// ignored. Anything not answered falls through to git's next helper
+// or its prompt.
+func runGitCredential(args []string, stdin io.Reader, stdout io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: getsec git-credential get|store|erase")
+		return 1
+	}
+	req, err := gitcred.ReadRequest(stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	if args[0] != "get" {
+		// store, erase and any operation added later.
+		return 0
+	}
+
+	path, err := gitcred.FilePath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	rules, err := gitcred.LoadRules(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "getsec: %v\n", err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	for _, rule := range gitcred.Matching(rules, req) {
+		var username string
+		refs := []string{rule.Password}
+		if req.Username != "" && rule.Username != "" {
+			// Check whose credential this is before its password is
+			// read: a rule for another user falls through.
+			names, err := resolveRefs(ctx, rule.Username)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "getsec: %s: %v\n", rule.Match, err)
+				return exitUnresolved
+			}
+			if username = names[rule.Username]; !gitcred.UsernameMatches(req, username) {
+				continue
+			}
+		} else {
+			refs = append(refs, rule.Username)
+		}
+		values, err := resolveRefs(ctx, refs...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "getsec: %s: %v\n", rule.Match, err)
+			return exitUnresolved
+		}
+		if username == "" {
+			username = values[rule.Username]
+		}
+		if err := gitcred.WriteCredential(stdout, req, username, values[rule.Password]); err != nil {
+			fmt.Fprintf(os.Stderr, "getsec: %s: %v\n", rule.Match, err)
+			return exitUnresolved
+		}
+		return 0
+	}
+	return 0
+}
+
+// resolveRefs resolves the non-empty refs through the daemon in one
+// round trip. Values that are not references come back unchanged.
+func resolveRefs(ctx context.Context, refs ...string) (map[string]string, error) {
+	values, _, err := resolveRefsWithExpiry(ctx, refs...)
+	return values, err
+}
+
+// resolveRefsWithExpiry is resolveRefs that also returns the earliest
+// expiry of the cache entries the values were served from, or the zero
+// time if none of them was cached.
+func resolveRefsWithExpiry(ctx context.Context, refs ...string) (map[string]string, time.Time, error) {
+	var items []item
+	for _, ref := range refs {
+		if ref != "" {
+			items = append(items, item{ref: ref})
+		}
+	}
+	if len(items) == 0 {
+		return map[string]string{}, time.Time{}, nil
+	}
+	st, err := client.EnsureDaemonRunning(ctx)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("cannot start or reach daemon: %w", err)
+	}
+	values, expires, err := client.ResolveWithExpiry(ctx, st, uniqueRefs(items))
+	var unresolved *client.UnresolvedError
+	if errors.As(err, &unresolved) {
+		return nil, time.Time{}, errors.New(unresolved.Reasons[0])
+	}
+	var earliest time.Time
+	for _, t := range expires {
+		if earliest.IsZero() || t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return values, earliest, err
+}
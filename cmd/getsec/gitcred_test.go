@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Requests the helper does not answer must not start the daemon.
+func TestRunGitCredentialUnanswered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yaml")
+	if err := os.WriteFile(path, []byte("- match: https://git.corp/*\n  password: user(corp)\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DESKTOP_SECRETS_CREDENTIALS_FILE", path)
+
+	cases := []struct {
+		args []string
+		in   string
+	}{
+		{[]string{"store"}, "protocol=https\nhost=git.corp\nusername=a\npassword=b\n"},
+		{[]string{"erase"}, "protocol=https\nhost=git.corp\n"},
+		{[]string{"get"}, "protocol=https\nhost=github.com\n"},
+	}
+	for _, c := range cases {
+		var out strings.Builder
+		if rc := runGitCredential(c.args, strings.NewReader(c.in), &out); rc != 0 || out.Len() != 0 {
+			t.Errorf("%v: rc=%d, output %q", c.args, rc, out.String())
+		}
+	}
+	if rc := runGitCredential(nil, strings.NewReader(""), &strings.Builder{}); rc == 0 {
+		t.Error("expected usage error without an operation")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/env"
+)
+
+// item is one requested secret: "KEY=reference", or a bare reference
+// with an empty key.
+type item struct {
+	key string
+	ref string
+}
+
+// name is how the item is reported in JSON output and diagnostics.
+func (it item) name() string {
+	if it.key != "" {
+		return it.key
+	}
+	return it.ref
+}
+
+// parseItem splits a line into key and reference. Only a valid
+// variable name counts as a key, so references that contain '=' of
+// their own — option lists, attribute filters — stay bare.
+func parseItem(line string) item {
+	if k, v, ok := strings.Cut(line, "="); ok && env.IsValidKey(strings.TrimSpace(k)) {
+		return item{key: strings.TrimSpace(k), ref: strings.TrimSpace(v)}
+	}
+	return item{ref: strings.TrimSpace(line)}
+}
+
+// readItems parses one item per line from r, skipping blank lines and
+// # comments.
+func readItems(r io.Reader) ([]item, error) {
+	var out []item
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, parseItem(line))
+	}
+	return out, sc.Err()
+}
+
+// readItemsFrom reads items from path, or stdin when path is "-".
+func readItemsFrom(path string) ([]item, error) {
+	if path == "-" {
+		return readItems(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readItems(f)
+}
+
+// uniqueRefs returns the distinct references in items, in order.
+func uniqueRefs(items []item) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, it := range items {
+		if !seen[it.ref] {
+			seen[it.ref] = true
+			out = append(out, it.ref)
+		}
+	}
+	return out
+}
+
+// writeLines prints KEY=value for keyed items and the bare value for
+// the rest, in input order. Keyed values are expanded against the
+// caller's environment as getsec always has; failed items become
+// "# name=<unresolved: reason>" comments.
+func writeLines(w io.Writer, items []item, values, failed map[string]string, newline bool) error {
+	var b strings.Builder
+	for i, it := range items {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		v, ok := values[it.ref]
+		switch {
+		case !ok:
+			fmt.Fprintf(&b, "# %s=<unresolved: %s>", it.name(), failed[it.ref])
+		case it.key != "":
+			b.WriteString(it.key + "=" + os.ExpandEnv(v))
+		default:
+			b.WriteString(v)
+		}
+	}
+	if newline && len(items) > 0 {
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeValue prints the value of a single item byte for byte, newlines
+// and surrounding whitespace included, or nothing when it failed.
+func writeValue(w io.Writer, it item, values map[string]string, newline bool) error {
+	v, ok := values[it.ref]
+	if !ok {
+		return nil
+	}
+	if newline {
+		v += "\n"
+	}
+	_, err := io.WriteString(w, v)
+	return err
+}
+
+// writeJSON prints the resolved items as one object keyed by name.
+// Values are not env-expanded. Failed items are listed under "errors"
+// so a consumer can tell which reference failed without parsing
+// stderr.
+func writeJSON(w io.Writer, items []item, values, failed map[string]string, newline bool) error {
+	out := struct {
+		Values map[string]string `json:"values"`
+		Errors map[string]string `json:"errors,omitempty"`
+	}{Values: map[string]string{}}
+	for _, it := range items {
+		if v, ok := values[it.ref]; ok {
+			out.Values[it.name()] = v
+			continue
+		}
+		if out.Errors == nil {
+			out.Errors = map[string]string{}
+		}
+		out.Errors[it.name()] = failed[it.ref]
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if newline {
+		b = append(b, '\n')
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// failedNames lists the names of the items that did not resolve.
+func failedNames(items []item, values map[string]string) []string {
+	var out []string
+	for _, it := range items {
+		if _, ok := values[it.ref]; !ok {
+			out = append(out, it.name())
+		}
+	}
+	return out
+}
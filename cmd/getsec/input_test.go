@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseItem(t *testing.T) {
+	cases := []struct {
+		in   string
+		want item
+	}{
+		{"API=keepass(&work|api)", item{key: "API", ref: "keepass(&work|api)"}},
+		{" API = user(x) ", item{key: "API", ref: "user(x)"}},
+		{"keepass(&work|api)", item{ref: "keepass(&work|api)"}},
+		{"awssts(arn:aws:iam::1:role/x; profile=dev|AccessKeyId)", item{ref: "awssts(arn:aws:iam::1:role/x; profile=dev|AccessKeyId)"}},
+		{"secretservice(service=db|label)", item{ref: "secretservice(service=db|label)"}},
+	}
+	for _, c := range cases {
+		if got := parseItem(c.in); got != c.want {
+			t.Errorf("parseItem(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestReadItems(t *testing.T) {
+	in := "# header\nA=user(a)\n\nuser(b)\nA=user(a)\n"
+	items, err := readItems(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []item{{key: "A", ref: "user(a)"}, {ref: "user(b)"}, {key: "A", ref: "user(a)"}}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("got %+v want %+v", items, want)
+	}
+	if refs := uniqueRefs(items); !reflect.DeepEqual(refs, []string{"user(a)", "user(b)"}) {
+		t.Fatalf("uniqueRefs=%v", refs)
+	}
+}
+
+func TestWriteOutputs(t *testing.T) {
+	items := []item{{key: "A", ref: "user(a)"}, {ref: "user(b)"}, {key: "C", ref: "user(c)"}}
+	values := map[string]string{"user(a)": "x=y", "user(b)": "bare"}
+	failed := map[string]string{"user(c)": "cancelled"}
+
+	var buf bytes.Buffer
+	if err := writeLines(&buf, items, values, failed, false); err != nil {
+		t.Fatal(err)
+	}
+	if want := "A=x=y\nbare\n# C=<unresolved: cancelled>"; buf.String() != want {
+		t.Fatalf("lines=%q want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, items, values, failed, true); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "values": {
+    "A": "x=y",
+    "user(b)": "bare"
+  },
+  "errors": {
+    "C": "cancelled"
+  }
+}
+`
+	if buf.String() != want {
+		t.Fatalf("json=%s", buf.String())
+	}
+
+	if got := failedNames(items, values); !reflect.DeepEqual(got, []string{"C"}) {
+		t.Fatalf("failedNames=%v", got)
+	}
+}
+
+func TestWriteValueVerbatim(t *testing.T) {
+	const pem = "  -----BEGIN KEY-----\nabc def\n-----END KEY-----\n "
+	it := item{ref: "pass(tls/key)"}
+	values := map[string]string{"pass(tls/key)": pem}
+
+	var buf bytes.Buffer
+	if err := writeValue(&buf, it, values, false); err != nil || buf.String() != pem {
+		t.Fatalf("value-only = %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := writeValue(&buf, item{ref: "pass(missing)"}, values, true); err != nil || buf.Len() != 0 {
+		t.Fatalf("failed item printed %q, %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err := writeJSON(&buf, []item{it}, values, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	var out struct{ Values map[string]string }
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil || out.Values["pass(tls/key)"] != pem {
+		t.Fatalf("json = %s, %v", buf.String(), err)
+	}
+}
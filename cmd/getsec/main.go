@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,17 +12,43 @@ import (
 
 	desktopsecrets "github.com/it-atelier-gn/desktop-secrets"
 	"github.com/it-atelier-gn/desktop-secrets/internal/client"
-	"github.com/it-atelier-gn/desktop-secrets/internal/env"
 	"github.com/it-atelier-gn/desktop-secrets/internal/version"
 )
 
+// exitUnresolved is the exit status when one or more references could
+// not be resolved. Errors that prevent resolving anything at all
+// (daemon unreachable, bad flags) exit 1.
+const exitUnresolved = 2
+
 func main() {
 	if desktopsecrets.Init() {
 		return
 	}
 
+	if isDockerHelper(os.Args[0]) {
+		os.Exit(runDockerCredential(os.Args[1:], os.Stdin, os.Stdout))
+	}
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "git-credential":
+			os.Exit(runGitCredential(os.Args[2:], os.Stdin, os.Stdout))
+		case "aws-credential-process":
+			os.Exit(runAWSCredentialProcess(os.Args[2:], os.Stdout))
+		case "kube-exec-credential":
+			os.Exit(runKubeExecCredential(os.Args[2:], os.Stdout))
+		}
+	}
+
 	var versionFlag bool
+	var valueOnly bool
+	var noNewline bool
+	var jsonFlag bool
+	var fromFile string
 	flag.BoolVar(&versionFlag, "version", false, "print version")
+	flag.BoolVar(&valueOnly, "value-only", false, "print only the value of a single reference; KEY= is optional")
+	flag.BoolVar(&noNewline, "n", false, "do not print a trailing newline")
+	flag.BoolVar(&jsonFlag, "json", false, `print {"values": {...}, "errors": {...}} keyed by KEY, or by the reference when it has none`)
+	flag.StringVar(&fromFile, "f", "", "read references from this file, one per line (- for stdin)")
 	flag.Parse()
 
 	if versionFlag {
@@ -29,7 +56,16 @@ func main() {
 		return
 	}
 
-	args := flag.Args()
+	items, err := collectItems(flag.Args(), fromFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if valueOnly && jsonFlag {
+		log.Fatalf("--value-only cannot be combined with --json")
+	}
+	if valueOnly && len(items) != 1 {
+		log.Fatalf("--value-only needs exactly one reference, got %d", len(items))
+	}
 
 	cliCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
@@ -39,21 +75,73 @@ func main() {
 		log.Fatalf("cannot start or reach daemon: %v", err)
 	}
 
-	b := strings.Join(args, "\n")
-
-	out, warnings, err := client.RenderViaDaemon(cliCtx, st, []byte(b))
-	if err != nil {
+	values, err := client.ResolveViaDaemon(cliCtx, st, uniqueRefs(items))
+	failed := map[string]string{}
+	var unresolved *client.UnresolvedError
+	switch {
+	case errors.As(err, &unresolved):
+		for i, ref := range unresolved.Refs {
+			failed[ref] = unresolved.Reasons[i]
+		}
+	case err != nil:
 		log.Fatalf("render failed: %v", err)
 	}
 
-	fmt.Print(string(env.ExpandClientEnvBytes(out)))
+	switch {
+	case valueOnly:
+		err = writeValue(os.Stdout, items[0], values, !noNewline)
+	case jsonFlag:
+		err = writeJSON(os.Stdout, items, values, failed, !noNewline)
+	default:
+		err = writeLines(os.Stdout, items, values, failed, !noNewline)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
 
 	// One or more provider lookups failed (user cancelled, denied,
-	// timed out, etc.). The body already carries diagnostic comments
-	// for each unresolved line — exit non-zero so shell pipelines that
-	// expect every requested secret notice the failure.
-	if warnings > 0 {
-		fmt.Fprintf(os.Stderr, "getsec: %d secret(s) failed to resolve (see comments above)\n", warnings)
-		os.Exit(2)
+	// timed out, etc.). Name each one on stderr and exit non-zero so
+	// shell pipelines that expect every requested secret notice the
+	// failure.
+	if names := failedNames(items, values); len(names) > 0 {
+		for _, it := range items {
+			if reason, ok := failed[it.ref]; ok {
+				fmt.Fprintf(os.Stderr, "getsec: %s: %s\n", it.name(), reason)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "getsec: %d secret(s) failed to resolve\n", len(names))
+		os.Exit(exitUnresolved)
+	}
+}
+
+// collectItems gathers the requested references from the command line
+// and, with -f, from a file or stdin. With neither, references are
+// read from stdin when it is a pipe or file rather than a terminal.
+func collectItems(args []string, fromFile string) ([]item, error) {
+	if len(args) == 0 && fromFile == "" && !stdinIsTerminal() {
+		fromFile = "-"
 	}
+	var items []item
+	for _, a := range args {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		items = append(items, parseItem(a))
+	}
+	if fromFile != "" {
+		more, err := readItemsFrom(fromFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read references: %w", err)
+		}
+		items = append(items, more...)
+	}
+	if len(items) == 0 {
+		return nil, errors.New("no references given")
+	}
+	return items, nil
+}
+
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
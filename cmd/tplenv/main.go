@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
@@ -65,29 +65,35 @@ func main() {
 
 	args := flag.Args()
 
-	cliCtx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
-
-	st, err := client.EnsureDaemonRunning(cliCtx)
-	if err != nil {
-		log.Fatalf("cannot start or reach daemon: %v", err)
-	}
-
-	b, err := client.ReadAndCombineEnvTemplates(".")
-	if err != nil {
-		log.Fatalf("cannot read files: %v", err)
+	if len(args) > 0 && args[0] == "render" {
+		ra, err := parseRenderArgs(args[1:], os.Stderr)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if err := renderTemplate(ra); err != nil {
+			log.Fatalf("render failed: %v", err)
+		}
+		return
 	}
 
-	out, warnings, err := client.RenderViaDaemon(cliCtx, st, []byte(b))
-	if err != nil {
-		log.Fatalf("render failed: %v", err)
-	}
-	if warnings > 0 {
-		fmt.Fprintf(os.Stderr, "tplenv: %d secret(s) failed to resolve (see # <unresolved: ...> comments in output)\n", warnings)
+	// Validate `run` arguments before contacting the daemon so a typo
+	// doesn't cost the user an unlock prompt.
+	var ra runArgs
+	isRun := len(args) > 0 && args[0] == "run"
+	if isRun {
+		var err error
+		ra, err = parseRunArgs(args[1:], os.Stderr)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
 	}
 
-	parsed := env.ExpandClientEnv(env.ParseEnvBytes(out))
-
 	// Apply only/exclude filters
 	var onlyList, excludeList []string
 	if strings.TrimSpace(onlyFlag) != "" {
@@ -96,20 +102,30 @@ func main() {
 	if strings.TrimSpace(excludeFlag) != "" {
 		excludeList = strings.Split(excludeFlag, ",")
 	}
-	parsed = filterEnv(parsed, onlyList, excludeList)
+	r := renderer{only: onlyList, exclude: excludeList, mask: ra.mask}
+
+	res, err := r.render()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if res.warnings > 0 {
+		fmt.Fprintf(os.Stderr, "tplenv: %d secret(s) failed to resolve (see # <unresolved: ...> comments in output)\n", res.warnings)
+	}
+	parsed := res.env
 
 	// If user asked for run
-	if len(args) > 0 && args[0] == "run" {
-		if len(args) < 2 {
-			log.Fatalf("run requires a command to execute")
+	if isRun {
+		if ra.watch {
+			r.reload = true
+			err = watchCommand(ra, r.render, res)
+		} else {
+			ra.opts.Mask = res.secrets
+			err = run.RunCommandWithEnv(ra.cmdLine[0], ra.cmdLine[1:], parsed, ra.opts)
 		}
-		cmdName := args[1]
-		cmdArgs := args[2:]
-		if err := run.RunCommandWithEnv(cmdName, cmdArgs, parsed); err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(interface{ ExitStatus() int }); ok {
-					os.Exit(status.ExitStatus())
-				}
+		if err != nil {
+			var exitErr *run.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.Code)
 			}
 			log.Fatalf("command failed: %v", err)
 		}
@@ -133,3 +149,52 @@ func main() {
 		log.Fatalf("invalid --format value: %s (allowed: env, json)", formatFlag)
 	}
 }
+
+// renderer renders the .env.tpl* files in the working directory through
+// the daemon and applies the --only/--exclude filters.
+type renderer struct {
+	only    []string
+	exclude []string
+	mask    bool
+	// reload has the daemon re-read KeePass databases that changed
+	// since they were unlocked, for --watch.
+	reload bool
+}
+
+type rendered struct {
+	env      map[string]string
+	secrets  []string
+	warnings int
+}
+
+func (r renderer) render() (rendered, error) {
+	var res rendered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	st, err := client.EnsureDaemonRunning(ctx)
+	if err != nil {
+		return res, fmt.Errorf("cannot start or reach daemon: %w", err)
+	}
+
+	b, err := client.ReadAndCombineEnvTemplates(".")
+	if err != nil {
+		return res, fmt.Errorf("cannot read files: %w", err)
+	}
+
+	if r.reload {
+		ctx = client.WithVaultReload(ctx)
+	}
+	out, warnings, err := client.RenderViaDaemon(ctx, st, []byte(b))
+	if err != nil {
+		return res, fmt.Errorf("render failed: %w", err)
+	}
+
+	res.warnings = warnings
+	res.env = filterEnv(env.ExpandClientEnv(env.ParseEnvBytes(out)), r.only, r.exclude)
+	if r.mask {
+		res.secrets = secretValues(env.ParseEnvBytes([]byte(b)), env.ParseEnvBytes(out), res.env)
+	}
+	return res, nil
+}
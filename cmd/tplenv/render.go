@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/client"
+	"github.com/it-atelier-gn/desktop-secrets/internal/shm"
+	"github.com/it-atelier-gn/desktop-secrets/internal/tmpl"
+)
+
+// renderArgs holds what follows `render` on the command line.
+type renderArgs struct {
+	in  string
+	out string
+}
+
+// parseRenderArgs accepts the output flag on either side of the input
+// file, so both `render -o out in.tpl` and `render in.tpl -o out` work.
+func parseRenderArgs(args []string, errOut io.Writer) (renderArgs, error) {
+	var ra renderArgs
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.StringVar(&ra.out, "o", "", "write the result to this file (mode 0600) instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(errOut, "usage: tplenv render [-o out] template|-")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return ra, err
+	}
+	if fs.NArg() == 0 {
+		return ra, errors.New("render requires a template file (or - for stdin)")
+	}
+	ra.in = fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return ra, err
+	}
+	if fs.NArg() > 0 {
+		return ra, fmt.Errorf("unexpected arguments after template: %v", fs.Args())
+	}
+	return ra, nil
+}
+
+// renderTemplate renders ra.in with Go text/template, resolving every
+// `secret "..."` call through the daemon in one batch.
+func renderTemplate(ra renderArgs) error {
+	var src []byte
+	var err error
+	name := ra.in
+	if ra.in == "-" {
+		name = "stdin"
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(ra.in)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read template: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	var st *shm.DaemonState
+	resolve := func(refs []string) (map[string]string, error) {
+		if st == nil {
+			s, err := client.EnsureDaemonRunning(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("cannot start or reach daemon: %w", err)
+			}
+			st = s
+		}
+		return client.ResolveViaDaemon(ctx, st, refs)
+	}
+
+	out, err := tmpl.Render(filepath.Base(name), src, resolve)
+	if err != nil {
+		return err
+	}
+
+	if ra.out == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return writeFileAtomic(ra.out, out)
+}
+
+// writeFileAtomic writes data next to path and renames it into place,
+// so a reader never sees a half-written file and a failed render
+// leaves the previous one intact. The file is created 0600 since it
+// holds secrets.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRenderArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"in.tpl", "-o", "out.yml"},
+		{"-o", "out.yml", "in.tpl"},
+	} {
+		ra, err := parseRenderArgs(args, io.Discard)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if ra.in != "in.tpl" || ra.out != "out.yml" {
+			t.Fatalf("%v: got %+v", args, ra)
+		}
+	}
+	if _, err := parseRenderArgs(nil, io.Discard); err == nil {
+		t.Fatal("expected error without template")
+	}
+	if _, err := parseRenderArgs([]string{"a.tpl", "b.tpl"}, io.Discard); err == nil {
+		t.Fatal("expected error for extra argument")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.yml")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "new" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("temp file left behind: %v", entries)
+	}
+}
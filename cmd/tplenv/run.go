@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/run"
+)
+
+// runArgs holds what follows `run` on the command line.
+type runArgs struct {
+	opts    run.Options
+	mask    bool
+	cmdLine []string
+
+	watch       bool
+	refresh     time.Duration
+	stopSignal  os.Signal
+	stopTimeout time.Duration
+}
+
+// parseRunArgs splits the arguments following `run` into run-specific
+// flags and the command line to execute. Flag parsing stops at the
+// first non-flag argument (or `--`), so the command's own flags pass
+// through untouched.
+func parseRunArgs(args []string, errOut io.Writer) (runArgs, error) {
+	var ra runArgs
+	var stopSignal string
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.BoolVar(&ra.opts.Exec, "exec", false, "replace tplenv with the command instead of supervising it (Unix only)")
+	fs.BoolVar(&ra.opts.StripInherited, "strip-inherited", false, "remove inherited variables that the template redefines instead of appending duplicates")
+	fs.BoolVar(&ra.mask, "mask", false, "replace resolved secret values in the command's stdout/stderr with ***")
+	fs.BoolVar(&ra.watch, "watch", false, "restart the command whenever the rendered environment changes")
+	fs.DurationVar(&ra.refresh, "watch-refresh", time.Minute, "with --watch, how often to re-render even if no watched file changed")
+	fs.StringVar(&stopSignal, "stop-signal", "TERM", "with --watch, signal sent to stop the command before a restart")
+	fs.DurationVar(&ra.stopTimeout, "stop-timeout", 10*time.Second, "with --watch, how long to wait after --stop-signal before killing the command")
+	fs.Usage = func() {
+		fmt.Fprintln(errOut, "usage: tplenv [flags] run [run flags] [--] command [args...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return ra, err
+	}
+	if fs.NArg() == 0 {
+		return ra, errors.New("run requires a command to execute")
+	}
+	if ra.mask && ra.opts.Exec {
+		return ra, errors.New("--mask cannot be combined with --exec: output can't be filtered after tplenv replaces itself")
+	}
+	if ra.watch && ra.opts.Exec {
+		return ra, errors.New("--watch cannot be combined with --exec: tplenv must stay around to restart the command")
+	}
+	if ra.watch && ra.refresh <= 0 {
+		return ra, errors.New("--watch-refresh must be positive")
+	}
+	sig, err := parseSignal(stopSignal)
+	if err != nil {
+		return ra, err
+	}
+	ra.stopSignal = sig
+	ra.cmdLine = fs.Args()
+	return ra, nil
+}
+
+// secretValues returns the values of envMap whose keys the daemon
+// resolved from a provider expression. The daemon echoes literal
+// lines verbatim, so a key whose rendered value differs from its
+// template value came from a provider. envMap holds the final,
+// client-expanded values and decides which keys are still present.
+func secretValues(tpl, rendered, envMap map[string]string) []string {
+	var out []string
+	for k, v := range envMap {
+		r, ok := rendered[k]
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(tpl[k]) == strings.TrimSpace(r) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+var stopSignals = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+}
+
+// parseSignal accepts a signal name with or without the SIG prefix,
+// in any case.
+func parseSignal(name string) (os.Signal, error) {
+	n := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if sig, ok := stopSignals[n]; ok {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported --stop-signal %q (allowed: INT, TERM, HUP, QUIT, KILL)", name)
+}
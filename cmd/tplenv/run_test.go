@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseRunArgs(t *testing.T) {
+	ra, err := parseRunArgs([]string{"--exec", "node", "--inspect", "server.js"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ra.opts.Exec || ra.opts.StripInherited || ra.mask {
+		t.Fatalf("unexpected args %+v", ra)
+	}
+	if want := []string{"node", "--inspect", "server.js"}; !reflect.DeepEqual(ra.cmdLine, want) {
+		t.Fatalf("cmdLine=%v want %v", ra.cmdLine, want)
+	}
+
+	ra, err = parseRunArgs([]string{"--", "--weird-binary"}, io.Discard)
+	if err != nil || len(ra.cmdLine) != 1 || ra.cmdLine[0] != "--weird-binary" {
+		t.Fatalf("cmdLine=%v err=%v", ra.cmdLine, err)
+	}
+
+	if _, err := parseRunArgs([]string{"--strip-inherited"}, io.Discard); err == nil {
+		t.Fatal("expected error when no command given")
+	}
+	if _, err := parseRunArgs([]string{"--mask", "--exec", "sh"}, io.Discard); err == nil {
+		t.Fatal("expected error for --mask with --exec")
+	}
+	if _, err := parseRunArgs([]string{"--watch", "--exec", "sh"}, io.Discard); err == nil {
+		t.Fatal("expected error for --watch with --exec")
+	}
+	if _, err := parseRunArgs([]string{"--stop-signal", "USR9", "sh"}, io.Discard); err == nil {
+		t.Fatal("expected error for unknown --stop-signal")
+	}
+
+	ra, err = parseRunArgs([]string{"--watch", "--watch-refresh", "30s", "--stop-signal", "sigint", "--stop-timeout", "2s", "npm", "start"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ra.watch || ra.refresh != 30*time.Second || ra.stopSignal != syscall.SIGINT || ra.stopTimeout != 2*time.Second {
+		t.Fatalf("unexpected watch args %+v", ra)
+	}
+}
+
+func TestSecretValues(t *testing.T) {
+	tpl := map[string]string{
+		"API_KEY":  "keepass(&work|api)",
+		"LOG":      "debug",
+		"DB_PASS":  " vault(secret/data/db|pw)",
+		"EXCLUDED": "user(x)",
+		"HOME_DIR": "$HOME/app",
+	}
+	rendered := map[string]string{
+		"API_KEY":  "s3cr3t",
+		"LOG":      "debug",
+		"DB_PASS":  "hunter22",
+		"EXCLUDED": "gone",
+		"HOME_DIR": "$HOME/app",
+	}
+	final := map[string]string{
+		"API_KEY":  "s3cr3t",
+		"LOG":      "debug",
+		"DB_PASS":  "hunter22",
+		"HOME_DIR": "/home/u/app",
+	}
+	got := secretValues(tpl, rendered, final)
+	sort.Strings(got)
+	if want := []string{"hunter22", "s3cr3t"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/client"
+	"github.com/it-atelier-gn/desktop-secrets/internal/keepass"
+	"github.com/it-atelier-gn/desktop-secrets/internal/run"
+)
+
+// watchPollInterval is how often the templates and the KeePass
+// databases they reference are checked for changes.
+const watchPollInterval = time.Second
+
+// watchCommand supervises the command for `run --watch`. The template
+// is re-rendered whenever a watched file changes and every ra.refresh
+// regardless, which re-resolves cloud references and, once the daemon's
+// cache has expired, brings up the unlock prompt again. The command is
+// restarted only when the rendered environment actually differs. A
+// render that fails or leaves references unresolved is reported and
+// the running command is kept, so a half-saved template or a dismissed
+// prompt doesn't take a dev server down.
+//
+// The command's own exit ends the watch with its status.
+func watchCommand(ra runArgs, render func() (rendered, error), first rendered) error {
+	// Signals reach the command through run.Process; this only keeps
+	// tplenv alive long enough to collect the exit status.
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	start := func(res rendered) (*run.Process, error) {
+		opts := ra.opts
+		opts.Mask = res.secrets
+		return run.Start(ra.cmdLine[0], ra.cmdLine[1:], res.env, opts)
+	}
+
+	cur := first
+	p, err := start(cur)
+	if err != nil {
+		return err
+	}
+
+	stamps := watchStamps()
+	poll := time.NewTicker(watchPollInterval)
+	defer poll.Stop()
+	refresh := time.NewTicker(ra.refresh)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-p.Done():
+			return p.Wait()
+		case <-interrupted:
+			return p.Wait()
+		case <-poll.C:
+			next := watchStamps()
+			if maps.Equal(next, stamps) {
+				continue
+			}
+			stamps = next
+		case <-refresh.C:
+		}
+		refresh.Reset(ra.refresh)
+
+		res, err := render()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tplenv: %v; keeping the running command\n", err)
+			continue
+		}
+		if res.warnings > 0 {
+			fmt.Fprintf(os.Stderr, "tplenv: %d secret(s) failed to resolve; keeping the running command\n", res.warnings)
+			continue
+		}
+		if maps.Equal(res.env, cur.env) {
+			continue
+		}
+
+		fmt.Fprintln(os.Stderr, "tplenv: environment changed, restarting command")
+		_ = p.Stop(ra.stopSignal, ra.stopTimeout)
+		cur = res
+		if p, err = start(cur); err != nil {
+			return err
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+// watchStamps records the modification time and size of every
+// .env.tpl* file in the working directory and of every KeePass
+// database they reference. Missing files are recorded as absent, so
+// creating or deleting one counts as a change.
+func watchStamps() map[string]fileStamp {
+	files, _ := filepath.Glob(".env.tpl*")
+	if tpl, err := client.ReadAndCombineEnvTemplates("."); err == nil {
+		for _, vault := range keepassVaults(tpl) {
+			if path, err := keepass.VaultFile(vault); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+
+	out := make(map[string]fileStamp, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			out[f] = fileStamp{}
+			continue
+		}
+		out[f] = fileStamp{modTime: fi.ModTime().UnixNano(), size: fi.Size()}
+	}
+	return out
+}
+
+// keepassVaults returns the vault part of every keepass(vault|entry)
+// expression in tpl, nested ones included, with any [nested] master
+// expression stripped: "&alias" or a database path.
+func keepassVaults(tpl string) []string {
+	const prefix = "keepass("
+	var out []string
+	seen := map[string]bool{}
+	lower := strings.ToLower(tpl)
+	for i := 0; ; {
+		j := strings.Index(lower[i:], prefix)
+		if j < 0 {
+			return out
+		}
+		i += j + len(prefix)
+		content := tpl[i:]
+		if end := closingParen(content); end >= 0 {
+			content = content[:end]
+		} else {
+			content, _, _ = strings.Cut(content, "\n")
+		}
+		vault, _, ok := cutTopLevelPipe(content)
+		if !ok {
+			continue
+		}
+		vault, _, _ = strings.Cut(vault, "[")
+		vault = strings.TrimSpace(vault)
+		if vault != "" && !seen[vault] {
+			seen[vault] = true
+			out = append(out, vault)
+		}
+	}
+}
+
+// closingParen returns the index of the ')' that closes an expression
+// whose opening '(' precedes s, or -1.
+func closingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case '\n':
+			return -1
+		}
+	}
+	return -1
+}
+
+// cutTopLevelPipe splits s at the first '|' outside parentheses and
+// brackets, mirroring the daemon's parser.
+func cutTopLevelPipe(s string) (before, after string, ok bool) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		case '|':
+			if depth == 0 {
+				return s[:i], s[i+1:], true
+			}
+		}
+	}
+	return s, "", false
+}
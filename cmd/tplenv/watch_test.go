@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeepassVaults(t *testing.T) {
+	tpl := `# comment keepass(ignored.kdbx) has no pipe
+DB=keepass(&work|db/password)
+API=KeePass(./secrets.kdbx|api)
+NESTED=keepass(&team[keepass(&work|team master)]|deploy)
+AGAIN=keepass(&work|other)
+UNCLOSED=keepass(&open
+OTHER=vault(secret/data/x|y)
+BROKEN=keepass(&half|`
+	got := keepassVaults(tpl)
+	want := []string{"&work", "./secrets.kdbx", "&team", "&half"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestCutTopLevelPipe(t *testing.T) {
+	before, after, ok := cutTopLevelPipe("&v[user(a|b)]|entry|x")
+	if !ok || before != "&v[user(a|b)]" || after != "entry|x" {
+		t.Fatalf("got %q %q %v", before, after, ok)
+	}
+	if _, _, ok := cutTopLevelPipe("no-pipe"); ok {
+		t.Fatal("expected no split")
+	}
+}
@@ -0,0 +1,62 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/run"
+)
+
+func TestWatchCommandRestartsOnChange(t *testing.T) {
+	t.Chdir(t.TempDir())
+	log := filepath.Join(t.TempDir(), "log")
+
+	// The command records each start and exits 7 on its own once it
+	// sees the third environment.
+	script := `echo "$FOO" >> "$LOG"; [ "$FOO" = three ] && exit 7; trap 'exit 0' TERM; while :; do sleep 0.05; done`
+	ra := runArgs{
+		cmdLine:     []string{"sh", "-c", script},
+		refresh:     50 * time.Millisecond,
+		stopSignal:  syscall.SIGTERM,
+		stopTimeout: 5 * time.Second,
+	}
+
+	envs := []map[string]string{
+		{"FOO": "one", "LOG": log},
+		{"FOO": "two", "LOG": log},
+		{"FOO": "two", "LOG": log},
+		{"FOO": "three", "LOG": log},
+	}
+	calls := 0
+	render := func() (rendered, error) {
+		if calls == 1 {
+			calls++
+			return rendered{}, errors.New("daemon unreachable")
+		}
+		i := min(calls, len(envs)-1)
+		calls++
+		return rendered{env: envs[i]}, nil
+	}
+
+	first, _ := render()
+	err := watchCommand(ra, render, first)
+	var exitErr *run.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 7 {
+		t.Fatalf("want exit 7, got %v", err)
+	}
+
+	b, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(b)); strings.Join(got, ",") != "one,two,three" {
+		t.Fatalf("starts=%v", got)
+	}
+}
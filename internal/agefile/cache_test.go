@@ -0,0 +1,127 @@
+package agefile
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestResolveSecretCachesUntilFileChanges(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeFile(t, "s.age", encryptTo(t, "TOKEN=t1\n", false, id.Recipient()))
+
+	m := NewManager(time.Hour)
+	calls := 0
+	m.SetIdentityFile(func() string {
+		calls++
+		return writeFile(t, "keys.txt", []byte(id.String()+"\n"))
+	})
+
+	for i := 0; i < 3; i++ {
+		if got, err := m.ResolveSecret(t.Context(), path, "TOKEN", "", ""); err != nil || got != "t1" {
+			t.Fatalf("call %d: %q, %v", i, got, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 decryption, got %d", calls)
+	}
+
+	if err := os.WriteFile(path, encryptTo(t, "TOKEN=t2\n", false, id.Recipient()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.ResolveSecret(t.Context(), path, "TOKEN", "", ""); err != nil || got != "t2" {
+		t.Fatalf("after change: %q, %v", got, err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected modified file to be decrypted again, got %d decryptions", calls)
+	}
+}
+
+func TestEvictForcesDecryption(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	path := writeFile(t, "s.age", encryptTo(t, "x", false, id.Recipient()))
+
+	m := NewManager(time.Hour)
+	if _, err := m.ResolveSecret(t.Context(), path, "", "", id.String()); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.CachedKeys()) != 1 {
+		t.Fatal("expected one cached entry")
+	}
+	m.Evict(path)
+	if len(m.CachedKeys()) != 0 {
+		t.Fatal("expected entry evicted")
+	}
+}
+
+func TestCacheKeyedByIdentityRef(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	path := writeFile(t, "s.age", encryptTo(t, "x", false, id.Recipient()))
+
+	m := NewManager(time.Hour)
+	if _, err := m.ResolveSecret(t.Context(), path, "", "user(right)", id.String()); err != nil {
+		t.Fatal(err)
+	}
+	// A reference naming another identity must not be served the
+	// plaintext cached for the first one.
+	if _, err := m.ResolveSecret(t.Context(), path, "", "user(wrong)", other.String()); err == nil {
+		t.Fatal("expected a different identity reference to bypass the cache")
+	}
+
+	key := CacheKey(path, "user(right)")
+	if keys := m.CachedKeys(); len(keys) != 1 || keys[0].Key != key {
+		t.Fatalf("CachedKeys = %v, want [%s]", keys, key)
+	}
+	m.Evict(key)
+	if len(m.CachedKeys()) != 0 {
+		t.Fatal("Evict by CacheKey left the entry cached")
+	}
+}
+
+func TestDecryptDoesNotHoldCache(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	path := writeFile(t, "s.age", encryptTo(t, "x", false, id.Recipient()))
+	keys := writeFile(t, "keys.txt", []byte(id.String()+"\n"))
+
+	m := NewManager(time.Hour)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.SetIdentityFile(func() string {
+		close(started)
+		<-release
+		return keys
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.ResolveSecret(context.Background(), path, "", "", "")
+		done <- err
+	}()
+	<-started
+
+	listed := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		m.Evict(path)
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CachedKeys blocked while the file was being decrypted")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
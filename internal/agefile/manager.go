@@ -0,0 +1,304 @@
+package agefile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/env"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+)
+
+type cacheEntry struct {
+	sealed  *memprotect.Sealed
+	expires time.Time
+	modTime time.Time
+}
+
+type Manager struct {
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	ttl   time.Duration
+	// identityFile returns the configured identity file, if any.
+	identityFile func() string
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		cache:        make(map[string]cacheEntry),
+		ttl:          ttl,
+		identityFile: func() string { return "" },
+	}
+}
+
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+// SetIdentityFile sets where the default identities are read from when
+// a reference doesn't supply its own. fn is consulted on every
+// decryption so config changes apply without a restart.
+func (m *Manager) SetIdentityFile(fn func() string) {
+	m.mu.Lock()
+	m.identityFile = fn
+	m.mu.Unlock()
+}
+
+// CacheKey is the key a file is cached and listed under, and the key
+// Evict takes: its absolute path, and the nested reference its identity
+// was resolved from when the reference names one.
+func CacheKey(path, identityRef string) string {
+	key := strings.TrimSpace(path)
+	if abs, err := filepath.Abs(key); err == nil {
+		key = abs
+	}
+	if identityRef = strings.TrimSpace(identityRef); identityRef != "" {
+		key += " (identity " + identityRef + ")"
+	}
+	return key
+}
+
+// ResolveSecret decrypts the age file at path and returns its
+// plaintext, or with field set, one key of a JSON object or dotenv
+// payload. identity, when non-empty, is the value of the nested
+// reference identityRef and replaces the configured identity file:
+// either age identities (AGE-SECRET-KEY-1...) for X25519 recipients
+// or, failing that, a passphrase for scrypt recipients.
+//
+// The plaintext is cached per file and identity reference until the
+// TTL expires or the file is modified. The cache lock is not held
+// while the file is decrypted.
+func (m *Manager) ResolveSecret(ctx context.Context, path, field, identityRef, identity string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", errors.New("empty age path")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	cacheKey := CacheKey(abs, identityRef)
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+
+	m.mu.Lock()
+	raw, ok := m.readCache(ctx, cacheKey, fi.ModTime())
+	m.mu.Unlock()
+	if ok {
+		return selectField(raw, field)
+	}
+
+	ids, err := m.identities(identity)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", fmt.Errorf("age: %w", err)
+	}
+	raw, err = decrypt(data, ids)
+	if err != nil {
+		return "", fmt.Errorf("age: decrypt %s: %w", filepath.Base(abs), err)
+	}
+
+	m.mu.Lock()
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, raw, fi.ModTime()))
+	m.mu.Unlock()
+	return selectField(raw, field)
+}
+
+func (m *Manager) identities(identity string) ([]age.Identity, error) {
+	if strings.TrimSpace(identity) != "" {
+		if ids, err := age.ParseIdentities(strings.NewReader(identity)); err == nil {
+			return ids, nil
+		}
+		// Not an identity file: treat the value as a passphrase.
+		id, err := age.NewScryptIdentity(strings.TrimRight(identity, "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{id}, nil
+	}
+
+	m.mu.Lock()
+	identityFile := m.identityFile
+	m.mu.Unlock()
+	path := strings.TrimSpace(identityFile())
+	if path == "" {
+		return nil, errors.New("no identity: configure age_identity_file or pass one as age(PATH[REFERENCE]|...)")
+	}
+	f, err := os.Open(os.ExpandEnv(path))
+	if err != nil {
+		return nil, fmt.Errorf("identity file: %w", err)
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("identity file: %w", err)
+	}
+	return ids, nil
+}
+
+// decrypt accepts both binary and ASCII-armored age files.
+func decrypt(data []byte, ids []age.Identity) (string, error) {
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return "", err
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// selectField returns raw when field is empty. Otherwise raw is read
+// as a JSON object if it is one, else as dotenv KEY=VALUE lines.
+func selectField(raw, field string) (string, error) {
+	if field == "" {
+		return raw, nil
+	}
+	if trimmed := strings.TrimSpace(raw); strings.HasPrefix(trimmed, "{") {
+		var obj map[string]any
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return "", fmt.Errorf("age: payload is not a JSON object: %w", err)
+		}
+		v, ok := obj[field]
+		if !ok {
+			return "", fmt.Errorf("age: field %q not found", field)
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	if v, ok := dotenv(raw)[field]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("age: field %q not found", field)
+}
+
+// dotenv parses KEY=VALUE lines, dropping an optional "export " prefix
+// and one level of matching quotes around the value.
+func dotenv(raw string) map[string]string {
+	var b strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(raw))
+	for sc.Scan() {
+		b.WriteString(strings.TrimPrefix(strings.TrimSpace(sc.Text()), "export "))
+		b.WriteByte('\n')
+	}
+	out := env.ParseEnvBytes([]byte(b.String()))
+	for k, v := range out {
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// Evict removes a single cache entry by key (see CacheKey).
+func (m *Manager) Evict(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cache[key]; !ok {
+		key = CacheKey(key, "")
+	}
+	if e, ok := m.cache[key]; ok {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+	}
+}
+
+func (m *Manager) EvictAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.cache {
+		e.sealed.Destroy()
+		delete(m.cache, k)
+	}
+}
+
+func (m *Manager) CachedKeys() []cacheinfo.Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]cacheinfo.Entry, 0, len(m.cache))
+	for k, e := range m.cache {
+		if now.Before(e.expires) {
+			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (m *Manager) readCache(ctx context.Context, key string, modTime time.Time) (string, bool) {
+	e, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(e.expires) || !e.modTime.Equal(modTime) {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return "", false
+	}
+	pt, err := e.sealed.OpenString()
+	if err != nil {
+		return "", false
+	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, true
+}
+
+func (m *Manager) storeCache(key, raw string, modTime time.Time) time.Time {
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return time.Time{}
+	}
+	if old, ok := m.cache[key]; ok {
+		old.sealed.Destroy()
+	}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl), modTime: modTime}
+	m.cache[key] = entry
+
+	go func(k string, e cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if cur, ok := m.cache[k]; ok && cur.sealed == e.sealed {
+			delete(m.cache, k)
+		}
+		m.mu.Unlock()
+		e.sealed.Destroy()
+	}(key, entry, m.ttl)
+	return entry.expires
+}
@@ -0,0 +1,127 @@
+package agefile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func encryptTo(t *testing.T, plain string, armored bool, r age.Recipient) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var dst io.Writer = &buf
+	var aw io.WriteCloser
+	if armored {
+		aw = armor.NewWriter(&buf)
+		dst = aw
+	}
+	w, err := age.Encrypt(dst, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if aw != nil {
+		if err := aw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSelectField(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{"whole payload", "line1\nline2\n", "", "line1\nline2\n", false},
+		{"json string", `{"user":"alice","pass":"p"}`, "pass", "p", false},
+		{"json number", `{"port":5432}`, "port", "5432", false},
+		{"json object", `{"db":{"a":1}}`, "db", `{"a":1}`, false},
+		{"json missing", `{"a":"x"}`, "b", "", true},
+		{"dotenv", "# team\nexport API_KEY=\"k 1\"\nDB_PASS='p=w'\nPLAIN=v\n", "DB_PASS", "p=w", false},
+		{"dotenv export", "export API_KEY=\"k 1\"\n", "API_KEY", "k 1", false},
+		{"dotenv missing", "A=1\n", "B", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := selectField(tc.raw, tc.field)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err=%v wantErr=%v", err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveX25519FromIdentityFile(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := writeFile(t, "keys.txt", []byte("# created: now\n"+id.String()+"\n"))
+
+	for _, armored := range []bool{false, true} {
+		path := writeFile(t, "team.env.age", encryptTo(t, "API_KEY=k-1\n", armored, id.Recipient()))
+		m := NewManager(0)
+		m.SetIdentityFile(func() string { return keys })
+		got, err := m.ResolveSecret(t.Context(), path, "API_KEY", "", "")
+		if err != nil || got != "k-1" {
+			t.Fatalf("armored=%v: got %q, %v", armored, got, err)
+		}
+	}
+}
+
+func TestResolveIdentityFromReference(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	path := writeFile(t, "s.age", encryptTo(t, "secret", false, id.Recipient()))
+	m := NewManager(0)
+	if _, err := m.ResolveSecret(t.Context(), path, "", "", ""); err == nil {
+		t.Fatal("expected error without any identity")
+	}
+	got, err := m.ResolveSecret(t.Context(), path, "", "", id.String())
+	if err != nil || got != "secret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestResolveScryptPassphrase(t *testing.T) {
+	r, err := age.NewScryptRecipient("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetWorkFactor(10)
+	path := writeFile(t, "s.age", encryptTo(t, `{"token":"t"}`, true, r))
+
+	m := NewManager(0)
+	if _, err := m.ResolveSecret(t.Context(), path, "token", "", "wrong"); err == nil {
+		t.Fatal("expected wrong passphrase to fail")
+	}
+	got, err := m.ResolveSecret(t.Context(), path, "token", "", "correct horse\n")
+	if err != nil || got != "t" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
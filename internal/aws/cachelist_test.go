@@ -7,8 +7,8 @@ import (
 
 func TestCachedKeysAndEvictAll(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("sm:b", "v1")
-	m.storeCache("ps:a", "v2")
+	m.storeCache("sm:b", "v1", false)
+	m.storeCache("ps:a", "v2", false)
 
 	keys := m.CachedKeys()
 	if len(keys) != 2 {
@@ -29,7 +29,7 @@ func TestCachedKeysAndEvictAll(t *testing.T) {
 
 func TestCachedKeysExcludesExpired(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("sm:x", "v")
+	m.storeCache("sm:x", "v", false)
 	for k, e := range m.cache {
 		e.expires = time.Now().Add(-time.Minute)
 		m.cache[k] = e
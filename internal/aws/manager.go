@@ -2,9 +2,11 @@ package aws
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
@@ -20,21 +23,83 @@ import (
 type cacheEntry struct {
 	sealed  *memprotect.Sealed
 	expires time.Time
+	binary  bool // sealed holds SecretBinary rather than SecretString
+}
+
+// Options select the account, region and secret version a reference
+// reads. Empty fields fall back to the SDK defaults (AWS_PROFILE,
+// AWS_REGION, the shared config files) and to the current version.
+type Options struct {
+	Profile      string
+	Region       string
+	VersionStage string // Secrets Manager only, e.g. AWSPREVIOUS
+	VersionID    string // Secrets Manager only
+}
+
+// CacheKey is the key a lookup of kind "sm" or "ps" is cached and
+// listed under, and the key Evict takes.
+func CacheKey(kind, name string, opt Options) string {
+	key := kind + ":" + name
+	var q []string
+	for _, kv := range [][2]string{
+		{"profile", opt.Profile}, {"region", opt.Region},
+		{"stage", opt.VersionStage}, {"version", opt.VersionID},
+	} {
+		if kv[1] != "" {
+			q = append(q, kv[0]+"="+kv[1])
+		}
+	}
+	if len(q) > 0 {
+		key += " (" + strings.Join(q, ", ") + ")"
+	}
+	return key
+}
+
+type secretsAPI interface {
+	GetSecretValue(ctx context.Context, in *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+type paramsAPI interface {
+	GetParameter(ctx context.Context, in *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+	ssm.GetParametersByPathAPIClient
+}
+
+// clients are the service clients for one (profile, region) pair.
+type clients struct {
+	sm  secretsAPI
+	ps  paramsAPI
+	sts stsAPI
 }
 
 type Manager struct {
-	mu    sync.Mutex
-	cfg   *aws.Config
-	smCli *secretsmanager.Client
-	psCli *ssm.Client
-	cache map[string]cacheEntry
-	ttl   time.Duration
+	mu      sync.Mutex
+	clients map[string]*clients // by profile + "|" + region
+	cache   map[string]cacheEntry
+	ttl     time.Duration
+	// newClients, resolveRef and askCode are injectable for tests.
+	newClients func(ctx context.Context, profile, region string) (*clients, error)
+	resolveRef func(ctx context.Context, value string) (string, error)
+	askCode    func(ctx context.Context, serial string) (string, error)
 }
 
 func NewManager(ttl time.Duration) *Manager {
 	return &Manager{
-		cache: make(map[string]cacheEntry),
-		ttl:   ttl,
+		clients: make(map[string]*clients),
+		cache:   make(map[string]cacheEntry),
+		ttl:     ttl,
+		newClients: func(ctx context.Context, profile, region string) (*clients, error) {
+			cfg, err := LoadConfig(ctx, profile, region)
+			if err != nil {
+				return nil, err
+			}
+			return &clients{
+				sm:  secretsmanager.NewFromConfig(cfg),
+				ps:  ssm.NewFromConfig(cfg),
+				sts: sts.NewFromConfig(cfg),
+			}, nil
+		},
+		resolveRef: func(_ context.Context, v string) (string, error) { return v, nil },
+		askCode:    promptMFACode,
 	}
 }
 
@@ -44,64 +109,101 @@ func (m *Manager) SetTTL(ttl time.Duration) {
 	m.mu.Unlock()
 }
 
-// init lazily loads AWS config and creates clients on first use.
-func (m *Manager) init(ctx context.Context) error {
-	if m.cfg != nil {
-		return nil
+// LoadConfig loads the SDK configuration for profile and region; empty
+// values leave the SDK defaults in place.
+func LoadConfig(ctx context.Context, profile, region string) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
 	}
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		return fmt.Errorf("AWS credentials not configured: %w", err)
+		if profile != "" {
+			return aws.Config{}, fmt.Errorf("AWS profile %q: %w", profile, err)
+		}
+		return aws.Config{}, fmt.Errorf("AWS credentials not configured: %w", err)
 	}
-	m.cfg = &cfg
-	m.smCli = secretsmanager.NewFromConfig(cfg)
-	m.psCli = ssm.NewFromConfig(cfg)
-	return nil
+	return cfg, nil
 }
 
-func (m *Manager) ResolveSecret(ctx context.Context, secretID, field string) (string, error) {
+// clientsFor lazily creates the clients for opt's profile and region.
+func (m *Manager) clientsFor(ctx context.Context, opt Options) (*clients, error) {
+	k := opt.Profile + "|" + opt.Region
+	if c, ok := m.clients[k]; ok {
+		return c, nil
+	}
+	c, err := m.newClients(ctx, opt.Profile, opt.Region)
+	if err != nil {
+		return nil, err
+	}
+	m.clients[k] = c
+	return c, nil
+}
+
+// ResolveSecret reads a Secrets Manager secret. A SecretBinary value is
+// returned base64-encoded unless field selects a key from it.
+func (m *Manager) ResolveSecret(ctx context.Context, secretID, field string, opt Options) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if err := m.init(ctx); err != nil {
-		return "", err
+	if opt.VersionStage != "" && opt.VersionID != "" {
+		return "", fmt.Errorf("awssm: stage and version are mutually exclusive")
 	}
 
-	cacheKey := "sm:" + secretID
-	if raw, ok := m.readCache(cacheKey); ok {
-		return extractField(raw, field)
+	cacheKey := CacheKey("sm", secretID, opt)
+	if e, raw, ok := m.readCache(cacheKey); ok {
+		cacheinfo.NoteExpiry(ctx, e.expires)
+		return extractSecretField(raw, field, e.binary)
 	}
 
-	out, err := m.smCli.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
-		SecretId: aws.String(secretID),
-	})
+	c, err := m.clientsFor(ctx, opt)
+	if err != nil {
+		return "", err
+	}
+
+	in := &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)}
+	if opt.VersionStage != "" {
+		in.VersionStage = aws.String(opt.VersionStage)
+	}
+	if opt.VersionID != "" {
+		in.VersionId = aws.String(opt.VersionID)
+	}
+	out, err := c.sm.GetSecretValue(ctx, in)
 	if err != nil {
 		return "", fmt.Errorf("awssm: failed to get secret %q: %w", secretID, err)
 	}
 
-	raw := ""
-	if out.SecretString != nil {
+	raw, binary := "", false
+	switch {
+	case out.SecretString != nil:
 		raw = *out.SecretString
+	case out.SecretBinary != nil:
+		raw, binary = string(out.SecretBinary), true
 	}
 
-	m.storeCache(cacheKey, raw)
-	return extractField(raw, field)
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, raw, binary))
+	return extractSecretField(raw, field, binary)
 }
 
-func (m *Manager) ResolveParameter(ctx context.Context, name, field string) (string, error) {
+func (m *Manager) ResolveParameter(ctx context.Context, name, field string, opt Options) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if err := m.init(ctx); err != nil {
-		return "", err
+	cacheKey := CacheKey("ps", name, opt)
+	if e, raw, ok := m.readCache(cacheKey); ok {
+		cacheinfo.NoteExpiry(ctx, e.expires)
+		return extractField(raw, field)
 	}
 
-	cacheKey := "ps:" + name
-	if raw, ok := m.readCache(cacheKey); ok {
-		return extractField(raw, field)
+	c, err := m.clientsFor(ctx, opt)
+	if err != nil {
+		return "", err
 	}
 
-	out, err := m.psCli.GetParameter(ctx, &ssm.GetParameterInput{
+	out, err := c.ps.GetParameter(ctx, &ssm.GetParameterInput{
 		Name:           aws.String(name),
 		WithDecryption: aws.Bool(true),
 	})
@@ -114,11 +216,63 @@ func (m *Manager) ResolveParameter(ctx context.Context, name, field string) (str
 		raw = *out.Parameter.Value
 	}
 
-	m.storeCache(cacheKey, raw)
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, raw, false))
 	return extractField(raw, field)
 }
 
-// Evict removes a single cache entry by key (e.g. "sm:<id>" or "ps:<name>").
+// ResolveParametersByPath reads every parameter below path, recursively,
+// and returns them by name relative to path (`/app/prod/db/host` below
+// `/app/prod/` is `db/host`).
+func (m *Manager) ResolveParametersByPath(ctx context.Context, path string, opt Options) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path = strings.TrimSuffix(path, "/") + "/"
+	cacheKey := CacheKey("ps", path, opt)
+	if e, raw, ok := m.readCache(cacheKey); ok {
+		var params map[string]string
+		if err := json.Unmarshal([]byte(raw), &params); err == nil {
+			cacheinfo.NoteExpiry(ctx, e.expires)
+			return params, nil
+		}
+	}
+
+	c, err := m.clientsFor(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]string)
+	pages := ssm.NewGetParametersByPathPaginator(c.ps, &ssm.GetParametersByPathInput{
+		Path:           aws.String(strings.TrimSuffix(path, "/")),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("awsps: failed to get parameters below %q: %w", path, err)
+		}
+		for _, p := range page.Parameters {
+			if p.Name == nil || p.Value == nil {
+				continue
+			}
+			params[strings.TrimPrefix(*p.Name, path)] = *p.Value
+		}
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("awsps: no parameters below %q", path)
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, string(raw), false))
+	return params, nil
+}
+
+// Evict removes a single cache entry by key (see CacheKey).
 // No-op if the key is not present.
 func (m *Manager) Evict(key string) {
 	m.mu.Lock()
@@ -152,36 +306,42 @@ func (m *Manager) CachedKeys() []cacheinfo.Entry {
 	return out
 }
 
-// readCache returns the decrypted plaintext for a cache key if present and
-// not expired. Expired entries are evicted and zeroed.
-func (m *Manager) readCache(key string) (string, bool) {
+// readCache returns the entry and decrypted plaintext for a cache key if
+// present and not expired. Expired entries are evicted and zeroed.
+func (m *Manager) readCache(key string) (cacheEntry, string, bool) {
 	e, ok := m.cache[key]
 	if !ok {
-		return "", false
+		return e, "", false
 	}
 	if !time.Now().Before(e.expires) {
 		e.sealed.Destroy()
 		delete(m.cache, key)
-		return "", false
+		return e, "", false
 	}
 	pt, err := e.sealed.OpenString()
 	if err != nil {
-		return "", false
+		return e, "", false
 	}
-	return pt, true
+	return e, pt, true
 }
 
 // storeCache encrypts and inserts a new value, destroying any prior entry
-// under the same key and scheduling a wipe at TTL expiry.
-func (m *Manager) storeCache(key, raw string) {
+// under the same key and scheduling a wipe at TTL expiry. It returns
+// when the entry expires.
+func (m *Manager) storeCache(key, raw string, binary bool) time.Time {
+	return m.storeCacheFor(key, raw, binary, m.ttl)
+}
+
+// storeCacheFor is storeCache with an explicit lifetime.
+func (m *Manager) storeCacheFor(key, raw string, binary bool, ttl time.Duration) time.Time {
 	sealed, err := memprotect.SealString(raw)
 	if err != nil {
-		return
+		return time.Time{}
 	}
 	if old, ok := m.cache[key]; ok {
 		old.sealed.Destroy()
 	}
-	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(ttl), binary: binary}
 	m.cache[key] = entry
 
 	go func(k string, e cacheEntry, d time.Duration) {
@@ -192,7 +352,19 @@ func (m *Manager) storeCache(key, raw string) {
 		}
 		m.mu.Unlock()
 		e.sealed.Destroy()
-	}(key, entry, m.ttl)
+	}(key, entry, ttl)
+	return entry.expires
+}
+
+// extractSecretField is extractField for Secrets Manager values. Binary
+// secrets are base64-encoded when returned whole, since environment
+// values can't carry arbitrary bytes; a field is looked up in them as
+// JSON text.
+func extractSecretField(value, field string, binary bool) (string, error) {
+	if binary && field == "" {
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	}
+	return extractField(value, field)
 }
 
 // extractField returns a JSON field from value if field is non-empty,
@@ -1,7 +1,19 @@
 package aws
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
 func TestExtractField(t *testing.T) {
@@ -33,3 +45,113 @@ func TestExtractField(t *testing.T) {
 		})
 	}
 }
+
+type fakeSM struct {
+	calls []*secretsmanager.GetSecretValueInput
+	out   *secretsmanager.GetSecretValueOutput
+}
+
+func (f *fakeSM) GetSecretValue(_ context.Context, in *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	f.calls = append(f.calls, in)
+	return f.out, nil
+}
+
+type fakePS struct {
+	pages [][]ssmtypes.Parameter
+}
+
+func (f *fakePS) GetParameter(_ context.Context, in *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+	return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Name: in.Name, Value: aws.String("v")}}, nil
+}
+
+func (f *fakePS) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	if *in.Path != "/app/prod" || !*in.Recursive || !*in.WithDecryption {
+		return nil, errors.New("unexpected input")
+	}
+	i := 0
+	if in.NextToken != nil {
+		i, _ = strconv.Atoi(*in.NextToken)
+	}
+	out := &ssm.GetParametersByPathOutput{Parameters: f.pages[i]}
+	if i+1 < len(f.pages) {
+		out.NextToken = aws.String(strconv.Itoa(i + 1))
+	}
+	return out, nil
+}
+
+func newFakeManager(sm *fakeSM, ps *fakePS) (*Manager, *[]string) {
+	var created []string
+	m := NewManager(time.Hour)
+	m.newClients = func(_ context.Context, profile, region string) (*clients, error) {
+		created = append(created, profile+"|"+region)
+		return &clients{sm: sm, ps: ps}, nil
+	}
+	return m, &created
+}
+
+func TestResolveSecretOptions(t *testing.T) {
+	sm := &fakeSM{out: &secretsmanager.GetSecretValueOutput{SecretString: aws.String(`{"password":"pw"}`)}}
+	m, created := newFakeManager(sm, &fakePS{})
+	ctx := context.Background()
+
+	for _, opt := range []Options{
+		{},
+		{Profile: "prod", Region: "eu-west-1"},
+		{Profile: "prod", Region: "eu-west-1", VersionStage: "AWSPREVIOUS"},
+		{Profile: "prod", Region: "eu-west-1", VersionStage: "AWSPREVIOUS"}, // cached
+		{VersionID: "v-1"},
+	} {
+		if got, err := m.ResolveSecret(ctx, "app", "password", opt); err != nil || got != "pw" {
+			t.Fatalf("%+v: got %q, %v", opt, got, err)
+		}
+	}
+	if want := []string{"|", "prod|eu-west-1"}; !slices.Equal(*created, want) {
+		t.Errorf("clients created for %v, want %v", *created, want)
+	}
+	if len(sm.calls) != 4 {
+		t.Fatalf("%d calls, want 4", len(sm.calls))
+	}
+	if sm.calls[2].VersionStage == nil || *sm.calls[2].VersionStage != "AWSPREVIOUS" || sm.calls[3].VersionId == nil || *sm.calls[3].VersionId != "v-1" {
+		t.Errorf("version selection not passed on: %+v %+v", sm.calls[2], sm.calls[3])
+	}
+	if _, err := m.ResolveSecret(ctx, "app", "", Options{VersionStage: "AWSCURRENT", VersionID: "v-1"}); err == nil {
+		t.Error("expected error for stage and version together")
+	}
+}
+
+func TestResolveSecretBinary(t *testing.T) {
+	sm := &fakeSM{out: &secretsmanager.GetSecretValueOutput{SecretBinary: []byte(`{"k":"v"}`)}}
+	m, _ := newFakeManager(sm, &fakePS{})
+	ctx := context.Background()
+
+	got, err := m.ResolveSecret(ctx, "bin", "", Options{})
+	if err != nil || got != base64.StdEncoding.EncodeToString([]byte(`{"k":"v"}`)) {
+		t.Fatalf("whole binary: got %q, %v", got, err)
+	}
+	if got, err := m.ResolveSecret(ctx, "bin", "k", Options{}); err != nil || got != "v" {
+		t.Fatalf("binary field: got %q, %v", got, err)
+	}
+	if len(sm.calls) != 1 {
+		t.Errorf("binary secret not cached: %d calls", len(sm.calls))
+	}
+}
+
+func TestResolveParametersByPath(t *testing.T) {
+	ps := &fakePS{pages: [][]ssmtypes.Parameter{
+		{{Name: aws.String("/app/prod/db/host"), Value: aws.String("db")}},
+		{{Name: aws.String("/app/prod/api-key"), Value: aws.String("k")}},
+	}}
+	m, _ := newFakeManager(&fakeSM{}, ps)
+
+	got, err := m.ResolveParametersByPath(context.Background(), "/app/prod/", Options{Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"db/host": "db", "api-key": "k"}; !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	keys := m.CachedKeys()
+	if len(keys) != 1 || keys[0].Key != "ps:/app/prod/ (region=us-east-1)" {
+		t.Errorf("CachedKeys = %+v", keys)
+	}
+}
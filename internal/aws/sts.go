@@ -0,0 +1,189 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/prompt"
+)
+
+// refreshMargin is how long before Expiration a cached session is
+// replaced, so a command never starts with credentials about to lapse.
+const refreshMargin = 5 * time.Minute
+
+type stsAPI interface {
+	AssumeRole(ctx context.Context, in *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+// AssumeOptions configure an AssumeRole call beyond the profile and
+// region it is made with.
+type AssumeOptions struct {
+	Options
+	Duration    time.Duration // 0 for the role's default (1h)
+	SessionName string
+	ExternalID  string
+	MFASerial   string
+	// MFACode is a reference such as user(MFA) or totp(...) that yields
+	// the token code. Empty prompts for it.
+	MFACode string
+}
+
+// Session is the credential_process document for a set of temporary
+// credentials.
+type Session struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// STSCacheKey is the key an assumed-role session is cached and listed
+// under.
+func STSCacheKey(roleARN string, opt AssumeOptions) string {
+	key := CacheKey("sts", roleARN, opt.Options)
+	if opt.SessionName != "" {
+		key += " as " + opt.SessionName
+	}
+	if opt.Duration > 0 {
+		key += " for " + opt.Duration.String()
+	}
+	return key
+}
+
+// SetReferenceResolver sets how MFACode references are resolved.
+func (m *Manager) SetReferenceResolver(fn func(ctx context.Context, value string) (string, error)) {
+	m.mu.Lock()
+	m.resolveRef = fn
+	m.mu.Unlock()
+}
+
+// AssumeRole returns field (AccessKeyId, SecretAccessKey, SessionToken
+// or Expiration) of temporary credentials for roleARN. All fields come
+// from one cached session, which is replaced shortly before it expires.
+// An empty field returns the session as credential_process JSON. The
+// session's own Expiration is reported through cacheinfo.NoteExpiry,
+// not the earlier refresh of the cache entry.
+func (m *Manager) AssumeRole(ctx context.Context, roleARN, field string, opt AssumeOptions) (string, error) {
+	key := STSCacheKey(roleARN, opt)
+
+	m.mu.Lock()
+	_, raw, ok := m.readCache(key)
+	resolveRef, askCode := m.resolveRef, m.askCode
+	m.mu.Unlock()
+	if ok {
+		return sessionField(ctx, raw, field)
+	}
+
+	// The MFA code is obtained without holding m.mu: its reference may
+	// itself be resolved through this manager.
+	in := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(opt.SessionName),
+	}
+	if opt.SessionName == "" {
+		in.RoleSessionName = aws.String("desktop-secrets")
+	}
+	if opt.Duration > 0 {
+		in.DurationSeconds = aws.Int32(int32(opt.Duration / time.Second))
+	}
+	if opt.ExternalID != "" {
+		in.ExternalId = aws.String(opt.ExternalID)
+	}
+	if opt.MFASerial != "" {
+		var code string
+		var err error
+		if opt.MFACode != "" {
+			code, err = resolveRef(ctx, opt.MFACode)
+		} else {
+			code, err = askCode(ctx, opt.MFASerial)
+		}
+		if err != nil {
+			return "", fmt.Errorf("awssts: MFA code: %w", err)
+		}
+		in.SerialNumber = aws.String(opt.MFASerial)
+		in.TokenCode = aws.String(strings.TrimSpace(code))
+	} else if opt.MFACode != "" {
+		return "", errors.New("awssts: mfa_code needs mfa (the MFA device serial)")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, raw, ok := m.readCache(key); ok {
+		return sessionField(ctx, raw, field)
+	}
+	c, err := m.clientsFor(ctx, opt.Options)
+	if err != nil {
+		return "", err
+	}
+	out, err := c.sts.AssumeRole(ctx, in)
+	if err != nil {
+		return "", fmt.Errorf("awssts: assume %q: %w", roleARN, err)
+	}
+	if out.Credentials == nil {
+		return "", fmt.Errorf("awssts: assume %q: no credentials returned", roleARN)
+	}
+	s := Session{
+		Version:         1,
+		AccessKeyId:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Expiration:      aws.ToTime(out.Credentials.Expiration),
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	ttl := m.ttl
+	if left := time.Until(s.Expiration) - refreshMargin; !s.Expiration.IsZero() && left < ttl {
+		ttl = max(left, 0)
+	}
+	m.storeCacheFor(key, string(b), false, ttl)
+	return sessionField(ctx, string(b), field)
+}
+
+func sessionField(ctx context.Context, raw, field string) (string, error) {
+	var s Session
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return "", err
+	}
+	cacheinfo.NoteExpiry(ctx, s.Expiration)
+	switch strings.ToLower(field) {
+	case "":
+		return raw, nil
+	case "accesskeyid":
+		return s.AccessKeyId, nil
+	case "secretaccesskey":
+		return s.SecretAccessKey, nil
+	case "sessiontoken":
+		return s.SessionToken, nil
+	case "expiration":
+		return s.Expiration.UTC().Format(time.RFC3339), nil
+	}
+	return "", fmt.Errorf("awssts: unknown field %q (AccessKeyId, SecretAccessKey, SessionToken or Expiration)", field)
+}
+
+func promptMFACode(ctx context.Context, serial string) (string, error) {
+	opts := &prompt.UserOptions{Prompt: "MFA code for " + serial}
+	if info := clientinfo.InfoFromContext(ctx); info.PID != 0 || info.ExePath != "" || info.Name != "" {
+		opts.ProcessDisplay = info.EffectiveDisplay()
+		opts.ProcessDetails = info.EffectiveTooltip()
+	}
+	result, err := prompt.PromptForPassword("AWS STS", prompt.StyleUser, nil, opts)
+	if err != nil {
+		return "", err
+	}
+	if result.Password == "" {
+		return "", errors.New("empty MFA code")
+	}
+	return result.Password, nil
+}
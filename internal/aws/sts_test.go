@@ -0,0 +1,136 @@
+package aws
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+)
+
+type fakeSTS struct {
+	calls []*sts.AssumeRoleInput
+	life  time.Duration
+}
+
+func (f *fakeSTS) AssumeRole(_ context.Context, in *sts.AssumeRoleInput, _ ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.calls = append(f.calls, in)
+	n := strconv.Itoa(len(f.calls))
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIA" + n),
+		SecretAccessKey: aws.String("secret" + n),
+		SessionToken:    aws.String("token" + n),
+		Expiration:      aws.Time(time.Now().Add(f.life)),
+	}}, nil
+}
+
+func newSTSManager(f *fakeSTS) *Manager {
+	m := NewManager(time.Hour)
+	m.newClients = func(context.Context, string, string) (*clients, error) {
+		return &clients{sts: f}, nil
+	}
+	return m
+}
+
+const testRole = "arn:aws:iam::123456789012:role/deploy"
+
+func TestAssumeRoleOneSessionForAllFields(t *testing.T) {
+	f := &fakeSTS{life: time.Hour}
+	m := newSTSManager(f)
+	ctx := context.Background()
+	opt := AssumeOptions{Duration: time.Hour}
+
+	var got []string
+	for _, field := range []string{"AccessKeyId", "secretaccesskey", "SessionToken"} {
+		v, err := m.AssumeRole(ctx, testRole, field, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if strings.Join(got, ",") != "ASIA1,secret1,token1" {
+		t.Fatalf("got %v", got)
+	}
+	if len(f.calls) != 1 || *f.calls[0].DurationSeconds != 3600 || *f.calls[0].RoleSessionName != "desktop-secrets" {
+		t.Fatalf("calls = %+v", f.calls)
+	}
+	doc, err := m.AssumeRole(ctx, testRole, "", opt)
+	if err != nil || !strings.Contains(doc, `"Version":1`) || !strings.Contains(doc, `"AccessKeyId":"ASIA1"`) {
+		t.Fatalf("credential_process document: %s, %v", doc, err)
+	}
+	if _, err := m.AssumeRole(ctx, testRole, "Password", opt); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestAssumeRoleRefreshesBeforeExpiration(t *testing.T) {
+	f := &fakeSTS{life: refreshMargin + 50*time.Millisecond}
+	m := newSTSManager(f)
+	ctx := context.Background()
+
+	if v, _ := m.AssumeRole(ctx, testRole, "AccessKeyId", AssumeOptions{}); v != "ASIA1" {
+		t.Fatalf("got %q", v)
+	}
+	keys := m.CachedKeys()
+	if len(keys) != 1 || keys[0].Expires.After(time.Now().Add(time.Second)) {
+		t.Fatalf("session cached past its refresh point: %+v", keys)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if v, _ := m.AssumeRole(ctx, testRole, "AccessKeyId", AssumeOptions{}); v != "ASIA2" {
+		t.Fatalf("session not refreshed: %q", v)
+	}
+}
+
+func TestAssumeRoleMFA(t *testing.T) {
+	f := &fakeSTS{life: time.Hour}
+	m := newSTSManager(f)
+	ctx := context.Background()
+	var asked []string
+	m.askCode = func(_ context.Context, serial string) (string, error) {
+		asked = append(asked, serial)
+		return " 123456 ", nil
+	}
+	m.SetReferenceResolver(func(_ context.Context, v string) (string, error) {
+		if v == "totp(keepass(v|aws))" {
+			return "654321", nil
+		}
+		return v, nil
+	})
+
+	const serial = "arn:aws:iam::123456789012:mfa/alice"
+	if _, err := m.AssumeRole(ctx, testRole, "AccessKeyId", AssumeOptions{MFASerial: serial}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AssumeRole(ctx, testRole, "AccessKeyId", AssumeOptions{MFASerial: serial, MFACode: "totp(keepass(v|aws))", SessionName: "ci"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 1 || asked[0] != serial {
+		t.Errorf("prompted for %v", asked)
+	}
+	if len(f.calls) != 2 || *f.calls[0].TokenCode != "123456" || *f.calls[1].TokenCode != "654321" || *f.calls[0].SerialNumber != serial {
+		t.Errorf("MFA not passed on: %+v", f.calls)
+	}
+	if _, err := m.AssumeRole(ctx, testRole, "AccessKeyId", AssumeOptions{MFACode: "123456", SessionName: "x"}); err == nil {
+		t.Error("expected error for mfa_code without mfa")
+	}
+}
+
+func TestAssumeRoleReportsSessionExpiration(t *testing.T) {
+	f := &fakeSTS{life: 2 * time.Hour}
+	m := newSTSManager(f)
+	for range 2 { // fresh session, then the cached one
+		ctx, earliest := cacheinfo.WithExpiry(context.Background())
+		if _, err := m.AssumeRole(ctx, testRole, "SessionToken", AssumeOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(earliest()); d < 110*time.Minute {
+			t.Fatalf("reported expiry in %v, want the session's own (2h), not the cache's (1h)", d)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package azkv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// AuthConfig is one entry of the azure_auth setting: which credential
+// to use for the vaults in Vaults. An entry without Vaults applies to
+// every vault not listed elsewhere; without any entry the
+// DefaultAzureCredential chain is used.
+//
+// ClientSecret may be a literal or a reference such as
+// keepass(&ops|azure sp|password).
+type AuthConfig struct {
+	Vaults       []string `mapstructure:"vaults"` // vault names or URLs
+	Method       string   `mapstructure:"method"` // default, cli or client_secret
+	Tenant       string   `mapstructure:"tenant"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+}
+
+// id identifies the credential an entry describes, so vaults sharing a
+// tenant and identity share one credential and its token cache.
+func (c *AuthConfig) id() string {
+	if c == nil {
+		return "default"
+	}
+	return strings.ToLower(strings.TrimSpace(c.Method)) + "|" + strings.TrimSpace(c.Tenant) + "|" + strings.TrimSpace(c.ClientID)
+}
+
+// authFor returns the entry of configs for vault, or nil for the
+// default chain.
+func authFor(configs []AuthConfig, vault string) *AuthConfig {
+	want := strings.ToLower(vaultURL(vault))
+	var fallback *AuthConfig
+	for _, c := range configs {
+		if len(c.Vaults) == 0 {
+			if fallback == nil {
+				fallback = &c
+			}
+			continue
+		}
+		for _, v := range c.Vaults {
+			if strings.ToLower(vaultURL(strings.TrimRight(strings.TrimSpace(v), "/"))) == want {
+				return &c
+			}
+		}
+	}
+	return fallback
+}
+
+// credentialFor returns the credential for cfg, creating it on first
+// use. A client secret reference is resolved without holding m.mu, as
+// it may itself point into a vault.
+func (m *Manager) credentialFor(ctx context.Context, cfg *AuthConfig) (azcore.TokenCredential, error) {
+	id := cfg.id()
+	m.mu.Lock()
+	cred, ok := m.creds[id]
+	resolveRef := m.resolveRef
+	m.mu.Unlock()
+	if ok {
+		return cred, nil
+	}
+
+	secret := ""
+	if cfg != nil && strings.EqualFold(strings.TrimSpace(cfg.Method), "client_secret") {
+		if strings.TrimSpace(cfg.ClientSecret) == "" {
+			return nil, fmt.Errorf("azkv: client_secret not configured")
+		}
+		v, err := resolveRef(ctx, strings.TrimSpace(cfg.ClientSecret))
+		if err != nil {
+			return nil, fmt.Errorf("azkv: client_secret: %w", err)
+		}
+		secret = v
+	}
+	cred, err := m.newCredential(cfg, secret)
+	if err != nil {
+		return nil, fmt.Errorf("Azure credentials not configured: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, ok := m.creds[id]; ok {
+		return cur, nil
+	}
+	m.creds[id] = cred
+	return cred, nil
+}
+
+// newCredential builds the credential cfg describes. secret is the
+// resolved client secret for the client_secret method.
+func newCredential(cfg *AuthConfig, secret string) (azcore.TokenCredential, error) {
+	if cfg == nil {
+		return azidentity.NewDefaultAzureCredential(nil)
+	}
+	tenant := strings.TrimSpace(cfg.Tenant)
+	switch strings.ToLower(strings.TrimSpace(cfg.Method)) {
+	case "", "default":
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{TenantID: tenant})
+	case "cli":
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: tenant})
+	case "client_secret":
+		if tenant == "" || strings.TrimSpace(cfg.ClientID) == "" {
+			return nil, fmt.Errorf("client_secret needs tenant and client_id")
+		}
+		return azidentity.NewClientSecretCredential(tenant, strings.TrimSpace(cfg.ClientID), secret, nil)
+	}
+	return nil, fmt.Errorf("unknown method %q (want default, cli or client_secret)", cfg.Method)
+}
@@ -5,7 +5,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 )
 
@@ -26,11 +26,11 @@ func (f *fakeVaultClient) GetSecret(_ context.Context, _ string, _ string, _ *az
 
 func newManagerWithFake(ttl time.Duration, fc *fakeVaultClient) *Manager {
 	m := NewManager(ttl)
-	m.newClient = func(_ string, _ *azidentity.DefaultAzureCredential) (vaultClient, error) {
+	m.newClient = func(_ string, _ azcore.TokenCredential) (vaultClient, error) {
 		return fc, nil
 	}
 	// avoid hitting Azure auth during test
-	m.cred = &azidentity.DefaultAzureCredential{}
+	m.newCredential = func(*AuthConfig, string) (azcore.TokenCredential, error) { return nil, nil }
 	return m
 }
 
@@ -7,8 +7,8 @@ import (
 
 func TestCachedKeysAndEvictAll(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("kv/b", "v1")
-	m.storeCache("kv/a", "v2")
+	m.storeCache("kv/b", "v1", "")
+	m.storeCache("kv/a", "v2", "")
 
 	keys := m.CachedKeys()
 	if len(keys) != 2 {
@@ -29,7 +29,7 @@ func TestCachedKeysAndEvictAll(t *testing.T) {
 
 func TestCachedKeysExcludesExpired(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("kv/x", "v")
+	m.storeCache("kv/x", "v", "")
 	for k, e := range m.cache {
 		e.expires = time.Now().Add(-time.Minute)
 		m.cache[k] = e
@@ -0,0 +1,192 @@
+package azkv
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// Content types Key Vault gives the secret backing a certificate.
+const (
+	contentTypePFX = "application/x-pkcs12"
+	contentTypePEM = "application/x-pem-file"
+)
+
+// ResolveCertificate returns part of the Key Vault certificate ref
+// names (same syntax as ResolveSecret), read from the secret Key Vault
+// keeps alongside every certificate, which holds the private key when
+// the certificate's policy marks it exportable:
+//
+//	pem   private key, then the certificate and its chain (the default)
+//	key   private key as PKCS #8 PEM
+//	cert  the certificate alone as PEM
+//	chain the certificate and its chain as PEM
+//	pfx   the certificate as stored, base64 PKCS #12 (PFX certificates only)
+func (m *Manager) ResolveCertificate(ctx context.Context, ref, part string) (string, error) {
+	raw, ct, err := m.fetch(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	part = strings.ToLower(strings.TrimSpace(part))
+	if part == "pfx" {
+		if ct != contentTypePFX {
+			return "", fmt.Errorf("azcert: %q is not stored as PFX (content type %q)", ref, ct)
+		}
+		return raw, nil
+	}
+
+	var key crypto.PrivateKey
+	var certs []*x509.Certificate
+	switch ct {
+	case contentTypePFX:
+		der, derr := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+		if derr != nil {
+			return "", fmt.Errorf("azcert: %q: PFX is not base64: %w", ref, derr)
+		}
+		blocks, derr := pkcs12.ToPEM(der, "")
+		if derr != nil {
+			return "", fmt.Errorf("azcert: %q: %w (set the certificate's content type to PEM if it uses a newer PFX encryption)", ref, derr)
+		}
+		key, certs, err = parseBlocks(blocks)
+	case contentTypePEM:
+		var blocks []*pem.Block
+		rest := []byte(raw)
+		for {
+			var b *pem.Block
+			if b, rest = pem.Decode(rest); b == nil {
+				break
+			}
+			blocks = append(blocks, b)
+		}
+		key, certs, err = parseBlocks(blocks)
+	default:
+		return "", fmt.Errorf("azcert: %q is not a certificate (content type %q)", ref, ct)
+	}
+	if err != nil {
+		return "", fmt.Errorf("azcert: %q: %w", ref, err)
+	}
+	if len(certs) == 0 {
+		return "", fmt.Errorf("azcert: %q holds no certificate", ref)
+	}
+	certs = leafFirst(key, certs)
+
+	var buf bytes.Buffer
+	writeKey := func() error {
+		if key == nil {
+			return fmt.Errorf("azcert: %q holds no private key (is the key exportable?)", ref)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("azcert: %q: %w", ref, err)
+		}
+		return pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	writeCerts := func(certs []*x509.Certificate) error {
+		for _, c := range certs {
+			if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch part {
+	case "", "pem":
+		if err := writeKey(); err != nil {
+			return "", err
+		}
+		err = writeCerts(certs)
+	case "key":
+		err = writeKey()
+	case "cert":
+		err = writeCerts(certs[:1])
+	case "chain":
+		err = writeCerts(certs)
+	default:
+		return "", fmt.Errorf("azcert: unknown part %q (pem, key, cert, chain or pfx)", part)
+	}
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseBlocks collects the private key and certificates from PEM
+// blocks. pkcs12.ToPEM labels PKCS #1 and SEC 1 keys "PRIVATE KEY", so
+// every key encoding is tried whatever the label says.
+func parseBlocks(blocks []*pem.Block) (crypto.PrivateKey, []*x509.Certificate, error) {
+	var key crypto.PrivateKey
+	var certs []*x509.Certificate
+	for _, b := range blocks {
+		switch {
+		case b.Type == "CERTIFICATE":
+			c, err := x509.ParseCertificate(b.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, c)
+		case strings.HasSuffix(b.Type, "PRIVATE KEY"):
+			if key != nil {
+				return nil, nil, errors.New("more than one private key")
+			}
+			k, err := parsePrivateKey(b.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			key = k
+		}
+	}
+	return key, certs, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if k, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("unsupported private key encoding")
+}
+
+// leafFirst moves the certificate matching key to the front, so cert
+// returns the leaf whatever order the chain was stored in.
+func leafFirst(key crypto.PrivateKey, certs []*x509.Certificate) []*x509.Certificate {
+	signer, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return certs
+	}
+	pub := signer.Public()
+	for i, c := range certs {
+		if samePublicKey(pub, c.PublicKey) {
+			out := append([]*x509.Certificate{c}, certs[:i]...)
+			return append(out, certs[i+1:]...)
+		}
+	}
+	return certs
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		return a.Equal(b)
+	case *ecdsa.PublicKey:
+		return a.Equal(b)
+	case ed25519.PublicKey:
+		return a.Equal(b)
+	}
+	return false
+}
@@ -10,7 +10,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
@@ -69,8 +69,9 @@ func isValidDNSLabel(s string) bool {
 }
 
 type cacheEntry struct {
-	sealed  *memprotect.Sealed
-	expires time.Time
+	sealed      *memprotect.Sealed
+	contentType string
+	expires     time.Time
 }
 
 type vaultClient interface {
@@ -79,26 +80,36 @@ type vaultClient interface {
 
 type Manager struct {
 	mu      sync.Mutex
-	cred    *azidentity.DefaultAzureCredential
-	clients map[string]vaultClient
+	creds   map[string]azcore.TokenCredential // by AuthConfig.id
+	clients map[string]vaultClient            // by vault URL and credential
 	cache   map[string]cacheEntry
 	ttl     time.Duration
-	// newClient is injectable for tests.
-	newClient func(vaultURL string, cred *azidentity.DefaultAzureCredential) (vaultClient, error)
+	// newClient, newCredential, authConfig, resolveRef and allowHost
+	// are injectable for tests.
+	newClient     func(vaultURL string, cred azcore.TokenCredential) (vaultClient, error)
+	newCredential func(cfg *AuthConfig, secret string) (azcore.TokenCredential, error)
+	authConfig    func() []AuthConfig
+	resolveRef    func(ctx context.Context, value string) (string, error)
+	allowHost     func(host string) bool
 }
 
 func NewManager(ttl time.Duration) *Manager {
 	return &Manager{
+		creds:   make(map[string]azcore.TokenCredential),
 		clients: make(map[string]vaultClient),
 		cache:   make(map[string]cacheEntry),
 		ttl:     ttl,
-		newClient: func(vaultURL string, cred *azidentity.DefaultAzureCredential) (vaultClient, error) {
+		newClient: func(vaultURL string, cred azcore.TokenCredential) (vaultClient, error) {
 			c, err := azsecrets.NewClient(vaultURL, cred, nil)
 			if err != nil {
 				return nil, err
 			}
 			return c, nil
 		},
+		newCredential: newCredential,
+		authConfig:    func() []AuthConfig { return nil },
+		resolveRef:    func(_ context.Context, v string) (string, error) { return v, nil },
+		allowHost:     isAllowedVaultHost,
 	}
 }
 
@@ -108,71 +119,106 @@ func (m *Manager) SetTTL(ttl time.Duration) {
 	m.mu.Unlock()
 }
 
-func (m *Manager) ensureCred() error {
-	if m.cred != nil {
-		return nil
-	}
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return fmt.Errorf("Azure credentials not configured: %w", err)
-	}
-	m.cred = cred
-	return nil
+// SetAuthConfig sets where per-vault credential settings come from. fn
+// is consulted whenever a vault is first used.
+func (m *Manager) SetAuthConfig(fn func() []AuthConfig) {
+	m.mu.Lock()
+	m.authConfig = fn
+	m.mu.Unlock()
 }
 
-func (m *Manager) clientFor(vault string) (vaultClient, error) {
-	if c, ok := m.clients[vault]; ok {
+// SetReferenceResolver sets how a client_secret that is a reference
+// (keepass(...), user(...)) is resolved.
+func (m *Manager) SetReferenceResolver(fn func(ctx context.Context, value string) (string, error)) {
+	m.mu.Lock()
+	m.resolveRef = fn
+	m.mu.Unlock()
+}
+
+func (m *Manager) clientFor(ctx context.Context, vault string) (vaultClient, error) {
+	m.mu.Lock()
+	authConfig := m.authConfig
+	m.mu.Unlock()
+	cfg := authFor(authConfig(), vault)
+	key := vaultURL(vault) + "|" + cfg.id()
+
+	m.mu.Lock()
+	c, ok := m.clients[key]
+	m.mu.Unlock()
+	if ok {
 		return c, nil
 	}
-	if err := m.ensureCred(); err != nil {
+	cred, err := m.credentialFor(ctx, cfg)
+	if err != nil {
 		return nil, err
 	}
-	url := vaultURL(vault)
-	c, err := m.newClient(url, m.cred)
+	c, err = m.newClient(vaultURL(vault), cred)
 	if err != nil {
 		return nil, fmt.Errorf("azkv: client for %q: %w", vault, err)
 	}
-	m.clients[vault] = c
+	m.mu.Lock()
+	m.clients[key] = c
+	m.mu.Unlock()
 	return c, nil
 }
 
 // ResolveSecret resolves an Azure Key Vault secret.
-// ref format: "VAULT/NAME" — VAULT is the vault name (e.g. "mykv") or full URL.
+// ref format: "VAULT/NAME[/VERSION]" — VAULT is the vault name (e.g.
+// "mykv") or full URL.
 func (m *Manager) ResolveSecret(ctx context.Context, ref, field string) (string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	vault, name, err := splitVaultAndName(ref)
+	raw, _, err := m.fetch(ctx, ref)
 	if err != nil {
 		return "", err
 	}
+	return extractField(raw, field)
+}
 
-	cacheKey := vault + "/" + name
-	if raw, ok := m.readCache(cacheKey); ok {
-		return extractField(raw, field)
+// fetch returns the value and content type of the secret ref names,
+// from the cache if possible.
+func (m *Manager) fetch(ctx context.Context, ref string) (value, contentType string, err error) {
+	r, err := splitRef(ref, m.allowHost)
+	if err != nil {
+		return "", "", err
 	}
+	key := r.key()
 
-	cli, err := m.clientFor(vault)
-	if err != nil {
-		return "", err
+	m.mu.Lock()
+	raw, ct, ok := m.readCache(ctx, key)
+	m.mu.Unlock()
+	if ok {
+		return raw, ct, nil
 	}
 
-	resp, err := cli.GetSecret(ctx, name, "", nil)
+	cli, err := m.clientFor(ctx, r.vault)
 	if err != nil {
-		return "", fmt.Errorf("azkv: get secret %q: %w", ref, err)
+		return "", "", err
 	}
 
-	raw := ""
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if raw, ct, ok := m.readCache(ctx, key); ok {
+		return raw, ct, nil
+	}
+	resp, err := cli.GetSecret(ctx, r.name, r.version, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("azkv: get secret %q: %w", ref, err)
+	}
 	if resp.Value != nil {
 		raw = *resp.Value
 	}
-
-	m.storeCache(cacheKey, raw)
-	return extractField(raw, field)
+	if resp.ContentType != nil {
+		ct = *resp.ContentType
+	}
+	cacheinfo.NoteExpiry(ctx, m.storeCache(key, raw, ct))
+	return raw, ct, nil
 }
 
-// Evict removes a single cache entry by key (vault + "/" + name).
+// Evict removes a single cache entry by key (vault + "/" + name, with
+// "/" + version when pinned). A reference is turned into its key.
 func (m *Manager) Evict(key string) {
+	if r, err := splitRef(key, m.allowHost); err == nil {
+		key = r.key()
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if e, ok := m.cache[key]; ok {
@@ -204,32 +250,33 @@ func (m *Manager) CachedKeys() []cacheinfo.Entry {
 	return out
 }
 
-func (m *Manager) readCache(key string) (string, bool) {
+func (m *Manager) readCache(ctx context.Context, key string) (string, string, bool) {
 	e, ok := m.cache[key]
 	if !ok {
-		return "", false
+		return "", "", false
 	}
 	if !time.Now().Before(e.expires) {
 		e.sealed.Destroy()
 		delete(m.cache, key)
-		return "", false
+		return "", "", false
 	}
 	pt, err := e.sealed.OpenString()
 	if err != nil {
-		return "", false
+		return "", "", false
 	}
-	return pt, true
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, e.contentType, true
 }
 
-func (m *Manager) storeCache(key, raw string) {
+func (m *Manager) storeCache(key, raw, contentType string) time.Time {
 	sealed, err := memprotect.SealString(raw)
 	if err != nil {
-		return
+		return time.Time{}
 	}
 	if old, ok := m.cache[key]; ok {
 		old.sealed.Destroy()
 	}
-	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	entry := cacheEntry{sealed: sealed, contentType: contentType, expires: time.Now().Add(m.ttl)}
 	m.cache[key] = entry
 
 	go func(k string, e cacheEntry, d time.Duration) {
@@ -241,48 +288,88 @@ func (m *Manager) storeCache(key, raw string) {
 		m.mu.Unlock()
 		e.sealed.Destroy()
 	}(key, entry, m.ttl)
+	return entry.expires
+}
+
+// secretRef is a parsed azkv or azcert reference.
+type secretRef struct {
+	vault   string // bare vault name or https://HOST
+	name    string
+	version string // empty for the current version
+}
+
+func (r secretRef) key() string {
+	if r.version == "" {
+		return r.vault + "/" + r.name
+	}
+	return r.vault + "/" + r.name + "/" + r.version
 }
 
-// splitVaultAndName accepts either "VAULT/NAME" (VAULT a bare DNS label,
-// normalised to https://VAULT.vault.azure.net) or "https://HOST/NAME" where
-// HOST matches allowedVaultHostSuffixes. The allowlist is what stops an
-// attacker-supplied URL from receiving the daemon's AAD bearer token.
-func splitVaultAndName(s string) (vault, name string, err error) {
+// splitRef accepts either "VAULT/NAME[/VERSION]" (VAULT a bare DNS
+// label, normalised to https://VAULT.vault.azure.net) or a URL whose
+// host passes allowHost: "https://HOST/NAME", or a Key Vault identifier
+// such as "https://HOST/secrets/NAME[/VERSION]" (certificates/ works
+// too, as a certificate's key and chain are read from its secret). The
+// allowlist is what stops an attacker-supplied URL from receiving the
+// daemon's AAD bearer token.
+func splitRef(s string, allowHost func(host string) bool) (secretRef, error) {
 	s = strings.TrimSpace(s)
 	if s == "" {
-		return "", "", fmt.Errorf("empty azkv reference")
+		return secretRef{}, fmt.Errorf("empty azkv reference")
 	}
 	lower := strings.ToLower(s)
 	if strings.HasPrefix(lower, "http://") {
-		return "", "", fmt.Errorf("azkv: only https URLs are accepted")
+		return secretRef{}, fmt.Errorf("azkv: only https URLs are accepted")
 	}
 	if strings.HasPrefix(lower, "https://") {
 		u, perr := url.Parse(s)
 		if perr != nil || u.Host == "" {
-			return "", "", fmt.Errorf("azkv: invalid URL")
+			return secretRef{}, fmt.Errorf("azkv: invalid URL")
+		}
+		if u.User != nil || !allowHost(u.Host) {
+			return secretRef{}, fmt.Errorf("azkv: vault host %q is not an Azure Key Vault hostname", u.Host)
 		}
-		if !isAllowedVaultHost(u.Host) {
-			return "", "", fmt.Errorf("azkv: vault host %q is not an Azure Key Vault hostname", u.Host)
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		r := secretRef{vault: "https://" + u.Host}
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			r.name = parts[0]
+		case (len(parts) == 2 || len(parts) == 3) && (parts[0] == "secrets" || parts[0] == "certificates"):
+			r.name = parts[1]
+			if len(parts) == 3 {
+				r.version = parts[2]
+			}
+		default:
+			return secretRef{}, fmt.Errorf("azkv: URL must be https://HOST/NAME or https://HOST/secrets/NAME[/VERSION]")
 		}
-		path := strings.Trim(u.Path, "/")
-		if path == "" || strings.Contains(path, "/") {
-			return "", "", fmt.Errorf("azkv: missing secret name after URL")
+		if r.name == "" || (len(parts) == 3 && r.version == "") {
+			return secretRef{}, fmt.Errorf("azkv: missing secret name after URL")
 		}
-		return "https://" + u.Host, path, nil
+		return r, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 {
+		return secretRef{}, fmt.Errorf("azkv: reference must be VAULT/NAME or VAULT/NAME/VERSION")
 	}
-	idx := strings.Index(s, "/")
-	if idx < 0 {
-		return "", "", fmt.Errorf("azkv: reference must be VAULT/NAME")
+	if len(parts) > 3 {
+		return secretRef{}, fmt.Errorf("azkv: too many segments in %q (want VAULT/NAME/VERSION)", s)
 	}
-	vault = strings.TrimSpace(s[:idx])
-	name = strings.TrimSpace(s[idx+1:])
-	if vault == "" || name == "" {
-		return "", "", fmt.Errorf("azkv: VAULT and NAME must both be non-empty")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	r := secretRef{vault: parts[0], name: parts[1]}
+	if r.vault == "" || r.name == "" {
+		return secretRef{}, fmt.Errorf("azkv: VAULT and NAME must both be non-empty")
+	}
+	if len(parts) == 3 {
+		if r.version = parts[2]; r.version == "" {
+			return secretRef{}, fmt.Errorf("azkv: empty VERSION")
+		}
 	}
-	if !isValidDNSLabel(strings.ToLower(vault)) {
-		return "", "", fmt.Errorf("azkv: VAULT %q is not a valid DNS label", vault)
+	if !isValidDNSLabel(strings.ToLower(r.vault)) {
+		return secretRef{}, fmt.Errorf("azkv: VAULT %q is not a valid DNS label", r.vault)
 	}
-	return vault, name, nil
+	return r, nil
 }
 
 func vaultURL(vault string) string {
@@ -4,26 +4,34 @@ import "testing"
 
 func TestSplitVaultAndName(t *testing.T) {
 	cases := []struct {
-		in        string
-		wantVault string
-		wantName  string
-		wantErr   bool
+		in          string
+		wantVault   string
+		wantName    string
+		wantVersion string
+		wantErr     bool
 	}{
-		{"mykv/dbpass", "mykv", "dbpass", false},
-		{"https://mykv.vault.azure.net/dbpass", "https://mykv.vault.azure.net", "dbpass", false},
-		{"nosep", "", "", true},
-		{"/name", "", "", true},
-		{"vault/", "", "", true},
-		{"", "", "", true},
+		{"mykv/dbpass", "mykv", "dbpass", "", false},
+		{"mykv/dbpass/0a1b2c", "mykv", "dbpass", "0a1b2c", false},
+		{"https://mykv.vault.azure.net/dbpass", "https://mykv.vault.azure.net", "dbpass", "", false},
+		{"https://mykv.vault.azure.net/secrets/dbpass", "https://mykv.vault.azure.net", "dbpass", "", false},
+		{"https://mykv.vault.azure.net/secrets/dbpass/0a1b2c", "https://mykv.vault.azure.net", "dbpass", "0a1b2c", false},
+		{"https://mykv.vault.azure.net/certificates/tls/0a1b2c", "https://mykv.vault.azure.net", "tls", "0a1b2c", false},
+		{"nosep", "", "", "", true},
+		{"/name", "", "", "", true},
+		{"vault/", "", "", "", true},
+		{"vault/name/", "", "", "", true},
+		{"vault/name/v/extra", "", "", "", true},
+		{"https://mykv.vault.azure.net/keys/k/v", "", "", "", true},
+		{"", "", "", "", true},
 	}
 	for _, tc := range cases {
 		t.Run(tc.in, func(t *testing.T) {
-			v, n, err := splitVaultAndName(tc.in)
+			r, err := splitRef(tc.in, isAllowedVaultHost)
 			if (err != nil) != tc.wantErr {
 				t.Fatalf("err=%v wantErr=%v", err, tc.wantErr)
 			}
-			if err == nil && (v != tc.wantVault || n != tc.wantName) {
-				t.Fatalf("got (%q,%q) want (%q,%q)", v, n, tc.wantVault, tc.wantName)
+			if err == nil && (r.vault != tc.wantVault || r.name != tc.wantName || r.version != tc.wantVersion) {
+				t.Fatalf("got %+v want (%q,%q,%q)", r, tc.wantVault, tc.wantName, tc.wantVersion)
 			}
 		})
 	}
@@ -42,7 +50,7 @@ func TestSplitVaultAndName_RejectsAttackerHosts(t *testing.T) {
 		"mykv-/dbpass",                            // trailing hyphen
 	}
 	for _, in := range rejected {
-		if _, _, err := splitVaultAndName(in); err == nil {
+		if _, err := splitRef(in, isAllowedVaultHost); err == nil {
 			t.Errorf("expected rejection for %q", in)
 		}
 	}
@@ -52,7 +60,7 @@ func TestSplitVaultAndName_RejectsAttackerHosts(t *testing.T) {
 		"https://mykv.vault.usgovcloudapi.net/dbpass",
 		"https://mykv.managedhsm.azure.net/dbpass",
 	} {
-		if _, _, err := splitVaultAndName(in); err != nil {
+		if _, err := splitRef(in, isAllowedVaultHost); err != nil {
 			t.Errorf("expected acceptance for %q, got %v", in, err)
 		}
 	}
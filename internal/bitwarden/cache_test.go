@@ -0,0 +1,119 @@
+package bitwarden
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveSecretCachesValuesButNotTOTP(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	prompts := 0
+	m := newTestManager(f, &prompts)
+
+	for i := 0; i < 3; i++ {
+		if _, err := m.ResolveSecret(t.Context(), "github", ""); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.ResolveSecret(t.Context(), "github", "totp"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lists, totps := 0, 0
+	for _, c := range f.calls {
+		switch {
+		case strings.HasPrefix(c, "list items"):
+			lists++
+		case strings.HasPrefix(c, "get totp"):
+			totps++
+		}
+	}
+	if lists != 4 || totps != 3 {
+		t.Fatalf("expected the password cached and TOTP fetched every time; got %d lists, %d totp calls", lists, totps)
+	}
+}
+
+func TestEvictSessionForcesUnlock(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	prompts := 0
+	m := newTestManager(f, &prompts)
+	if _, err := m.ResolveSecret(t.Context(), "github", ""); err != nil {
+		t.Fatal(err)
+	}
+	m.Evict(SessionKey)
+	if m.Unlocked() {
+		t.Fatal("expected session evicted")
+	}
+	if _, err := m.ResolveSecret(t.Context(), "github", "username"); err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 2 {
+		t.Fatalf("expected a second prompt, got %d", prompts)
+	}
+}
+
+func TestEvictByCacheKeyClearsDefaultField(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	prompts := 0
+	m := newTestManager(f, &prompts)
+	if _, err := m.ResolveSecret(t.Context(), "github", ""); err != nil {
+		t.Fatal(err)
+	}
+	m.Evict(CacheKey("github", ""))
+	for _, e := range m.CachedKeys() {
+		if e.Key != SessionKey {
+			t.Fatalf("%s still cached after Evict", e.Key)
+		}
+	}
+}
+
+func TestUnlockPromptDoesNotHoldCache(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	m := newTestManager(f, new(int))
+	asked, release := make(chan struct{}), make(chan struct{})
+	prompts := 0
+	m.askPassword = func(context.Context, time.Duration) (string, time.Duration, error) {
+		prompts++
+		close(asked)
+		<-release
+		return f.password, time.Hour, nil
+	}
+
+	results := make(chan error, 2)
+	for _, field := range []string{"", "username"} {
+		go func() {
+			_, err := m.ResolveSecret(t.Context(), "github", field)
+			results <- err
+		}()
+	}
+	<-asked
+
+	// The prompt is up: the tray and the approval gate must not block.
+	done := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		m.Unlocked()
+		m.Evict("db|password")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cache blocked while the unlock prompt was open")
+	}
+
+	close(release)
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prompts != 1 {
+		t.Fatalf("prompted %d times, want one unlock shared by both lookups", prompts)
+	}
+}
@@ -0,0 +1,41 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachedKeysAndEvictAll(t *testing.T) {
+	m := NewManager(time.Hour)
+	m.storeCache("b|password", "x")
+	m.storeCache("a|password", "x")
+	m.storeSession("key", time.Hour)
+
+	keys := m.CachedKeys()
+	if len(keys) != 3 {
+		t.Fatalf("got %d keys, want 3", len(keys))
+	}
+	if keys[0].Key != SessionKey || keys[1].Key != "a|password" || keys[2].Key != "b|password" {
+		t.Errorf("keys not sorted: %v", keys)
+	}
+
+	m.EvictAll()
+	if got := m.CachedKeys(); len(got) != 0 {
+		t.Errorf("CachedKeys not empty after EvictAll: %d", len(got))
+	}
+	if len(m.cache) != 0 || m.session != nil {
+		t.Error("cache or session not cleared")
+	}
+}
+
+func TestCachedKeysExcludesExpired(t *testing.T) {
+	m := NewManager(time.Hour)
+	m.storeCache("x|password", "x")
+	for k, e := range m.cache {
+		e.expires = time.Now().Add(-time.Minute)
+		m.cache[k] = e
+	}
+	if got := m.CachedKeys(); len(got) != 0 {
+		t.Fatalf("expected expired excluded, got %d", len(got))
+	}
+}
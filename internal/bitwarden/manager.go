@@ -0,0 +1,483 @@
+package bitwarden
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+	"github.com/it-atelier-gn/desktop-secrets/internal/prompt"
+	"github.com/it-atelier-gn/desktop-secrets/internal/utils"
+)
+
+// SessionKey is the CachedKeys entry standing for the unlocked session.
+const SessionKey = "(session)"
+
+type bwRunner func(ctx context.Context, env []string, args ...string) ([]byte, error)
+
+type cacheEntry struct {
+	sealed  *memprotect.Sealed
+	expires time.Time
+}
+
+type Manager struct {
+	mu sync.Mutex
+	// unlockMu lets one `bw unlock` (and its prompt) run at a time. It
+	// is never taken while mu is held: lookups that hit the cache, and
+	// the tray, don't wait for the prompt.
+	unlockMu  sync.Mutex
+	cache     map[string]cacheEntry
+	session   *cacheEntry
+	ttl       time.Duration
+	unlockTTL *utils.AtomicDuration
+	// runBW and askPassword are injectable for tests.
+	runBW       bwRunner
+	askPassword func(ctx context.Context, ttl time.Duration) (string, time.Duration, error)
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		cache: make(map[string]cacheEntry),
+		ttl:   ttl,
+		runBW: func(ctx context.Context, env []string, args ...string) ([]byte, error) {
+			cmd := exec.CommandContext(ctx, "bw", args...)
+			cmd.Env = append(os.Environ(), env...)
+			out, err := cmd.Output()
+			if err != nil {
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					return nil, fmt.Errorf("bw: %s", strings.TrimSpace(string(ee.Stderr)))
+				}
+				return nil, fmt.Errorf("bw: %w", err)
+			}
+			return out, nil
+		},
+		askPassword: promptPassword,
+	}
+}
+
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+func (m *Manager) SetUnlockTTL(unlockTTL *utils.AtomicDuration) {
+	m.unlockTTL = unlockTTL
+}
+
+// Unlocked reports whether a session is available without prompting:
+// one unlocked earlier, or BW_SESSION in the daemon's environment.
+func (m *Manager) Unlocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.liveSession() != nil || os.Getenv("BW_SESSION") != ""
+}
+
+// ResolveSecret reads a field of a Bitwarden item via the bw CLI. item
+// is an item ID or an exact item name. field is password (the
+// default), username, notes, uri, totp for the current code, or the
+// name of a custom field. TOTP codes are not cached.
+//
+// The vault is unlocked with the master password from the daemon's
+// password prompt; the resulting BW_SESSION is kept sealed for the
+// unlock TTL chosen there.
+func (m *Manager) ResolveSecret(ctx context.Context, item, field string) (string, error) {
+	item = strings.TrimSpace(item)
+	if item == "" {
+		return "", errors.New("empty bw item")
+	}
+	field = strings.TrimSpace(field)
+	if field == "" {
+		field = "password"
+	}
+	cacheKey := CacheKey(item, field)
+
+	m.mu.Lock()
+	val, ok := m.readCache(ctx, cacheKey)
+	m.mu.Unlock()
+	if ok {
+		return val, nil
+	}
+
+	val, err := m.withSession(ctx, func(session string) (string, error) {
+		return m.lookup(ctx, session, item, field)
+	})
+	if err != nil {
+		return "", fmt.Errorf("bw: %s|%s: %w", item, field, err)
+	}
+
+	if !strings.EqualFold(field, "totp") {
+		m.mu.Lock()
+		cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, val))
+		m.mu.Unlock()
+	}
+	return val, nil
+}
+
+// CacheKey is the cache key of bw(item|field): "item|field", with the
+// default field spelled out.
+func CacheKey(item, field string) string {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		field = "password"
+	}
+	return strings.TrimSpace(item) + "|" + field
+}
+
+// withSession runs fn with a session key, unlocking first if needed.
+// If a cached session turns out to be stale (bw locked or logged out
+// behind our back) it is dropped and the vault unlocked once more.
+// It runs without m.mu held.
+func (m *Manager) withSession(ctx context.Context, fn func(session string) (string, error)) (string, error) {
+	session, cached, err := m.sessionKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	val, err := fn(session)
+	if err == nil || cached == nil || !isLockedErr(err) {
+		return val, err
+	}
+	m.mu.Lock()
+	if m.session == cached {
+		m.dropSession()
+	}
+	m.mu.Unlock()
+	if session, _, err = m.sessionKey(ctx); err != nil {
+		return "", err
+	}
+	return fn(session)
+}
+
+// cachedSession returns the key of the session we unlocked, if it is
+// still live.
+func (m *Manager) cachedSession() (string, *cacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.liveSession(); s != nil {
+		if key, err := s.sealed.OpenString(); err == nil {
+			return key, s
+		}
+	}
+	return "", nil
+}
+
+// sessionKey returns the cached session, BW_SESSION from the
+// environment, or a fresh one from `bw unlock`. cached is the cache
+// entry the key came from, nil for BW_SESSION.
+func (m *Manager) sessionKey(ctx context.Context) (key string, cached *cacheEntry, err error) {
+	if key, s := m.cachedSession(); s != nil {
+		return key, s, nil
+	}
+	if key := os.Getenv("BW_SESSION"); key != "" {
+		return key, nil, nil
+	}
+
+	m.unlockMu.Lock()
+	defer m.unlockMu.Unlock()
+	// Another lookup may have unlocked while we waited.
+	if key, s := m.cachedSession(); s != nil {
+		return key, s, nil
+	}
+
+	out, err := m.runBW(ctx, nil, "status", "--nointeraction")
+	if err != nil {
+		return "", nil, err
+	}
+	var st struct {
+		Status    string `json:"status"`
+		ServerURL string `json:"serverUrl"`
+	}
+	if err := json.Unmarshal(out, &st); err != nil {
+		return "", nil, fmt.Errorf("parse bw status: %w", err)
+	}
+	if st.Status == "unauthenticated" {
+		return "", nil, errors.New("not logged in; run `bw login` first (and `bw config server URL` for Vaultwarden)")
+	}
+
+	var ttl time.Duration
+	if m.unlockTTL != nil {
+		ttl = m.unlockTTL.Load()
+	}
+	password, ttl, err := m.askPassword(ctx, ttl)
+	if err != nil {
+		return "", nil, err
+	}
+	out, err = m.runBW(ctx, []string{"BW_PASSWORD=" + password}, "unlock", "--raw", "--passwordenv", "BW_PASSWORD", "--nointeraction")
+	if err != nil {
+		return "", nil, fmt.Errorf("unlock: %w", err)
+	}
+	key = strings.TrimSpace(string(out))
+	clear(out)
+	if key == "" {
+		return "", nil, errors.New("unlock returned no session key")
+	}
+	m.mu.Lock()
+	s := m.storeSession(key, ttl)
+	m.mu.Unlock()
+	return key, s, nil
+}
+
+func isLockedErr(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "locked") || strings.Contains(msg, "not logged in") ||
+		strings.Contains(msg, "session key is invalid")
+}
+
+type bwItem struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Notes string `json:"notes"`
+	Login *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		URIs     []struct {
+			URI string `json:"uri"`
+		} `json:"uris"`
+	} `json:"login"`
+	Fields []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"fields"`
+}
+
+var itemID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func (m *Manager) lookup(ctx context.Context, session, item, field string) (string, error) {
+	it, err := m.findItem(ctx, session, item)
+	if err != nil {
+		return "", err
+	}
+	switch strings.ToLower(field) {
+	case "password", "username", "uri":
+		if it.Login == nil {
+			return "", fmt.Errorf("%q is not a login item", it.Name)
+		}
+		switch strings.ToLower(field) {
+		case "password":
+			return it.Login.Password, nil
+		case "username":
+			return it.Login.Username, nil
+		default:
+			if len(it.Login.URIs) == 0 {
+				return "", fmt.Errorf("%q has no URI", it.Name)
+			}
+			return it.Login.URIs[0].URI, nil
+		}
+	case "notes":
+		return it.Notes, nil
+	case "totp":
+		out, err := m.bw(ctx, session, "get", "totp", it.ID)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	for _, f := range it.Fields {
+		if f.Name == field {
+			return f.Value, nil
+		}
+	}
+	return "", fmt.Errorf("field %q not found", field)
+}
+
+// findItem fetches an item by ID, or by exact name. bw's own name
+// lookup is a fuzzy search, so names are listed and matched here to
+// avoid picking "github-old" for "github".
+func (m *Manager) findItem(ctx context.Context, session, item string) (*bwItem, error) {
+	if itemID.MatchString(item) {
+		out, err := m.bw(ctx, session, "get", "item", item)
+		if err != nil {
+			return nil, err
+		}
+		var it bwItem
+		if err := json.Unmarshal(out, &it); err != nil {
+			return nil, fmt.Errorf("parse item: %w", err)
+		}
+		return &it, nil
+	}
+
+	out, err := m.bw(ctx, session, "list", "items", "--search", item)
+	if err != nil {
+		return nil, err
+	}
+	var list []bwItem
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("parse items: %w", err)
+	}
+	var matches []bwItem
+	for _, it := range list {
+		if it.Name == item {
+			matches = append(matches, it)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no item named %q", item)
+	case 1:
+		return &matches[0], nil
+	}
+	ids := make([]string, len(matches))
+	for i, it := range matches {
+		ids[i] = it.ID
+	}
+	return nil, fmt.Errorf("%d items named %q; use an item ID (%s)", len(matches), item, strings.Join(ids, ", "))
+}
+
+func (m *Manager) bw(ctx context.Context, session string, args ...string) ([]byte, error) {
+	return m.runBW(ctx, []string{"BW_SESSION=" + session}, append(args, "--nointeraction")...)
+}
+
+func promptPassword(ctx context.Context, ttl time.Duration) (string, time.Duration, error) {
+	opts := &prompt.UserOptions{
+		CurrentTTL: int(ttl.Minutes()),
+		Prompt:     "Bitwarden master password",
+	}
+	if info := clientinfo.InfoFromContext(ctx); info.PID != 0 || info.ExePath != "" || info.Name != "" {
+		opts.ProcessDisplay = info.EffectiveDisplay()
+		opts.ProcessDetails = info.EffectiveTooltip()
+	}
+	result, err := prompt.PromptForPassword("Bitwarden", prompt.StyleUser, nil, opts)
+	if err != nil {
+		return "", 0, err
+	}
+	if result.Password == "" {
+		return "", 0, errors.New("empty password")
+	}
+	return result.Password, time.Duration(result.TTLMinutes) * time.Minute, nil
+}
+
+func (m *Manager) liveSession() *cacheEntry {
+	if m.session == nil {
+		return nil
+	}
+	if !time.Now().Before(m.session.expires) {
+		m.session.sealed.Destroy()
+		m.session = nil
+		return nil
+	}
+	return m.session
+}
+
+func (m *Manager) storeSession(key string, ttl time.Duration) *cacheEntry {
+	sealed, err := memprotect.SealString(key)
+	if err != nil {
+		return nil
+	}
+	m.dropSession()
+	s := &cacheEntry{sealed: sealed, expires: time.Now().Add(ttl)}
+	m.session = s
+
+	go func(s *cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if m.session == s {
+			m.session = nil
+		}
+		m.mu.Unlock()
+		s.sealed.Destroy()
+	}(s, ttl)
+	return s
+}
+
+func (m *Manager) dropSession() {
+	if m.session != nil {
+		m.session.sealed.Destroy()
+		m.session = nil
+	}
+}
+
+// Evict removes a single cache entry by key (see CacheKey), or the
+// session for SessionKey.
+func (m *Manager) Evict(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key == SessionKey {
+		m.dropSession()
+		return
+	}
+	if e, ok := m.cache[key]; ok {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+	}
+}
+
+// EvictAll drops every cached value and the session, so the next
+// lookup asks for the master password again.
+func (m *Manager) EvictAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.cache {
+		e.sealed.Destroy()
+		delete(m.cache, k)
+	}
+	m.dropSession()
+}
+
+func (m *Manager) CachedKeys() []cacheinfo.Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]cacheinfo.Entry, 0, len(m.cache)+1)
+	for k, e := range m.cache {
+		if now.Before(e.expires) {
+			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+		}
+	}
+	if s := m.session; s != nil && now.Before(s.expires) {
+		out = append(out, cacheinfo.Entry{Key: SessionKey, Expires: s.expires})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (m *Manager) readCache(ctx context.Context, key string) (string, bool) {
+	e, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(e.expires) {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return "", false
+	}
+	pt, err := e.sealed.OpenString()
+	if err != nil {
+		return "", false
+	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, true
+}
+
+func (m *Manager) storeCache(key, raw string) time.Time {
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return time.Time{}
+	}
+	if old, ok := m.cache[key]; ok {
+		old.sealed.Destroy()
+	}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	m.cache[key] = entry
+
+	go func(k string, e cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if cur, ok := m.cache[k]; ok && cur.sealed == e.sealed {
+			delete(m.cache, k)
+		}
+		m.mu.Unlock()
+		e.sealed.Destroy()
+	}(key, entry, m.ttl)
+	return entry.expires
+}
@@ -0,0 +1,222 @@
+package bitwarden
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const githubID = "0b7e3a4c-1111-2222-3333-444455556666"
+
+// fakeBW emulates the bw commands the manager uses against an
+// in-memory vault unlocked by one master password.
+type fakeBW struct {
+	mu       sync.Mutex
+	items    []map[string]any
+	status   string
+	password string
+	valid    map[string]bool // session keys bw accepts
+	next     int
+	calls    []string
+}
+
+func newFakeBW() *fakeBW {
+	return &fakeBW{
+		status:   "locked",
+		password: "master",
+		valid:    map[string]bool{},
+		items: []map[string]any{
+			{
+				"id": githubID, "name": "github", "notes": "2fa on phone",
+				"login": map[string]any{
+					"username": "alice", "password": "hunter2",
+					"uris": []any{map[string]any{"uri": "https://github.com"}},
+				},
+				"fields": []any{map[string]any{"name": "api token", "value": "ghp_x"}},
+			},
+			{"id": "aaaaaaaa-0000-0000-0000-000000000001", "name": "github-old", "login": map[string]any{"password": "old"}},
+			{"id": "aaaaaaaa-0000-0000-0000-000000000002", "name": "db", "login": map[string]any{"password": "one"}},
+			{"id": "aaaaaaaa-0000-0000-0000-000000000003", "name": "db", "login": map[string]any{"password": "two"}},
+		},
+	}
+}
+
+func envValue(env []string, key string) string {
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func (f *fakeBW) run(_ context.Context, env []string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, strings.Join(args, " "))
+	if !slices.Contains(args, "--nointeraction") {
+		return nil, errors.New("bw would prompt without --nointeraction")
+	}
+	switch args[0] {
+	case "status":
+		return json.Marshal(map[string]string{"status": f.status})
+	case "unlock":
+		if envValue(env, "BW_PASSWORD") != f.password {
+			return nil, errors.New("Invalid master password.")
+		}
+		f.next++
+		key := "session-" + string(rune('0'+f.next))
+		f.valid[key] = true
+		return []byte(key + "\n"), nil
+	}
+	if !f.valid[envValue(env, "BW_SESSION")] {
+		return nil, errors.New("Vault is locked.")
+	}
+	switch {
+	case args[0] == "list":
+		var out []map[string]any
+		for _, it := range f.items {
+			if strings.Contains(it["name"].(string), args[3]) {
+				out = append(out, it)
+			}
+		}
+		return json.Marshal(out)
+	case args[0] == "get" && args[1] == "item":
+		for _, it := range f.items {
+			if it["id"] == args[2] {
+				return json.Marshal(it)
+			}
+		}
+		return nil, errors.New("Not found.")
+	case args[0] == "get" && args[1] == "totp":
+		return []byte("123456\n"), nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func newTestManager(f *fakeBW, prompts *int) *Manager {
+	m := NewManager(time.Hour)
+	m.runBW = f.run
+	m.askPassword = func(context.Context, time.Duration) (string, time.Duration, error) {
+		*prompts++
+		return f.password, time.Hour, nil
+	}
+	return m
+}
+
+func TestResolveSecretFields(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	prompts := 0
+	m := newTestManager(f, &prompts)
+
+	cases := []struct {
+		item, field, want string
+		wantErr           bool
+	}{
+		{"github", "", "hunter2", false},
+		{"github", "username", "alice", false},
+		{"github", "uri", "https://github.com", false},
+		{"github", "notes", "2fa on phone", false},
+		{"github", "api token", "ghp_x", false},
+		{"github", "totp", "123456", false},
+		{githubID, "password", "hunter2", false},
+		{"github-old", "", "old", false},
+		{"github", "missing", "", true},
+		{"gitlab", "", "", true},
+		{"db", "", "", true},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(t.Context(), tc.item, tc.field)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s|%s: err=%v wantErr=%v", tc.item, tc.field, err, tc.wantErr)
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("%s|%s: got %q want %q", tc.item, tc.field, got, tc.want)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("expected a single unlock prompt, got %d", prompts)
+	}
+}
+
+func TestResolveSecretAmbiguousNameListsIDs(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	prompts := 0
+	_, err := newTestManager(newFakeBW(), &prompts).ResolveSecret(t.Context(), "db", "")
+	if err == nil || !strings.Contains(err.Error(), "aaaaaaaa-0000-0000-0000-000000000002") {
+		t.Fatalf("expected ambiguity error naming the IDs, got %v", err)
+	}
+}
+
+func TestResolveSecretNotLoggedIn(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	f.status = "unauthenticated"
+	prompts := 0
+	_, err := newTestManager(f, &prompts).ResolveSecret(t.Context(), "github", "")
+	if err == nil || !strings.Contains(err.Error(), "bw login") {
+		t.Fatalf("expected login hint, got %v", err)
+	}
+	if prompts != 0 {
+		t.Fatal("must not prompt when not logged in")
+	}
+}
+
+func TestResolveSecretWrongPassword(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	m := NewManager(time.Hour)
+	m.runBW = f.run
+	m.askPassword = func(context.Context, time.Duration) (string, time.Duration, error) {
+		return "wrong", time.Hour, nil
+	}
+	if _, err := m.ResolveSecret(t.Context(), "github", ""); err == nil {
+		t.Fatal("expected unlock failure")
+	}
+	if m.Unlocked() {
+		t.Fatal("failed unlock must not leave a session")
+	}
+}
+
+func TestResolveSecretReunlocksStaleSession(t *testing.T) {
+	t.Setenv("BW_SESSION", "")
+	f := newFakeBW()
+	prompts := 0
+	m := newTestManager(f, &prompts)
+	if _, err := m.ResolveSecret(t.Context(), "github", "username"); err != nil {
+		t.Fatal(err)
+	}
+
+	// `bw lock` elsewhere invalidates our session.
+	f.valid = map[string]bool{}
+	got, err := m.ResolveSecret(t.Context(), "github", "")
+	if err != nil || got != "hunter2" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if prompts != 2 {
+		t.Fatalf("expected a second unlock prompt, got %d", prompts)
+	}
+}
+
+func TestResolveSecretUsesEnvSession(t *testing.T) {
+	f := newFakeBW()
+	f.valid["from-env"] = true
+	t.Setenv("BW_SESSION", "from-env")
+	prompts := 0
+	m := newTestManager(f, &prompts)
+	if !m.Unlocked() {
+		t.Fatal("expected BW_SESSION to count as unlocked")
+	}
+	if got, err := m.ResolveSecret(t.Context(), "github", ""); err != nil || got != "hunter2" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if prompts != 0 {
+		t.Fatal("must not prompt with BW_SESSION set")
+	}
+}
@@ -5,4 +5,5 @@ import "time"
 type Entry struct {
 	Key     string
 	Expires time.Time
+	Detail  string // optional second line in the cached-secrets window
 }
@@ -0,0 +1,41 @@
+package cacheinfo
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type expiryKey struct{}
+
+type expiry struct {
+	mu       sync.Mutex
+	earliest time.Time
+}
+
+// WithExpiry returns a context under which providers report when the
+// values they serve stop being valid (see NoteExpiry). earliest returns
+// the earliest time reported, or the zero time if none was.
+func WithExpiry(ctx context.Context) (_ context.Context, earliest func() time.Time) {
+	e := &expiry{}
+	return context.WithValue(ctx, expiryKey{}, e), func() time.Time {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.earliest
+	}
+}
+
+// NoteExpiry records that a value served under ctx expires at t: the
+// cache entry it came from, or the credential itself when that ends
+// first. It does nothing outside WithExpiry.
+func NoteExpiry(ctx context.Context, t time.Time) {
+	e, _ := ctx.Value(expiryKey{}).(*expiry)
+	if e == nil || t.IsZero() {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.earliest.IsZero() || t.Before(e.earliest) {
+		e.earliest = t
+	}
+}
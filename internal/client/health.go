@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/ipc"
@@ -13,6 +14,25 @@ import (
 
 // Try a quick health check to an existing daemon.
 func tryHealth(ctx context.Context, st *shm.DaemonState) error {
+	_, err := health(ctx, st)
+	return err
+}
+
+// CacheTTL asks the daemon how long it caches resolved values.
+func CacheTTL(ctx context.Context, st *shm.DaemonState) (time.Duration, error) {
+	h, err := health(ctx, st)
+	if err != nil {
+		return 0, err
+	}
+	secs, err := strconv.Atoi(h.Get("X-DesktopSecrets-TTL"))
+	if err != nil {
+		return 0, fmt.Errorf("daemon did not report its cache TTL")
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// health calls /health and returns the response headers.
+func health(ctx context.Context, st *shm.DaemonState) (http.Header, error) {
 	endpoint := st.Endpoint
 	transport := &http.Transport{
 		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
@@ -25,11 +45,11 @@ func tryHealth(ctx context.Context, st *shm.DaemonState) error {
 	req.Header.Set("X-DesktopSecrets-Token", st.Token)
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("health status %d", resp.StatusCode)
+		return nil, fmt.Errorf("health status %d", resp.StatusCode)
 	}
-	return nil
+	return resp.Header, nil
 }
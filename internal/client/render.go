@@ -14,6 +14,15 @@ import (
 	"github.com/it-atelier-gn/desktop-secrets/internal/shm"
 )
 
+type reloadKey struct{}
+
+// WithVaultReload marks renders made with ctx as ones that should see
+// KeePass databases changed since they were unlocked. The daemon re-reads
+// such a database with the key it was unlocked with; no prompt is shown.
+func WithVaultReload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reloadKey{}, true)
+}
+
 // Render by calling the daemon: send the .env.tpl content and read result.
 // Warnings is the count of provider lines the daemon could not resolve
 // (parsed from X-EnvTray-Warnings); the corresponding lines appear in
@@ -33,6 +42,9 @@ func RenderViaDaemon(ctx context.Context, st *shm.DaemonState, tpl []byte) (body
 	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://ipc/render", bytes.NewReader(tpl))
 	req.Header.Set("X-DesktopSecrets-Token", st.Token)
 	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if reload, _ := ctx.Value(reloadKey{}).(bool); reload {
+		req.Header.Set("X-DesktopSecrets-Reload", "keepass")
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/ipc"
+	"github.com/it-atelier-gn/desktop-secrets/internal/shm"
+)
+
+// UnresolvedError lists the references a ResolveViaDaemon batch could
+// not resolve, with the daemon's diagnostic for each.
+type UnresolvedError struct {
+	Refs    []string
+	Reasons []string
+}
+
+func (e *UnresolvedError) Error() string {
+	parts := make([]string, len(e.Refs))
+	for i, ref := range e.Refs {
+		parts[i] = fmt.Sprintf("%s: %s", ref, e.Reasons[i])
+	}
+	return fmt.Sprintf("%d secret(s) failed to resolve: %s", len(e.Refs), strings.Join(parts, "; "))
+}
+
+// ResolveViaDaemon resolves refs in a single /resolve round trip. The
+// result maps every resolved reference to its value exactly as the
+// provider returned it; values are not env-expanded. If any reference
+// fails the resolved ones are still returned alongside an
+// *UnresolvedError.
+func ResolveViaDaemon(ctx context.Context, st *shm.DaemonState, refs []string) (map[string]string, error) {
+	values, _, err := ResolveWithExpiry(ctx, st, refs)
+	return values, err
+}
+
+// ResolveWithExpiry is ResolveViaDaemon that also returns, for values
+// the daemon served from a cache, when that entry expires. Values that
+// are not cached have no expiry.
+func ResolveWithExpiry(ctx context.Context, st *shm.DaemonState, refs []string) (map[string]string, map[string]time.Time, error) {
+	if len(refs) == 0 {
+		return map[string]string{}, map[string]time.Time{}, nil
+	}
+	reqBody, err := json.Marshal(struct {
+		Refs []string `json:"refs"`
+	}{refs})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoint := st.Endpoint
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return ipc.Dial(ctx, "", endpoint)
+		},
+		DisableKeepAlives: true,
+	}
+	client := &http.Client{Transport: transport, Timeout: 120 * time.Second}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://ipc/resolve", bytes.NewReader(reqBody))
+	req.Header.Set("X-DesktopSecrets-Token", st.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return nil, nil, fmt.Errorf("resolve failed: %s", bytes.TrimSpace(b))
+	}
+	var body struct {
+		Values  map[string]string    `json:"values"`
+		Expires map[string]time.Time `json:"expires"`
+		Errors  map[string]string    `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("resolve failed: invalid response: %w", err)
+	}
+
+	out := make(map[string]string, len(refs))
+	expires := make(map[string]time.Time)
+	var unresolved UnresolvedError
+	for _, ref := range refs {
+		if v, ok := body.Values[ref]; ok {
+			out[ref] = v
+			if t, ok := body.Expires[ref]; ok {
+				expires[ref] = t
+			}
+			continue
+		}
+		reason, ok := body.Errors[ref]
+		if !ok {
+			reason = "no value returned"
+		}
+		unresolved.Refs = append(unresolved.Refs, ref)
+		unresolved.Reasons = append(unresolved.Reasons, reason)
+	}
+	if len(unresolved.Refs) > 0 {
+		return out, expires, &unresolved
+	}
+	return out, expires, nil
+}
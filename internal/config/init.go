@@ -30,6 +30,12 @@ func InitConfig() error {
 	viper.SetDefault("retrieval_approval", static.DefaultRetrievalApproval)
 	viper.SetDefault("approval_factor_required", static.DefaultApprovalFactor)
 	viper.SetDefault("approval_grant_minutes", static.DefaultApprovalGrantMinutes)
+	viper.SetDefault("age_identity_file", "")
+	viper.SetDefault("pass_store_dir", "")
+	viper.SetDefault("kubeconfig", "")
+	viper.SetDefault("vault_renew_leases", false)
+	viper.SetDefault("ssh_agent", false)
+	viper.SetDefault("secret_service_backend", false)
 
 	var configFileNotFoundError viper.ConfigFileNotFoundError
 	if err := viper.ReadInConfig(); err != nil {
@@ -24,11 +24,15 @@ func (f *fakeSMClient) AccessSecretVersion(_ context.Context, _ *secretmanagerpb
 	}, nil
 }
 
+func (f *fakeSMClient) GetSecret(_ context.Context, _ *secretmanagerpb.GetSecretRequest, _ ...any) (*secretmanagerpb.Secret, error) {
+	return nil, f.respErr
+}
+
 func (f *fakeSMClient) Close() error { return nil }
 
 func newManagerWithFake(ttl time.Duration, fc *fakeSMClient) *Manager {
 	m := NewManager(ttl)
-	m.cli = fc
+	m.newClient = func(context.Context, clientConfig) (smClient, error) { return fc, nil }
 	return m
 }
 
@@ -36,7 +40,7 @@ func TestResolveSecretRoundTrip(t *testing.T) {
 	fc := &fakeSMClient{payload: `{"username":"u","password":"p"}`}
 	m := newManagerWithFake(time.Hour, fc)
 
-	got, err := m.ResolveSecret(context.Background(), "proj/secret", "password")
+	got, err := m.ResolveSecret(context.Background(), "proj/secret", "password", Options{})
 	if err != nil {
 		t.Fatalf("ResolveSecret: %v", err)
 	}
@@ -50,7 +54,7 @@ func TestResolveSecretCachesAcrossCalls(t *testing.T) {
 	m := newManagerWithFake(time.Hour, fc)
 
 	for i := 0; i < 3; i++ {
-		if _, err := m.ResolveSecret(context.Background(), "proj/sec", ""); err != nil {
+		if _, err := m.ResolveSecret(context.Background(), "proj/sec", "", Options{}); err != nil {
 			t.Fatalf("call %d: %v", i, err)
 		}
 	}
@@ -62,11 +66,11 @@ func TestResolveSecretCachesAcrossCalls(t *testing.T) {
 func TestExpiredEntryEvictedOnRead(t *testing.T) {
 	fc := &fakeSMClient{payload: "v"}
 	m := newManagerWithFake(time.Millisecond, fc)
-	if _, err := m.ResolveSecret(context.Background(), "proj/sec", ""); err != nil {
+	if _, err := m.ResolveSecret(context.Background(), "proj/sec", "", Options{}); err != nil {
 		t.Fatal(err)
 	}
 	time.Sleep(10 * time.Millisecond)
-	if _, err := m.ResolveSecret(context.Background(), "proj/sec", ""); err != nil {
+	if _, err := m.ResolveSecret(context.Background(), "proj/sec", "", Options{}); err != nil {
 		t.Fatal(err)
 	}
 	if fc.calls != 2 {
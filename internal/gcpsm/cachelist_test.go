@@ -7,8 +7,8 @@ import (
 
 func TestCachedKeysAndEvictAll(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("projects/p/secrets/b/versions/latest", "v1")
-	m.storeCache("projects/p/secrets/a/versions/latest", "v2")
+	m.storeCache("projects/p/secrets/b/versions/latest", "v1", false)
+	m.storeCache("projects/p/secrets/a/versions/latest", "v2", false)
 
 	keys := m.CachedKeys()
 	if len(keys) != 2 {
@@ -29,7 +29,7 @@ func TestCachedKeysAndEvictAll(t *testing.T) {
 
 func TestCachedKeysExcludesExpired(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("projects/p/secrets/x/versions/latest", "v")
+	m.storeCache("projects/p/secrets/x/versions/latest", "v", false)
 	for k, e := range m.cache {
 		e.expires = time.Now().Add(-time.Minute)
 		m.cache[k] = e
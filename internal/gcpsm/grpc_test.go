@@ -0,0 +1,212 @@
+package gcpsm
+
+import (
+	"context"
+	"hash/crc32"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeSecretManager serves AccessSecretVersion and GetSecret from
+// versions and labels, keyed by full resource name.
+type fakeSecretManager struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+	mu       sync.Mutex
+	versions map[string]string
+	labels   map[string]map[string]string
+	// corrupt, when set, is returned in place of the payload while
+	// the checksum still describes the original.
+	corrupt  string
+	accessed []string
+}
+
+func (f *fakeSecretManager) AccessSecretVersion(_ context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.accessed = append(f.accessed, req.GetName())
+	v, ok := f.versions[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetName())
+	}
+	sum := int64(crc32.Checksum([]byte(v), crc32c))
+	if f.corrupt != "" {
+		v = f.corrupt
+	}
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    req.GetName(),
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(v), DataCrc32C: &sum},
+	}, nil
+}
+
+func (f *fakeSecretManager) GetSecret(_ context.Context, req *secretmanagerpb.GetSecretRequest) (*secretmanagerpb.Secret, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.labels[req.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s not found", req.GetName())
+	}
+	return &secretmanagerpb.Secret{Name: req.GetName(), Labels: l}, nil
+}
+
+// newFakeServer starts f on a local port and returns a manager whose
+// clients all reach it through concreteClient, recording the config
+// each was created for.
+func newFakeServer(t *testing.T, f *fakeSecretManager) (*Manager, *[]clientConfig) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	secretmanagerpb.RegisterSecretManagerServiceServer(srv, f)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	var made []clientConfig
+	m := NewManager(time.Hour)
+	m.newClient = func(ctx context.Context, cfg clientConfig) (smClient, error) {
+		made = append(made, cfg)
+		c, err := secretmanager.NewClient(ctx,
+			option.WithEndpoint(lis.Addr().String()),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		)
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return &concreteClient{c: c}, nil
+	}
+	return m, &made
+}
+
+func TestGRPCRegionalAndImpersonation(t *testing.T) {
+	f := &fakeSecretManager{versions: map[string]string{
+		"projects/p/secrets/db/versions/latest":                        `{"password":"global"}`,
+		"projects/p/locations/europe-west1/secrets/db/versions/latest": `{"password":"regional"}`,
+		"projects/ops/secrets/token/versions/3":                        "ops-token",
+	}}
+	m, made := newFakeServer(t, f)
+	m.SetAuthConfig(func() []AuthConfig {
+		return []AuthConfig{
+			{Projects: []string{"ops"}, Impersonate: "reader@ops.iam.gserviceaccount.com", Delegates: []string{"hop@ops.iam.gserviceaccount.com"}},
+		}
+	})
+	ctx := t.Context()
+
+	cases := []struct {
+		ref, field string
+		opt        Options
+		want       string
+	}{
+		{"p/db", "password", Options{}, "global"},
+		{"p/db", "password", Options{Location: "europe-west1"}, "regional"},
+		{"projects/p/locations/europe-west1/secrets/db", "password", Options{}, "regional"},
+		{"ops/token/3", "", Options{}, "ops-token"},
+		{"p/db", "password", Options{Impersonate: "deploy@p.iam.gserviceaccount.com"}, "global"},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(ctx, tc.ref, tc.field, tc.opt)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %+v: got %q, %v; want %q", tc.ref, tc.opt, got, err, tc.want)
+		}
+	}
+
+	want := []clientConfig{
+		{},
+		{location: "europe-west1"},
+		{impersonate: "reader@ops.iam.gserviceaccount.com", delegates: []string{"hop@ops.iam.gserviceaccount.com"}},
+		{impersonate: "deploy@p.iam.gserviceaccount.com"},
+	}
+	if len(*made) != len(want) {
+		t.Fatalf("clients made for %+v, want %+v", *made, want)
+	}
+	for i := range want {
+		if (*made)[i].key() != want[i].key() {
+			t.Errorf("client %d made for %+v, want %+v", i, (*made)[i], want[i])
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, e := range m.CachedKeys() {
+		keys[e.Key] = true
+	}
+	for _, k := range []string{
+		"projects/p/secrets/db/versions/latest",
+		"projects/p/locations/europe-west1/secrets/db/versions/latest",
+		"projects/p/secrets/db/versions/latest (as deploy@p.iam.gserviceaccount.com)",
+	} {
+		if !keys[k] {
+			t.Errorf("%q not cached; have %v", k, keys)
+		}
+	}
+
+	if _, err := m.ResolveSecret(ctx, "projects/p/locations/us-east1/secrets/db", "", Options{Location: "europe-west1"}); err == nil {
+		t.Error("conflicting locations accepted")
+	}
+}
+
+func TestGRPCChecksum(t *testing.T) {
+	f := &fakeSecretManager{versions: map[string]string{
+		"projects/p/secrets/api/versions/latest": "s3cret",
+	}}
+	m, _ := newFakeServer(t, f)
+	ctx := t.Context()
+
+	if got, err := m.ResolveSecret(ctx, "p/api", "", Options{Checksum: true}); err != nil || got != "s3cret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	// An unverified cache entry is fetched again when a checksum is
+	// asked for.
+	m.EvictAll()
+	f.corrupt = "s3creX"
+	if got, err := m.ResolveSecret(ctx, "p/api", "", Options{}); err != nil || got != "s3creX" {
+		t.Fatalf("unchecked read: got %q, %v", got, err)
+	}
+	_, err := m.ResolveSecret(ctx, "p/api", "", Options{Checksum: true})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want checksum mismatch", err)
+	}
+	if len(f.accessed) != 3 {
+		t.Errorf("accessed %d times, want 3", len(f.accessed))
+	}
+}
+
+func TestGRPCLabels(t *testing.T) {
+	f := &fakeSecretManager{labels: map[string]map[string]string{
+		"projects/p/secrets/db": {"owner": "payments", "env": "prod"},
+	}}
+	m, _ := newFakeServer(t, f)
+	ctx := t.Context()
+
+	if got, err := m.ResolveSecret(ctx, "p/db", "labels.owner", Options{}); err != nil || got != "payments" {
+		t.Errorf("labels.owner: got %q, %v", got, err)
+	}
+	if got, err := m.ResolveSecret(ctx, "p/db/4", "labels", Options{}); err != nil || got != `{"env":"prod","owner":"payments"}` {
+		t.Errorf("labels: got %q, %v", got, err)
+	}
+	if _, err := m.ResolveSecret(ctx, "p/db", "labels.team", Options{}); err == nil {
+		t.Error("missing label resolved")
+	}
+	if len(f.accessed) != 0 {
+		t.Errorf("labels read the payload: %v", f.accessed)
+	}
+}
+
+func TestEndpointFor(t *testing.T) {
+	if got := endpointFor("europe-west1"); got != "secretmanager.europe-west1.rep.googleapis.com:443" {
+		t.Fatalf("endpointFor = %q", got)
+	}
+}
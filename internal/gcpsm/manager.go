@@ -3,7 +3,9 @@ package gcpsm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"sort"
 	"strings"
 	"sync"
@@ -11,6 +13,8 @@ import (
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
@@ -19,10 +23,13 @@ import (
 type cacheEntry struct {
 	sealed  *memprotect.Sealed
 	expires time.Time
+	// verified is set once the payload matched its CRC32C checksum.
+	verified bool
 }
 
 type smClient interface {
 	AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...any) (*secretmanagerpb.AccessSecretVersionResponse, error)
+	GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, opts ...any) (*secretmanagerpb.Secret, error)
 	Close() error
 }
 
@@ -35,27 +42,86 @@ func (c *concreteClient) AccessSecretVersion(ctx context.Context, req *secretman
 	return c.c.AccessSecretVersion(ctx, req)
 }
 
+func (c *concreteClient) GetSecret(ctx context.Context, req *secretmanagerpb.GetSecretRequest, _ ...any) (*secretmanagerpb.Secret, error) {
+	return c.c.GetSecret(ctx, req)
+}
+
 func (c *concreteClient) Close() error { return c.c.Close() }
 
+// Options qualify a reference beyond its resource name.
+type Options struct {
+	// Impersonate is the service account to act as, overriding any
+	// gcp_auth entry for the project.
+	Impersonate string
+	// Location selects a regional secret; shorthand references are
+	// then read from locations/LOCATION.
+	Location string
+	// Checksum verifies the payload against the CRC32C the service
+	// returns with it.
+	Checksum bool
+}
+
+// AuthConfig is one entry of the gcp_auth setting: the service account
+// to impersonate for the projects in Projects. An entry without
+// Projects applies to every project not listed elsewhere.
+type AuthConfig struct {
+	Projects    []string `mapstructure:"projects"`
+	Impersonate string   `mapstructure:"impersonate"`
+	Delegates   []string `mapstructure:"delegates"` // delegation chain, if any
+}
+
+// clientConfig is what a client is created for: an endpoint and the
+// identity to call it as.
+type clientConfig struct {
+	location    string
+	impersonate string
+	delegates   []string
+}
+
+func (c clientConfig) key() string {
+	return c.location + "|" + c.impersonate + "|" + strings.Join(c.delegates, ",")
+}
+
 type Manager struct {
-	mu        sync.Mutex
-	cli       smClient
-	cache     map[string]cacheEntry
-	ttl       time.Duration
-	newClient func(ctx context.Context) (smClient, error)
+	mu      sync.Mutex
+	clients map[string]smClient // by clientConfig.key
+	cache   map[string]cacheEntry
+	ttl     time.Duration
+	// newClient and authConfig are injectable for tests.
+	newClient  func(ctx context.Context, cfg clientConfig) (smClient, error)
+	authConfig func() []AuthConfig
 }
 
 func NewManager(ttl time.Duration) *Manager {
 	return &Manager{
-		cache: make(map[string]cacheEntry),
-		ttl:   ttl,
-		newClient: func(ctx context.Context) (smClient, error) {
-			c, err := secretmanager.NewClient(ctx)
+		clients: make(map[string]smClient),
+		cache:   make(map[string]cacheEntry),
+		ttl:     ttl,
+		newClient: func(ctx context.Context, cfg clientConfig) (smClient, error) {
+			var opts []option.ClientOption
+			if cfg.location != "" {
+				opts = append(opts, option.WithEndpoint(endpointFor(cfg.location)))
+			}
+			if cfg.impersonate != "" {
+				// The token source outlives this request, so it must
+				// not be tied to its context.
+				ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+					TargetPrincipal: cfg.impersonate,
+					Delegates:       cfg.delegates,
+					Scopes:          secretmanager.DefaultAuthScopes(),
+				})
+				if err != nil {
+					return nil, fmt.Errorf("impersonate %s: %w", cfg.impersonate, err)
+				}
+				opts = append(opts, option.WithTokenSource(ts))
+			}
+			c, err := secretmanager.NewClient(ctx, opts...)
 			if err != nil {
 				return nil, err
 			}
 			return &concreteClient{c: c}, nil
 		},
+		authConfig: func() []AuthConfig { return nil },
 	}
 }
 
@@ -65,52 +131,175 @@ func (m *Manager) SetTTL(ttl time.Duration) {
 	m.mu.Unlock()
 }
 
-func (m *Manager) init(ctx context.Context) error {
-	if m.cli != nil {
-		return nil
+// SetAuthConfig sets where per-project impersonation settings come
+// from. fn is consulted whenever a project is first used.
+func (m *Manager) SetAuthConfig(fn func() []AuthConfig) {
+	m.mu.Lock()
+	m.authConfig = fn
+	m.mu.Unlock()
+}
+
+// endpointFor is the regional endpoint serving secrets in location.
+func endpointFor(location string) string {
+	return "secretmanager." + location + ".rep.googleapis.com:443"
+}
+
+// clientFor returns the client for r read with opt. The caller holds
+// m.mu.
+func (m *Manager) clientFor(ctx context.Context, r resource, opt Options) (smClient, error) {
+	cfg := clientConfig{location: r.location, impersonate: opt.Impersonate}
+	if cfg.impersonate == "" {
+		if a := authFor(m.authConfig(), r.project); a != nil {
+			cfg.impersonate, cfg.delegates = strings.TrimSpace(a.Impersonate), a.Delegates
+		}
 	}
-	c, err := m.newClient(ctx)
+	if c, ok := m.clients[cfg.key()]; ok {
+		return c, nil
+	}
+	c, err := m.newClient(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("GCP credentials not configured: %w", err)
+		return nil, fmt.Errorf("GCP credentials not configured: %w", err)
 	}
-	m.cli = c
-	return nil
+	m.clients[cfg.key()] = c
+	return c, nil
+}
+
+func authFor(configs []AuthConfig, project string) *AuthConfig {
+	var fallback *AuthConfig
+	for _, c := range configs {
+		if len(c.Projects) == 0 {
+			if fallback == nil {
+				fallback = &c
+			}
+			continue
+		}
+		for _, p := range c.Projects {
+			if strings.TrimSpace(p) == project {
+				return &c
+			}
+		}
+	}
+	return fallback
+}
+
+// CacheKey is the key a reference is cached and listed under, and the
+// key Evict takes: its resource name, and the service account it is
+// read as when the reference names one.
+func CacheKey(ref string, opt Options) string {
+	key := strings.TrimSpace(ref)
+	if r, err := parseResource(ref, opt.Location); err == nil {
+		key = r.name()
+	}
+	if opt.Impersonate != "" {
+		key += " (as " + opt.Impersonate + ")"
+	}
+	return key
 }
 
 // ResolveSecret resolves a GCP Secret Manager secret.
 // ref format: "PROJECT/NAME" or "PROJECT/NAME/VERSION". Default version is "latest".
-// Fully-qualified "projects/PROJECT/secrets/NAME/versions/VERSION" is also accepted.
-func (m *Manager) ResolveSecret(ctx context.Context, ref, field string) (string, error) {
+// Fully-qualified "projects/PROJECT/[locations/LOCATION/]secrets/NAME[/versions/VERSION]"
+// is also accepted. The field `labels` returns the secret's labels as
+// JSON and `labels.KEY` a single label.
+func (m *Manager) ResolveSecret(ctx context.Context, ref, field string, opt Options) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	resource, err := buildResourceName(ref)
+	r, err := parseResource(ref, opt.Location)
 	if err != nil {
 		return "", err
 	}
+	if field == "labels" || strings.HasPrefix(field, "labels.") {
+		return m.labels(ctx, r, field, opt)
+	}
 
-	if raw, ok := m.readCache(resource); ok {
+	key := CacheKey(ref, opt)
+	if raw, verified, ok := m.readCache(ctx, key); ok && (verified || !opt.Checksum) {
 		return extractField(raw, field)
 	}
 
-	if err := m.init(ctx); err != nil {
+	cli, err := m.clientFor(ctx, r, opt)
+	if err != nil {
 		return "", err
 	}
 
-	resp, err := m.cli.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: resource})
+	resp, err := cli.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: r.name()})
 	if err != nil {
-		return "", fmt.Errorf("gcpsm: access %q: %w", resource, err)
+		return "", fmt.Errorf("gcpsm: access %q: %w", r.name(), err)
 	}
 
-	raw := ""
+	var data []byte
 	if resp.Payload != nil {
-		raw = string(resp.Payload.Data)
+		data = resp.Payload.Data
+	}
+	if opt.Checksum {
+		if err := verifyChecksum(resp.Payload, r.name()); err != nil {
+			return "", err
+		}
 	}
 
-	m.storeCache(resource, raw)
+	raw := string(data)
+	cacheinfo.NoteExpiry(ctx, m.storeCache(key, raw, opt.Checksum))
 	return extractField(raw, field)
 }
 
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyChecksum checks a payload against the CRC32C the service
+// computed for it, catching corruption between the service and us.
+func verifyChecksum(p *secretmanagerpb.SecretPayload, name string) error {
+	if p == nil || p.DataCrc32C == nil {
+		return fmt.Errorf("gcpsm: %q: response carries no checksum", name)
+	}
+	if got := int64(crc32.Checksum(p.Data, crc32c)); got != *p.DataCrc32C {
+		return fmt.Errorf("gcpsm: %q: checksum mismatch (payload %d, service %d)", name, got, *p.DataCrc32C)
+	}
+	return nil
+}
+
+// labels returns the labels of r's secret (see ResolveSecret). They are
+// cached like payloads, under the secret's name. The caller holds m.mu.
+func (m *Manager) labels(ctx context.Context, r resource, field string, opt Options) (string, error) {
+	key := r.secretName() + " labels"
+	if opt.Impersonate != "" {
+		key += " (as " + opt.Impersonate + ")"
+	}
+	raw, _, ok := m.readCache(ctx, key)
+	if !ok {
+		cli, err := m.clientFor(ctx, r, opt)
+		if err != nil {
+			return "", err
+		}
+		sec, err := cli.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{Name: r.secretName()})
+		if err != nil {
+			return "", fmt.Errorf("gcpsm: get %q: %w", r.secretName(), err)
+		}
+		labels := sec.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		b, err := json.Marshal(labels)
+		if err != nil {
+			return "", err
+		}
+		raw = string(b)
+		cacheinfo.NoteExpiry(ctx, m.storeCache(key, raw, false))
+	}
+	name, ok := strings.CutPrefix(field, "labels.")
+	if !ok {
+		return raw, nil
+	}
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(raw), &labels); err != nil {
+		return "", err
+	}
+	v, ok := labels[name]
+	if !ok {
+		return "", fmt.Errorf("gcpsm: label %q not set on %q", name, r.secretName())
+	}
+	return v, nil
+}
+
 func (m *Manager) EvictAll() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -134,7 +323,7 @@ func (m *Manager) CachedKeys() []cacheinfo.Entry {
 	return out
 }
 
-// Evict removes a single cache entry by key (the GCP secret resource name).
+// Evict removes a single cache entry by key (see CacheKey).
 func (m *Manager) Evict(key string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -144,32 +333,33 @@ func (m *Manager) Evict(key string) {
 	}
 }
 
-func (m *Manager) readCache(key string) (string, bool) {
+func (m *Manager) readCache(ctx context.Context, key string) (string, bool, bool) {
 	e, ok := m.cache[key]
 	if !ok {
-		return "", false
+		return "", false, false
 	}
 	if !time.Now().Before(e.expires) {
 		e.sealed.Destroy()
 		delete(m.cache, key)
-		return "", false
+		return "", false, false
 	}
 	pt, err := e.sealed.OpenString()
 	if err != nil {
-		return "", false
+		return "", false, false
 	}
-	return pt, true
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, e.verified, true
 }
 
-func (m *Manager) storeCache(key, raw string) {
+func (m *Manager) storeCache(key, raw string, verified bool) time.Time {
 	sealed, err := memprotect.SealString(raw)
 	if err != nil {
-		return
+		return time.Time{}
 	}
 	if old, ok := m.cache[key]; ok {
 		old.sealed.Destroy()
 	}
-	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl), verified: verified}
 	m.cache[key] = entry
 
 	go func(k string, e cacheEntry, d time.Duration) {
@@ -181,33 +371,79 @@ func (m *Manager) storeCache(key, raw string) {
 		m.mu.Unlock()
 		e.sealed.Destroy()
 	}(key, entry, m.ttl)
+	return entry.expires
 }
 
-// buildResourceName converts shorthand references into the fully-qualified
-// "projects/P/secrets/N/versions/V" form expected by the API.
-func buildResourceName(ref string) (string, error) {
+// resource is a secret version in Secret Manager.
+type resource struct {
+	project, location, secret, version string
+}
+
+// secretName is the name of the secret the version belongs to.
+func (r resource) secretName() string {
+	n := "projects/" + r.project
+	if r.location != "" {
+		n += "/locations/" + r.location
+	}
+	return n + "/secrets/" + r.secret
+}
+
+// name is the fully-qualified version name expected by the API.
+func (r resource) name() string {
+	return r.secretName() + "/versions/" + r.version
+}
+
+// parseResource reads a shorthand "PROJECT/NAME[/VERSION]" reference,
+// regional if location is set, or a fully-qualified
+// "projects/P/[locations/L/]secrets/N[/versions/V]" name. The version
+// defaults to "latest".
+func parseResource(ref, location string) (resource, error) {
 	ref = strings.TrimSpace(ref)
+	location = strings.TrimSpace(location)
 	if ref == "" {
-		return "", fmt.Errorf("empty gcpsm reference")
-	}
-	if strings.HasPrefix(ref, "projects/") {
-		return ref, nil
+		return resource{}, fmt.Errorf("empty gcpsm reference")
 	}
 	parts := strings.Split(ref, "/")
+	for _, p := range parts {
+		if p == "" {
+			return resource{}, fmt.Errorf("gcpsm: empty segment in %q", ref)
+		}
+	}
+	r := resource{location: location, version: "latest"}
+	if parts[0] == "projects" {
+		const usage = "gcpsm: resource must be projects/P/[locations/L/]secrets/N[/versions/V]"
+		if len(parts) < 4 {
+			return resource{}, errors.New(usage)
+		}
+		r.project, parts = parts[1], parts[2:]
+		if parts[0] == "locations" {
+			if len(parts) < 4 {
+				return resource{}, errors.New(usage)
+			}
+			if location != "" && location != parts[1] {
+				return resource{}, fmt.Errorf("gcpsm: location=%s conflicts with %q", location, ref)
+			}
+			r.location, parts = parts[1], parts[2:]
+		}
+		switch {
+		case len(parts) == 2 && parts[0] == "secrets":
+			r.secret = parts[1]
+		case len(parts) == 4 && parts[0] == "secrets" && parts[2] == "versions":
+			r.secret, r.version = parts[1], parts[3]
+		default:
+			return resource{}, errors.New(usage)
+		}
+		return r, nil
+	}
 	switch len(parts) {
 	case 2:
-		if parts[0] == "" || parts[1] == "" {
-			return "", fmt.Errorf("gcpsm: reference must be PROJECT/NAME[/VERSION]")
-		}
-		return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", parts[0], parts[1]), nil
+		r.project, r.secret = parts[0], parts[1]
 	case 3:
-		if parts[0] == "" || parts[1] == "" || parts[2] == "" {
-			return "", fmt.Errorf("gcpsm: reference must be PROJECT/NAME[/VERSION]")
-		}
-		return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", parts[0], parts[1], parts[2]), nil
+		r.project, r.secret, r.version = parts[0], parts[1], parts[2]
 	default:
-		return "", fmt.Errorf("gcpsm: reference must be PROJECT/NAME[/VERSION]")
+		return resource{}, fmt.Errorf("gcpsm: reference must be PROJECT/NAME[/VERSION]")
 	}
+	return r, nil
 }
 
 func extractField(value, field string) (string, error) {
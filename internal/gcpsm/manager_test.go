@@ -2,7 +2,7 @@ package gcpsm
 
 import "testing"
 
-func TestBuildResourceName(t *testing.T) {
+func TestParseResource(t *testing.T) {
 	cases := []struct {
 		in      string
 		want    string
@@ -11,6 +11,10 @@ func TestBuildResourceName(t *testing.T) {
 		{"my-proj/mysecret", "projects/my-proj/secrets/mysecret/versions/latest", false},
 		{"my-proj/mysecret/5", "projects/my-proj/secrets/mysecret/versions/5", false},
 		{"projects/my-proj/secrets/mysecret/versions/7", "projects/my-proj/secrets/mysecret/versions/7", false},
+		{"projects/my-proj/locations/europe-west1/secrets/mysecret/versions/2", "projects/my-proj/locations/europe-west1/secrets/mysecret/versions/2", false},
+		{"projects/my-proj/secrets/mysecret", "projects/my-proj/secrets/mysecret/versions/latest", false},
+		{"projects/my-proj/keys/mysecret", "", true},
+		{"projects/my-proj/locations/europe-west1", "", true},
 		{"nosep", "", true},
 		{"/mysecret", "", true},
 		{"my-proj/", "", true},
@@ -18,11 +22,11 @@ func TestBuildResourceName(t *testing.T) {
 	}
 	for _, tc := range cases {
 		t.Run(tc.in, func(t *testing.T) {
-			got, err := buildResourceName(tc.in)
+			r, err := parseResource(tc.in, "")
 			if (err != nil) != tc.wantErr {
 				t.Fatalf("err=%v wantErr=%v", err, tc.wantErr)
 			}
-			if err == nil && got != tc.want {
+			if got := r.name(); err == nil && got != tc.want {
 				t.Fatalf("got %q want %q", got, tc.want)
 			}
 		})
@@ -0,0 +1,232 @@
+// Package gitcred implements the git credential-helper protocol on top
+// of secret references mapped to hosts and paths in credentials.yaml.
+// The docker credential helper matches registries against the same
+// rules.
+package gitcred
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule maps a URL pattern to the references holding its credentials.
+// Username and Password are secret references or literal values.
+type Rule struct {
+	Match    string `yaml:"match"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Request is the attribute set git sends to a helper.
+type Request struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+}
+
+// FilePath is credentials.yaml in the settings directory, or
+// $DESKTOP_SECRETS_CREDENTIALS_FILE.
+func FilePath() (string, error) {
+	if override := os.Getenv("DESKTOP_SECRETS_CREDENTIALS_FILE"); override != "" {
+		return override, nil
+	}
+	dir, err := utils.GetSettingsDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "credentials.yaml"), nil
+}
+
+// LoadRules reads the rules from path. A missing file has no rules.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, r := range rules {
+		if _, _, _, err := splitPattern(r.Match); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", path, i+1, err)
+		}
+		if strings.TrimSpace(r.Password) == "" {
+			return nil, fmt.Errorf("%s: rule %d (%s): password is required", path, i+1, r.Match)
+		}
+	}
+	return rules, nil
+}
+
+// ReadRequest reads key=value lines up to a blank line or EOF.
+// Attributes a helper does not need are ignored.
+func ReadRequest(r io.Reader) (Request, error) {
+	var req Request
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return Request{}, fmt.Errorf("invalid credential line %q", line)
+		}
+		switch key {
+		case "protocol":
+			req.Protocol = val
+		case "host":
+			req.Host = val
+		case "path":
+			req.Path = val
+		case "username":
+			req.Username = val
+		case "url":
+			if err := req.setURL(val); err != nil {
+				return Request{}, err
+			}
+		}
+	}
+	return req, sc.Err()
+}
+
+// ParseURL turns a URL into the request git would send for it. A
+// URL without a scheme is taken as https, as docker does for registry
+// names.
+func ParseURL(s string) (Request, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	var req Request
+	err := req.setURL(s)
+	return req, err
+}
+
+func (req *Request) setURL(s string) error {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return fmt.Errorf("invalid credential url %q", s)
+	}
+	req.Protocol = scheme
+	host, p, _ := strings.Cut(rest, "/")
+	if user, h, ok := strings.Cut(host, "@"); ok {
+		req.Username, _, _ = strings.Cut(user, ":")
+		host = h
+	}
+	req.Host = host
+	req.Path = p
+	return nil
+}
+
+// Find returns the first rule whose pattern matches req.
+func Find(rules []Rule, req Request) (Rule, bool) {
+	if m := Matching(rules, req); len(m) > 0 {
+		return m[0], true
+	}
+	return Rule{}, false
+}
+
+// Matching returns every rule whose pattern matches req, in file
+// order, for callers that may pass over a rule (see UsernameMatches).
+func Matching(rules []Rule, req Request) []Rule {
+	var out []Rule
+	for _, r := range rules {
+		if matches(r.Match, req) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// UsernameMatches reports whether a rule whose username resolved to
+// username may answer req: git named no user (https://alice@host or
+// credential.username), the rule names none, or they are the same.
+func UsernameMatches(req Request, username string) bool {
+	return req.Username == "" || username == "" || username == req.Username
+}
+
+// matches reports whether pattern ("https://git.corp/*",
+// "https://*.corp", "ssh://git.corp/team/*") covers req. The scheme
+// must match; in the host * stands for any run of characters
+// without a '/', in the path for any run at all. Git only sends the
+// path with credential.useHttpPath, so a rule that names more than
+// "/*" does not match requests without one.
+func matches(pattern string, req Request) bool {
+	scheme, host, p, err := splitPattern(pattern)
+	if err != nil || !strings.EqualFold(scheme, req.Protocol) {
+		return false
+	}
+	if !globMatch(strings.ToLower(host), strings.ToLower(req.Host), "[^/]*") {
+		return false
+	}
+	return globMatch(p, strings.Trim(req.Path, "/"), ".*")
+}
+
+// splitPattern splits a rule pattern into scheme, host and path
+// without its leading and trailing slashes. A pattern without a path
+// is taken as the host's every path.
+func splitPattern(pattern string) (scheme, host, path string, err error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(pattern), "://")
+	if !ok || scheme == "" {
+		return "", "", "", fmt.Errorf("match %q needs a scheme, e.g. https://%s", pattern, pattern)
+	}
+	host, path, ok = strings.Cut(rest, "/")
+	if host == "" {
+		return "", "", "", fmt.Errorf("match %q has no host", pattern)
+	}
+	if !ok {
+		path = "*"
+	}
+	return scheme, host, strings.Trim(path, "/"), nil
+}
+
+// globMatch matches s against pattern, where * stands for star.
+func globMatch(pattern, s, star string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, star) + "$")
+	return err == nil && re.MatchString(s)
+}
+
+// WriteCredential writes the answer to a get request. Values git
+// cannot carry (newlines, NUL) are refused rather than truncated, as
+// is a password for another user than the one git asked for.
+func WriteCredential(w io.Writer, req Request, username, password string) error {
+	for _, v := range []string{username, password} {
+		if strings.ContainsAny(v, "\n\x00") {
+			return errors.New("credential contains a newline or NUL byte")
+		}
+	}
+	if !UsernameMatches(req, username) {
+		return fmt.Errorf("credential is for %q, not %q", username, req.Username)
+	}
+	var b strings.Builder
+	if req.Protocol != "" {
+		fmt.Fprintf(&b, "protocol=%s\n", req.Protocol)
+	}
+	if req.Host != "" {
+		fmt.Fprintf(&b, "host=%s\n", req.Host)
+	}
+	if username != "" {
+		fmt.Fprintf(&b, "username=%s\n", username)
+	}
+	fmt.Fprintf(&b, "password=%s\n", password)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,137 @@
+package gitcred
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadRequest(t *testing.T) {
+	in := "protocol=https\nhost=git.corp:8443\npath=team/app.git\nwwwauth[]=Basic realm=x\n\nignored=1\n"
+	req, err := ReadRequest(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Request{Protocol: "https", Host: "git.corp:8443", Path: "team/app.git"}
+	if req != want {
+		t.Fatalf("got %+v, want %+v", req, want)
+	}
+
+	req, err = ReadRequest(strings.NewReader("url=https://alice@git.corp/team/app.git\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Request{Protocol: "https", Host: "git.corp", Path: "team/app.git", Username: "alice"}
+	if req != want {
+		t.Fatalf("url: got %+v, want %+v", req, want)
+	}
+
+	if _, err := ReadRequest(strings.NewReader("garbage\n")); err == nil {
+		t.Fatal("expected error for a line without =")
+	}
+}
+
+func TestFind(t *testing.T) {
+	rules := []Rule{
+		{Match: "https://git.corp/team-a/*", Password: "a"},
+		{Match: "https://git.corp/*", Password: "corp"},
+		{Match: "https://*.example.com", Password: "example"},
+		{Match: "https://git.corp:8443", Password: "port"},
+	}
+	cases := []struct {
+		req  Request
+		want string
+	}{
+		{Request{Protocol: "https", Host: "git.corp", Path: "team-a/app.git"}, "a"},
+		{Request{Protocol: "https", Host: "git.corp", Path: "team-b/app.git"}, "corp"},
+		{Request{Protocol: "https", Host: "git.corp"}, "corp"},
+		{Request{Protocol: "https", Host: "GIT.example.com"}, "example"},
+		{Request{Protocol: "https", Host: "git.corp:8443", Path: "x"}, "port"},
+		{Request{Protocol: "http", Host: "git.corp"}, ""},
+		{Request{Protocol: "https", Host: "example.com"}, ""},
+		{Request{Protocol: "https", Host: "evil.com/git.corp"}, ""},
+	}
+	for _, c := range cases {
+		r, ok := Find(rules, c.req)
+		if got := r.Password; got != c.want || ok != (c.want != "") {
+			t.Errorf("Find(%+v) = %q, %v; want %q", c.req, got, ok, c.want)
+		}
+	}
+}
+
+func TestMatchingSkipsOtherUsers(t *testing.T) {
+	rules := []Rule{
+		{Match: "https://git.corp/*", Username: "alice", Password: "a"},
+		{Match: "https://github.com/*", Username: "carol", Password: "c"},
+		{Match: "https://git.corp/*", Username: "bob", Password: "b"},
+		{Match: "https://git.corp/*", Password: "any"},
+	}
+	answer := func(req Request) string {
+		for _, r := range Matching(rules, req) {
+			if UsernameMatches(req, r.Username) {
+				return r.Password
+			}
+		}
+		return ""
+	}
+	cases := map[string]string{"": "a", "alice": "a", "bob": "b", "dave": "any"}
+	for user, want := range cases {
+		if got := answer(Request{Protocol: "https", Host: "git.corp", Username: user}); got != want {
+			t.Errorf("username %q: answered %q, want %q", user, got, want)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "credentials.yaml")
+
+	rules, err := LoadRules(path)
+	if err != nil || rules != nil {
+		t.Fatalf("missing file: %v, %v", rules, err)
+	}
+
+	yml := `
+- match: https://git.corp/*
+  username: keepass(&work|/Git/corp|UserName)
+  password: keepass(&work|/Git/corp|Password)
+`
+	if err := os.WriteFile(path, []byte(yml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err = LoadRules(path)
+	if err != nil || len(rules) != 1 || rules[0].Username != "keepass(&work|/Git/corp|UserName)" {
+		t.Fatalf("got %+v, %v", rules, err)
+	}
+
+	for _, bad := range []string{
+		"- match: git.corp\n  password: x\n",
+		"- match: https://git.corp\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules(path); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestWriteCredential(t *testing.T) {
+	var b strings.Builder
+	req := Request{Protocol: "https", Host: "git.corp"}
+	if err := WriteCredential(&b, req, "alice", "s3cr3t"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "protocol=https\nhost=git.corp\nusername=alice\npassword=s3cr3t\n"; b.String() != want {
+		t.Fatalf("got %q, want %q", b.String(), want)
+	}
+	if err := WriteCredential(&b, req, "alice", "line1\nline2"); err == nil {
+		t.Fatal("expected error for a multi-line password")
+	}
+	req.Username = "bob"
+	if err := WriteCredential(&b, req, "alice", "s3cr3t"); err == nil {
+		t.Fatal("expected error for another user's password")
+	}
+}
@@ -17,15 +17,22 @@ import (
 // A tight umask is set around the bind so there is no window where the socket
 // inode is world-connectable.
 func Listen() (net.Listener, Endpoint, error) {
+	return ListenNamed(fmt.Sprintf("desktop-secrets-%s", randomToken()))
+}
+
+// ListenNamed is Listen for a fixed name, for sockets other programs
+// find by path (the ssh-agent socket). The socket is name.sock in the
+// runtime directory.
+func ListenNamed(name string) (net.Listener, Endpoint, error) {
 	dir, err := utils.GetRuntimeDirectory()
 	if err != nil {
 		return nil, "", err
 	}
-	path := filepath.Join(dir, fmt.Sprintf("desktop-secrets-%s.sock", randomToken()))
+	path := filepath.Join(dir, name+".sock")
 
-	// Best-effort cleanup of stale socket at the chosen path. Path
-	// includes a random token so collisions with an unrelated daemon
-	// are vanishingly rare; remove only if it exists.
+	// Best-effort cleanup of a stale socket at the chosen path. The
+	// runtime directory is per-user and the daemon is single-instance,
+	// so anything there is left over from an earlier run.
 	_ = os.Remove(path)
 
 	addr, err := net.ResolveUnixAddr("unix", path)
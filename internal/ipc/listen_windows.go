@@ -15,7 +15,19 @@ import (
 // Listen creates a new named pipe and returns the listener and its
 // public endpoint string. ACL restricts access to the current user.
 func Listen() (net.Listener, Endpoint, error) {
-	name := fmt.Sprintf(`\\.\pipe\desktop-secrets-%s`, randomToken())
+	return ListenNamed(fmt.Sprintf("desktop-secrets-%s", randomToken()))
+}
+
+// ListenNamed is Listen for a fixed name, for pipes other programs
+// find by path (the ssh-agent pipe): \\.\pipe\name-SID. Pipe names
+// are machine-wide, so the current user's SID keeps another session
+// from taking the name first.
+func ListenNamed(name string) (net.Listener, Endpoint, error) {
+	sid, err := currentUserSID()
+	if err != nil {
+		return nil, "", fmt.Errorf("pipe name: %w", err)
+	}
+	name = `\\.\pipe\` + name + "-" + sid
 
 	sd, err := currentUserSDDL()
 	if err != nil {
@@ -48,6 +60,16 @@ func Dial(ctx context.Context, _ string, addr string) (net.Conn, error) {
 // currentUserSDDL builds a security descriptor restricting the pipe
 // to the current user (and SYSTEM).
 func currentUserSDDL() (string, error) {
+	sid, err := currentUserSID()
+	if err != nil {
+		return "", err
+	}
+	// Owner: current user; DACL: GenericAll for current user + SYSTEM,
+	// no other ACEs.
+	return fmt.Sprintf("O:%sG:%sD:(A;;GA;;;%s)(A;;GA;;;SY)", sid, sid, sid), nil
+}
+
+func currentUserSID() (string, error) {
 	u, err := user.Current()
 	if err != nil {
 		return "", err
@@ -56,7 +78,5 @@ func currentUserSDDL() (string, error) {
 	if sid == "" {
 		return "", fmt.Errorf("empty user SID")
 	}
-	// Owner: current user; DACL: GenericAll for current user + SYSTEM,
-	// no other ACEs.
-	return fmt.Sprintf("O:%sG:%sD:(A;;GA;;;%s)(A;;GA;;;SY)", sid, sid, sid), nil
+	return sid, nil
 }
@@ -0,0 +1,34 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveSecretCached(t *testing.T) {
+	a := newAPIServer(t, map[string]map[string]string{"apps/db": {"password": "p", "user": "u"}})
+	a.tokens["dev-token"] = true
+	cfg := writeKubeconfig(t, a, testUsers)
+	m := NewManager(time.Hour)
+	m.SetKubeconfig(func() string { return cfg })
+
+	for _, key := range []string{"password", "user", "password"} {
+		if _, err := m.ResolveSecret(t.Context(), "db", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := a.requests.Load(); n != 1 {
+		t.Fatalf("expected one API request, got %d", n)
+	}
+
+	m.Evict("apps/db")
+	if len(m.CachedKeys()) != 0 {
+		t.Fatal("expected Evict by reference to resolve the current context")
+	}
+	if _, err := m.ResolveSecret(t.Context(), "dev/apps/db", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.requests.Load(); n != 2 {
+		t.Fatalf("expected a second API request, got %d", n)
+	}
+}
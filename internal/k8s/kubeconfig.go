@@ -0,0 +1,267 @@
+package k8s
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string  `yaml:"name"`
+		Cluster cluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string      `yaml:"name"`
+		Context kubeContext `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string   `yaml:"name"`
+		User authInfo `yaml:"user"`
+	} `yaml:"users"`
+}
+
+type cluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type kubeContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+type authInfo struct {
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  *execConfig `yaml:"exec"`
+	AuthProvider          *struct {
+		Name string `yaml:"name"`
+	} `yaml:"auth-provider"`
+}
+
+type execConfig struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+	InstallHint string `yaml:"installHint"`
+}
+
+// target is everything needed to talk to one context's cluster.
+type target struct {
+	context   string
+	namespace string
+	userName  string
+	cluster   cluster
+	user      authInfo
+}
+
+// kubeconfigPaths returns the files to merge: the configured path
+// list, else $KUBECONFIG, else ~/.kube/config, as kubectl does.
+func kubeconfigPaths(configured string) ([]string, error) {
+	list := configured
+	if list == "" {
+		list = os.Getenv("KUBECONFIG")
+	}
+	if list != "" {
+		var out []string
+		for _, p := range filepath.SplitList(list) {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, os.ExpandEnv(p))
+			}
+		}
+		return out, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Join(home, ".kube", "config")}, nil
+}
+
+// loadTarget merges the kubeconfig files and looks up contextName
+// (the current context when empty). As with kubectl, the first file
+// to define a name wins, and relative file references are resolved
+// against the file that holds them.
+func loadTarget(paths []string, contextName string) (*target, error) {
+	var merged kubeconfig
+	seen := map[string]bool{}
+	loaded := 0
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var kc kubeconfig
+		if err := yaml.Unmarshal(data, &kc); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", p, err)
+		}
+		loaded++
+		dir := filepath.Dir(p)
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = kc.CurrentContext
+		}
+		for _, c := range kc.Clusters {
+			if !seen["cluster/"+c.Name] {
+				seen["cluster/"+c.Name] = true
+				c.Cluster.CertificateAuthority = relTo(dir, c.Cluster.CertificateAuthority)
+				merged.Clusters = append(merged.Clusters, c)
+			}
+		}
+		for _, c := range kc.Contexts {
+			if !seen["context/"+c.Name] {
+				seen["context/"+c.Name] = true
+				merged.Contexts = append(merged.Contexts, c)
+			}
+		}
+		for _, u := range kc.Users {
+			if !seen["user/"+u.Name] {
+				seen["user/"+u.Name] = true
+				u.User.TokenFile = relTo(dir, u.User.TokenFile)
+				u.User.ClientCertificate = relTo(dir, u.User.ClientCertificate)
+				u.User.ClientKey = relTo(dir, u.User.ClientKey)
+				if u.User.Exec != nil && strings.ContainsRune(u.User.Exec.Command, filepath.Separator) {
+					u.User.Exec.Command = relTo(dir, u.User.Exec.Command)
+				}
+				merged.Users = append(merged.Users, u)
+			}
+		}
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no kubeconfig found (looked in %s)", strings.Join(paths, ", "))
+	}
+
+	if contextName == "" {
+		contextName = merged.CurrentContext
+	}
+	if contextName == "" {
+		return nil, errors.New("no context given and no current-context set")
+	}
+	t := &target{context: contextName}
+	found := false
+	for _, c := range merged.Contexts {
+		if c.Name == contextName {
+			t.namespace, t.userName, found = c.Context.Namespace, c.Context.User, true
+			clusterName := c.Context.Cluster
+			for _, cl := range merged.Clusters {
+				if cl.Name == clusterName {
+					t.cluster = cl.Cluster
+				}
+			}
+			if t.cluster.Server == "" {
+				return nil, fmt.Errorf("context %q: cluster %q not found or has no server", contextName, clusterName)
+			}
+			for _, u := range merged.Users {
+				if u.Name == c.Context.User {
+					t.user = u.User
+				}
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+	if t.user.AuthProvider != nil {
+		return nil, fmt.Errorf("context %q: auth-provider %q is not supported; use an exec plugin", contextName, t.user.AuthProvider.Name)
+	}
+	return t, nil
+}
+
+func relTo(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
+
+// tlsConfig builds the TLS settings for the cluster, including a
+// client certificate from the kubeconfig or an exec plugin.
+func (t *target) tlsConfig(certPEM, keyPEM []byte) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.cluster.TLSServerName,
+		InsecureSkipVerify: t.cluster.InsecureSkipTLSVerify,
+	}
+	ca, err := fileOrData(t.cluster.CertificateAuthority, t.cluster.CertificateAuthorityData)
+	if err != nil {
+		return nil, fmt.Errorf("certificate authority: %w", err)
+	}
+	if ca != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("certificate authority: no PEM certificates found")
+		}
+		cfg.RootCAs = pool
+	}
+	if certPEM == nil {
+		if certPEM, err = fileOrData(t.user.ClientCertificate, t.user.ClientCertificateData); err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		if keyPEM, err = fileOrData(t.user.ClientKey, t.user.ClientKeyData); err != nil {
+			return nil, fmt.Errorf("client key: %w", err)
+		}
+	}
+	if certPEM != nil || keyPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// fileOrData returns the inline base64 data if set, else the file's
+// contents, else nil.
+func fileOrData(path, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	}
+	if path != "" {
+		return os.ReadFile(path)
+	}
+	return nil, nil
+}
+
+// staticAuth sets the request's credentials from the kubeconfig user,
+// for users without an exec plugin.
+func (t *target) staticAuth(req *http.Request) error {
+	switch {
+	case t.user.Token != "":
+		req.Header.Set("Authorization", "Bearer "+t.user.Token)
+	case t.user.TokenFile != "":
+		b, err := os.ReadFile(t.user.TokenFile)
+		if err != nil {
+			return fmt.Errorf("token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(b)))
+	case t.user.Username != "":
+		req.SetBasicAuth(t.user.Username, t.user.Password)
+	}
+	return nil
+}
@@ -0,0 +1,436 @@
+// Package k8s reads Kubernetes Secrets with the user's kubeconfig,
+// without kubectl or client-go.
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+)
+
+type execRunner func(ctx context.Context, command string, args, env []string) ([]byte, error)
+
+type cacheEntry struct {
+	sealed  *memprotect.Sealed
+	expires time.Time
+}
+
+// execStatus is the status of an ExecCredential returned by a plugin.
+type execStatus struct {
+	Token                 string    `json:"token"`
+	ClientCertificateData string    `json:"clientCertificateData"`
+	ClientKeyData         string    `json:"clientKeyData"`
+	ExpirationTimestamp   time.Time `json:"expirationTimestamp"`
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+type Manager struct {
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	// creds holds exec plugin credentials by kubeconfig user until
+	// they expire.
+	creds map[string]cacheEntry
+	ttl   time.Duration
+	// runExec and kubeconfig are injectable for tests.
+	runExec    execRunner
+	kubeconfig func() string
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		cache: make(map[string]cacheEntry),
+		creds: make(map[string]cacheEntry),
+		ttl:   ttl,
+		runExec: func(ctx context.Context, command string, args, env []string) ([]byte, error) {
+			cmd := exec.CommandContext(ctx, command, args...)
+			cmd.Env = append(os.Environ(), env...)
+			out, err := cmd.Output()
+			if err != nil {
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					return nil, fmt.Errorf("%s: %s", command, strings.TrimSpace(string(ee.Stderr)))
+				}
+				return nil, err
+			}
+			return out, nil
+		},
+		kubeconfig: func() string { return "" },
+	}
+}
+
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+// SetKubeconfig sets the kubeconfig path list. fn is consulted on every
+// lookup; when it returns "" $KUBECONFIG or ~/.kube/config is used.
+func (m *Manager) SetKubeconfig(fn func() string) {
+	m.mu.Lock()
+	m.kubeconfig = fn
+	m.mu.Unlock()
+}
+
+// ResolveSecret reads the Secret named by ref, "[CONTEXT/][NAMESPACE/]NAME",
+// and returns the decoded value of key. Without a context the
+// kubeconfig's current context is used, without a namespace the
+// context's namespace or "default". An empty key is allowed when the
+// Secret holds a single entry.
+//
+// The decoded Secret is cached until the TTL expires. The cache lock
+// is not held while the exec plugin or the API request runs.
+func (m *Manager) ResolveSecret(ctx context.Context, ref, key string) (string, error) {
+	m.mu.Lock()
+	t, ns, name, err := m.target(ref)
+	if err != nil {
+		m.mu.Unlock()
+		return "", fmt.Errorf("k8s: %w", err)
+	}
+	cacheKey := t.context + "/" + ns + "/" + name
+	raw, ok := m.readCache(ctx, cacheKey)
+	m.mu.Unlock()
+	if ok {
+		return selectKey(raw, key)
+	}
+
+	data, err := m.fetch(ctx, t, ns, name)
+	if errors.Is(err, errUnauthorized) && t.user.Exec != nil {
+		// The plugin's token may have been revoked before it expired.
+		m.mu.Lock()
+		m.dropCred(t.userName)
+		m.mu.Unlock()
+		data, err = m.fetch(ctx, t, ns, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("k8s: %s: %w", cacheKey, err)
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, string(b)))
+	m.mu.Unlock()
+	return selectKey(string(b), key)
+}
+
+func (m *Manager) target(ref string) (t *target, ns, name string, err error) {
+	contextName, ns, name, err := parseRef(ref)
+	if err != nil {
+		return nil, "", "", err
+	}
+	paths, err := kubeconfigPaths(strings.TrimSpace(m.kubeconfig()))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if t, err = loadTarget(paths, contextName); err != nil {
+		return nil, "", "", err
+	}
+	if ns == "" {
+		ns = t.namespace
+	}
+	if ns == "" {
+		ns = "default"
+	}
+	return t, ns, name, nil
+}
+
+// parseRef splits "[CONTEXT/][NAMESPACE/]NAME" from the right, since
+// context names (EKS ARNs, for one) may themselves contain slashes.
+func parseRef(ref string) (contextName, ns, name string, err error) {
+	parts := strings.Split(strings.TrimSpace(ref), "/")
+	name = strings.TrimSpace(parts[len(parts)-1])
+	if name == "" {
+		return "", "", "", errors.New("empty secret name")
+	}
+	if len(parts) >= 2 {
+		ns = strings.TrimSpace(parts[len(parts)-2])
+	}
+	if len(parts) >= 3 {
+		contextName = strings.TrimSpace(strings.Join(parts[:len(parts)-2], "/"))
+	}
+	return contextName, ns, name, nil
+}
+
+// fetch GETs the Secret and returns its decoded data. It runs without
+// m.mu held.
+func (m *Manager) fetch(ctx context.Context, t *target, ns, name string) (map[string]string, error) {
+	var certPEM, keyPEM []byte
+	var token string
+	if t.user.Exec != nil {
+		st, err := m.execCredential(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		token = st.Token
+		if st.ClientCertificateData != "" {
+			certPEM, keyPEM = []byte(st.ClientCertificateData), []byte(st.ClientKeyData)
+		}
+	}
+	tlsCfg, err := t.tlsConfig(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsCfg, Proxy: http.ProxyFromEnvironment},
+	}
+	// The transport is built per request; don't leave its connection
+	// idle behind it.
+	defer client.CloseIdleConnections()
+
+	u := strings.TrimRight(t.cluster.Server, "/") + "/api/v1/namespaces/" + url.PathEscape(ns) + "/secrets/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if err := t.staticAuth(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w (context %q)", errUnauthorized, t.context)
+	default:
+		var st struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &st) == nil && st.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, st.Message)
+		}
+		return nil, errors.New(resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("parse secret: %w", err)
+	}
+	out := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decode %q: %w", k, err)
+		}
+		out[k] = string(b)
+	}
+	return out, nil
+}
+
+// execCredential runs the user's exec plugin, or returns its cached
+// result while that hasn't expired. Credentials without an expiry are
+// not cached, as the plugin is then expected to cache them itself.
+// It runs without m.mu held.
+func (m *Manager) execCredential(ctx context.Context, t *target) (*execStatus, error) {
+	var cached string
+	m.mu.Lock()
+	if e, ok := m.creds[t.userName]; ok && time.Now().Before(e.expires) {
+		cached, _ = e.sealed.OpenString()
+	}
+	m.mu.Unlock()
+	if cached != "" {
+		var st execStatus
+		if json.Unmarshal([]byte(cached), &st) == nil {
+			return &st, nil
+		}
+	}
+
+	cfg := t.user.Exec
+	apiVersion := cfg.APIVersion
+	if apiVersion == "" {
+		apiVersion = "client.authentication.k8s.io/v1"
+	}
+	info, _ := json.Marshal(map[string]any{
+		"apiVersion": apiVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]any{"interactive": false},
+	})
+	env := []string{"KUBERNETES_EXEC_INFO=" + string(info)}
+	for _, e := range cfg.Env {
+		env = append(env, e.Name+"="+e.Value)
+	}
+
+	out, err := m.runExec(ctx, cfg.Command, cfg.Args, env)
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) && cfg.InstallHint != "" {
+			return nil, fmt.Errorf("exec plugin: %w\n%s", err, cfg.InstallHint)
+		}
+		return nil, fmt.Errorf("exec plugin: %w", err)
+	}
+	var cred struct {
+		Status *execStatus `json:"status"`
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return nil, fmt.Errorf("exec plugin: parse ExecCredential: %w", err)
+	}
+	if cred.Status == nil || (cred.Status.Token == "" && cred.Status.ClientCertificateData == "") {
+		return nil, errors.New("exec plugin: ExecCredential has no token or client certificate")
+	}
+
+	if exp := cred.Status.ExpirationTimestamp; !exp.IsZero() {
+		raw, _ := json.Marshal(cred.Status)
+		if sealed, err := memprotect.SealString(string(raw)); err == nil {
+			m.mu.Lock()
+			m.dropCred(t.userName)
+			m.creds[t.userName] = cacheEntry{sealed: sealed, expires: exp}
+			m.mu.Unlock()
+		}
+	}
+	return cred.Status, nil
+}
+
+// dropCred forgets user's exec plugin credential. The caller holds m.mu.
+func (m *Manager) dropCred(user string) {
+	if e, ok := m.creds[user]; ok {
+		e.sealed.Destroy()
+		delete(m.creds, user)
+	}
+}
+
+// selectKey returns one entry of the cached Secret data.
+func selectKey(raw, key string) (string, error) {
+	var data map[string]string
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return "", fmt.Errorf("k8s: cached secret is corrupt: %w", err)
+	}
+	if key != "" {
+		v, ok := data[key]
+		if !ok {
+			return "", fmt.Errorf("k8s: key %q not found (have %s)", key, strings.Join(sortedKeys(data), ", "))
+		}
+		return v, nil
+	}
+	if len(data) == 1 {
+		for _, v := range data {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("k8s: secret has %d keys, pick one with |KEY (have %s)", len(data), strings.Join(sortedKeys(data), ", "))
+}
+
+func sortedKeys(m map[string]string) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Evict removes a single cache entry by key ("CONTEXT/NAMESPACE/NAME"),
+// or by a reference as written in the env file.
+func (m *Manager) Evict(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e, ok := m.cache[key]; ok {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return
+	}
+	if t, ns, name, err := m.target(key); err == nil {
+		key = t.context + "/" + ns + "/" + name
+		if e, ok := m.cache[key]; ok {
+			e.sealed.Destroy()
+			delete(m.cache, key)
+		}
+	}
+}
+
+// EvictAll drops every cached Secret and exec plugin credential.
+func (m *Manager) EvictAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.cache {
+		e.sealed.Destroy()
+		delete(m.cache, k)
+	}
+	for u := range m.creds {
+		m.dropCred(u)
+	}
+}
+
+func (m *Manager) CachedKeys() []cacheinfo.Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]cacheinfo.Entry, 0, len(m.cache))
+	for k, e := range m.cache {
+		if now.Before(e.expires) {
+			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (m *Manager) readCache(ctx context.Context, key string) (string, bool) {
+	e, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(e.expires) {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return "", false
+	}
+	pt, err := e.sealed.OpenString()
+	if err != nil {
+		return "", false
+	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, true
+}
+
+func (m *Manager) storeCache(key, raw string) time.Time {
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return time.Time{}
+	}
+	if old, ok := m.cache[key]; ok {
+		old.sealed.Destroy()
+	}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	m.cache[key] = entry
+
+	go func(k string, e cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if cur, ok := m.cache[k]; ok && cur.sealed == e.sealed {
+			delete(m.cache, k)
+		}
+		m.mu.Unlock()
+		e.sealed.Destroy()
+	}(key, entry, m.ttl)
+	return entry.expires
+}
@@ -0,0 +1,292 @@
+package k8s
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// apiServer is a stand-in for the Kubernetes API serving Secrets to
+// requests bearing one of the accepted tokens.
+type apiServer struct {
+	*httptest.Server
+	tokens   map[string]bool
+	requests atomic.Int32
+}
+
+func newAPIServer(t *testing.T, secrets map[string]map[string]string) *apiServer {
+	t.Helper()
+	a := &apiServer{tokens: map[string]bool{}}
+	a.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.requests.Add(1)
+		auth := r.Header.Get("Authorization")
+		user, pass, basic := r.BasicAuth()
+		if !a.tokens[strings.TrimPrefix(auth, "Bearer ")] && !(basic && user == "admin" && pass == "pw") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// /api/v1/namespaces/NS/secrets/NAME
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/"), "/")
+		data, ok := secrets[parts[0]+"/"+parts[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"kind": "Status", "message": `secrets "` + parts[2] + `" not found`})
+			return
+		}
+		enc := map[string]string{}
+		for k, v := range data {
+			enc[k] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"kind": "Secret", "data": enc})
+	}))
+	t.Cleanup(a.Close)
+	return a
+}
+
+func (a *apiServer) caData() string {
+	p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.Certificate().Raw})
+	return base64.StdEncoding.EncodeToString(p)
+}
+
+func writeKubeconfig(t *testing.T, a *apiServer, users string) string {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: ` + a.URL + `
+    certificate-authority-data: ` + a.caData() + `
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev-user, namespace: apps}
+- name: arn:aws:eks:eu-west-1:123:cluster/prod
+  context: {cluster: dev, user: eks-user}
+- name: basic
+  context: {cluster: dev, user: basic-user}
+- name: filetoken
+  context: {cluster: dev, user: file-user}
+users:
+` + users
+	path := filepath.Join(dir, "config")
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testUsers = `- name: dev-user
+  user: {token: dev-token}
+- name: basic-user
+  user: {username: admin, password: pw}
+- name: file-user
+  user: {tokenFile: token.txt}
+- name: eks-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+      args: [eks, get-token, --cluster-name, prod]
+      env: [{name: AWS_PROFILE, value: prod}]
+`
+
+func TestResolveSecret(t *testing.T) {
+	a := newAPIServer(t, map[string]map[string]string{
+		"apps/db":       {"password": "s3cr3t", "user": "app"},
+		"apps/single":   {"token": "only"},
+		"default/eks":   {"key": "from-eks"},
+		"kube-system/x": {"k": "v"},
+	})
+	a.tokens["dev-token"] = true
+	a.tokens["eks-token"] = true
+	a.tokens["file-token"] = true
+	cfg := writeKubeconfig(t, a, testUsers)
+	if err := os.WriteFile(filepath.Join(filepath.Dir(cfg), "token.txt"), []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(time.Hour)
+	m.SetKubeconfig(func() string { return cfg })
+	var execEnv []string
+	m.runExec = func(_ context.Context, command string, args, env []string) ([]byte, error) {
+		execEnv = env
+		return []byte(`{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"eks-token"}}`), nil
+	}
+
+	cases := []struct {
+		ref, key, want string
+		wantErr        bool
+	}{
+		{"db", "password", "s3cr3t", false},
+		{"apps/db", "user", "app", false},
+		{"dev/apps/single", "", "only", false},
+		{"arn:aws:eks:eu-west-1:123:cluster/prod/default/eks", "key", "from-eks", false},
+		{"basic/kube-system/x", "k", "v", false},
+		{"filetoken/kube-system/x", "k", "v", false},
+		{"db", "", "", true},
+		{"db", "missing", "", true},
+		{"apps/nope", "k", "", true},
+		{"nope/apps/db", "user", "", true},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(t.Context(), tc.ref, tc.key)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s|%s: err=%v wantErr=%v", tc.ref, tc.key, err, tc.wantErr)
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("%s|%s: got %q want %q", tc.ref, tc.key, got, tc.want)
+		}
+	}
+
+	info := ""
+	for _, e := range execEnv {
+		if v, ok := strings.CutPrefix(e, "KUBERNETES_EXEC_INFO="); ok {
+			info = v
+		}
+	}
+	if !strings.Contains(info, "client.authentication.k8s.io/v1beta1") || !strings.Contains(strings.Join(execEnv, " "), "AWS_PROFILE=prod") {
+		t.Errorf("exec plugin env = %v", execEnv)
+	}
+}
+
+func TestResolveSecretNotFoundMessage(t *testing.T) {
+	a := newAPIServer(t, nil)
+	a.tokens["dev-token"] = true
+	m := NewManager(time.Hour)
+	cfg := writeKubeconfig(t, a, testUsers)
+	m.SetKubeconfig(func() string { return cfg })
+	_, err := m.ResolveSecret(t.Context(), "db", "password")
+	if err == nil || !strings.Contains(err.Error(), `secrets "db" not found`) {
+		t.Fatalf("expected API status message, got %v", err)
+	}
+}
+
+func TestExecCredentialCachedUntilExpiry(t *testing.T) {
+	a := newAPIServer(t, map[string]map[string]string{"default/a": {"k": "1"}, "default/b": {"k": "2"}})
+	a.tokens["eks-token"] = true
+	cfg := writeKubeconfig(t, a, testUsers)
+
+	m := NewManager(time.Hour)
+	m.SetKubeconfig(func() string { return cfg })
+	runs := 0
+	m.runExec = func(context.Context, string, []string, []string) ([]byte, error) {
+		runs++
+		exp := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		return []byte(`{"status":{"token":"eks-token","expirationTimestamp":"` + exp + `"}}`), nil
+	}
+	ctxName := "arn:aws:eks:eu-west-1:123:cluster/prod/default/"
+	for _, name := range []string{"a", "b"} {
+		if _, err := m.ResolveSecret(t.Context(), ctxName+name, "k"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("expected the plugin to run once, ran %d times", runs)
+	}
+
+	// A revoked token is refreshed once on 401.
+	delete(a.tokens, "eks-token")
+	a.tokens["eks-token-2"] = true
+	m.runExec = func(context.Context, string, []string, []string) ([]byte, error) {
+		runs++
+		return []byte(`{"status":{"token":"eks-token-2"}}`), nil
+	}
+	m.EvictAll()
+	if _, err := m.ResolveSecret(t.Context(), ctxName+"a", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("expected a second plugin run, got %d", runs)
+	}
+}
+
+func TestExecPluginDoesNotHoldCache(t *testing.T) {
+	a := newAPIServer(t, map[string]map[string]string{"default/a": {"k": "1"}})
+	a.tokens["eks-token"] = true
+	cfg := writeKubeconfig(t, a, testUsers)
+
+	m := NewManager(time.Hour)
+	m.SetKubeconfig(func() string { return cfg })
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.runExec = func(context.Context, string, []string, []string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte(`{"status":{"token":"eks-token"}}`), nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.ResolveSecret(context.Background(), "arn:aws:eks:eu-west-1:123:cluster/prod/default/a", "k")
+		done <- err
+	}()
+	<-started
+
+	listed := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CachedKeys blocked while the exec plugin was running")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKubeconfigMerge(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a")
+	second := filepath.Join(dir, "b")
+	for path, content := range map[string]string{
+		first:  "current-context: one\ncontexts:\n- name: one\n  context: {cluster: c, user: u}\nusers:\n- name: u\n  user: {client-certificate: certs/u.crt}\n",
+		second: "current-context: two\nclusters:\n- name: c\n  cluster: {server: https://x}\nusers:\n- name: u\n  user: {token: ignored}\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tg, err := loadTarget([]string{first, filepath.Join(dir, "missing"), second}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg.context != "one" || tg.cluster.Server != "https://x" || tg.user.Token != "" {
+		t.Fatalf("unexpected merge result: %+v", tg)
+	}
+	if tg.user.ClientCertificate != filepath.Join(dir, "certs", "u.crt") {
+		t.Fatalf("relative path not resolved: %q", tg.user.ClientCertificate)
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	cases := []struct{ ref, ctx, ns, name string }{
+		{"db", "", "", "db"},
+		{"apps/db", "", "apps", "db"},
+		{"dev/apps/db", "dev", "apps", "db"},
+		{"arn:aws:eks:r:1:cluster/prod/apps/db", "arn:aws:eks:r:1:cluster/prod", "apps", "db"},
+	}
+	for _, tc := range cases {
+		c, ns, name, err := parseRef(tc.ref)
+		if err != nil || c != tc.ctx || ns != tc.ns || name != tc.name {
+			t.Errorf("parseRef(%q) = %q %q %q %v", tc.ref, c, ns, name, err)
+		}
+	}
+	if _, _, _, err := parseRef("apps/"); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
@@ -132,3 +132,33 @@ func TestSetKeyfilesValidation(t *testing.T) {
 		t.Error("expected error for empty keyfile")
 	}
 }
+
+func TestVaultFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DESKTOP_SECRETS_TEST_VAULTDIR", dir)
+	t.Setenv("DESKTOP_SECRETS_ALIASES_FILE", filepath.Join(dir, "aliases.yaml"))
+
+	m := newManagerForTest(t)
+	if err := m.SetAliases([]AliasInfo{
+		{Name: "v", File: "$DESKTOP_SECRETS_TEST_VAULTDIR/db.kdbx"},
+	}); err != nil {
+		t.Fatalf("SetAliases: %v", err)
+	}
+
+	got, err := VaultFile("&v")
+	if err != nil {
+		t.Fatalf("VaultFile: %v", err)
+	}
+	if want := filepath.Join(dir, "db.kdbx"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := VaultFile("&missing"); err == nil {
+		t.Error("expected error for unknown alias")
+	}
+
+	abs, _ := filepath.Abs("x.kdbx")
+	if got, err := VaultFile("x.kdbx"); err != nil || got != abs {
+		t.Errorf("direct path: got %q, %v; want %q", got, err, abs)
+	}
+}
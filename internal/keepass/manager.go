@@ -1,8 +1,10 @@
 package keepass
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
 	"github.com/it-atelier-gn/desktop-secrets/internal/prompt"
@@ -82,19 +85,145 @@ type unlockedVault struct {
 	expires  time.Time
 	mu       sync.RWMutex
 	filename string
+	modTime  time.Time
+	// creds is the composite key the vault was unlocked with, kept
+	// sealed so a changed file can be re-read without a prompt. Only
+	// an unlock for a render that asked for reloads keeps it.
+	creds *memprotect.Sealed
+}
+
+// stale reports whether the .kdbx on disk changed after this snapshot
+// was decrypted. The unlocked vault is a copy, so an entry edited and
+// saved in KeePass stays invisible until it is reloaded. A file that
+// can no longer be stat'ed keeps its snapshot.
+func (u *unlockedVault) stale() bool {
+	u.mu.RLock()
+	modTime := u.modTime
+	u.mu.RUnlock()
+	if modTime.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(u.filename)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(modTime)
 }
 
-func (u *unlockedVault) destroy() {
+// reloadable reports whether the composite key was kept for reload.
+func (u *unlockedVault) reloadable() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.creds != nil
+}
+
+// reload re-reads the database file with the cached composite key and
+// swaps the entries in place, keeping the unlock and its expiry. On
+// error the previous snapshot stays in use.
+func (u *unlockedVault) reload() error {
+	u.mu.RLock()
+	sealed := u.creds
+	u.mu.RUnlock()
+	creds, err := openCredentials(sealed)
+	if err != nil {
+		return err
+	}
+	entries, modTime, err := readVault(u.filename, creds)
+	wipeCredentials(creds)
+	if err != nil {
+		return err
+	}
+
 	u.mu.Lock()
-	defer u.mu.Unlock()
-	for _, e := range u.entries {
+	old := u.entries
+	if old != nil {
+		u.entries, u.modTime = entries, modTime
+	} else {
+		// Expired or evicted while the file was read.
+		old = entries
+	}
+	u.mu.Unlock()
+	destroyEntries(old)
+	runtime.GC()
+	return nil
+}
+
+type reloadKey struct{}
+
+// WithReload marks ctx as a render that should pick up changes to the
+// KeePass databases it reads: an unlocked vault whose file changed is
+// re-read with the key it was unlocked with. tplenv --watch asks for
+// this; other callers keep the snapshot taken at unlock. Only vaults
+// unlocked for such a render keep their key in memory.
+func WithReload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reloadKey{}, true)
+}
+
+func reloadRequested(ctx context.Context) bool {
+	v, _ := ctx.Value(reloadKey{}).(bool)
+	return v
+}
+
+// sealCredentials and openCredentials store a composite key as three
+// length-prefixed hashes; an empty one stands for an unused part.
+func sealCredentials(c *gokeepasslib.DBCredentials) (*memprotect.Sealed, error) {
+	var buf []byte
+	for _, part := range [][]byte{c.Passphrase, c.Key, c.Windows} {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(part)))
+		buf = append(buf, part...)
+	}
+	defer memprotect.Wipe(buf)
+	return memprotect.Seal(buf)
+}
+
+func openCredentials(s *memprotect.Sealed) (*gokeepasslib.DBCredentials, error) {
+	buf, err := s.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer memprotect.Wipe(buf)
+	var parts [3][]byte
+	rest := buf
+	for i := range parts {
+		if len(rest) < 4 {
+			return nil, errors.New("corrupt cached credentials")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		rest = rest[4:]
+		if uint32(len(rest)) < n {
+			return nil, errors.New("corrupt cached credentials")
+		}
+		if n > 0 {
+			parts[i] = bytes.Clone(rest[:n])
+		}
+		rest = rest[n:]
+	}
+	return &gokeepasslib.DBCredentials{Passphrase: parts[0], Key: parts[1], Windows: parts[2]}, nil
+}
+
+func wipeCredentials(c *gokeepasslib.DBCredentials) {
+	memprotect.Wipe(c.Passphrase)
+	memprotect.Wipe(c.Key)
+	memprotect.Wipe(c.Windows)
+}
+
+func destroyEntries(entries []*sealedEntry) {
+	for _, e := range entries {
 		for _, s := range e.sealed {
 			s.Destroy()
 		}
 		e.sealed = nil
 		e.plain = nil
 	}
+}
+
+func (u *unlockedVault) destroy() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	destroyEntries(u.entries)
 	u.entries = nil
+	u.creds.Destroy()
+	u.creds = nil
 	runtime.GC()
 }
 
@@ -273,6 +402,35 @@ func aliasesFilePath() (string, error) {
 	return filepath.Join(dir, "aliases.yaml"), nil
 }
 
+// VaultFile returns the database path a keepass(...) vault spec names:
+// "&alias" is looked up in aliases.yaml and env-expanded the same way
+// ResolvePassword does, anything else is taken as a path. It reads the
+// aliases file afresh and is meant for clients that watch the
+// database, not for resolution.
+func VaultFile(vault string) (string, error) {
+	name, ok := strings.CutPrefix(vault, "&")
+	if !ok {
+		return filepath.Abs(vault)
+	}
+	path, err := aliasesFilePath()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var a aliasMap
+	if err := yaml.Unmarshal(data, &a); err != nil {
+		return "", err
+	}
+	al, ok := a[name]
+	if !ok {
+		return "", fmt.Errorf("alias %q not configured", name)
+	}
+	return filepath.Abs(os.ExpandEnv(al.file))
+}
+
 func (m *KPManager) Aliases() []AliasInfo {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -393,6 +551,7 @@ func (m *KPManager) ResolvePassword(ctx context.Context, vault, entry string, ma
 	if vlt.entries == nil {
 		return "", errors.New("vault expired")
 	}
+	cacheinfo.NoteExpiry(ctx, vlt.expires)
 
 	pwd, err := findAttribute(vlt.entries, entry, attr)
 	if err != nil {
@@ -403,12 +562,29 @@ func (m *KPManager) ResolvePassword(ctx context.Context, vault, entry string, ma
 }
 
 func (m *KPManager) getOrOpenVault(ctx context.Context, key, path, master string, ttl time.Duration) (*unlockedVault, error) {
+	keep := reloadRequested(ctx)
 	m.mu.Lock()
 	if v, exists := m.vaults[key]; exists && v.entries != nil && time.Now().Before(v.expires) {
 		m.mu.Unlock()
-		return v, nil
+		if !keep || !v.stale() {
+			return v, nil
+		}
+		if v.reloadable() {
+			// A file caught mid-save fails to decode; the next
+			// change is picked up by the next render.
+			_ = v.reload()
+			return v, nil
+		}
+		// Unlocked without keeping its key: unlock it again.
+		m.mu.Lock()
+		if cur, ok := m.vaults[key]; ok && cur == v {
+			delete(m.vaults, key)
+		}
+		m.mu.Unlock()
+		v.destroy()
+	} else {
+		m.mu.Unlock()
 	}
-	m.mu.Unlock()
 
 	// Try stored keyfile first
 	m.mu.RLock()
@@ -419,7 +595,7 @@ func (m *KPManager) getOrOpenVault(ctx context.Context, key, path, master string
 	m.mu.RUnlock()
 
 	if lastKeyfile != "" {
-		if u, err := m.openVaultWithKeyfile(key, path, lastKeyfile, ttl); err == nil {
+		if u, err := m.openVaultWithKeyfile(key, path, lastKeyfile, ttl, keep); err == nil {
 			m.mu.Lock()
 			m.vaults[key] = u
 			m.mu.Unlock()
@@ -429,7 +605,7 @@ func (m *KPManager) getOrOpenVault(ctx context.Context, key, path, master string
 
 	// Try non-interactive master password
 	if master != "" {
-		if u, err := m.openVaultWithMaster(key, path, master, ttl); err == nil {
+		if u, err := m.openVaultWithMaster(key, path, master, ttl, keep); err == nil {
 			m.mu.Lock()
 			m.vaults[key] = u
 			m.mu.Unlock()
@@ -448,9 +624,9 @@ func (m *KPManager) getOrOpenVault(ctx context.Context, key, path, master string
 		CurrentTTL:  int(m.unlockTTL.Load().Minutes()),
 		Check: func(useKeyfile bool, keyfile string, password string, ttl int) error {
 			if useKeyfile {
-				u, err = m.openVaultWithKeyfile(key, path, keyfile, time.Duration(ttl)*time.Minute)
+				u, err = m.openVaultWithKeyfile(key, path, keyfile, time.Duration(ttl)*time.Minute, keep)
 			} else {
-				u, err = m.openVaultWithMaster(key, path, password, time.Duration(ttl)*time.Minute)
+				u, err = m.openVaultWithMaster(key, path, password, time.Duration(ttl)*time.Minute, keep)
 			}
 			return err
 		},
@@ -487,12 +663,12 @@ func (m *KPManager) getOrOpenVault(ctx context.Context, key, path, master string
 	return u, nil
 }
 
-func (m *KPManager) openVaultWithMaster(key, path, master string, ttl time.Duration) (*unlockedVault, error) {
+func (m *KPManager) openVaultWithMaster(key, path, master string, ttl time.Duration, keepCreds bool) (*unlockedVault, error) {
 	creds := gokeepasslib.NewPasswordCredentials(master)
-	return m.openVault(key, path, ttl, creds)
+	return m.openVault(key, path, ttl, creds, keepCreds)
 }
 
-func (m *KPManager) openVaultWithKeyfile(key, path, keyfile string, ttl time.Duration) (*unlockedVault, error) {
+func (m *KPManager) openVaultWithKeyfile(key, path, keyfile string, ttl time.Duration, keepCreds bool) (*unlockedVault, error) {
 	info, err := os.Stat(keyfile)
 	if err != nil {
 		return nil, fmt.Errorf("stat keyfile: %w", err)
@@ -509,7 +685,7 @@ func (m *KPManager) openVaultWithKeyfile(key, path, keyfile string, ttl time.Dur
 		pwd := strings.TrimRight(string(data), "\r\n")
 
 		if pwd != "" {
-			if u, err := m.openVault(key, path, ttl, gokeepasslib.NewPasswordCredentials(pwd)); err == nil {
+			if u, err := m.openVault(key, path, ttl, gokeepasslib.NewPasswordCredentials(pwd), keepCreds); err == nil {
 				return u, nil
 			}
 		}
@@ -520,40 +696,30 @@ func (m *KPManager) openVaultWithKeyfile(key, path, keyfile string, ttl time.Dur
 		return nil, fmt.Errorf("create key credentials: %w", err)
 	}
 
-	return m.openVault(key, path, ttl, creds)
+	return m.openVault(key, path, ttl, creds, keepCreds)
 }
 
-func (m *KPManager) openVault(key, path string, ttl time.Duration, creds *gokeepasslib.DBCredentials) (*unlockedVault, error) {
-	f, err := os.Open(path)
+// openVault decrypts the database and caches it for ttl. keepCreds
+// seals the composite key alongside it so reload can re-read the file.
+func (m *KPManager) openVault(key, path string, ttl time.Duration, creds *gokeepasslib.DBCredentials, keepCreds bool) (*unlockedVault, error) {
+	entries, modTime, err := readVault(path, creds)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	db := gokeepasslib.NewDatabase()
-	db.Credentials = creds
-
-	dec := gokeepasslib.NewDecoder(f)
-	if err := dec.Decode(db); err != nil {
-		return nil, fmt.Errorf("decode kdbx: %w", err)
-	}
-
-	db.UnlockProtectedEntries()
-
-	entries, err := sealProtectedEntries(db)
-	if err != nil {
-		return nil, fmt.Errorf("seal entries: %w", err)
+	var sealed *memprotect.Sealed
+	if keepCreds {
+		if sealed, err = sealCredentials(creds); err != nil {
+			destroyEntries(entries)
+			return nil, fmt.Errorf("seal credentials: %w", err)
+		}
 	}
 
-	db.Credentials = nil
-	db.Content = nil
-	db.Header = nil
-	db = nil
-
 	u := &unlockedVault{
 		entries:  entries,
 		expires:  time.Now().Add(ttl),
 		filename: path,
+		modTime:  modTime,
+		creds:    sealed,
 	}
 
 	runtime.GC()
@@ -573,6 +739,45 @@ func (m *KPManager) openVault(key, path string, ttl time.Duration, creds *gokeep
 	return u, nil
 }
 
+// readVault decrypts the database at path and returns its entries
+// sealed, along with the file's modification time.
+func readVault(path string, creds *gokeepasslib.DBCredentials) ([]*sealedEntry, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if fi, err := f.Stat(); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	db := gokeepasslib.NewDatabase()
+	db.Credentials = creds
+
+	dec := gokeepasslib.NewDecoder(f)
+	if err := dec.Decode(db); err != nil {
+		return nil, time.Time{}, fmt.Errorf("decode kdbx: %w", err)
+	}
+
+	db.UnlockProtectedEntries()
+
+	entries, err := sealProtectedEntries(db)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("seal entries: %w", err)
+	}
+
+	db.Credentials = nil
+	db.Content = nil
+	db.Header = nil
+	return entries, modTime, nil
+}
+
+// attachmentPrefix selects an entry attachment instead of a field, as
+// in keepass(vault|/SSH/github|attachment:id_ed25519).
+const attachmentPrefix = "attachment:"
+
 func sealProtectedEntries(db *gokeepasslib.Database) ([]*sealedEntry, error) {
 	var out []*sealedEntry
 	if db == nil || db.Content == nil || db.Content.Root == nil {
@@ -625,6 +830,28 @@ func sealProtectedEntries(db *gokeepasslib.Database) ([]*sealedEntry, error) {
 				}
 			}
 
+			// Attachments are sealed alongside the fields, under
+			// "attachment:NAME" so they cannot clash with a field name.
+			for _, br := range e.Binaries {
+				b := db.FindBinary(br.Value.ID)
+				if b == nil {
+					continue
+				}
+				content, err := b.GetContentBytes()
+				if err != nil {
+					return fmt.Errorf("attachment %q: %w", br.Name, err)
+				}
+				s, err := memprotect.Seal(content)
+				memprotect.Wipe(content)
+				if err != nil {
+					return err
+				}
+				if se.sealed == nil {
+					se.sealed = make(map[string]*memprotect.Sealed)
+				}
+				se.sealed[attachmentPrefix+br.Name] = s
+			}
+
 			out = append(out, se)
 		}
 
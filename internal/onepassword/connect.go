@@ -0,0 +1,199 @@
+package onepassword
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// connectClient reads items from a 1Password Connect server's REST API.
+type connectClient struct {
+	host  string // e.g. https://op-connect.internal:8080
+	token string
+	http  *http.Client
+}
+
+type connectVault struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type connectItem struct {
+	ID       string           `json:"id"`
+	Title    string           `json:"title"`
+	Sections []connectSection `json:"sections"`
+	Fields   []connectField   `json:"fields"`
+	Files    []connectFile    `json:"files"`
+}
+
+type connectSection struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+type connectField struct {
+	ID      string          `json:"id"`
+	Label   string          `json:"label"`
+	Purpose string          `json:"purpose"`
+	Value   string          `json:"value"`
+	Section *connectSection `json:"section"`
+}
+
+type connectFile struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Section     *connectSection `json:"section"`
+	ContentPath string          `json:"content_path"`
+}
+
+// read returns the field or file r names.
+func (c *connectClient) read(ctx context.Context, r itemRef) (string, error) {
+	vaultID, err := c.vaultID(ctx, r.vault)
+	if err != nil {
+		return "", err
+	}
+	itemID, err := c.itemID(ctx, vaultID, r.item)
+	if err != nil {
+		return "", err
+	}
+	var item connectItem
+	if err := c.getJSON(ctx, "/v1/vaults/"+url.PathEscape(vaultID)+"/items/"+url.PathEscape(itemID), &item); err != nil {
+		return "", err
+	}
+
+	sectionIDs := map[string]bool{}
+	if r.section != "" {
+		for _, s := range item.Sections {
+			if strings.EqualFold(s.Label, r.section) || s.ID == r.section {
+				sectionIDs[s.ID] = true
+			}
+		}
+		if len(sectionIDs) == 0 {
+			return "", fmt.Errorf("op: section %q not found on %s/%s", r.section, r.vault, r.item)
+		}
+	}
+	inSection := func(s *connectSection) bool {
+		return r.section == "" || (s != nil && sectionIDs[s.ID])
+	}
+
+	if r.file {
+		for _, f := range item.Files {
+			if (f.Name == r.field || f.ID == r.field) && inSection(f.Section) {
+				path := f.ContentPath
+				if path == "" {
+					path = "/v1/vaults/" + url.PathEscape(vaultID) + "/items/" + url.PathEscape(itemID) + "/files/" + url.PathEscape(f.ID) + "/content"
+				}
+				b, err := c.get(ctx, path)
+				if err != nil {
+					return "", err
+				}
+				return fileContent(b), nil
+			}
+		}
+		return "", fmt.Errorf("op: file %q not found on %s/%s", r.field, r.vault, r.item)
+	}
+
+	// Without a section, fields outside any section are preferred, as
+	// with op read.
+	find := func(topLevel bool) (*connectField, error) {
+		var match *connectField
+		for i, f := range item.Fields {
+			if !inSection(f.Section) || (topLevel && f.Section != nil && f.Section.ID != "") {
+				continue
+			}
+			if strings.EqualFold(f.Label, r.field) || f.ID == r.field ||
+				(r.field == "password" && r.section == "" && f.Purpose == "PASSWORD") {
+				if match != nil && match.ID != f.ID {
+					return nil, fmt.Errorf("op: more than one field %q on %s/%s; qualify it with its section", r.field, r.vault, r.item)
+				}
+				match = &item.Fields[i]
+			}
+		}
+		return match, nil
+	}
+	match, err := find(r.section == "")
+	if err == nil && match == nil && r.section == "" {
+		match, err = find(false)
+	}
+	if err != nil {
+		return "", err
+	}
+	if match == nil {
+		return "", fmt.Errorf("op: field %q not found on %s/%s", r.field, r.vault, r.item)
+	}
+	return match.Value, nil
+}
+
+// vaultID looks a vault up by name, taking name as the ID if no vault
+// has that name.
+func (c *connectClient) vaultID(ctx context.Context, name string) (string, error) {
+	var vaults []connectVault
+	if err := c.getJSON(ctx, "/v1/vaults?filter="+url.QueryEscape(`name eq "`+name+`"`), &vaults); err != nil {
+		return "", err
+	}
+	switch len(vaults) {
+	case 0:
+		return name, nil
+	case 1:
+		return vaults[0].ID, nil
+	}
+	return "", fmt.Errorf("op: more than one vault named %q", name)
+}
+
+// itemID looks an item up by title, taking title as the ID if no item
+// has that title.
+func (c *connectClient) itemID(ctx context.Context, vaultID, title string) (string, error) {
+	var items []connectItem
+	if err := c.getJSON(ctx, "/v1/vaults/"+url.PathEscape(vaultID)+"/items?filter="+url.QueryEscape(`title eq "`+title+`"`), &items); err != nil {
+		return "", err
+	}
+	switch len(items) {
+	case 0:
+		return title, nil
+	case 1:
+		return items[0].ID, nil
+	}
+	return "", fmt.Errorf("op: more than one item titled %q", title)
+}
+
+func (c *connectClient) getJSON(ctx context.Context, path string, v any) error {
+	b, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("op: connect %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *connectClient) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("op: connect: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("op: connect: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Message != "" {
+			return nil, fmt.Errorf("op: connect %s: %s (%d)", strings.SplitN(path, "?", 2)[0], e.Message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("op: connect %s: status %d", strings.SplitN(path, "?", 2)[0], resp.StatusCode)
+	}
+	return b, nil
+}
@@ -0,0 +1,122 @@
+package onepassword
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newConnectStub stands in for a Connect server holding one vault,
+// "Work" (vault-1), with one item, "Database" (item-1).
+func newConnectStub(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	item := connectItem{
+		ID: "item-1", Title: "Database",
+		Sections: []connectSection{{ID: "sec-admin", Label: "Admin"}, {ID: "sec-ro", Label: "Read only"}},
+		Fields: []connectField{
+			{ID: "password", Label: "password", Purpose: "PASSWORD", Value: "main-pw"},
+			{ID: "username", Label: "username", Purpose: "USERNAME", Value: "app"},
+			{ID: "f1", Label: "password", Value: "admin-pw", Section: &connectSection{ID: "sec-admin"}},
+			{ID: "f2", Label: "password", Value: "ro-pw", Section: &connectSection{ID: "sec-ro"}},
+		},
+		Files: []connectFile{{ID: "file-1", Name: "ca.pem", ContentPath: "/v1/vaults/vault-1/items/item-1/files/file-1/content"}},
+	}
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer connect-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": 401, "message": "Invalid bearer token"})
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		filter := r.URL.Query().Get("filter")
+		var v any
+		switch r.URL.Path {
+		case "/v1/vaults":
+			v = []connectVault{}
+			if filter == `name eq "Work"` {
+				v = []connectVault{{ID: "vault-1", Name: "Work"}}
+			}
+		case "/v1/vaults/vault-1/items":
+			v = []connectItem{}
+			if filter == `title eq "Database"` {
+				v = []connectItem{{ID: "item-1", Title: "Database"}}
+			}
+		case "/v1/vaults/vault-1/items/item-1":
+			v = item
+		case "/v1/vaults/vault-1/items/item-1/files/file-1/content":
+			_, _ = w.Write([]byte("-----BEGIN CERTIFICATE-----\n"))
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": 404, "message": "Not found"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(v)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &paths
+}
+
+func TestConnectBackend(t *testing.T) {
+	srv, _ := newConnectStub(t)
+	m := NewManager(time.Minute)
+	m.runOp = func(_ context.Context, _ []string, _ ...string) ([]byte, error) {
+		t.Error("op CLI used with a Connect server configured")
+		return nil, nil
+	}
+	m.SetConfig(func() Config { return Config{ConnectHost: srv.URL + "/", ConnectToken: "keepass(connect)"} })
+	m.SetReferenceResolver(func(_ context.Context, v string) (string, error) { return "connect-token", nil })
+	ctx := t.Context()
+
+	cases := []struct{ ref, field, want string }{
+		{"Work/Database", "", "main-pw"},
+		{"Work/Database", "username", "app"},
+		{"Work/Database", "Admin/password", "admin-pw"},
+		{"Work/Database", "read only/password", "ro-pw"},
+		{"Work/Database", "file:ca.pem", "-----BEGIN CERTIFICATE-----\n"},
+		{"vault-1/item-1", "USERNAME", "app"},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(ctx, tc.ref, tc.field, Options{})
+		if err != nil || got != tc.want {
+			t.Errorf("%s|%s: got %q, %v; want %q", tc.ref, tc.field, got, err, tc.want)
+		}
+	}
+
+	errs := []struct {
+		ref, field string
+		opt        Options
+		want       string
+	}{
+		{"Work/Database", "Billing/password", Options{}, `section "Billing" not found`},
+		{"Work/Database", "pin", Options{}, `field "pin" not found`},
+		{"Work/Database", "file:key.pem", Options{}, `file "key.pem" not found`},
+		{"Work/Nope", "", Options{}, "Not found (404)"},
+		{"Work/Database", "", Options{Account: "acme"}, "not supported with a Connect server"},
+	}
+	for _, tc := range errs {
+		_, err := m.ResolveSecret(ctx, tc.ref, tc.field, tc.opt)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s|%s: err %v, want %q", tc.ref, tc.field, err, tc.want)
+		}
+	}
+}
+
+func TestConnectBadToken(t *testing.T) {
+	srv, _ := newConnectStub(t)
+	m := NewManager(time.Minute)
+	m.SetConfig(func() Config { return Config{ConnectHost: srv.URL, ConnectToken: "wrong"} })
+	_, err := m.ResolveSecret(t.Context(), "Work/Database", "", Options{})
+	if err == nil || !strings.Contains(err.Error(), "Invalid bearer token") {
+		t.Fatalf("err = %v", err)
+	}
+	m.SetConfig(func() Config { return Config{ConnectHost: srv.URL} })
+	if _, err := m.ResolveSecret(t.Context(), "Work/Database", "", Options{}); err == nil || !strings.Contains(err.Error(), "op_connect_token") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -1,14 +1,19 @@
 package onepassword
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 	"os/exec"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
@@ -19,20 +24,46 @@ type cacheEntry struct {
 	expires time.Time
 }
 
+// Config is where the manager reads from. Tokens may be literals or
+// references such as keepass(&ops|1password sa).
+type Config struct {
+	// ServiceAccountToken is handed to the op CLI as
+	// OP_SERVICE_ACCOUNT_TOKEN, for use without the desktop app.
+	ServiceAccountToken string
+	// ConnectHost and ConnectToken select a 1Password Connect server
+	// instead of the CLI.
+	ConnectHost  string
+	ConnectToken string
+}
+
+// Options qualify a reference beyond its vault, item and field.
+type Options struct {
+	// Account is the sign-in address or ID of the account to read
+	// from, as for op --account. Empty uses the CLI's default.
+	Account string
+}
+
 type Manager struct {
 	mu    sync.Mutex
 	cache map[string]cacheEntry
 	ttl   time.Duration
-	// runOp is injectable for tests.
-	runOp func(ctx context.Context, args ...string) ([]byte, error)
+	// runOp, config, resolveRef and httpClient are injectable for
+	// tests.
+	runOp      func(ctx context.Context, env []string, args ...string) ([]byte, error)
+	config     func() Config
+	resolveRef func(ctx context.Context, value string) (string, error)
+	httpClient *http.Client
 }
 
 func NewManager(ttl time.Duration) *Manager {
 	return &Manager{
 		cache: make(map[string]cacheEntry),
 		ttl:   ttl,
-		runOp: func(ctx context.Context, args ...string) ([]byte, error) {
+		runOp: func(ctx context.Context, env []string, args ...string) ([]byte, error) {
 			cmd := exec.CommandContext(ctx, "op", args...)
+			if len(env) > 0 {
+				cmd.Env = append(os.Environ(), env...)
+			}
 			out, err := cmd.Output()
 			if err != nil {
 				var ee *exec.ExitError
@@ -43,6 +74,9 @@ func NewManager(ttl time.Duration) *Manager {
 			}
 			return out, nil
 		},
+		config:     func() Config { return Config{} },
+		resolveRef: func(_ context.Context, v string) (string, error) { return v, nil },
+		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
 }
 
@@ -52,42 +86,187 @@ func (m *Manager) SetTTL(ttl time.Duration) {
 	m.mu.Unlock()
 }
 
-// ResolveSecret reads a value from 1Password via the `op` CLI.
-// ref format: "VAULT/ITEM". field selects a named field on the item (1Password
-// fields are native — no JSON parsing). If field is empty, the default
-// `password` field is returned.
-func (m *Manager) ResolveSecret(ctx context.Context, ref, field string) (string, error) {
+// SetConfig sets where the service account token and Connect settings
+// come from. fn is consulted on every read that misses the cache.
+func (m *Manager) SetConfig(fn func() Config) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.config = fn
+	m.mu.Unlock()
+}
 
+// SetReferenceResolver sets how tokens that are references are
+// resolved.
+func (m *Manager) SetReferenceResolver(fn func(ctx context.Context, value string) (string, error)) {
+	m.mu.Lock()
+	m.resolveRef = fn
+	m.mu.Unlock()
+}
+
+// itemRef is a parsed reference: the item, and the field or file on it.
+type itemRef struct {
+	vault, item string
+	section     string // empty for fields outside any section
+	field       string
+	file        bool // field names a file attachment
+}
+
+// parseRef splits "VAULT/ITEM" and a field of the form
+// "[SECTION/]FIELD" or "[SECTION/]file:NAME". An empty field selects
+// the password.
+func parseRef(ref, field string) (itemRef, error) {
 	ref = strings.TrimSpace(ref)
 	if ref == "" {
-		return "", errors.New("empty op reference")
+		return itemRef{}, errors.New("empty op reference")
 	}
-	if !strings.Contains(ref, "/") {
-		return "", errors.New("op: reference must be VAULT/ITEM")
+	vault, item, ok := strings.Cut(ref, "/")
+	if !ok || strings.TrimSpace(vault) == "" || strings.TrimSpace(item) == "" || strings.Contains(item, "/") {
+		return itemRef{}, errors.New("op: reference must be VAULT/ITEM")
 	}
-	if field == "" {
-		field = "password"
+	r := itemRef{vault: strings.TrimSpace(vault), item: strings.TrimSpace(item), field: strings.TrimSpace(field)}
+	if sec, f, ok := strings.Cut(r.field, "/"); ok {
+		r.section, r.field = strings.TrimSpace(sec), strings.TrimSpace(f)
+		if r.section == "" || strings.Contains(r.field, "/") {
+			return itemRef{}, fmt.Errorf("op: field must be [SECTION/]FIELD, got %q", field)
+		}
 	}
+	if name, ok := strings.CutPrefix(r.field, "file:"); ok {
+		r.file, r.field = true, strings.TrimSpace(name)
+	}
+	if r.field == "" {
+		if r.file || r.section != "" {
+			return itemRef{}, fmt.Errorf("op: missing name in field %q", field)
+		}
+		r.field = "password"
+	}
+	return r, nil
+}
 
-	opURI := "op://" + ref + "/" + field
-	cacheKey := opURI
-	if val, ok := m.readCache(cacheKey); ok {
-		return val, nil
+// uri is the op:// secret reference for r.
+func (r itemRef) uri() string {
+	u := "op://" + r.vault + "/" + r.item + "/"
+	if r.section != "" {
+		u += r.section + "/"
+	}
+	return u + r.field
+}
+
+// CacheKey is the key a reference is cached and listed under, and the
+// key Evict takes.
+func CacheKey(ref, field string, opt Options) string {
+	r, err := parseRef(ref, field)
+	if err != nil {
+		return strings.TrimSpace(ref) + "/" + field
+	}
+	key := r.uri()
+	if r.file {
+		key += " (file)"
+	}
+	if opt.Account != "" {
+		key += " (account=" + opt.Account + ")"
 	}
+	return key
+}
 
-	out, err := m.runOp(ctx, "read", opURI)
+// ResolveSecret reads a value from 1Password via the `op` CLI, or from
+// a Connect server if one is configured.
+// ref format: "VAULT/ITEM". field selects a named field on the item
+// (1Password fields are native — no JSON parsing), optionally qualified
+// by its section as "SECTION/FIELD"; "file:NAME" reads a file
+// attachment. If field is empty, the default `password` field is
+// returned.
+func (m *Manager) ResolveSecret(ctx context.Context, ref, field string, opt Options) (string, error) {
+	r, err := parseRef(ref, field)
 	if err != nil {
-		return "", fmt.Errorf("op: read %s: %w", opURI, err)
+		return "", err
 	}
-	val := strings.TrimRight(string(out), "\r\n")
+	key := CacheKey(ref, field, opt)
 
-	m.storeCache(cacheKey, val)
+	m.mu.Lock()
+	val, ok := m.readCache(ctx, key)
+	cfg, resolveRef := m.config(), m.resolveRef
+	m.mu.Unlock()
+	if ok {
+		return val, nil
+	}
+
+	// Tokens are resolved without holding m.mu: a reference may itself
+	// be read through this manager.
+	resolve := func(v, name string) (string, error) {
+		if strings.TrimSpace(v) == "" {
+			return "", nil
+		}
+		out, err := resolveRef(ctx, strings.TrimSpace(v))
+		if err != nil {
+			return "", fmt.Errorf("op: %s: %w", name, err)
+		}
+		return out, nil
+	}
+	var fetch func() (string, error)
+	if host := strings.TrimSpace(cfg.ConnectHost); host != "" {
+		if opt.Account != "" {
+			return "", errors.New("op: account= is not supported with a Connect server")
+		}
+		token, err := resolve(cfg.ConnectToken, "op_connect_token")
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			return "", errors.New("op: op_connect_token not configured")
+		}
+		c := &connectClient{host: strings.TrimRight(host, "/"), token: token, http: m.httpClient}
+		fetch = func() (string, error) { return c.read(ctx, r) }
+	} else {
+		token, err := resolve(cfg.ServiceAccountToken, "op_service_account_token")
+		if err != nil {
+			return "", err
+		}
+		fetch = func() (string, error) { return m.readCLI(ctx, r, opt, token) }
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if val, ok := m.readCache(ctx, key); ok {
+		return val, nil
+	}
+	val, err = fetch()
+	if err != nil {
+		return "", err
+	}
+	cacheinfo.NoteExpiry(ctx, m.storeCache(key, val))
 	return val, nil
 }
 
-// Evict removes a single cache entry by key (the op:// reference).
+// readCLI reads r with `op read`. The caller holds m.mu.
+func (m *Manager) readCLI(ctx context.Context, r itemRef, opt Options, token string) (string, error) {
+	args := []string{"read"}
+	if opt.Account != "" {
+		args = append(args, "--account", opt.Account)
+	}
+	args = append(args, r.uri())
+	var env []string
+	if token != "" {
+		env = append(env, "OP_SERVICE_ACCOUNT_TOKEN="+token)
+	}
+	out, err := m.runOp(ctx, env, args...)
+	if err != nil {
+		return "", fmt.Errorf("op: read %s: %w", r.uri(), err)
+	}
+	if r.file {
+		return fileContent(out), nil
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// fileContent returns an attachment as text, or base64-encoded if it
+// is binary and so cannot be passed on as an environment value.
+func fileContent(b []byte) string {
+	if utf8.Valid(b) && !bytes.ContainsRune(b, 0) {
+		return string(b)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Evict removes a single cache entry by key (see CacheKey).
 func (m *Manager) Evict(key string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -120,7 +299,7 @@ func (m *Manager) CachedKeys() []cacheinfo.Entry {
 	return out
 }
 
-func (m *Manager) readCache(key string) (string, bool) {
+func (m *Manager) readCache(ctx context.Context, key string) (string, bool) {
 	e, ok := m.cache[key]
 	if !ok {
 		return "", false
@@ -134,13 +313,14 @@ func (m *Manager) readCache(key string) (string, bool) {
 	if err != nil {
 		return "", false
 	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
 	return pt, true
 }
 
-func (m *Manager) storeCache(key, raw string) {
+func (m *Manager) storeCache(key, raw string) time.Time {
 	sealed, err := memprotect.SealString(raw)
 	if err != nil {
-		return
+		return time.Time{}
 	}
 	if old, ok := m.cache[key]; ok {
 		old.sealed.Destroy()
@@ -157,4 +337,5 @@ func (m *Manager) storeCache(key, raw string) {
 		m.mu.Unlock()
 		e.sealed.Destroy()
 	}(key, entry, m.ttl)
+	return entry.expires
 }
@@ -11,13 +11,13 @@ func TestResolveSecret(t *testing.T) {
 	ctx := context.Background()
 	m := NewManager(time.Minute)
 	var calls []string
-	m.runOp = func(_ context.Context, args ...string) ([]byte, error) {
+	m.runOp = func(_ context.Context, _ []string, args ...string) ([]byte, error) {
 		calls = append(calls, strings.Join(args, " "))
 		// echo the URI back so tests can assert on it
 		return []byte("value-for-" + args[len(args)-1] + "\n"), nil
 	}
 
-	got, err := m.ResolveSecret(ctx, "Personal/GitHub", "")
+	got, err := m.ResolveSecret(ctx, "Personal/GitHub", "", Options{})
 	if err != nil {
 		t.Fatalf("err=%v", err)
 	}
@@ -25,7 +25,7 @@ func TestResolveSecret(t *testing.T) {
 		t.Fatalf("got %q", got)
 	}
 
-	got, err = m.ResolveSecret(ctx, "Personal/AWS", "access_key")
+	got, err = m.ResolveSecret(ctx, "Personal/AWS", "access_key", Options{})
 	if err != nil {
 		t.Fatalf("err=%v", err)
 	}
@@ -38,12 +38,12 @@ func TestResolveSecret_Caches(t *testing.T) {
 	ctx := context.Background()
 	m := NewManager(time.Minute)
 	n := 0
-	m.runOp = func(_ context.Context, _ ...string) ([]byte, error) {
+	m.runOp = func(_ context.Context, _ []string, _ ...string) ([]byte, error) {
 		n++
 		return []byte("x\n"), nil
 	}
-	_, _ = m.ResolveSecret(ctx, "V/I", "f")
-	_, _ = m.ResolveSecret(ctx, "V/I", "f")
+	_, _ = m.ResolveSecret(ctx, "V/I", "f", Options{})
+	_, _ = m.ResolveSecret(ctx, "V/I", "f", Options{})
 	if n != 1 {
 		t.Fatalf("expected single underlying call, got %d", n)
 	}
@@ -52,13 +52,68 @@ func TestResolveSecret_Caches(t *testing.T) {
 func TestResolveSecret_Errors(t *testing.T) {
 	ctx := context.Background()
 	m := NewManager(time.Minute)
-	m.runOp = func(_ context.Context, _ ...string) ([]byte, error) {
+	m.runOp = func(_ context.Context, _ []string, _ ...string) ([]byte, error) {
 		return nil, nil
 	}
-	if _, err := m.ResolveSecret(ctx, "", ""); err == nil {
+	if _, err := m.ResolveSecret(ctx, "", "", Options{}); err == nil {
 		t.Fatal("expected error for empty ref")
 	}
-	if _, err := m.ResolveSecret(ctx, "noSlash", ""); err == nil {
+	if _, err := m.ResolveSecret(ctx, "noSlash", "", Options{}); err == nil {
 		t.Fatal("expected error for missing slash")
 	}
 }
+
+func TestResolveSecret_CLIOptions(t *testing.T) {
+	ctx := context.Background()
+	m := NewManager(time.Minute)
+	var calls, envs []string
+	m.runOp = func(_ context.Context, env []string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		envs = append(envs, strings.Join(env, " "))
+		if strings.HasSuffix(args[len(args)-1], "/cert.der") {
+			return []byte{0x30, 0x82, 0x00, 0xff}, nil
+		}
+		return []byte("ok\n"), nil
+	}
+	m.SetConfig(func() Config { return Config{ServiceAccountToken: "keepass(op sa)"} })
+	m.SetReferenceResolver(func(_ context.Context, v string) (string, error) {
+		if v != "keepass(op sa)" {
+			t.Errorf("resolved %q", v)
+		}
+		return "ops_token", nil
+	})
+
+	reads := []struct {
+		ref, field string
+		opt        Options
+		want       string
+	}{
+		{"Work/DB", "", Options{Account: "acme.1password.com"}, "read --account acme.1password.com op://Work/DB/password"},
+		{"Work/DB", "Admin/password", Options{}, "read op://Work/DB/Admin/password"},
+		{"Work/TLS", "file:cert.der", Options{}, "read op://Work/TLS/cert.der"},
+	}
+	for i, r := range reads {
+		got, err := m.ResolveSecret(ctx, r.ref, r.field, r.opt)
+		if err != nil {
+			t.Fatalf("%s|%s: %v", r.ref, r.field, err)
+		}
+		if calls[i] != r.want {
+			t.Errorf("op %s, want %s", calls[i], r.want)
+		}
+		if envs[i] != "OP_SERVICE_ACCOUNT_TOKEN=ops_token" {
+			t.Errorf("env %q", envs[i])
+		}
+		if r.field == "file:cert.der" && got != "MIIA/w==" {
+			t.Errorf("binary file = %q, want base64", got)
+		}
+	}
+
+	if got := CacheKey("Work/DB", "", Options{Account: "acme.1password.com"}); got != "op://Work/DB/password (account=acme.1password.com)" {
+		t.Errorf("CacheKey = %q", got)
+	}
+	for _, field := range []string{"/password", "a/b/c", "file:", "Sec/"} {
+		if _, err := m.ResolveSecret(ctx, "Work/DB", field, Options{}); err == nil {
+			t.Errorf("field %q accepted", field)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pass
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolveSecretCachesUntilFileChanges(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "api.gpg", "enc:one")
+	m := s.manager()
+
+	for i := 0; i < 3; i++ {
+		if got, err := m.ResolveSecret(t.Context(), "api", ""); err != nil || got != "one" {
+			t.Fatalf("call %d: %q, %v", i, got, err)
+		}
+	}
+	if s.calls != 1 {
+		t.Fatalf("expected 1 decryption, got %d", s.calls)
+	}
+
+	s.write(t, "api.gpg", "enc:two")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(s.dir, "api.gpg"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.ResolveSecret(t.Context(), "api", ""); err != nil || got != "two" {
+		t.Fatalf("after change: %q, %v", got, err)
+	}
+	if s.calls != 2 {
+		t.Fatalf("expected modified entry to be decrypted again, got %d", s.calls)
+	}
+}
+
+func TestEvictNormalizesEntry(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "a/b.gpg", "enc:x")
+	m := s.manager()
+	if _, err := m.ResolveSecret(t.Context(), "a/b", ""); err != nil {
+		t.Fatal(err)
+	}
+	m.Evict("/a/b.gpg")
+	if len(m.CachedKeys()) != 0 {
+		t.Fatal("expected entry evicted")
+	}
+}
+
+func TestGPGDoesNotHoldCache(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "api.gpg", "enc:one")
+	m := s.manager()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.runGPG = func(context.Context, []byte, ...string) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("one"), nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.ResolveSecret(context.Background(), "api", "")
+		done <- err
+	}()
+	<-started
+
+	listed := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		m.Evict("api")
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CachedKeys blocked while gpg was running")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
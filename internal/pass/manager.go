@@ -0,0 +1,286 @@
+package pass
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+)
+
+type gpgRunner func(ctx context.Context, stdin []byte, args ...string) ([]byte, error)
+
+type cacheEntry struct {
+	sealed  *memprotect.Sealed
+	expires time.Time
+	modTime time.Time
+}
+
+type Manager struct {
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	ttl   time.Duration
+	// runGPG and storeDir are injectable for tests.
+	runGPG   gpgRunner
+	storeDir func() string
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		cache: make(map[string]cacheEntry),
+		ttl:   ttl,
+		runGPG: func(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+			cmd := exec.CommandContext(ctx, "gpg", args...)
+			cmd.Stdin = bytes.NewReader(stdin)
+			out, err := cmd.Output()
+			if err != nil {
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					return nil, fmt.Errorf("gpg: %s", strings.TrimSpace(string(ee.Stderr)))
+				}
+				return nil, fmt.Errorf("gpg: %w", err)
+			}
+			return out, nil
+		},
+		storeDir: func() string { return "" },
+	}
+}
+
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+// SetStoreDir sets where the password store lives. fn is consulted on
+// every lookup; when it returns "" the store is $PASSWORD_STORE_DIR or
+// ~/.password-store, as with the pass CLI.
+func (m *Manager) SetStoreDir(fn func() string) {
+	m.mu.Lock()
+	m.storeDir = fn
+	m.mu.Unlock()
+}
+
+// ResolveSecret decrypts the entry (e.g. "work/github") with gpg and
+// returns its password, the first line, or with field set, the value
+// of the first "field: value" line below it. The field name is matched
+// case-insensitively; "password" always selects the first line.
+//
+// The decrypted entry is cached until the TTL expires or the file is
+// modified. The cache lock is not held while gpg runs, since it may
+// wait on a pinentry prompt.
+func (m *Manager) ResolveSecret(ctx context.Context, entry, field string) (string, error) {
+	entry = entryName(entry)
+	if entry == "" {
+		return "", errors.New("empty pass entry")
+	}
+	dir, err := m.dir()
+	if err != nil {
+		return "", fmt.Errorf("pass: %w", err)
+	}
+	file := filepath.Join(dir, filepath.FromSlash(entry)+".gpg")
+	if rel, err := filepath.Rel(dir, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("pass: entry %q is outside the password store", entry)
+	}
+
+	fi, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("pass: %s is not in the password store", entry)
+	}
+	if err != nil {
+		return "", fmt.Errorf("pass: %w", err)
+	}
+	m.mu.Lock()
+	raw, ok := m.readCache(ctx, entry, fi.ModTime())
+	m.mu.Unlock()
+	if ok {
+		return selectField(raw, field)
+	}
+
+	ids, err := gpgIDs(dir, filepath.Dir(file))
+	if err != nil {
+		return "", fmt.Errorf("pass: %w", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("pass: %w", err)
+	}
+	args := []string{"--batch", "--quiet", "--decrypt"}
+	for _, id := range ids {
+		args = append(args, "--try-secret-key", id)
+	}
+	out, err := m.runGPG(ctx, data, args...)
+	if err != nil {
+		return "", fmt.Errorf("pass: decrypt %s (encrypted for %s): %w", entry, strings.Join(ids, ", "), err)
+	}
+	raw = string(out)
+	clear(out)
+
+	m.mu.Lock()
+	cacheinfo.NoteExpiry(ctx, m.storeCache(entry, raw, fi.ModTime()))
+	m.mu.Unlock()
+	return selectField(raw, field)
+}
+
+func (m *Manager) dir() (string, error) {
+	m.mu.Lock()
+	storeDir := m.storeDir
+	m.mu.Unlock()
+	dir := strings.TrimSpace(storeDir())
+	if dir == "" {
+		dir = os.Getenv("PASSWORD_STORE_DIR")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".password-store")
+	}
+	return filepath.Abs(os.ExpandEnv(dir))
+}
+
+// entryName normalizes an entry the way pass accepts it: slashes as
+// separators, no leading slash, no .gpg suffix.
+func entryName(entry string) string {
+	entry = strings.ReplaceAll(strings.TrimSpace(entry), `\`, "/")
+	entry = strings.Trim(entry, "/")
+	return strings.TrimSuffix(entry, ".gpg")
+}
+
+// gpgIDs returns the key IDs from the .gpg-id nearest to dir, walking
+// up to the store root, which is how pass picks the recipients of a
+// subfolder. They are passed to gpg as keys to try, so entries
+// encrypted with hidden recipients still decrypt.
+func gpgIDs(root, dir string) ([]string, error) {
+	for {
+		b, err := os.ReadFile(filepath.Join(dir, ".gpg-id"))
+		if err == nil {
+			var ids []string
+			sc := bufio.NewScanner(bytes.NewReader(b))
+			for sc.Scan() {
+				line, _, _ := strings.Cut(sc.Text(), "#")
+				if line = strings.TrimSpace(line); line != "" {
+					ids = append(ids, line)
+				}
+			}
+			if len(ids) == 0 {
+				return nil, fmt.Errorf("%s is empty", filepath.Join(dir, ".gpg-id"))
+			}
+			return ids, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if dir == root {
+			return nil, fmt.Errorf("no .gpg-id in %s; is it a password store (pass init)?", root)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("no .gpg-id in %s; is it a password store (pass init)?", root)
+		}
+		dir = parent
+	}
+}
+
+// selectField picks the password (first line) or a "key: value" line
+// from a decrypted entry.
+func selectField(raw, field string) (string, error) {
+	first, rest, _ := strings.Cut(raw, "\n")
+	field = strings.TrimSpace(field)
+	if field == "" || strings.EqualFold(field, "password") {
+		return strings.TrimRight(first, "\r"), nil
+	}
+	for _, line := range strings.Split(rest, "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), field) {
+			return strings.TrimSpace(v), nil
+		}
+	}
+	return "", fmt.Errorf("pass: field %q not found", field)
+}
+
+// Evict removes a single cache entry by key (the entry name).
+func (m *Manager) Evict(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = entryName(key)
+	if e, ok := m.cache[key]; ok {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+	}
+}
+
+func (m *Manager) EvictAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.cache {
+		e.sealed.Destroy()
+		delete(m.cache, k)
+	}
+}
+
+func (m *Manager) CachedKeys() []cacheinfo.Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]cacheinfo.Entry, 0, len(m.cache))
+	for k, e := range m.cache {
+		if now.Before(e.expires) {
+			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (m *Manager) readCache(ctx context.Context, key string, modTime time.Time) (string, bool) {
+	e, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(e.expires) || !e.modTime.Equal(modTime) {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return "", false
+	}
+	pt, err := e.sealed.OpenString()
+	if err != nil {
+		return "", false
+	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, true
+}
+
+func (m *Manager) storeCache(key, raw string, modTime time.Time) time.Time {
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return time.Time{}
+	}
+	if old, ok := m.cache[key]; ok {
+		old.sealed.Destroy()
+	}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl), modTime: modTime}
+	m.cache[key] = entry
+
+	go func(k string, e cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if cur, ok := m.cache[k]; ok && cur.sealed == e.sealed {
+			delete(m.cache, k)
+		}
+		m.mu.Unlock()
+		e.sealed.Destroy()
+	}(key, entry, m.ttl)
+	return entry.expires
+}
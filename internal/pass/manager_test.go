@@ -0,0 +1,142 @@
+package pass
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeStore lays out a password store whose ".gpg" files hold the
+// plaintext prefixed with "enc:", and a gpg that strips the prefix.
+type fakeStore struct {
+	dir   string
+	calls int
+	args  [][]string
+}
+
+func newFakeStore(t *testing.T) *fakeStore {
+	t.Helper()
+	s := &fakeStore{dir: t.TempDir()}
+	s.write(t, ".gpg-id", "alice@example.com\n")
+	return s
+}
+
+func (s *fakeStore) write(t *testing.T, name, content string) {
+	t.Helper()
+	p := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *fakeStore) manager() *Manager {
+	m := NewManager(time.Hour)
+	m.SetStoreDir(func() string { return s.dir })
+	m.runGPG = func(_ context.Context, stdin []byte, args ...string) ([]byte, error) {
+		s.calls++
+		s.args = append(s.args, args)
+		pt, ok := strings.CutPrefix(string(stdin), "enc:")
+		if !ok {
+			return nil, errors.New("decryption failed: No secret key")
+		}
+		return []byte(pt), nil
+	}
+	return m
+}
+
+func TestResolveSecretFields(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "work/github.gpg", "enc:hunter2\nlogin: alice\nURL: https://github.com\notpauth: otpauth://totp/x\n")
+	m := s.manager()
+
+	cases := []struct {
+		entry, field, want string
+		wantErr            bool
+	}{
+		{"work/github", "", "hunter2", false},
+		{"work/github", "password", "hunter2", false},
+		{"/work/github.gpg", "login", "alice", false},
+		{"work/github", "url", "https://github.com", false},
+		{"work/github", "otpauth", "otpauth://totp/x", false},
+		{"work/github", "email", "", true},
+		{"work/gitlab", "", "", true},
+		{"../outside", "", "", true},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(t.Context(), tc.entry, tc.field)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s|%s: err=%v wantErr=%v", tc.entry, tc.field, err, tc.wantErr)
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("%s|%s: got %q want %q", tc.entry, tc.field, got, tc.want)
+		}
+	}
+}
+
+func TestResolveSecretUsesNearestGpgID(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "team/.gpg-id", "# team keys\nAAAA1111\nBBBB2222 # bob\n")
+	s.write(t, "team/db/prod.gpg", "enc:p")
+	s.write(t, "own.gpg", "enc:o")
+	m := s.manager()
+
+	if _, err := m.ResolveSecret(t.Context(), "team/db/prod", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ResolveSecret(t.Context(), "own", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"--batch", "--quiet", "--decrypt", "--try-secret-key", "AAAA1111", "--try-secret-key", "BBBB2222"},
+		{"--batch", "--quiet", "--decrypt", "--try-secret-key", "alice@example.com"},
+	}
+	for i := range want {
+		if !slices.Equal(s.args[i], want[i]) {
+			t.Errorf("call %d args = %v, want %v", i, s.args[i], want[i])
+		}
+	}
+}
+
+func TestResolveSecretRequiresStore(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "x.gpg"), []byte("enc:x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(time.Hour)
+	m.SetStoreDir(func() string { return dir })
+	m.runGPG = func(context.Context, []byte, ...string) ([]byte, error) {
+		t.Fatal("gpg must not run without a .gpg-id")
+		return nil, nil
+	}
+	_, err := m.ResolveSecret(t.Context(), "x", "")
+	if err == nil || !strings.Contains(err.Error(), ".gpg-id") {
+		t.Fatalf("expected missing .gpg-id error, got %v", err)
+	}
+}
+
+func TestResolveSecretReportsRecipients(t *testing.T) {
+	s := newFakeStore(t)
+	s.write(t, "bad.gpg", "garbage")
+	_, err := s.manager().ResolveSecret(t.Context(), "bad", "")
+	if err == nil || !strings.Contains(err.Error(), "alice@example.com") {
+		t.Fatalf("expected error naming the recipient, got %v", err)
+	}
+}
+
+func TestStoreDirFallsBackToEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PASSWORD_STORE_DIR", dir)
+	m := NewManager(time.Hour)
+	got, err := m.dir()
+	if err != nil || got != dir {
+		t.Fatalf("got %q, %v; want %q", got, err, dir)
+	}
+}
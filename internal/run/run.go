@@ -2,24 +2,228 @@ package run
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 )
 
-func RunCommandWithEnv(cmdName string, cmdArgs []string, env map[string]string) error {
+// Options controls how RunCommandWithEnv launches the child.
+type Options struct {
+	// Exec replaces the tplenv process with the command instead of
+	// supervising it. Only honoured on Unix; Windows has no exec(2)
+	// and falls back to supervision. Ignored when Mask is set, since
+	// output can't be filtered once tplenv is gone.
+	Exec bool
+	// StripInherited drops variables from the inherited os.Environ()
+	// that env redefines, rather than appending a second definition
+	// and relying on the child's libc to pick the last one.
+	StripInherited bool
+	// Mask lists secret values to scrub from the child's stdout and
+	// stderr (see MaskWriter). Empty means output is passed through
+	// untouched.
+	Mask []string
+}
+
+// ExitError reports a child that did not exit with status 0. Code
+// follows the shell convention: the child's own exit status, or
+// 128+N when it was terminated by signal N.
+type ExitError struct {
+	Code   int
+	Signal os.Signal
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("terminated by signal %v", e.Signal)
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// RunCommandWithEnv runs cmdName with env layered over the current
+// environment and waits for it. SIGINT/SIGTERM/SIGHUP received by
+// tplenv are forwarded to the child's process group. A non-zero exit
+// is returned as *ExitError so the caller can propagate the status.
+func RunCommandWithEnv(cmdName string, cmdArgs []string, env map[string]string, opts Options) error {
+	if opts.Exec && canExec && len(opts.Mask) == 0 {
+		return execCommand(cmdName, cmdArgs, MergeEnv(os.Environ(), env, opts.StripInherited))
+	}
+	p, err := Start(cmdName, cmdArgs, env, opts)
+	if err != nil {
+		return err
+	}
+	return p.Wait()
+}
+
+// Process is a supervised child started by Start. Signals received by
+// tplenv are forwarded to it for as long as it runs.
+type Process struct {
+	cmd    *exec.Cmd
+	pid    int
+	done   chan struct{}
+	err    error
+	finish []func()
+}
+
+// Start launches cmdName under supervision without waiting for it.
+// opts.Exec is ignored.
+func Start(cmdName string, cmdArgs []string, env map[string]string, opts Options) (*Process, error) {
 	cmd := exec.Command(cmdName, cmdArgs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	cmd.Env = MergeEnv(os.Environ(), env, opts.StripInherited)
 
-	merged := os.Environ()
-	for k, v := range env {
-		merged = append(merged, fmt.Sprintf("%s=%s", k, v))
+	p := &Process{cmd: cmd, done: make(chan struct{})}
+	if len(opts.Mask) > 0 {
+		stdout, finishOut, err := maskedPipe(os.Stdout, opts.Mask)
+		if err != nil {
+			return nil, err
+		}
+		stderr, finishErr, err := maskedPipe(os.Stderr, opts.Mask)
+		if err != nil {
+			finishOut()
+			return nil, err
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		p.finish = append(p.finish, finishOut, finishErr)
 	}
-	cmd.Env = merged
 
-	if err := cmd.Run(); err != nil {
-		return err
+	if err := p.start(); err != nil {
+		p.runFinish()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Done is closed once the child has exited and its output drained.
+func (p *Process) Done() <-chan struct{} { return p.done }
+
+// Wait blocks until the child exits and returns nil, an *ExitError,
+// or the error that prevented it from being waited on.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stop asks the child to terminate with sig and waits up to timeout
+// for it to exit before killing it outright. On Windows, where there
+// is no way to deliver sig to a console process, the child is killed
+// immediately.
+func (p *Process) Stop(sig os.Signal, timeout time.Duration) error {
+	select {
+	case <-p.done:
+		return p.err
+	default:
+	}
+	p.signal(sig)
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		p.kill()
+		<-p.done
+	}
+	return p.err
+}
+
+func (p *Process) exited(err error) {
+	p.runFinish()
+	p.err = err
+	close(p.done)
+}
+
+func (p *Process) runFinish() {
+	for _, f := range p.finish {
+		f()
+	}
+	p.finish = nil
+}
+
+// maskDrainTimeout bounds how long output is still read after the
+// child has exited. A background process the child left running may
+// hold the pipe open indefinitely; past this, its output is cut off
+// rather than keeping tplenv alive.
+var maskDrainTimeout = 2 * time.Second
+
+// maskedPipe returns the write end of a pipe for the child to use as
+// stdout or stderr; everything read from the other end is passed
+// through a MaskWriter to dst. The pipe is handed to the child as an
+// *os.File so exec doesn't start copy goroutines that only cmd.Wait
+// would join. finish closes tplenv's copy of the write end, drains
+// what the child left behind for up to maskDrainTimeout and flushes;
+// call it once the child has exited.
+func maskedPipe(dst io.Writer, secrets []string) (*os.File, func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	mw := NewMaskWriter(dst, secrets)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(mw, r)
+		_ = mw.Flush()
+		_ = r.Close()
+	}()
+	finish := func() {
+		_ = w.Close()
+		select {
+		case <-done:
+			return
+		case <-time.After(maskDrainTimeout):
+		}
+		// Closing the read end ends the copy; anything a leftover
+		// process writes from now on fails with EPIPE instead of
+		// reaching the terminal unmasked.
+		_ = r.Close()
+		select {
+		case <-done:
+		case <-time.After(maskDrainTimeout):
+		}
+	}
+	return w, finish, nil
+}
+
+// MergeEnv appends env to base as KEY=VALUE entries in sorted key
+// order. With strip set, base entries whose key env redefines are
+// removed first so the child sees exactly one definition. Keys are
+// compared case-insensitively on Windows, matching the OS.
+func MergeEnv(base []string, env map[string]string, strip bool) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]string, 0, len(base)+len(env))
+	if strip {
+		redefined := make(map[string]struct{}, len(env))
+		for _, k := range keys {
+			redefined[envKey(k)] = struct{}{}
+		}
+		for _, kv := range base {
+			k, _, _ := strings.Cut(kv, "=")
+			if _, ok := redefined[envKey(k)]; ok {
+				continue
+			}
+			out = append(out, kv)
+		}
+	} else {
+		out = append(out, base...)
+	}
+	for _, k := range keys {
+		out = append(out, k+"="+env[k])
+	}
+	return out
+}
+
+func envKey(k string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(k)
 	}
-	return nil
+	return k
 }
@@ -0,0 +1,31 @@
+package run
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/bin", "API_KEY=stale", "HOME=/home/u"}
+	env := map[string]string{"API_KEY": "fresh", "DB_URL": "pg://x"}
+
+	got := MergeEnv(base, env, false)
+	want := []string{"PATH=/bin", "API_KEY=stale", "HOME=/home/u", "API_KEY=fresh", "DB_URL=pg://x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("append: got %v want %v", got, want)
+	}
+
+	got = MergeEnv(base, env, true)
+	want = []string{"PATH=/bin", "HOME=/home/u", "API_KEY=fresh", "DB_URL=pg://x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("strip: got %v want %v", got, want)
+	}
+}
+
+func TestMergeEnvDoesNotMutateBase(t *testing.T) {
+	base := []string{"A=1", "B=2"}
+	_ = MergeEnv(base, map[string]string{"A": "x"}, true)
+	if base[0] != "A=1" || base[1] != "B=2" {
+		t.Fatalf("base mutated: %v", base)
+	}
+}
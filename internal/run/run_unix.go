@@ -0,0 +1,147 @@
+//go:build !windows
+
+package run
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const canExec = true
+
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+func execCommand(cmdName string, cmdArgs []string, env []string) error {
+	path, err := exec.LookPath(cmdName)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, append([]string{cmdName}, cmdArgs...), env)
+}
+
+// start launches the child in its own process group so a forwarded
+// signal reaches everything it spawned, not just the direct child.
+// When tplenv owns the terminal the child's group is made the
+// foreground group — otherwise an interactive child would be stopped
+// with SIGTTIN on its first read — and the terminal is handed back
+// when the child exits.
+//
+// The child is reaped with wait4(WUNTRACED) rather than cmd.Wait so a
+// Ctrl+Z can be mirrored: tplenv stops itself, letting the shell see
+// the job as suspended, and resumes the child on `fg`.
+func (p *Process) start() error {
+	tty, haveTTY := foregroundTTY()
+	p.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if haveTTY {
+		p.cmd.SysProcAttr.Foreground = true
+		p.cmd.SysProcAttr.Ctty = tty
+	}
+
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, forwardedSignals...)
+	if err := p.cmd.Start(); err != nil {
+		signal.Stop(sigs)
+		return err
+	}
+	pid := p.cmd.Process.Pid
+	p.pid = pid
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				p.signal(sig)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		err := waitChild(pid, tty, haveTTY)
+		signal.Stop(sigs)
+		close(stop)
+		if haveTTY {
+			setForeground(tty, unix.Getpgrp())
+		}
+		_ = p.cmd.Process.Release()
+		p.exited(err)
+	}()
+	return nil
+}
+
+func waitChild(pid, tty int, haveTTY bool) error {
+	for {
+		var ws syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &ws, syscall.WUNTRACED, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case ws.Stopped():
+			if !haveTTY {
+				continue
+			}
+			setForeground(tty, unix.Getpgrp())
+			_ = syscall.Kill(syscall.Getpid(), syscall.SIGSTOP)
+			setForeground(tty, pid)
+			_ = syscall.Kill(-pid, syscall.SIGCONT)
+		case ws.Signaled():
+			return &ExitError{Code: 128 + int(ws.Signal()), Signal: ws.Signal()}
+		case ws.Exited():
+			if ws.ExitStatus() != 0 {
+				return &ExitError{Code: ws.ExitStatus()}
+			}
+			return nil
+		}
+	}
+}
+
+// signal delivers sig to the child's whole process group. Nothing is
+// sent once the child has been reaped, since its PID may be reused.
+func (p *Process) signal(sig os.Signal) {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return
+	}
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	_ = syscall.Kill(-p.pid, s)
+}
+
+func (p *Process) kill() {
+	p.signal(syscall.SIGKILL)
+}
+
+// foregroundTTY returns stdin's descriptor when it is the controlling
+// terminal and tplenv's process group currently holds it. A tplenv
+// started in the background (`&`) must not steal the terminal.
+func foregroundTTY() (int, bool) {
+	fd := int(os.Stdin.Fd())
+	pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil || pgrp != unix.Getpgrp() {
+		return 0, false
+	}
+	return fd, true
+}
+
+// setForeground makes pgrp the terminal's foreground process group.
+// SIGTTOU is ignored for the duration because the caller may itself
+// be in the background at this point.
+func setForeground(tty, pgrp int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	_ = unix.IoctlSetPointerInt(tty, unix.TIOCSPGRP, pgrp)
+}
@@ -0,0 +1,133 @@
+//go:build !windows
+
+package run
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunCommandExitCodes(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{"success", "exit 0", 0},
+		{"plain status", "exit 3", 3},
+		{"killed by SIGTERM", "kill -TERM $$", 143},
+		{"killed by SIGKILL", "kill -KILL $$", 137},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := RunCommandWithEnv("sh", []string{"-c", tc.script}, nil, Options{})
+			if tc.want == 0 {
+				if err != nil {
+					t.Fatalf("err=%v", err)
+				}
+				return
+			}
+			var exitErr *ExitError
+			if !errors.As(err, &exitErr) {
+				t.Fatalf("want *ExitError, got %v", err)
+			}
+			if exitErr.Code != tc.want {
+				t.Fatalf("code=%d want %d", exitErr.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandPassesEnv(t *testing.T) {
+	err := RunCommandWithEnv("sh", []string{"-c", `test "$TPLENV_RUN_TEST" = injected`},
+		map[string]string{"TPLENV_RUN_TEST": "injected"}, Options{StripInherited: true})
+	if err != nil {
+		t.Fatalf("child did not see injected variable: %v", err)
+	}
+}
+
+func TestRunCommandMasksOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	err = RunCommandWithEnv("sh", []string{"-c", `printf 'db=%s\n' "$DB_PASS"`},
+		map[string]string{"DB_PASS": "hunter22"}, Options{Mask: []string{"hunter22"}})
+	os.Stdout = saved
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(r)
+	if string(out) != "db=***\n" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+func TestMaskedOutputDoesNotWaitForBackgroundProcess(t *testing.T) {
+	saved := maskDrainTimeout
+	maskDrainTimeout = 100 * time.Millisecond
+	defer func() { maskDrainTimeout = saved }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedOut := os.Stdout
+	os.Stdout = w
+	start := time.Now()
+	err = RunCommandWithEnv("sh", []string{"-c", `sleep 10 & printf 'db=%s\n' "$DB_PASS"`},
+		map[string]string{"DB_PASS": "hunter22"}, Options{Mask: []string{"hunter22"}})
+	os.Stdout = savedOut
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("waited %v for a background process holding stdout", d)
+	}
+	out, _ := io.ReadAll(r)
+	if string(out) != "db=***\n" {
+		t.Fatalf("got %q", out)
+	}
+}
+
+func TestProcessStop(t *testing.T) {
+	p, err := Start("sh", []string{"-c", "sleep 30"}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	err = p.Stop(syscall.SIGTERM, 5*time.Second)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Signal != syscall.SIGTERM {
+		t.Fatalf("want SIGTERM exit, got %v", err)
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Fatal("Stop waited for the timeout instead of the signal")
+	}
+}
+
+func TestProcessStopKillsAfterTimeout(t *testing.T) {
+	p, err := Start("sh", []string{"-c", "trap '' TERM; while :; do sleep 1; done"}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the trap be installed
+	err = p.Stop(syscall.SIGTERM, 200*time.Millisecond)
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Signal != syscall.SIGKILL {
+		t.Fatalf("want SIGKILL exit, got %v", err)
+	}
+	select {
+	case <-p.Done():
+	default:
+		t.Fatal("Done not closed after Stop")
+	}
+}
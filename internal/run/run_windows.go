@@ -0,0 +1,48 @@
+//go:build windows
+
+package run
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+const canExec = false
+
+func execCommand(string, []string, []string) error {
+	return errors.New("exec is not supported on Windows")
+}
+
+// start runs the child and waits for it in the background. Windows has
+// no process-group signals to forward: Ctrl+C and Ctrl+Break are
+// delivered by the console to every attached process, the child
+// included, so tplenv only swallows the interrupt to stay alive long
+// enough to report the child's exit status.
+func (p *Process) start() error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	if err := p.cmd.Start(); err != nil {
+		signal.Stop(sigs)
+		return err
+	}
+	go func() {
+		err := p.cmd.Wait()
+		signal.Stop(sigs)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = &ExitError{Code: exitErr.ExitCode()}
+		}
+		p.exited(err)
+	}()
+	return nil
+}
+
+// signal kills the child: a console process can't be sent an
+// arbitrary signal on Windows.
+func (p *Process) signal(os.Signal) { p.kill() }
+
+func (p *Process) kill() {
+	_ = p.cmd.Process.Kill()
+}
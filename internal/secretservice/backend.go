@@ -0,0 +1,518 @@
+package secretservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	collectionIface = "org.freedesktop.Secret.Collection"
+	propertiesIface = "org.freedesktop.DBus.Properties"
+
+	collectionPrefix = "/org/freedesktop/secrets/collection/"
+	sessionPrefix    = "/org/freedesktop/secrets/session/"
+
+	// readTimeout bounds one read, approval and unlock prompts included.
+	readTimeout = 120 * time.Second
+)
+
+// ItemConfig is one item of a served collection.
+type ItemConfig struct {
+	Label      string            `mapstructure:"label"`
+	Attributes map[string]string `mapstructure:"attributes"`
+	Ref        string            `mapstructure:"ref"`
+}
+
+// CollectionConfig is a collection the backend serves. The first one
+// is the "default" alias.
+type CollectionConfig struct {
+	Name  string       `mapstructure:"name"`
+	Label string       `mapstructure:"label"`
+	Items []ItemConfig `mapstructure:"items"`
+}
+
+// Backend serves configured collections on the session bus as
+// org.freedesktop.secrets, so libsecret applications read their items
+// from the daemon. It is read-only: items exist only in the
+// configuration, and their secrets are resolved from references on
+// every read, in the context of the calling process.
+type Backend struct {
+	// connect is injectable for tests.
+	connect func() (*dbus.Conn, error)
+
+	config        func() []CollectionConfig
+	read          func(context.Context, string) (string, error)
+	callerContext func(context.Context, int) context.Context
+
+	mu          sync.Mutex
+	conn        *dbus.Conn
+	ctx         context.Context
+	cancel      context.CancelFunc
+	sessions    map[dbus.ObjectPath]string // session -> owning connection
+	nextSession int
+}
+
+func NewBackend() *Backend {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Backend{
+		connect:       sessionBus,
+		config:        func() []CollectionConfig { return nil },
+		read:          func(context.Context, string) (string, error) { return "", errors.New("no reference resolver") },
+		callerContext: func(ctx context.Context, _ int) context.Context { return ctx },
+		ctx:           ctx,
+		cancel:        cancel,
+		sessions:      map[dbus.ObjectPath]string{},
+	}
+}
+
+// SetConfig sets where the collections are read from. It is called on
+// every request, so configuration changes apply without a restart.
+func (b *Backend) SetConfig(f func() []CollectionConfig) { b.config = f }
+
+// SetReferenceResolver sets how an item's reference is resolved.
+func (b *Backend) SetReferenceResolver(f func(context.Context, string) (string, error)) {
+	b.read = f
+}
+
+// SetCallerContext sets how the PID of the process calling over D-Bus
+// is attached to the context its reads run in.
+func (b *Backend) SetCallerContext(f func(context.Context, int) context.Context) {
+	b.callerContext = f
+}
+
+// Start connects to the session bus and serves the collections on it.
+func (b *Backend) Start() error {
+	conn, err := b.connect()
+	if err != nil {
+		return fmt.Errorf("secretservice: connect: %w", err)
+	}
+	if err := b.Serve(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Serve exports the service on conn and takes the org.freedesktop.secrets
+// name, failing if another provider (GNOME Keyring, KWallet) owns it.
+func (b *Backend) Serve(conn *dbus.Conn) error {
+	exports := []struct {
+		v     any
+		iface string
+	}{
+		{serviceObject{b}, serviceIface},
+		{collectionObject{b}, collectionIface},
+		{itemObject{b}, itemIface},
+		{sessionObject{b}, sessionIface},
+		{propertiesObject{b}, propertiesIface},
+	}
+	for _, e := range exports {
+		if err := conn.ExportSubtree(e.v, servicePath, e.iface); err != nil {
+			return fmt.Errorf("secretservice: export %s: %w", e.iface, err)
+		}
+	}
+	reply, err := conn.RequestName(serviceName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("secretservice: request name: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("secretservice: %s is already owned by another provider", serviceName)
+	}
+	if err := b.watchDisconnects(conn); err != nil {
+		_, _ = conn.ReleaseName(serviceName)
+		return err
+	}
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+	return nil
+}
+
+// watchDisconnects drops the sessions of connections that leave the
+// bus, since clients rarely close them. The signal channel is closed
+// with conn.
+func (b *Backend) watchDisconnects(conn *dbus.Conn) error {
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchSender("org.freedesktop.DBus"),
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+	); err != nil {
+		return fmt.Errorf("secretservice: watch NameOwnerChanged: %w", err)
+	}
+	ch := make(chan *dbus.Signal, 16)
+	conn.Signal(ch)
+	go func() {
+		for sig := range ch {
+			if sig.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(sig.Body) != 3 {
+				continue
+			}
+			name, _ := sig.Body[0].(string)
+			newOwner, _ := sig.Body[2].(string)
+			if newOwner == "" && strings.HasPrefix(name, ":") {
+				b.dropSessions(name)
+			}
+		}
+	}()
+	return nil
+}
+
+// dropSessions forgets the sessions opened by the connection owner.
+func (b *Backend) dropSessions(owner string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for path, o := range b.sessions {
+		if o == owner {
+			delete(b.sessions, path)
+		}
+	}
+}
+
+// Close gives up the bus name and cancels reads in progress.
+func (b *Backend) Close() {
+	b.cancel()
+	b.mu.Lock()
+	conn := b.conn
+	b.conn = nil
+	clear(b.sessions)
+	b.mu.Unlock()
+	if conn != nil {
+		_, _ = conn.ReleaseName(serviceName)
+		conn.Close()
+	}
+}
+
+var (
+	errNotSupported = dbus.NewError("org.freedesktop.DBus.Error.NotSupported",
+		[]any{"desktop-secrets serves configured items only and cannot store secrets"})
+	errNoSession = dbus.NewError("org.freedesktop.Secret.Error.NoSession", []any{"no such session"})
+)
+
+func errNoSuchObject(path dbus.ObjectPath) *dbus.Error {
+	return dbus.NewError("org.freedesktop.Secret.Error.NoSuchObject", []any{"no such object " + string(path)})
+}
+
+func errUnknownInterface(iface string) *dbus.Error {
+	err := dbus.MakeUnknownInterfaceError(iface)
+	return &err
+}
+
+// pathElement escapes a collection name for use in an object path,
+// which allows only [A-Za-z0-9_] in an element.
+func pathElement(name string) string {
+	var sb strings.Builder
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "_%02x", c)
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+func collectionPath(c CollectionConfig) dbus.ObjectPath {
+	return dbus.ObjectPath(collectionPrefix + pathElement(c.Name))
+}
+
+func itemPath(c CollectionConfig, i int) dbus.ObjectPath {
+	return dbus.ObjectPath(string(collectionPath(c)) + "/" + strconv.Itoa(i+1))
+}
+
+// lookup finds the collection, and the item when path names one, of
+// an object path. item is nil for a collection.
+func (b *Backend) lookup(path dbus.ObjectPath) (*CollectionConfig, *ItemConfig, bool) {
+	rest, ok := strings.CutPrefix(string(path), collectionPrefix)
+	if !ok {
+		return nil, nil, false
+	}
+	name, idx, hasItem := strings.Cut(rest, "/")
+	for _, c := range b.config() {
+		if pathElement(c.Name) != name {
+			continue
+		}
+		if !hasItem {
+			return &c, nil, true
+		}
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 1 || i > len(c.Items) {
+			return nil, nil, false
+		}
+		return &c, &c.Items[i-1], true
+	}
+	return nil, nil, false
+}
+
+// search returns the items whose attributes include attrs, in the
+// collection at only or in all of them.
+func (b *Backend) search(only dbus.ObjectPath, attrs map[string]string) []dbus.ObjectPath {
+	out := []dbus.ObjectPath{}
+	for _, c := range b.config() {
+		if only != "" && collectionPath(c) != only {
+			continue
+		}
+		for i, it := range c.Items {
+			if matchAttributes(it.Attributes, attrs) {
+				out = append(out, itemPath(c, i))
+			}
+		}
+	}
+	return out
+}
+
+func matchAttributes(have, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := have[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// getSecret resolves the item at path for the process behind sender,
+// in a session that sender opened.
+func (b *Backend) getSecret(sender dbus.Sender, path, session dbus.ObjectPath) (secret, *dbus.Error) {
+	b.mu.Lock()
+	owner, ok := b.sessions[session]
+	conn := b.conn
+	b.mu.Unlock()
+	if !ok || owner != string(sender) {
+		return secret{}, errNoSession
+	}
+	_, it, ok := b.lookup(path)
+	if !ok || it == nil {
+		return secret{}, errNoSuchObject(path)
+	}
+
+	ctx, cancel := context.WithTimeout(b.ctx, readTimeout)
+	defer cancel()
+	ctx = b.callerContext(ctx, callerPID(conn, sender))
+	v, err := b.read(ctx, it.Ref)
+	if err != nil {
+		return secret{}, dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []any{err.Error()})
+	}
+	return secret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       []byte(v),
+		ContentType: "text/plain; charset=utf8",
+	}, nil
+}
+
+// callerPID asks the bus for the PID of the process owning sender, or
+// returns 0 when it cannot tell.
+func callerPID(conn *dbus.Conn, sender dbus.Sender) int {
+	if conn == nil {
+		return 0
+	}
+	var pid uint32
+	err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixProcessID", 0, string(sender)).Store(&pid)
+	if err != nil {
+		return 0
+	}
+	return int(pid)
+}
+
+// The D-Bus objects. Each is exported on the whole subtree under
+// /org/freedesktop/secrets and tells from the message path which
+// object was called.
+
+type serviceObject struct{ b *Backend }
+
+func (o serviceObject) OpenSession(algorithm string, _ dbus.Variant, sender dbus.Sender, msg dbus.Message) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return dbus.MakeVariant(""), "/", errNoSuchObject(msgPath(msg))
+	}
+	// Secrets cross the bus, which only the user's processes can
+	// connect to, in plain; libsecret falls back to that.
+	if algorithm != "plain" {
+		return dbus.MakeVariant(""), "/", dbus.NewError("org.freedesktop.DBus.Error.NotSupported",
+			[]any{"algorithm " + algorithm + " is not supported"})
+	}
+	o.b.mu.Lock()
+	o.b.nextSession++
+	path := dbus.ObjectPath(sessionPrefix + strconv.Itoa(o.b.nextSession))
+	o.b.sessions[path] = string(sender)
+	o.b.mu.Unlock()
+	return dbus.MakeVariant(""), path, nil
+}
+
+func (o serviceObject) SearchItems(attrs map[string]string, msg dbus.Message) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return nil, nil, errNoSuchObject(msgPath(msg))
+	}
+	return o.b.search("", attrs), []dbus.ObjectPath{}, nil
+}
+
+// Unlock reports everything unlocked: access is decided per read, by
+// the approval of the calling process.
+func (o serviceObject) Unlock(objects []dbus.ObjectPath, msg dbus.Message) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return nil, "/", errNoSuchObject(msgPath(msg))
+	}
+	return objects, "/", nil
+}
+
+func (o serviceObject) Lock(_ []dbus.ObjectPath, msg dbus.Message) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return nil, "/", errNoSuchObject(msgPath(msg))
+	}
+	return []dbus.ObjectPath{}, "/", nil
+}
+
+func (o serviceObject) GetSecrets(items []dbus.ObjectPath, session dbus.ObjectPath, sender dbus.Sender, msg dbus.Message) (map[dbus.ObjectPath]secret, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return nil, errNoSuchObject(msgPath(msg))
+	}
+	out := map[dbus.ObjectPath]secret{}
+	for _, p := range items {
+		if _, it, ok := o.b.lookup(p); !ok || it == nil {
+			continue
+		}
+		s, err := o.b.getSecret(sender, p, session)
+		if err != nil {
+			return nil, err
+		}
+		out[p] = s
+	}
+	return out, nil
+}
+
+func (o serviceObject) ReadAlias(name string, msg dbus.Message) (dbus.ObjectPath, *dbus.Error) {
+	if msgPath(msg) != servicePath {
+		return "/", errNoSuchObject(msgPath(msg))
+	}
+	cols := o.b.config()
+	if name != "default" || len(cols) == 0 {
+		return "/", nil
+	}
+	return collectionPath(cols[0]), nil
+}
+
+func (o serviceObject) CreateCollection(map[string]dbus.Variant, string) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return "/", "/", errNotSupported
+}
+
+func (o serviceObject) SetAlias(string, dbus.ObjectPath) *dbus.Error { return errNotSupported }
+
+type collectionObject struct{ b *Backend }
+
+func (o collectionObject) SearchItems(attrs map[string]string, msg dbus.Message) ([]dbus.ObjectPath, *dbus.Error) {
+	_, it, ok := o.b.lookup(msgPath(msg))
+	if !ok || it != nil {
+		return nil, errNoSuchObject(msgPath(msg))
+	}
+	return o.b.search(msgPath(msg), attrs), nil
+}
+
+func (o collectionObject) CreateItem(map[string]dbus.Variant, secret, bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return "/", "/", errNotSupported
+}
+
+func (o collectionObject) Delete() (dbus.ObjectPath, *dbus.Error) { return "/", errNotSupported }
+
+type itemObject struct{ b *Backend }
+
+func (o itemObject) GetSecret(session dbus.ObjectPath, sender dbus.Sender, msg dbus.Message) (secret, *dbus.Error) {
+	return o.b.getSecret(sender, msgPath(msg), session)
+}
+
+func (o itemObject) SetSecret(secret) *dbus.Error { return errNotSupported }
+
+func (o itemObject) Delete() (dbus.ObjectPath, *dbus.Error) { return "/", errNotSupported }
+
+type sessionObject struct{ b *Backend }
+
+func (o sessionObject) Close(sender dbus.Sender, msg dbus.Message) *dbus.Error {
+	o.b.mu.Lock()
+	defer o.b.mu.Unlock()
+	if owner, ok := o.b.sessions[msgPath(msg)]; !ok || owner != string(sender) {
+		return errNoSession
+	}
+	delete(o.b.sessions, msgPath(msg))
+	return nil
+}
+
+type propertiesObject struct{ b *Backend }
+
+func (o propertiesObject) Get(iface, name string, msg dbus.Message) (dbus.Variant, *dbus.Error) {
+	props, err := o.b.properties(msgPath(msg), iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.UnknownProperty", []any{iface + "." + name})
+	}
+	return v, nil
+}
+
+func (o propertiesObject) GetAll(iface string, msg dbus.Message) (map[string]dbus.Variant, *dbus.Error) {
+	return o.b.properties(msgPath(msg), iface)
+}
+
+func (o propertiesObject) Set(string, string, dbus.Variant) *dbus.Error { return errNotSupported }
+
+// properties returns the properties of iface on the object at path.
+func (b *Backend) properties(path dbus.ObjectPath, iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if path == servicePath {
+		if iface != serviceIface {
+			return nil, errUnknownInterface(iface)
+		}
+		cols := []dbus.ObjectPath{}
+		for _, c := range b.config() {
+			cols = append(cols, collectionPath(c))
+		}
+		return map[string]dbus.Variant{"Collections": dbus.MakeVariant(cols)}, nil
+	}
+
+	c, it, ok := b.lookup(path)
+	switch {
+	case !ok:
+		return nil, errNoSuchObject(path)
+	case it == nil && iface == collectionIface:
+		items := []dbus.ObjectPath{}
+		for i := range c.Items {
+			items = append(items, itemPath(*c, i))
+		}
+		label := c.Label
+		if label == "" {
+			label = c.Name
+		}
+		return map[string]dbus.Variant{
+			"Items":    dbus.MakeVariant(items),
+			"Label":    dbus.MakeVariant(label),
+			"Locked":   dbus.MakeVariant(false),
+			"Created":  dbus.MakeVariant(uint64(0)),
+			"Modified": dbus.MakeVariant(uint64(0)),
+		}, nil
+	case it != nil && iface == itemIface:
+		attrs := it.Attributes
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		return map[string]dbus.Variant{
+			"Attributes": dbus.MakeVariant(attrs),
+			"Label":      dbus.MakeVariant(it.Label),
+			"Locked":     dbus.MakeVariant(false),
+			"Created":    dbus.MakeVariant(uint64(0)),
+			"Modified":   dbus.MakeVariant(uint64(0)),
+		}, nil
+	}
+	return nil, errUnknownInterface(iface)
+}
+
+func msgPath(msg dbus.Message) dbus.ObjectPath {
+	p, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+	return p
+}
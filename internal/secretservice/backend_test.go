@@ -0,0 +1,199 @@
+package secretservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+type pidKey struct{}
+
+func startBackend(t *testing.T, addr string, cols []CollectionConfig, refs map[string]string) (*Backend, *atomic.Int64) {
+	t.Helper()
+	var callerPID atomic.Int64
+	b := NewBackend()
+	b.connect = func() (*dbus.Conn, error) { return dbus.Connect(addr) }
+	b.SetConfig(func() []CollectionConfig { return cols })
+	b.SetCallerContext(func(ctx context.Context, pid int) context.Context {
+		return context.WithValue(ctx, pidKey{}, pid)
+	})
+	b.SetReferenceResolver(func(ctx context.Context, ref string) (string, error) {
+		pid, _ := ctx.Value(pidKey{}).(int)
+		callerPID.Store(int64(pid))
+		v, ok := refs[ref]
+		if !ok {
+			return "", errors.New("denied")
+		}
+		return v, nil
+	})
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b, &callerPID
+}
+
+var testCollections = []CollectionConfig{
+	{Name: "work", Label: "Work", Items: []ItemConfig{
+		{Label: "GitHub token", Attributes: map[string]string{"service": "github", "user": "alice"}, Ref: "keepass(&work|/GitHub)"},
+		{Label: "Jira", Attributes: map[string]string{"service": "jira"}, Ref: "keepass(&work|/Jira)"},
+	}},
+	{Name: "my.home", Items: []ItemConfig{
+		{Label: "NAS", Attributes: map[string]string{"service": "nas"}, Ref: "pass(nas)"},
+	}},
+}
+
+func TestBackendServesManager(t *testing.T) {
+	addr := startBus(t)
+	_, callerPID := startBackend(t, addr, testCollections, map[string]string{
+		"keepass(&work|/GitHub)": "ghp_x",
+		"pass(nas)":              "nas-pw",
+	})
+	m := newTestManager(addr)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	got, err := m.Resolve(ctx, "service=github", "")
+	if err != nil || got != "ghp_x" {
+		t.Fatalf("Resolve = %q, %v", got, err)
+	}
+	if callerPID.Load() != int64(os.Getpid()) {
+		t.Errorf("read ran for pid %d, want the caller %d", callerPID.Load(), os.Getpid())
+	}
+	if got, err := m.Resolve(ctx, "", "NAS"); err != nil || got != "nas-pw" {
+		t.Fatalf("Resolve by label = %q, %v", got, err)
+	}
+	if _, err := m.Resolve(ctx, "service=jira", ""); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("expected the resolver's refusal, got %v", err)
+	}
+	if _, err := m.Resolve(ctx, "service=gitlab", ""); err == nil {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestBackendObjects(t *testing.T) {
+	addr := startBus(t)
+	startBackend(t, addr, testCollections, map[string]string{"pass(nas)": "nas-pw"})
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	svc := conn.Object(serviceName, servicePath)
+
+	v, err := svc.GetProperty(serviceIface + ".Collections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := dbus.ObjectPath("/org/freedesktop/secrets/collection/my_2ehome")
+	if cols, _ := v.Value().([]dbus.ObjectPath); len(cols) != 2 || cols[1] != home {
+		t.Fatalf("Collections = %v", v)
+	}
+	var def dbus.ObjectPath
+	if err := svc.Call(serviceIface+".ReadAlias", 0, "default").Store(&def); err != nil || def != collectionPrefix+"work" {
+		t.Fatalf("ReadAlias(default) = %s, %v", def, err)
+	}
+
+	var items []dbus.ObjectPath
+	if err := conn.Object(serviceName, home).Call(collectionIface+".SearchItems", 0, map[string]string{}).Store(&items); err != nil || len(items) != 1 {
+		t.Fatalf("collection SearchItems = %v, %v", items, err)
+	}
+	item := conn.Object(serviceName, items[0])
+	v, err = item.GetProperty(itemIface + ".Attributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, _ := v.Value().(map[string]string); attrs["service"] != "nas" {
+		t.Fatalf("Attributes = %v", v)
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := svc.Call(serviceIface+".OpenSession", 0, "dh-ietf1024-sha256-aes128-cbc-pkcs7", dbus.MakeVariant([]byte{1})).Store(&output, &session); err == nil {
+		t.Fatal("encrypted session accepted")
+	}
+	if err := svc.Call(serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	var secrets map[dbus.ObjectPath]secret
+	if err := svc.Call(serviceIface+".GetSecrets", 0, items, session).Store(&secrets); err != nil {
+		t.Fatal(err)
+	}
+	if s := secrets[items[0]]; string(s.Value) != "nas-pw" || s.Session != session {
+		t.Fatalf("GetSecrets = %+v", secrets)
+	}
+
+	// A session belongs to the connection that opened it.
+	other, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	var s secret
+	if err := other.Object(serviceName, items[0]).Call(itemIface+".GetSecret", 0, session).Store(&s); err == nil {
+		t.Fatal("read with another connection's session")
+	}
+	if err := conn.Object(serviceName, session).Call(sessionIface+".Close", 0).Err; err != nil {
+		t.Fatal(err)
+	}
+	if err := item.Call(itemIface+".GetSecret", 0, session).Store(&s); err == nil {
+		t.Fatal("read with a closed session")
+	}
+
+	if err := conn.Object(serviceName, home).Call(collectionIface+".CreateItem", 0,
+		map[string]dbus.Variant{}, secret{Session: session}, false).Err; err == nil {
+		t.Fatal("CreateItem succeeded")
+	}
+	if err := conn.Object(serviceName, collectionPrefix+"missing/1").Call(itemIface+".GetSecret", 0, session).Err; err == nil {
+		t.Fatal("GetSecret on a missing item succeeded")
+	}
+}
+
+func TestBackendNameTaken(t *testing.T) {
+	addr := startBus(t)
+	startService(t, addr)
+	b := NewBackend()
+	b.connect = func() (*dbus.Conn, error) { return dbus.Connect(addr) }
+	if err := b.Start(); err == nil || !strings.Contains(err.Error(), "already owned") {
+		b.Close()
+		t.Fatalf("Start = %v, want already owned", err)
+	}
+}
+
+func TestBackendDropsSessionsOnDisconnect(t *testing.T) {
+	addr := startBus(t)
+	b, _ := startBackend(t, addr, testCollections, nil)
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := conn.Object(serviceName, servicePath).Call(serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		t.Fatal(err)
+	}
+	sessions := func() int {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.sessions)
+	}
+	if sessions() != 1 {
+		t.Fatalf("%d sessions open, want 1", sessions())
+	}
+
+	// The client leaves without closing its session.
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for sessions() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("session kept after its connection left the bus")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !darwin
+
+package secretservice
+
+import "github.com/godbus/dbus/v5"
+
+func sessionBus() (*dbus.Conn, error) {
+	return dbus.ConnectSessionBus()
+}
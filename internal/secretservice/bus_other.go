@@ -0,0 +1,13 @@
+//go:build windows || darwin
+
+package secretservice
+
+import (
+	"errors"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func sessionBus() (*dbus.Conn, error) {
+	return nil, errors.New("secretservice is only supported on Linux and BSD desktops")
+}
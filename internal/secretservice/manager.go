@@ -0,0 +1,225 @@
+// Package secretservice reads secrets from the freedesktop Secret
+// Service (GNOME Keyring, KWallet, KeePassXC) over the D-Bus session
+// bus, and can serve configured items as that service itself.
+package secretservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	serviceName  = "org.freedesktop.secrets"
+	servicePath  = dbus.ObjectPath("/org/freedesktop/secrets")
+	serviceIface = "org.freedesktop.Secret.Service"
+	itemIface    = "org.freedesktop.Secret.Item"
+	promptIface  = "org.freedesktop.Secret.Prompt"
+	sessionIface = "org.freedesktop.Secret.Session"
+)
+
+// secret is the Secret struct of the spec, (oayays).
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type Manager struct {
+	// connect is injectable for tests.
+	connect func() (*dbus.Conn, error)
+}
+
+func NewManager() *Manager { return &Manager{connect: sessionBus} }
+
+// Resolve returns the secret of the item whose attributes include
+// every "attr=value" pair in query and, when label is set, whose label
+// is exactly label. Exactly one item must match. A locked item
+// triggers the service's own unlock prompt.
+//
+// The secret is transferred with the "plain" algorithm; it never
+// leaves the local session bus.
+func (m *Manager) Resolve(ctx context.Context, query, label string) (string, error) {
+	attrs, err := parseAttributes(query)
+	if err != nil {
+		return "", fmt.Errorf("secretservice: %w", err)
+	}
+	label = strings.TrimSpace(label)
+	if len(attrs) == 0 && label == "" {
+		return "", errors.New("secretservice: need attributes or a label")
+	}
+
+	conn, err := m.connect()
+	if err != nil {
+		return "", fmt.Errorf("secretservice: %w", err)
+	}
+	defer conn.Close()
+	svc := conn.Object(serviceName, servicePath)
+
+	var unlocked, locked []dbus.ObjectPath
+	if err := svc.CallWithContext(ctx, serviceIface+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return "", fmt.Errorf("secretservice: search: %w", err)
+	}
+	item, isLocked, err := pickItem(conn, attrs, label, unlocked, locked)
+	if err != nil {
+		return "", fmt.Errorf("secretservice: %w", err)
+	}
+	if isLocked {
+		if err := unlock(ctx, conn, item); err != nil {
+			return "", fmt.Errorf("secretservice: unlock: %w", err)
+		}
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := svc.CallWithContext(ctx, serviceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", fmt.Errorf("secretservice: open session: %w", err)
+	}
+	defer conn.Object(serviceName, session).Call(sessionIface+".Close", 0)
+
+	var s secret
+	if err := conn.Object(serviceName, item).CallWithContext(ctx, itemIface+".GetSecret", 0, session).Store(&s); err != nil {
+		return "", fmt.Errorf("secretservice: get secret: %w", err)
+	}
+	v := string(s.Value)
+	clear(s.Value)
+	return v, nil
+}
+
+// pickItem narrows the search result by label and requires a single
+// match. Two items for the same query are an ambiguity for the user to
+// resolve, not something to pick from at random.
+func pickItem(conn *dbus.Conn, attrs map[string]string, label string, unlocked, locked []dbus.ObjectPath) (dbus.ObjectPath, bool, error) {
+	type candidate struct {
+		path   dbus.ObjectPath
+		locked bool
+	}
+	var all []candidate
+	for _, p := range unlocked {
+		all = append(all, candidate{p, false})
+	}
+	for _, p := range locked {
+		all = append(all, candidate{p, true})
+	}
+
+	var matches []candidate
+	var labels []string
+	for _, c := range all {
+		v, err := conn.Object(serviceName, c.path).GetProperty(itemIface + ".Label")
+		if err != nil {
+			return "", false, fmt.Errorf("read label of %s: %w", c.path, err)
+		}
+		l, _ := v.Value().(string)
+		if label != "" && l != label {
+			continue
+		}
+		matches = append(matches, c)
+		labels = append(labels, fmt.Sprintf("%q", l))
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", false, fmt.Errorf("no item matches %s", describe(attrs, label))
+	case 1:
+		return matches[0].path, matches[0].locked, nil
+	}
+	return "", false, fmt.Errorf("%d items match %s (labels %s); add attributes or a |label", len(matches), describe(attrs, label), strings.Join(labels, ", "))
+}
+
+// unlock asks the service to unlock item, running its prompt when it
+// needs one and waiting for the user to finish.
+func unlock(ctx context.Context, conn *dbus.Conn, item dbus.ObjectPath) error {
+	var done []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := conn.Object(serviceName, servicePath).CallWithContext(ctx, serviceIface+".Unlock", 0, []dbus.ObjectPath{item}).Store(&done, &prompt); err != nil {
+		return err
+	}
+	if prompt == "/" {
+		return nil
+	}
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(promptIface),
+		dbus.WithMatchMember("Completed"),
+	); err != nil {
+		return err
+	}
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+
+	if err := conn.Object(serviceName, prompt).CallWithContext(ctx, promptIface+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Object(serviceName, prompt).Call(promptIface+".Dismiss", 0)
+			return ctx.Err()
+		case sig := <-signals:
+			if sig.Path != prompt || sig.Name != promptIface+".Completed" || len(sig.Body) == 0 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return errors.New("unlock prompt dismissed")
+			}
+			return nil
+		}
+	}
+}
+
+// parseAttributes reads "attr=value,attr2=value". A backslash escapes
+// a comma inside a value.
+func parseAttributes(query string) (map[string]string, error) {
+	attrs := map[string]string{}
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && i+1 < len(query) && query[i+1] == ',':
+			cur.WriteByte(',')
+			i++
+		case query[i] == ',':
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(query[i])
+		}
+	}
+	parts = append(parts, cur.String())
+
+	for _, p := range parts {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid attribute %q, want attr=value", strings.TrimSpace(p))
+		}
+		attrs[k] = strings.TrimSpace(v)
+	}
+	return attrs, nil
+}
+
+func describe(attrs map[string]string, label string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		parts = append(parts, k+"="+attrs[k])
+	}
+	if label != "" {
+		parts = append(parts, "label="+label)
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
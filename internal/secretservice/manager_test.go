@@ -0,0 +1,249 @@
+package secretservice
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// startBus runs a private dbus-daemon and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	bin, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(conf, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>session</type>
+  <listen>unix:dir=`+dir+`</listen>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bin, "--config-file="+conf, "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() { _ = cmd.Process.Kill(); _ = cmd.Wait() })
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Skipf("dbus-daemon printed no address: %v", err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+type stubItem struct {
+	path   dbus.ObjectPath
+	label  string
+	attrs  map[string]string
+	secret string
+	locked bool
+}
+
+// stubService implements the parts of org.freedesktop.Secret.Service
+// the manager calls.
+type stubService struct {
+	conn    *dbus.Conn
+	mu      sync.Mutex
+	items   []*stubItem
+	dismiss bool // answer unlock prompts with dismissed=true
+	prompts int
+}
+
+func (s *stubService) OpenSession(algorithm string, _ dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.MakeVariant(""), "/", dbus.NewError("org.freedesktop.DBus.Error.NotSupported", nil)
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (s *stubService) SearchItems(attrs map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unlocked, locked := []dbus.ObjectPath{}, []dbus.ObjectPath{}
+	for _, it := range s.items {
+		match := true
+		for k, v := range attrs {
+			if it.attrs[k] != v {
+				match = false
+			}
+		}
+		switch {
+		case !match:
+		case it.locked:
+			locked = append(locked, it.path)
+		default:
+			unlocked = append(unlocked, it.path)
+		}
+	}
+	return unlocked, locked, nil
+}
+
+func (s *stubService) Unlock(paths []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return []dbus.ObjectPath{}, "/org/freedesktop/secrets/prompt/1", nil
+}
+
+// Prompt implements org.freedesktop.Secret.Prompt on the prompt path.
+func (s *stubService) Prompt(string) *dbus.Error {
+	s.mu.Lock()
+	s.prompts++
+	dismiss := s.dismiss
+	if !dismiss {
+		for _, it := range s.items {
+			it.locked = false
+		}
+	}
+	s.mu.Unlock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = s.conn.Emit("/org/freedesktop/secrets/prompt/1", promptIface+".Completed", dismiss, dbus.MakeVariant([]dbus.ObjectPath{}))
+	}()
+	return nil
+}
+
+type stubItemObject struct {
+	s  *stubService
+	it *stubItem
+}
+
+func (o stubItemObject) GetSecret(session dbus.ObjectPath) (secret, *dbus.Error) {
+	o.s.mu.Lock()
+	defer o.s.mu.Unlock()
+	if o.it.locked {
+		return secret{}, dbus.NewError("org.freedesktop.Secret.Error.IsLocked", nil)
+	}
+	return secret{Session: session, Value: []byte(o.it.secret), ContentType: "text/plain"}, nil
+}
+
+func startService(t *testing.T, addr string, items ...*stubItem) *stubService {
+	t.Helper()
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &stubService{conn: conn, items: items}
+	if err := conn.Export(s, servicePath, serviceIface); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Export(s, "/org/freedesktop/secrets/prompt/1", promptIface); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		if err := conn.Export(stubItemObject{s, it}, it.path, itemIface); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := prop.Export(conn, it.path, prop.Map{
+			itemIface: {"Label": {Value: it.label}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply, err := conn.RequestName(serviceName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("request name: %v %v", reply, err)
+	}
+	return s
+}
+
+func newTestManager(addr string) *Manager {
+	return &Manager{connect: func() (*dbus.Conn, error) { return dbus.Connect(addr) }}
+}
+
+func TestResolve(t *testing.T) {
+	addr := startBus(t)
+	startService(t, addr,
+		&stubItem{path: "/org/freedesktop/secrets/collection/login/1", label: "GitHub token",
+			attrs: map[string]string{"service": "github", "user": "alice"}, secret: "ghp_x"},
+		&stubItem{path: "/org/freedesktop/secrets/collection/login/2", label: "GitHub token (old)",
+			attrs: map[string]string{"service": "github", "user": "bob"}, secret: "ghp_old"},
+	)
+	m := newTestManager(addr)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	cases := []struct {
+		query, label, want string
+		wantErr            bool
+	}{
+		{"service=github,user=alice", "", "ghp_x", false},
+		{"service=github", "GitHub token (old)", "ghp_old", false},
+		{"", "GitHub token", "ghp_x", false},
+		{"service=github", "", "", true},
+		{"service=gitlab", "", "", true},
+		{"service", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tc := range cases {
+		got, err := m.Resolve(ctx, tc.query, tc.label)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%q|%q: err=%v wantErr=%v", tc.query, tc.label, err, tc.wantErr)
+		}
+		if err == nil && got != tc.want {
+			t.Errorf("%q|%q: got %q want %q", tc.query, tc.label, got, tc.want)
+		}
+	}
+}
+
+func TestResolveUnlocksLockedItem(t *testing.T) {
+	addr := startBus(t)
+	s := startService(t, addr, &stubItem{path: "/org/freedesktop/secrets/collection/login/1",
+		label: "db", attrs: map[string]string{"app": "db"}, secret: "pw", locked: true})
+	m := newTestManager(addr)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	s.mu.Lock()
+	s.dismiss = true
+	s.mu.Unlock()
+	if _, err := m.Resolve(ctx, "app=db", ""); err == nil || !strings.Contains(err.Error(), "dismissed") {
+		t.Fatalf("expected dismissed prompt error, got %v", err)
+	}
+
+	s.mu.Lock()
+	s.dismiss = false
+	s.mu.Unlock()
+	got, err := m.Resolve(ctx, "app=db", "")
+	if err != nil || got != "pw" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.prompts != 2 {
+		t.Fatalf("expected 2 unlock prompts, got %d", s.prompts)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	got, err := parseAttributes(` service = github , note=a\,b,`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["service"] != "github" || got["note"] != "a,b" {
+		t.Fatalf("got %v", got)
+	}
+	if _, err := parseAttributes("=x"); err == nil {
+		t.Fatal("expected error for empty attribute name")
+	}
+}
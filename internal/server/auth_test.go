@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func newServerForAuthTest(t *testing.T) *DaemonServer {
@@ -31,6 +32,20 @@ func TestAuthAcceptsCorrectToken(t *testing.T) {
 	}
 }
 
+func TestHealthReportsCacheTTL(t *testing.T) {
+	ds := newServerForAuthTest(t)
+	ds.App.UnlockTTL.Store(15 * time.Minute)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-DesktopSecrets-Token", "good-token-aaaa")
+
+	ds.auth(ds.handleHealth)(rec, req)
+
+	if got := rec.Header().Get("X-DesktopSecrets-TTL"); got != "900" {
+		t.Fatalf("got TTL header %q want 900", got)
+	}
+}
+
 func TestAuthRejectsBadToken(t *testing.T) {
 	ds := newServerForAuthTest(t)
 	rec := httptest.NewRecorder()
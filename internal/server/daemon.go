@@ -97,6 +97,9 @@ func RunDaemon() {
 		serverErrCh <- appState.Server.Serve() // blocking call until shutdown
 	}()
 
+	startSSHAgent(appState)
+	startSecretServiceBackend(appState)
+
 	// Publish daemon state to shared memory and keep mapping open.
 	st := &shm.DaemonState{Endpoint: endpoint, Token: token, PID: os.Getpid()}
 	buf, _ := json.Marshal(st)
@@ -4,13 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/it-atelier-gn/desktop-secrets/internal/agefile"
 	"github.com/it-atelier-gn/desktop-secrets/internal/approval"
 	"github.com/it-atelier-gn/desktop-secrets/internal/audit"
+	"github.com/it-atelier-gn/desktop-secrets/internal/aws"
+	"github.com/it-atelier-gn/desktop-secrets/internal/bitwarden"
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/env"
+	"github.com/it-atelier-gn/desktop-secrets/internal/gcpsm"
+	"github.com/it-atelier-gn/desktop-secrets/internal/onepassword"
+	"github.com/it-atelier-gn/desktop-secrets/internal/sops"
+	"github.com/it-atelier-gn/desktop-secrets/internal/totp"
+	"github.com/it-atelier-gn/desktop-secrets/internal/vault"
 )
 
 // ResolveEnvLines processes KEY=VALUE lines and replaces values of the form
@@ -22,15 +35,15 @@ func ResolveEnvLines(ctx context.Context, app *AppState, lines []string) ([]stri
 	var out []string
 	var errs []error
 
-	if app == nil {
-		return lines, []error{errors.New("app state is nil")}
-	}
-	if app.KP == nil || app.USER == nil || app.WINCRED == nil || app.AWS == nil ||
-		app.AZKV == nil || app.GCPSM == nil || app.KEYCHAIN == nil ||
-		app.VAULT == nil || app.ONEPASSWORD == nil {
-		return lines, []error{errors.New("resolvers not configured")}
+	if err := checkResolvers(app); err != nil {
+		return lines, []error{err}
 	}
 
+	// Keys read from the same Vault dynamic secret must come from one
+	// lease, so the whole request is resolved as one render.
+	ctx, done := vault.WithRender(ctx)
+	defer done()
+
 	for _, line := range lines {
 		trim := strings.TrimSpace(line)
 		// preserve comments and blank lines
@@ -55,17 +68,22 @@ func ResolveEnvLines(ctx context.Context, app *AppState, lines []string) ([]stri
 
 		// Non-provider lines pass through verbatim. Server-side os.ExpandEnv
 		// would expand against the daemon's env, leaking it to the client.
-		lower := strings.ToLower(val)
-		if !strings.HasPrefix(lower, "keepass(") && !strings.HasPrefix(lower, "user(") &&
-			!strings.HasPrefix(lower, "wincred(") && !strings.HasPrefix(lower, "awssm(") &&
-			!strings.HasPrefix(lower, "awsps(") && !strings.HasPrefix(lower, "azkv(") &&
-			!strings.HasPrefix(lower, "gcpsm(") && !strings.HasPrefix(lower, "keychain(") &&
-			!strings.HasPrefix(lower, "vault(") && !strings.HasPrefix(lower, "op(") {
+		if !isProviderExpr(val) {
 			out = append(out, key+"="+val)
 			continue
 		}
 
-		resolved, err := parseAndResolve(ctx, app, app.UnlockTTL.Load(), val)
+		var resolved string
+		var expanded []string
+		var err error
+		if isParameterPathExpr(val) {
+			var params map[string]string
+			if params, err = resolveParameterPath(ctx, app, val); err == nil {
+				expanded, err = expandParameters(key, params)
+			}
+		} else {
+			resolved, err = parseAndResolve(ctx, app, app.UnlockTTL.Load(), val)
+		}
 		if err != nil {
 			// Replace the failed line with a diagnostic comment instead
 			// of echoing the literal "KEY=user(...)" provider
@@ -79,12 +97,76 @@ func ResolveEnvLines(ctx context.Context, app *AppState, lines []string) ([]stri
 			errs = append(errs, fmt.Errorf("key %s: %w", key, err))
 			continue
 		}
+		if expanded != nil {
+			out = append(out, expanded...)
+			continue
+		}
 		out = append(out, key+"="+resolved)
 	}
 
 	return out, errs
 }
 
+// ResolveRefs resolves each reference on its own, for clients that
+// need the values verbatim rather than as KEY=VALUE lines. References
+// that are not provider expressions come back unchanged. Every
+// reference ends up in exactly one of values and errs; expires holds,
+// for values served from a provider's cache, when that cache entry (or
+// the credential itself) expires.
+func ResolveRefs(ctx context.Context, app *AppState, refs []string) (values map[string]string, expires map[string]time.Time, errs map[string]error) {
+	values = make(map[string]string, len(refs))
+	expires = map[string]time.Time{}
+	errs = map[string]error{}
+	if err := checkResolvers(app); err != nil {
+		for _, ref := range refs {
+			errs[ref] = err
+		}
+		return values, expires, errs
+	}
+
+	ctx, done := vault.WithRender(ctx)
+	defer done()
+
+	for _, ref := range refs {
+		if _, seen := values[ref]; seen {
+			continue
+		}
+		if _, seen := errs[ref]; seen {
+			continue
+		}
+		if !isProviderExpr(strings.TrimSpace(ref)) {
+			values[ref] = ref
+			continue
+		}
+		refCtx, earliest := cacheinfo.WithExpiry(ctx)
+		v, err := parseAndResolve(refCtx, app, app.UnlockTTL.Load(), ref)
+		if err != nil {
+			errs[ref] = err
+			continue
+		}
+		values[ref] = v
+		if t := earliest(); !t.IsZero() {
+			expires[ref] = t
+		}
+	}
+	return values, expires, errs
+}
+
+// checkResolvers reports an app state that cannot resolve anything.
+func checkResolvers(app *AppState) error {
+	if app == nil {
+		return errors.New("app state is nil")
+	}
+	if app.KP == nil || app.USER == nil || app.WINCRED == nil || app.AWS == nil ||
+		app.AZKV == nil || app.GCPSM == nil || app.KEYCHAIN == nil ||
+		app.VAULT == nil || app.ONEPASSWORD == nil || app.SOPS == nil || app.AGE == nil ||
+		app.PASS == nil || app.BW == nil || app.SECRETSERVICE == nil ||
+		app.K8S == nil {
+		return errors.New("resolvers not configured")
+	}
+	return nil
+}
+
 func gate(ctx context.Context, app *AppState, providerKey, providerRef string, evictor approval.Evictor, fn func() (string, error)) (string, error) {
 	return gateWithUnlock(ctx, app, providerKey, providerRef, evictor, nil, fn)
 }
@@ -157,6 +239,24 @@ func logGateError(app *AppState, info clientinfo.Info, providerKey, providerRef
 	}
 }
 
+// providerPrefixes are the expression openers parseAndResolve handles.
+var providerPrefixes = []string{
+	"keepass(", "user(", "wincred(", "awssm(", "awsps(", "awssts(", "azkv(", "azcert(", "gcpsm(",
+	"keychain(", "vault(", "op(", "sops(", "age(", "pass(", "bw(",
+	"secretservice(", "k8s(", "totp(",
+}
+
+// isProviderExpr reports whether val is a provider expression.
+func isProviderExpr(val string) bool {
+	lower := strings.ToLower(val)
+	for _, p := range providerPrefixes {
+		if strings.HasPrefix(lower, p) {
+			return true
+		}
+	}
+	return false
+}
+
 // parseAndResolve parses a top-level expression and resolves it.
 // If a nested expression exists inside brackets, the nested expression is
 // resolved first and its raw value is passed to the upper-level KP resolver
@@ -294,14 +394,20 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 		if strings.TrimSpace(rem) != "" {
 			return "", fmt.Errorf("unexpected trailing characters after awssm expression")
 		}
-		secretID, field := splitFirstPipe(strings.TrimSpace(content))
+		rest, field := cutField(content)
+		secretID, opts, err := splitRefOptions(rest, "profile", "region", "stage", "version")
+		if err != nil {
+			return "", fmt.Errorf("parse awssm: %w", err)
+		}
 		if secretID == "" {
 			return "", errors.New("empty awssm secret id")
 		}
-		return gate(ctx, app, "awssm:"+secretID+"|"+field, fmt.Sprintf("awssm(%s|%s)", secretID, field),
-			func(_ string) { app.AWS.Evict("sm:" + secretID) },
+		opt := aws.Options{Profile: opts["profile"], Region: opts["region"], VersionStage: opts["stage"], VersionID: opts["version"]}
+		key := aws.CacheKey("sm", secretID, opt)
+		return gate(ctx, app, "awssm:"+key+"|"+field, fmt.Sprintf("awssm(%s|%s)", key, field),
+			func(_ string) { app.AWS.Evict(key) },
 			func() (string, error) {
-				v, err := app.AWS.ResolveSecret(ctx, secretID, field)
+				v, err := app.AWS.ResolveSecret(ctx, secretID, field, opt)
 				if err != nil {
 					return "", fmt.Errorf("awssm resolve failed: %w", err)
 				}
@@ -317,14 +423,23 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 		if strings.TrimSpace(rem) != "" {
 			return "", fmt.Errorf("unexpected trailing characters after awsps expression")
 		}
-		name, field := splitFirstPipe(strings.TrimSpace(content))
+		rest, field := cutField(content)
+		name, opts, err := splitRefOptions(rest, "profile", "region")
+		if err != nil {
+			return "", fmt.Errorf("parse awsps: %w", err)
+		}
 		if name == "" {
 			return "", errors.New("empty awsps parameter name")
 		}
-		return gate(ctx, app, "awsps:"+name+"|"+field, fmt.Sprintf("awsps(%s|%s)", name, field),
-			func(_ string) { app.AWS.Evict("ps:" + name) },
+		opt := aws.Options{Profile: opts["profile"], Region: opts["region"]}
+		key := aws.CacheKey("ps", name, opt)
+		if strings.HasSuffix(name, "/") {
+			return "", errParameterPath
+		}
+		return gate(ctx, app, "awsps:"+key+"|"+field, fmt.Sprintf("awsps(%s|%s)", key, field),
+			func(_ string) { app.AWS.Evict(key) },
 			func() (string, error) {
-				v, err := app.AWS.ResolveParameter(ctx, name, field)
+				v, err := app.AWS.ResolveParameter(ctx, name, field, opt)
 				if err != nil {
 					return "", fmt.Errorf("awsps resolve failed: %w", err)
 				}
@@ -332,6 +447,46 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 			})
 	}
 
+	if strings.HasPrefix(strings.ToLower(s), "awssts(") {
+		content, rem, err := parseParenContent(s[len("awssts"):])
+		if err != nil {
+			return "", fmt.Errorf("parse awssts: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after awssts expression")
+		}
+		rest, field := cutField(content)
+		roleARN, opts, err := splitRefOptions(rest, "profile", "region", "duration", "session_name", "external_id", "mfa", "mfa_code")
+		if err != nil {
+			return "", fmt.Errorf("parse awssts: %w", err)
+		}
+		if roleARN == "" {
+			return "", errors.New("empty awssts role ARN")
+		}
+		opt := aws.AssumeOptions{
+			Options:     aws.Options{Profile: opts["profile"], Region: opts["region"]},
+			SessionName: opts["session_name"],
+			ExternalID:  opts["external_id"],
+			MFASerial:   opts["mfa"],
+			MFACode:     opts["mfa_code"],
+		}
+		if d := opts["duration"]; d != "" {
+			if opt.Duration, err = parseDurationOpt(d); err != nil {
+				return "", fmt.Errorf("parse awssts: %w", err)
+			}
+		}
+		key := aws.STSCacheKey(roleARN, opt)
+		return gate(ctx, app, "awssts:"+key+"|"+field, fmt.Sprintf("awssts(%s|%s)", key, field),
+			func(_ string) { app.AWS.Evict(key) },
+			func() (string, error) {
+				v, err := app.AWS.AssumeRole(ctx, roleARN, field, opt)
+				if err != nil {
+					return "", fmt.Errorf("awssts resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
 	if strings.HasPrefix(strings.ToLower(s), "azkv(") {
 		content, rem, err := parseParenContent(s[len("azkv"):])
 		if err != nil {
@@ -355,6 +510,29 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 			})
 	}
 
+	if strings.HasPrefix(strings.ToLower(s), "azcert(") {
+		content, rem, err := parseParenContent(s[len("azcert"):])
+		if err != nil {
+			return "", fmt.Errorf("parse azcert: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after azcert expression")
+		}
+		ref, part := splitFirstPipe(strings.TrimSpace(content))
+		if ref == "" {
+			return "", errors.New("empty azcert reference")
+		}
+		return gate(ctx, app, "azcert:"+ref+"|"+part, fmt.Sprintf("azcert(%s|%s)", ref, part),
+			func(_ string) { app.AZKV.Evict(ref) },
+			func() (string, error) {
+				v, err := app.AZKV.ResolveCertificate(ctx, ref, part)
+				if err != nil {
+					return "", fmt.Errorf("azcert resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
 	if strings.HasPrefix(strings.ToLower(s), "gcpsm(") {
 		content, rem, err := parseParenContent(s[len("gcpsm"):])
 		if err != nil {
@@ -363,14 +541,25 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 		if strings.TrimSpace(rem) != "" {
 			return "", fmt.Errorf("unexpected trailing characters after gcpsm expression")
 		}
-		ref, field := splitFirstPipe(strings.TrimSpace(content))
+		rest, field := cutField(content)
+		ref, opts, err := splitRefOptions(rest, "impersonate", "location", "checksum")
+		if err != nil {
+			return "", fmt.Errorf("parse gcpsm: %w", err)
+		}
 		if ref == "" {
 			return "", errors.New("empty gcpsm reference")
 		}
-		return gate(ctx, app, "gcpsm:"+ref+"|"+field, fmt.Sprintf("gcpsm(%s|%s)", ref, field),
-			func(_ string) { app.GCPSM.Evict(ref) },
+		opt := gcpsm.Options{Impersonate: opts["impersonate"], Location: opts["location"]}
+		if v, ok := opts["checksum"]; ok {
+			if opt.Checksum, err = strconv.ParseBool(v); err != nil {
+				return "", fmt.Errorf("parse gcpsm: checksum=%q is not true or false", v)
+			}
+		}
+		key := gcpsm.CacheKey(ref, opt)
+		return gate(ctx, app, "gcpsm:"+key+"|"+field, fmt.Sprintf("gcpsm(%s|%s)", key, field),
+			func(_ string) { app.GCPSM.Evict(key) },
 			func() (string, error) {
-				v, err := app.GCPSM.ResolveSecret(ctx, ref, field)
+				v, err := app.GCPSM.ResolveSecret(ctx, ref, field, opt)
 				if err != nil {
 					return "", fmt.Errorf("gcpsm resolve failed: %w", err)
 				}
@@ -408,14 +597,15 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 		if strings.TrimSpace(rem) != "" {
 			return "", fmt.Errorf("unexpected trailing characters after vault expression")
 		}
-		path, field := splitFirstPipe(strings.TrimSpace(content))
-		if path == "" {
-			return "", errors.New("empty vault path")
+		path, field, opt, err := parseVaultRef(content)
+		if err != nil {
+			return "", err
 		}
-		return gate(ctx, app, "vault:"+path+"|"+field, fmt.Sprintf("vault(%s|%s)", path, field),
-			func(_ string) { app.VAULT.Evict(path) },
+		key := vault.CacheKey(path, opt)
+		return gate(ctx, app, "vault:"+key+"|"+field, fmt.Sprintf("vault(%s|%s)", key, field),
+			func(_ string) { app.VAULT.Evict(key) },
 			func() (string, error) {
-				v, err := app.VAULT.ResolveSecret(ctx, path, field)
+				v, err := app.VAULT.ResolveSecret(ctx, path, field, opt)
 				if err != nil {
 					return "", fmt.Errorf("vault resolve failed: %w", err)
 				}
@@ -431,14 +621,20 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 		if strings.TrimSpace(rem) != "" {
 			return "", fmt.Errorf("unexpected trailing characters after op expression")
 		}
-		ref, field := splitFirstPipe(strings.TrimSpace(content))
+		rest, field := cutField(content)
+		ref, opts, err := splitRefOptions(rest, "account")
+		if err != nil {
+			return "", fmt.Errorf("parse op: %w", err)
+		}
 		if ref == "" {
 			return "", errors.New("empty op reference")
 		}
-		return gate(ctx, app, "op:"+ref+"|"+field, fmt.Sprintf("op(%s|%s)", ref, field),
-			func(_ string) { app.ONEPASSWORD.Evict(ref) },
+		opt := onepassword.Options{Account: opts["account"]}
+		key := onepassword.CacheKey(ref, field, opt)
+		return gate(ctx, app, "op:"+key, fmt.Sprintf("op(%s)", key),
+			func(_ string) { app.ONEPASSWORD.Evict(key) },
 			func() (string, error) {
-				v, err := app.ONEPASSWORD.ResolveSecret(ctx, ref, field)
+				v, err := app.ONEPASSWORD.ResolveSecret(ctx, ref, field, opt)
 				if err != nil {
 					return "", fmt.Errorf("op resolve failed: %w", err)
 				}
@@ -446,9 +642,246 @@ func parseAndResolve(ctx context.Context, app *AppState, ttl time.Duration, s st
 			})
 	}
 
+	if strings.HasPrefix(strings.ToLower(s), "sops(") {
+		content, rem, err := parseParenContent(s[len("sops"):])
+		if err != nil {
+			return "", fmt.Errorf("parse sops: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after sops expression")
+		}
+		path, nestedExpr, field, err := parseFileRef(ctx, content)
+		if err != nil {
+			return "", fmt.Errorf("invalid sops path: %w", err)
+		}
+		key := sops.CacheKey(path, nestedExpr)
+		return gate(ctx, app, "sops:"+key+"|"+field, fmt.Sprintf("sops(%s|%s)", key, field),
+			func(_ string) { app.SOPS.Evict(key) },
+			func() (string, error) {
+				ageKey, err := resolveNested(ctx, app, ttl, nestedExpr)
+				if err != nil {
+					return "", err
+				}
+				v, err := app.SOPS.ResolveSecret(ctx, path, field, nestedExpr, ageKey)
+				if err != nil {
+					return "", fmt.Errorf("sops resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "age(") {
+		content, rem, err := parseParenContent(s[len("age"):])
+		if err != nil {
+			return "", fmt.Errorf("parse age: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after age expression")
+		}
+		path, nestedExpr, field, err := parseFileRef(ctx, content)
+		if err != nil {
+			return "", fmt.Errorf("invalid age path: %w", err)
+		}
+		key := agefile.CacheKey(path, nestedExpr)
+		return gate(ctx, app, "age:"+key+"|"+field, fmt.Sprintf("age(%s|%s)", key, field),
+			func(_ string) { app.AGE.Evict(key) },
+			func() (string, error) {
+				identity, err := resolveNested(ctx, app, ttl, nestedExpr)
+				if err != nil {
+					return "", err
+				}
+				v, err := app.AGE.ResolveSecret(ctx, path, field, nestedExpr, identity)
+				if err != nil {
+					return "", fmt.Errorf("age resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "pass(") {
+		content, rem, err := parseParenContent(s[len("pass"):])
+		if err != nil {
+			return "", fmt.Errorf("parse pass: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after pass expression")
+		}
+		entry, field := splitFirstPipe(strings.TrimSpace(content))
+		if entry == "" {
+			return "", errors.New("empty pass entry")
+		}
+		return gate(ctx, app, "pass:"+entry+"|"+field, fmt.Sprintf("pass(%s|%s)", entry, field),
+			func(_ string) { app.PASS.Evict(entry) },
+			func() (string, error) {
+				v, err := app.PASS.ResolveSecret(ctx, entry, field)
+				if err != nil {
+					return "", fmt.Errorf("pass resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "bw(") {
+		content, rem, err := parseParenContent(s[len("bw"):])
+		if err != nil {
+			return "", fmt.Errorf("parse bw: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after bw expression")
+		}
+		item, field := splitFirstPipe(strings.TrimSpace(content))
+		if item == "" {
+			return "", errors.New("empty bw item")
+		}
+		key := bitwarden.CacheKey(item, field)
+		return gateWithUnlock(ctx, app, "bw:"+key, fmt.Sprintf("bw(%s|%s)", item, field),
+			func(_ string) { app.BW.Evict(key) },
+			func() bool { return !app.BW.Unlocked() },
+			func() (string, error) {
+				v, err := app.BW.ResolveSecret(ctx, item, field)
+				if err != nil {
+					return "", fmt.Errorf("bw resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "secretservice(") {
+		content, rem, err := parseParenContent(s[len("secretservice"):])
+		if err != nil {
+			return "", fmt.Errorf("parse secretservice: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after secretservice expression")
+		}
+		query, label := splitFirstPipe(strings.TrimSpace(content))
+		if query == "" && label == "" {
+			return "", errors.New("empty secretservice query")
+		}
+		return gate(ctx, app, "secretservice:"+query+"|"+label, fmt.Sprintf("secretservice(%s|%s)", query, label), nil,
+			func() (string, error) {
+				v, err := app.SECRETSERVICE.Resolve(ctx, query, label)
+				if err != nil {
+					return "", fmt.Errorf("secretservice resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "k8s(") {
+		content, rem, err := parseParenContent(s[len("k8s"):])
+		if err != nil {
+			return "", fmt.Errorf("parse k8s: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after k8s expression")
+		}
+		ref, key := splitFirstPipe(strings.TrimSpace(content))
+		if ref == "" {
+			return "", errors.New("empty k8s reference")
+		}
+		return gate(ctx, app, "k8s:"+ref+"|"+key, fmt.Sprintf("k8s(%s|%s)", ref, key),
+			func(_ string) { app.K8S.Evict(ref) },
+			func() (string, error) {
+				v, err := app.K8S.ResolveSecret(ctx, ref, key)
+				if err != nil {
+					return "", fmt.Errorf("k8s resolve failed: %w", err)
+				}
+				return v, nil
+			})
+	}
+
+	if strings.HasPrefix(strings.ToLower(s), "totp(") {
+		content, rem, err := parseParenContent(s[len("totp"):])
+		if err != nil {
+			return "", fmt.Errorf("parse totp: %w", err)
+		}
+		if strings.TrimSpace(rem) != "" {
+			return "", fmt.Errorf("unexpected trailing characters after totp expression")
+		}
+		seedRef, opts, err := splitRefOptions(content, "digits", "period", "algorithm", "min_remaining")
+		if err != nil {
+			return "", fmt.Errorf("parse totp: %w", err)
+		}
+		if !isProviderExpr(seedRef) {
+			return "", errors.New("totp needs a reference to the seed, e.g. totp(keepass(&work|/VPN|otp))")
+		}
+		var minRemaining time.Duration
+		if v, ok := opts["min_remaining"]; ok {
+			if minRemaining, err = parseDurationOpt(v); err != nil {
+				return "", fmt.Errorf("parse totp: min_remaining: %w", err)
+			}
+		}
+		return gate(ctx, app, "totp:"+seedRef, fmt.Sprintf("totp(%s)", seedRef), nil,
+			func() (string, error) {
+				seed, err := resolveNested(ctx, app, ttl, seedRef)
+				if err != nil {
+					return "", err
+				}
+				p, err := totp.Parse(seed)
+				if err != nil {
+					return "", err
+				}
+				defer clear(p.Secret)
+				for _, name := range []string{"digits", "period", "algorithm"} {
+					if err := p.Set(name, opts[name]); err != nil {
+						return "", err
+					}
+				}
+				if minRemaining >= p.Period {
+					return "", fmt.Errorf("totp: min_remaining must be shorter than the %v period", p.Period)
+				}
+				now := time.Now()
+				if left := totp.Remaining(p, now); left < minRemaining {
+					// Wait for the next window so the code is not about
+					// to expire by the time it is used.
+					select {
+					case <-ctx.Done():
+						return "", ctx.Err()
+					case <-time.After(left):
+					}
+					now = now.Add(left)
+				}
+				return totp.Code(p, now), nil
+			})
+	}
+
 	return "", errors.New("not a recognized expression")
 }
 
+// parseFileRef splits the content of a file-based reference,
+// "PATH[NESTED]|field", into its parts. The optional bracketed
+// expression supplies a key for the file. Relative paths are made
+// relative to the client's working directory, not the daemon's.
+func parseFileRef(ctx context.Context, content string) (path, nestedExpr, field string, err error) {
+	pathRaw := strings.TrimSpace(content)
+	if idx := indexTopLevelPipe(content); idx >= 0 {
+		pathRaw = strings.TrimSpace(content[:idx])
+		field = strings.TrimSpace(content[idx+1:])
+	}
+	path, nestedExpr, err = splitVaultAndSingleNested(pathRaw)
+	if err != nil {
+		return "", "", "", err
+	}
+	if cwd := clientinfo.InfoFromContext(ctx).Cwd; cwd != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	return path, nestedExpr, field, nil
+}
+
+// resolveNested resolves an optional nested expression; empty in,
+// empty out.
+func resolveNested(ctx context.Context, app *AppState, ttl time.Duration, expr string) (string, error) {
+	if expr == "" {
+		return "", nil
+	}
+	v, err := parseAndResolve(ctx, app, ttl, expr)
+	if err != nil {
+		return "", fmt.Errorf("resolving nested expression %q: %w", expr, err)
+	}
+	return v, nil
+}
+
 // errComment renders an error message so it can be safely embedded in
 // a single-line shell comment. Newlines, carriage returns, and the
 // closing '>' (which would prematurely terminate "<unresolved: ...>")
@@ -574,3 +1007,204 @@ func splitVaultAndSingleNested(vaultRaw string) (base string, nestedExpr string,
 	// no brackets
 	return vaultRaw, "", nil
 }
+
+// parseVaultRef splits the content of
+// vault(PATH; version=N; namespace=NS|FIELD). FIELD and the options
+// are optional.
+func parseVaultRef(content string) (path, field string, opt vault.ReadOptions, err error) {
+	rest, field := cutField(content)
+	if f, extra, ok := strings.Cut(field, "|"); ok {
+		return "", "", opt, fmt.Errorf("vault: unexpected %q after field %q", strings.TrimSpace(extra), strings.TrimSpace(f))
+	}
+	path, opts, err := splitRefOptions(rest, "version", "namespace")
+	if err != nil {
+		return "", "", opt, fmt.Errorf("vault: %w", err)
+	}
+	if path == "" {
+		return "", "", opt, errors.New("empty vault path")
+	}
+	if v, ok := opts["version"]; ok {
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil || n < 1 {
+			return "", "", opt, fmt.Errorf("vault: invalid version %q", v)
+		}
+		opt.Version = n
+	}
+	opt.Namespace = strings.Trim(opts["namespace"], "/")
+	return path, field, opt, nil
+}
+
+// splitRefOptions splits `REF; name=value; ...` into REF and its
+// options, rejecting option names not in allowed. Values may be
+// references themselves; `;` inside their parentheses is not a
+// separator.
+func splitRefOptions(content string, allowed ...string) (string, map[string]string, error) {
+	parts := splitTopLevel(content, ';')
+	opts := make(map[string]string)
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(p, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return "", nil, fmt.Errorf("option %q is not NAME=VALUE", p)
+		}
+		if !slices.Contains(allowed, name) {
+			return "", nil, fmt.Errorf("unknown option %q (allowed: %s)", name, strings.Join(allowed, ", "))
+		}
+		opts[name] = strings.TrimSpace(val)
+	}
+	return strings.TrimSpace(parts[0]), opts, nil
+}
+
+// isParameterPathExpr reports whether val is an awsps(...) reference to
+// a parameter path, which expands into one variable per parameter.
+func isParameterPathExpr(val string) bool {
+	if !strings.HasPrefix(strings.ToLower(val), "awsps(") {
+		return false
+	}
+	content, _, err := parseParenContent(val[len("awsps"):])
+	if err != nil {
+		return false
+	}
+	rest, _ := cutField(content)
+	name, _, err := splitRefOptions(rest, "profile", "region")
+	return err == nil && strings.HasSuffix(name, "/")
+}
+
+// errParameterPath rejects an awsps(/path/) reference anywhere it
+// would have to stand for a single value.
+var errParameterPath = errors.New("awsps: path references are only valid as a whole env line, as in KEY=awsps(/path/)")
+
+// resolveParameterPath reads every parameter below the path of an
+// awsps(/path/) reference, for ResolveEnvLines to expand.
+func resolveParameterPath(ctx context.Context, app *AppState, val string) (map[string]string, error) {
+	content, rem, err := parseParenContent(strings.TrimSpace(val)[len("awsps"):])
+	if err != nil {
+		return nil, fmt.Errorf("parse awsps: %w", err)
+	}
+	if strings.TrimSpace(rem) != "" {
+		return nil, fmt.Errorf("unexpected trailing characters after awsps expression")
+	}
+	rest, field := cutField(content)
+	if field != "" {
+		return nil, errors.New("awsps: a parameter path takes no field")
+	}
+	name, opts, err := splitRefOptions(rest, "profile", "region")
+	if err != nil {
+		return nil, fmt.Errorf("parse awsps: %w", err)
+	}
+	opt := aws.Options{Profile: opts["profile"], Region: opts["region"]}
+	key := aws.CacheKey("ps", name, opt)
+	var params map[string]string
+	_, err = gate(ctx, app, "awsps:"+key, fmt.Sprintf("awsps(%s)", key),
+		func(_ string) { app.AWS.Evict(key) },
+		func() (string, error) {
+			p, err := app.AWS.ResolveParametersByPath(ctx, name, opt)
+			if err != nil {
+				return "", fmt.Errorf("awsps resolve failed: %w", err)
+			}
+			params = p
+			return "", nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// expandParameters turns the parameters read from a path into KEY=VALUE
+// lines. Each variable is named prefix_NAME, with NAME the parameter's
+// name below the path, upper-cased and with every character that is not
+// allowed in a variable name replaced by `_`: `DB=awsps(/app/db/)`
+// yields DB_HOST and DB_PASSWORD for /app/db/host and /app/db/password.
+func expandParameters(prefix string, params map[string]string) ([]string, error) {
+	if !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	names := make([]string, 0, len(params))
+	for n := range params {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	from := make(map[string]string, len(names))
+	for _, n := range names {
+		key := prefix + strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			}
+			return '_'
+		}, n)
+		if prev, dup := from[key]; dup {
+			return nil, fmt.Errorf("awsps: parameters %q and %q both map to %s", prev, n, key)
+		}
+		from[key] = n
+		out = append(out, key+"="+params[n])
+	}
+	return out, nil
+}
+
+// splitTopLevel splits s at every sep outside parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutField removes the `|FIELD` selector from a reference that takes
+// options, which may come before or after it: both `ID|FIELD; opt=v`
+// and `ID; opt=v|FIELD` yield ("ID; opt=v", "FIELD"). A `|` inside a
+// nested reference is left alone.
+func cutField(s string) (rest, field string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '|':
+			if depth != 0 {
+				continue
+			}
+			end := len(s)
+			if j := strings.IndexByte(s[i:], ';'); j >= 0 {
+				end = i + j
+			}
+			return strings.TrimSpace(s[:i] + s[end:]), strings.TrimSpace(s[i+1 : end])
+		}
+	}
+	return strings.TrimSpace(s), ""
+}
+
+// parseDurationOpt reads a duration option given as a Go duration
+// (`1h`, `90m`) or in seconds.
+func parseDurationOpt(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	return d, nil
+}
@@ -2,12 +2,21 @@ package server
 
 import (
 	"context"
+	"encoding/base32"
 	"errors"
+	"github.com/it-atelier-gn/desktop-secrets/internal/aws"
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/gcpsm"
 	"github.com/it-atelier-gn/desktop-secrets/internal/keepass"
+	"github.com/it-atelier-gn/desktop-secrets/internal/onepassword"
+	"github.com/it-atelier-gn/desktop-secrets/internal/totp"
 	"github.com/it-atelier-gn/desktop-secrets/internal/utils"
+	"github.com/it-atelier-gn/desktop-secrets/internal/vault"
+	"path/filepath"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -91,16 +100,19 @@ func (f *fakeKPResolver) Keyfiles() []keepass.KeyfileInfo { return nil }
 func (f *fakeKPResolver) SetKeyfiles([]keepass.KeyfileInfo) error { return nil }
 
 type fakeAWSResolver struct {
-	secrets    map[string]string // "sm:id|field" -> value
-	parameters map[string]string // "ps:name|field" -> value
+	secrets    map[string]string            // "cache key|field" -> value
+	parameters map[string]string            // "cache key|field" -> value
+	paths      map[string]map[string]string // cache key -> parameters
+	sessions   map[string]string            // "sts cache key|field" -> value
+	assumed    []aws.AssumeOptions
 	err        error
 }
 
-func (f *fakeAWSResolver) ResolveSecret(_ context.Context, secretID, field string) (string, error) {
+func (f *fakeAWSResolver) ResolveSecret(_ context.Context, secretID, field string, opt aws.Options) (string, error) {
 	if f.err != nil {
 		return "", f.err
 	}
-	k := "sm:" + secretID + "|" + field
+	k := aws.CacheKey("sm", secretID, opt) + "|" + field
 	if v, ok := f.secrets[k]; ok {
 		return v, nil
 	}
@@ -113,17 +125,40 @@ func (f *fakeAWSResolver) EvictAll() {}
 
 func (f *fakeAWSResolver) CachedKeys() []cacheinfo.Entry { return nil }
 
-func (f *fakeAWSResolver) ResolveParameter(_ context.Context, name, field string) (string, error) {
+func (f *fakeAWSResolver) ResolveParameter(_ context.Context, name, field string, opt aws.Options) (string, error) {
 	if f.err != nil {
 		return "", f.err
 	}
-	k := "ps:" + name + "|" + field
+	k := aws.CacheKey("ps", name, opt) + "|" + field
 	if v, ok := f.parameters[k]; ok {
 		return v, nil
 	}
 	return "", errors.New("parameter not found")
 }
 
+func (f *fakeAWSResolver) AssumeRole(_ context.Context, roleARN, field string, opt aws.AssumeOptions) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	f.assumed = append(f.assumed, opt)
+	if v, ok := f.sessions[aws.STSCacheKey(roleARN, opt)+"|"+field]; ok {
+		return v, nil
+	}
+	return "", errors.New("role not found")
+}
+
+func (f *fakeAWSResolver) SetReferenceResolver(func(context.Context, string) (string, error)) {}
+
+func (f *fakeAWSResolver) ResolveParametersByPath(_ context.Context, path string, opt aws.Options) (map[string]string, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if v, ok := f.paths[aws.CacheKey("ps", path, opt)]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no parameters")
+}
+
 type fakeWincredResolver struct {
 	// map "target|field" -> value
 	creds map[string]string
@@ -143,6 +178,7 @@ func (f *fakeWincredResolver) Resolve(_ context.Context, target, field string) (
 
 type fakeAzureResolver struct {
 	secrets map[string]string // "ref|field" -> value
+	certs   map[string]string // "ref|part" -> value
 	err     error
 }
 
@@ -156,6 +192,15 @@ func (f *fakeAzureResolver) ResolveSecret(_ context.Context, ref, field string)
 	return "", errors.New("azkv secret not found")
 }
 
+func (f *fakeAzureResolver) ResolveCertificate(_ context.Context, ref, part string) (string, error) {
+	if v, ok := f.certs[ref+"|"+part]; ok {
+		return v, nil
+	}
+	return "", errors.New("azcert certificate not found")
+}
+
+func (f *fakeAzureResolver) SetReferenceResolver(func(context.Context, string) (string, error)) {}
+
 func (f *fakeAzureResolver) Evict(string) {}
 
 func (f *fakeAzureResolver) EvictAll() {}
@@ -164,13 +209,15 @@ func (f *fakeAzureResolver) CachedKeys() []cacheinfo.Entry { return nil }
 
 type fakeGCPResolver struct {
 	secrets map[string]string // "ref|field" -> value
+	opts    []gcpsm.Options
 	err     error
 }
 
-func (f *fakeGCPResolver) ResolveSecret(_ context.Context, ref, field string) (string, error) {
+func (f *fakeGCPResolver) ResolveSecret(_ context.Context, ref, field string, opt gcpsm.Options) (string, error) {
 	if f.err != nil {
 		return "", f.err
 	}
+	f.opts = append(f.opts, opt)
 	if v, ok := f.secrets[ref+"|"+field]; ok {
 		return v, nil
 	}
@@ -199,15 +246,15 @@ func (f *fakeKeychainResolver) Resolve(_ context.Context, service, account strin
 }
 
 type fakeVaultResolver struct {
-	secrets map[string]string // "path|field" -> value
+	secrets map[string]string // "cache key|field" -> value
 	err     error
 }
 
-func (f *fakeVaultResolver) ResolveSecret(_ context.Context, path, field string) (string, error) {
+func (f *fakeVaultResolver) ResolveSecret(_ context.Context, path, field string, opt vault.ReadOptions) (string, error) {
 	if f.err != nil {
 		return "", f.err
 	}
-	if v, ok := f.secrets[path+"|"+field]; ok {
+	if v, ok := f.secrets[vault.CacheKey(path, opt)+"|"+field]; ok {
 		return v, nil
 	}
 	return "", errors.New("vault secret not found")
@@ -219,27 +266,141 @@ func (f *fakeVaultResolver) EvictAll() {}
 
 func (f *fakeVaultResolver) CachedKeys() []cacheinfo.Entry { return nil }
 
+func (f *fakeVaultResolver) Close(context.Context) {}
+
 type fakeOnePasswordResolver struct {
 	secrets map[string]string // "ref|field" -> value
+	opts    []onepassword.Options
 	err     error
 }
 
-func (f *fakeOnePasswordResolver) ResolveSecret(_ context.Context, ref, field string) (string, error) {
+func (f *fakeOnePasswordResolver) ResolveSecret(_ context.Context, ref, field string, opt onepassword.Options) (string, error) {
 	if f.err != nil {
 		return "", f.err
 	}
+	f.opts = append(f.opts, opt)
 	if v, ok := f.secrets[ref+"|"+field]; ok {
 		return v, nil
 	}
 	return "", errors.New("op secret not found")
 }
 
+func (f *fakeOnePasswordResolver) SetReferenceResolver(func(context.Context, string) (string, error)) {
+}
+
 func (f *fakeOnePasswordResolver) Evict(string) {}
 
 func (f *fakeOnePasswordResolver) EvictAll() {}
 
 func (f *fakeOnePasswordResolver) CachedKeys() []cacheinfo.Entry { return nil }
 
+type fakeSopsResolver struct {
+	secrets map[string]string // "path|field|ageKey" -> value
+	err     error
+}
+
+func (f *fakeSopsResolver) ResolveSecret(_ context.Context, path, field, _, ageKey string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if v, ok := f.secrets[path+"|"+field+"|"+ageKey]; ok {
+		return v, nil
+	}
+	return "", errors.New("sops secret not found")
+}
+
+func (f *fakeSopsResolver) Evict(string) {}
+
+func (f *fakeSopsResolver) EvictAll() {}
+
+func (f *fakeSopsResolver) CachedKeys() []cacheinfo.Entry { return nil }
+
+type fakeAgeResolver struct {
+	secrets map[string]string // "path|field|identity" -> value
+}
+
+func (f *fakeAgeResolver) ResolveSecret(_ context.Context, path, field, _, identity string) (string, error) {
+	if v, ok := f.secrets[path+"|"+field+"|"+identity]; ok {
+		return v, nil
+	}
+	return "", errors.New("age secret not found")
+}
+
+func (f *fakeAgeResolver) Evict(string) {}
+
+func (f *fakeAgeResolver) EvictAll() {}
+
+func (f *fakeAgeResolver) CachedKeys() []cacheinfo.Entry { return nil }
+
+type fakePassResolver struct {
+	secrets map[string]string    // "entry|field" -> value
+	expires map[string]time.Time // "entry|field" -> cache expiry
+}
+
+func (f *fakePassResolver) ResolveSecret(ctx context.Context, entry, field string) (string, error) {
+	if v, ok := f.secrets[entry+"|"+field]; ok {
+		cacheinfo.NoteExpiry(ctx, f.expires[entry+"|"+field])
+		return v, nil
+	}
+	return "", errors.New("pass entry not found")
+}
+
+func (f *fakePassResolver) Evict(string) {}
+
+func (f *fakePassResolver) EvictAll() {}
+
+func (f *fakePassResolver) CachedKeys() []cacheinfo.Entry { return nil }
+
+type fakeBitwardenResolver struct {
+	secrets  map[string]string // "item|field" -> value
+	unlocked bool
+}
+
+func (f *fakeBitwardenResolver) ResolveSecret(_ context.Context, item, field string) (string, error) {
+	if v, ok := f.secrets[item+"|"+field]; ok {
+		return v, nil
+	}
+	return "", errors.New("bw item not found")
+}
+
+func (f *fakeBitwardenResolver) Unlocked() bool { return f.unlocked }
+
+func (f *fakeBitwardenResolver) SetUnlockTTL(*utils.AtomicDuration) {}
+
+func (f *fakeBitwardenResolver) Evict(string) {}
+
+func (f *fakeBitwardenResolver) EvictAll() {}
+
+func (f *fakeBitwardenResolver) CachedKeys() []cacheinfo.Entry { return nil }
+
+type fakeSecretServiceResolver struct {
+	secrets map[string]string // "query|label" -> value
+}
+
+func (f *fakeSecretServiceResolver) Resolve(_ context.Context, query, label string) (string, error) {
+	if v, ok := f.secrets[query+"|"+label]; ok {
+		return v, nil
+	}
+	return "", errors.New("no matching item")
+}
+
+type fakeK8sResolver struct {
+	secrets map[string]string // "ref|key" -> value
+}
+
+func (f *fakeK8sResolver) ResolveSecret(_ context.Context, ref, key string) (string, error) {
+	if v, ok := f.secrets[ref+"|"+key]; ok {
+		return v, nil
+	}
+	return "", errors.New("k8s secret not found")
+}
+
+func (f *fakeK8sResolver) Evict(string) {}
+
+func (f *fakeK8sResolver) EvictAll() {}
+
+func (f *fakeK8sResolver) CachedKeys() []cacheinfo.Entry { return nil }
+
 // newTestApp wires fakes into an AppState. Pass nil for any resolver to use the default empty fake.
 func newTestApp(kp KPResolver, usr UserResolver, wc WincredResolver, awsr AWSResolver, az AzureResolver, gcp GCPResolver, kc KeychainResolver) *AppState {
 	return newTestAppFull(kp, usr, wc, awsr, az, gcp, kc, nil, nil)
@@ -273,7 +434,12 @@ func newTestAppFull(kp KPResolver, usr UserResolver, wc WincredResolver, awsr AW
 	if op == nil {
 		op = &fakeOnePasswordResolver{}
 	}
-	return &AppState{KP: kp, USER: usr, WINCRED: wc, AWS: awsr, AZKV: az, GCPSM: gcp, KEYCHAIN: kc, VAULT: vlt, ONEPASSWORD: op}
+	// Providers added later default to empty fakes; tests that need one
+	// assign it on the returned AppState.
+	return &AppState{KP: kp, USER: usr, WINCRED: wc, AWS: awsr, AZKV: az, GCPSM: gcp, KEYCHAIN: kc, VAULT: vlt, ONEPASSWORD: op,
+		SOPS: &fakeSopsResolver{}, AGE: &fakeAgeResolver{}, PASS: &fakePassResolver{},
+		BW: &fakeBitwardenResolver{}, SECRETSERVICE: &fakeSecretServiceResolver{},
+		K8S: &fakeK8sResolver{}}
 }
 
 // --- Unit tests ---
@@ -604,6 +770,136 @@ func TestParseAndResolve_AWS(t *testing.T) {
 	}
 }
 
+func TestParseAndResolve_AWSOptions(t *testing.T) {
+	ctx := context.Background()
+	awsr := &fakeAWSResolver{
+		secrets: map[string]string{
+			"sm:MyApp/DB (profile=prod, region=eu-west-1)|password": "prod-pass",
+			"sm:MyApp/DB (stage=AWSPREVIOUS)|password":              "old-pass",
+			"sm:MyApp/DB (version=v-123)|":                          "pinned",
+		},
+		parameters: map[string]string{
+			"ps:/myapp/key (profile=dev)|": "dev-key",
+		},
+		paths: map[string]map[string]string{
+			"ps:/myapp/prod/ (region=us-east-1)": {"db/host": "db.internal", "api-key": "k1"},
+		},
+	}
+	app := newTestApp(nil, nil, nil, awsr, nil, nil, nil)
+
+	for ref, want := range map[string]string{
+		"awssm(MyApp/DB|password; profile=prod; region=eu-west-1)": "prod-pass",
+		"awssm(MyApp/DB|password;stage=AWSPREVIOUS)":               "old-pass",
+		"awssm(MyApp/DB; Version=v-123)":                           "pinned",
+		"awsps(/myapp/key; profile=dev)":                           "dev-key",
+	} {
+		got, err := parseAndResolve(ctx, app, 0, ref)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, err %v; want %q", ref, got, err, want)
+		}
+	}
+	for _, bad := range []string{
+		"awssm(MyApp/DB; colour=red)",
+		"awssm(MyApp/DB; profile)",
+		"awsps(/myapp/key; stage=AWSCURRENT)",
+		"awsps(/myapp/prod/|host; region=us-east-1)",
+	} {
+		if _, err := parseAndResolve(ctx, app, 0, bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+
+	// A path stands for several variables, so it cannot be one value:
+	// not for getsec or a template, and not nested in another reference.
+	for _, ref := range []string{
+		"awsps(/myapp/prod/; region=us-east-1)",
+		"totp(awsps(/myapp/prod/; region=us-east-1))",
+	} {
+		if _, err := parseAndResolve(ctx, app, 0, ref); !errors.Is(err, errParameterPath) {
+			t.Errorf("%s: got %v, want errParameterPath", ref, err)
+		}
+	}
+}
+
+func TestParseAndResolve_AWSSTS(t *testing.T) {
+	ctx := context.Background()
+	const role = "arn:aws:iam::123456789012:role/deploy"
+	awsr := &fakeAWSResolver{sessions: map[string]string{
+		"sts:" + role + " (profile=prod) for 1h0m0s|AccessKeyId":     "ASIA1",
+		"sts:" + role + " (profile=prod) for 1h0m0s|SecretAccessKey": "secret1",
+		"sts:" + role + " as ci for 15m0s|SessionToken":              "tok",
+		"sts:" + role + "|": `{"Version":1}`,
+		"sts:" + role + " (region=eu-west-1)|Expiration": "2026-10-18T12:00:00Z",
+	}}
+	app := newTestApp(nil, nil, nil, awsr, nil, nil, nil)
+
+	for ref, want := range map[string]string{
+		"awssts(" + role + "; profile=prod; duration=1h|AccessKeyId)":                                                               "ASIA1",
+		"awssts(" + role + "|SecretAccessKey; profile=prod; duration=3600)":                                                         "secret1",
+		"awssts(" + role + "; session_name=ci; duration=15m; mfa=arn:aws:iam::1:mfa/a; mfa_code=totp(keepass(v|a;b))|SessionToken)": "tok",
+		"awssts(" + role + ")":                              `{"Version":1}`,
+		"awssts(" + role + "|Expiration; region=eu-west-1)": "2026-10-18T12:00:00Z",
+	} {
+		got, err := parseAndResolve(ctx, app, 0, ref)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, err %v; want %q", ref, got, err, want)
+		}
+	}
+	var mfa *aws.AssumeOptions
+	for i := range awsr.assumed {
+		if awsr.assumed[i].MFASerial != "" {
+			mfa = &awsr.assumed[i]
+		}
+	}
+	if mfa == nil || mfa.MFASerial != "arn:aws:iam::1:mfa/a" || mfa.MFACode != "totp(keepass(v|a;b))" {
+		t.Errorf("MFA options not passed on: %+v", mfa)
+	}
+
+	for _, bad := range []string{"awssts()", "awssts(" + role + "; duration=soon)", "awssts(" + role + "; colour=red)"} {
+		if _, err := parseAndResolve(ctx, app, 0, bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestCutField(t *testing.T) {
+	cases := []struct{ in, rest, field string }{
+		{"id", "id", ""},
+		{"id|f", "id", "f"},
+		{"id|f; a=b", "id; a=b", "f"},
+		{"id; a=b|f", "id; a=b", "f"},
+		{"id; a=x(y|z)|f", "id; a=x(y|z)", "f"},
+		{"id; a=x(y|z)", "id; a=x(y|z)", ""},
+	}
+	for _, tc := range cases {
+		rest, field := cutField(tc.in)
+		if rest != tc.rest || field != tc.field {
+			t.Errorf("cutField(%q) = %q, %q; want %q, %q", tc.in, rest, field, tc.rest, tc.field)
+		}
+	}
+}
+
+func TestResolveEnvLines_ParameterPathExpands(t *testing.T) {
+	awsr := &fakeAWSResolver{paths: map[string]map[string]string{
+		"ps:/myapp/prod/": {"db/host": "db.internal", "api-key": "k1"},
+		"ps:/clash/":      {"a-b": "1", "a_b": "2"},
+	}}
+	app := newTestApp(nil, nil, nil, awsr, nil, nil, nil)
+
+	out, errs := ResolveEnvLines(context.Background(), app, []string{
+		"APP=awsps(/myapp/prod/)",
+		"CLASH_=awsps(/clash/)",
+		"OTHER=x",
+	})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "both map to CLASH_A_B") {
+		t.Fatalf("errs = %v", errs)
+	}
+	want := []string{"APP_API_KEY=k1", "APP_DB_HOST=db.internal", "# CLASH_=<unresolved: awsps: parameters \"a-b\" and \"a_b\" both map to CLASH_A_B>", "OTHER=x"}
+	if strings.Join(out, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("out = %q", out)
+	}
+}
+
 func TestParseAndResolve_Azure(t *testing.T) {
 	ctx := context.Background()
 	az := &fakeAzureResolver{secrets: map[string]string{
@@ -625,6 +921,35 @@ func TestParseAndResolve_Azure(t *testing.T) {
 	}
 }
 
+func TestParseAndResolve_AzureCertificate(t *testing.T) {
+	ctx := context.Background()
+	az := &fakeAzureResolver{
+		secrets: map[string]string{"mykv/dbpass/4f2a|": "pinned"},
+		certs: map[string]string{
+			"mykv/tls|":    "key+chain",
+			"mykv/tls|key": "key",
+		},
+	}
+	app := newTestApp(nil, nil, nil, nil, az, nil, nil)
+
+	for expr, want := range map[string]string{
+		"azkv(mykv/dbpass/4f2a)": "pinned",
+		"azcert(mykv/tls)":       "key+chain",
+		"azcert(mykv/tls|key)":   "key",
+	} {
+		got, err := parseAndResolve(ctx, app, 0, expr)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, err %v; want %q", expr, got, err, want)
+		}
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "azcert()"); err == nil {
+		t.Fatal("expected error for empty azcert reference")
+	}
+	if !isProviderExpr("azcert(mykv/tls|cert)") {
+		t.Error("azcert not recognised as a provider expression")
+	}
+}
+
 func TestParseAndResolve_GCP(t *testing.T) {
 	ctx := context.Background()
 	gcp := &fakeGCPResolver{secrets: map[string]string{
@@ -646,6 +971,31 @@ func TestParseAndResolve_GCP(t *testing.T) {
 	}
 }
 
+func TestParseAndResolve_GCPOptions(t *testing.T) {
+	ctx := context.Background()
+	gcp := &fakeGCPResolver{secrets: map[string]string{
+		"my-proj/db|password": "regional-pass",
+	}}
+	app := newTestApp(nil, nil, nil, nil, nil, gcp, nil)
+
+	got, err := parseAndResolve(ctx, app, 0, "gcpsm(my-proj/db; location=europe-west1; impersonate=reader@my-proj.iam.gserviceaccount.com; checksum=true|password)")
+	if err != nil || got != "regional-pass" {
+		t.Fatalf("got %q, err %v", got, err)
+	}
+	want := gcpsm.Options{Impersonate: "reader@my-proj.iam.gserviceaccount.com", Location: "europe-west1", Checksum: true}
+	if len(gcp.opts) != 1 || gcp.opts[0] != want {
+		t.Fatalf("options = %+v, want %+v", gcp.opts, want)
+	}
+	for _, expr := range []string{
+		"gcpsm(my-proj/db; checksum=maybe)",
+		"gcpsm(my-proj/db; profile=prod)",
+	} {
+		if _, err := parseAndResolve(ctx, app, 0, expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
 func TestParseAndResolve_Keychain(t *testing.T) {
 	ctx := context.Background()
 	kc := &fakeKeychainResolver{creds: map[string]string{
@@ -687,6 +1037,35 @@ func TestParseAndResolve_Vault(t *testing.T) {
 	if _, err := parseAndResolve(ctx, app, 0, "vault()"); err == nil {
 		t.Fatal("expected error for empty vault path")
 	}
+	for _, bad := range []string{"vault(secret/myapp; version=0)", "vault(secret/myapp; version=x)", "vault(secret/myapp; owner=x)", "vault(secret/myapp|a|b)"} {
+		if _, err := parseAndResolve(ctx, app, 0, bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestParseAndResolve_VaultOptions(t *testing.T) {
+	ctx := context.Background()
+	vlt := &fakeVaultResolver{secrets: map[string]string{
+		"secret/myapp?version=3|password":          "v3",
+		"[team-a] secret/myapp|":                   "ns",
+		"[team-a] secret/myapp?version=2|password": "ns-v2",
+		"secret/myapp|metadata.created_time":       "2026-01-01T00:00:00Z",
+	}}
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, vlt, nil)
+
+	for ref, want := range map[string]string{
+		"vault(secret/myapp; version=3|password)":                     "v3",
+		"vault(secret/myapp|password; version=3)":                     "v3",
+		"vault(secret/myapp; namespace=team-a)":                       "ns",
+		"vault(secret/myapp; namespace=/team-a/; version=2|password)": "ns-v2",
+		"vault(secret/myapp | metadata.created_time)":                 "2026-01-01T00:00:00Z",
+	} {
+		got, err := parseAndResolve(ctx, app, 0, ref)
+		if err != nil || got != want {
+			t.Errorf("%s: got %q, err %v; want %q", ref, got, err, want)
+		}
+	}
 }
 
 func TestParseAndResolve_OnePassword(t *testing.T) {
@@ -710,6 +1089,211 @@ func TestParseAndResolve_OnePassword(t *testing.T) {
 	}
 }
 
+func TestParseAndResolve_OnePasswordOptions(t *testing.T) {
+	ctx := context.Background()
+	op := &fakeOnePasswordResolver{secrets: map[string]string{
+		"Work/DB|Admin/password": "admin-pw",
+		"Work/TLS|file:ca.pem":   "PEM",
+	}}
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, op)
+
+	got, err := parseAndResolve(ctx, app, 0, "op(Work/DB; account=acme.1password.com|Admin/password)")
+	if err != nil || got != "admin-pw" {
+		t.Fatalf("got %q, err %v", got, err)
+	}
+	if got, err := parseAndResolve(ctx, app, 0, "op(Work/TLS|file:ca.pem)"); err != nil || got != "PEM" {
+		t.Fatalf("file: got %q, err %v", got, err)
+	}
+	want := []onepassword.Options{{Account: "acme.1password.com"}, {}}
+	if !slices.Equal(op.opts, want) {
+		t.Fatalf("options = %+v, want %+v", op.opts, want)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "op(Work/DB; vault=x)"); err == nil {
+		t.Fatal("expected error for unknown option")
+	}
+}
+
+func TestParseAndResolve_Sops(t *testing.T) {
+	ctx := clientinfo.WithInfo(context.Background(), clientinfo.Info{Cwd: filepath.FromSlash("/repo")})
+	enc := filepath.FromSlash("/repo/secrets.enc.yaml")
+	user := &fakeUserResolver{creds: map[string]string{"age key": "AGE-SECRET-KEY-1XYZ"}}
+	app := newTestAppFull(nil, user, nil, nil, nil, nil, nil, nil, nil)
+	app.SOPS = &fakeSopsResolver{secrets: map[string]string{
+		enc + "|db.password|":                    "from-default-key",
+		enc + "|db.password|AGE-SECRET-KEY-1XYZ": "from-nested-key",
+	}}
+
+	got, err := parseAndResolve(ctx, app, 0, "sops(secrets.enc.yaml|db.password)")
+	if err != nil || got != "from-default-key" {
+		t.Fatalf("sops relative path: got %q, err %v", got, err)
+	}
+	got, err = parseAndResolve(ctx, app, 0, "sops(secrets.enc.yaml[user(age key)]|db.password)")
+	if err != nil || got != "from-nested-key" {
+		t.Fatalf("sops nested age key: got %q, err %v", got, err)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "sops()"); err == nil {
+		t.Fatal("expected error for empty sops path")
+	}
+}
+
+func TestParseAndResolve_Age(t *testing.T) {
+	ctx := context.Background()
+	abs := filepath.FromSlash("/team/secrets.env.age")
+	kp := &fakeKPResolver{creds: map[string]string{"&team|age identity|": "AGE-SECRET-KEY-1ABC"}}
+	app := newTestAppFull(kp, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.AGE = &fakeAgeResolver{secrets: map[string]string{
+		abs + "||":                           "whole file",
+		abs + "|DB_PASS|AGE-SECRET-KEY-1ABC": "db-pass",
+	}}
+
+	got, err := parseAndResolve(ctx, app, 0, "age("+abs+")")
+	if err != nil || got != "whole file" {
+		t.Fatalf("age whole file: got %q, err %v", got, err)
+	}
+	got, err = parseAndResolve(ctx, app, 0, "age("+abs+"[keepass(&team|age identity)]|DB_PASS)")
+	if err != nil || got != "db-pass" {
+		t.Fatalf("age nested identity: got %q, err %v", got, err)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "age("+abs+"[user(missing)]|DB_PASS)"); err == nil {
+		t.Fatal("expected error when nested identity fails")
+	}
+}
+
+func TestParseAndResolve_Pass(t *testing.T) {
+	ctx := context.Background()
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.PASS = &fakePassResolver{secrets: map[string]string{
+		"work/github|":      "hunter2",
+		"work/github|login": "alice",
+	}}
+
+	got, err := parseAndResolve(ctx, app, 0, "pass(work/github)")
+	if err != nil || got != "hunter2" {
+		t.Fatalf("pass password: got %q, err %v", got, err)
+	}
+	got, err = parseAndResolve(ctx, app, 0, "PASS( work/github | login )")
+	if err != nil || got != "alice" {
+		t.Fatalf("pass field: got %q, err %v", got, err)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "pass()"); err == nil {
+		t.Fatal("expected error for empty pass entry")
+	}
+
+	out, errs := ResolveEnvLines(ctx, app, []string{"TOKEN=pass(work/github)"})
+	if len(errs) != 0 || len(out) != 1 || out[0] != "TOKEN=hunter2" {
+		t.Fatalf("ResolveEnvLines: out=%v errs=%v", out, errs)
+	}
+}
+
+func TestParseAndResolve_Bitwarden(t *testing.T) {
+	ctx := context.Background()
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.BW = &fakeBitwardenResolver{secrets: map[string]string{
+		"github|":          "hunter2",
+		"github|totp":      "123456",
+		"github|api token": "ghp_x",
+	}}
+
+	for expr, want := range map[string]string{
+		"bw(github)":            "hunter2",
+		"bw(github|totp)":       "123456",
+		"BW( github|api token)": "ghp_x",
+	} {
+		got, err := parseAndResolve(ctx, app, 0, expr)
+		if err != nil || got != want {
+			t.Fatalf("%s: got %q, err %v", expr, got, err)
+		}
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "bw()"); err == nil {
+		t.Fatal("expected error for empty bw item")
+	}
+}
+
+func TestParseAndResolve_SecretService(t *testing.T) {
+	ctx := context.Background()
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.SECRETSERVICE = &fakeSecretServiceResolver{secrets: map[string]string{
+		"service=github,user=alice|":        "ghp_x",
+		"service=github|GitHub token (old)": "ghp_old",
+	}}
+
+	got, err := parseAndResolve(ctx, app, 0, "secretservice(service=github,user=alice)")
+	if err != nil || got != "ghp_x" {
+		t.Fatalf("attributes: got %q, err %v", got, err)
+	}
+	got, err = parseAndResolve(ctx, app, 0, "secretservice(service=github|GitHub token (old))")
+	if err != nil || got != "ghp_old" {
+		t.Fatalf("label with parens: got %q, err %v", got, err)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "secretservice()"); err == nil {
+		t.Fatal("expected error for empty query")
+	}
+}
+
+func TestParseAndResolve_K8s(t *testing.T) {
+	ctx := context.Background()
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.K8S = &fakeK8sResolver{secrets: map[string]string{
+		"dev/apps/db|password": "s3cr3t",
+		"apps/token|":          "only",
+	}}
+
+	got, err := parseAndResolve(ctx, app, 0, "k8s(dev/apps/db|password)")
+	if err != nil || got != "s3cr3t" {
+		t.Fatalf("k8s key: got %q, err %v", got, err)
+	}
+	got, err = parseAndResolve(ctx, app, 0, "k8s(apps/token)")
+	if err != nil || got != "only" {
+		t.Fatalf("k8s single key: got %q, err %v", got, err)
+	}
+	if _, err := parseAndResolve(ctx, app, 0, "k8s()"); err == nil {
+		t.Fatal("expected error for empty k8s reference")
+	}
+}
+
+func TestParseAndResolve_TOTP(t *testing.T) {
+	ctx := context.Background()
+	seed := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.PASS = &fakePassResolver{secrets: map[string]string{
+		"web/acme|otp":  "otpauth://totp/ACME:alice?secret=" + seed + "&digits=8",
+		"web/acme|seed": seed,
+	}}
+
+	p, err := totp.Parse(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := func(digits int) []string {
+		// The window may roll over between computing and checking.
+		now := time.Now()
+		p.Digits = digits
+		return []string{totp.Code(p, now), totp.Code(p, now.Add(p.Period))}
+	}
+
+	w := want(8)
+	got, err := parseAndResolve(ctx, app, 0, "totp(pass(web/acme|otp))")
+	if err != nil || !contains(w, got) {
+		t.Fatalf("otpauth seed: got %q, err %v, want one of %v", got, err, w)
+	}
+	w = want(6)
+	got, err = parseAndResolve(ctx, app, 0, "totp(pass(web/acme|seed); min_remaining=1)")
+	if err != nil || !contains(w, got) {
+		t.Fatalf("bare seed: got %q, err %v, want one of %v", got, err, w)
+	}
+
+	for _, bad := range []string{
+		"totp(" + seed + ")",
+		"totp(pass(web/acme|seed); digits=4)",
+		"totp(pass(web/acme|seed); min_remaining=30)",
+		"totp(pass(web/acme|seed); counter=1)",
+	} {
+		if _, err := parseAndResolve(ctx, app, 0, bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
 // --- small helpers used by tests ---
 
 func contains(slice []string, s string) bool {
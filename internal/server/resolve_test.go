@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/client"
+	"github.com/it-atelier-gn/desktop-secrets/internal/shm"
+)
+
+func TestResolveViaDaemonKeepsValuesVerbatim(t *testing.T) {
+	const pem = "  -----BEGIN CERTIFICATE-----\nMIIB  x\nDS_REF_1=spoofed\n-----END CERTIFICATE-----\n  "
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.PASS = &fakePassResolver{secrets: map[string]string{
+		"tls/cert|": pem,
+		"tls/key|":  " key ",
+	}}
+	ds, err := NewDaemonServer(app, "good-token-aaaa")
+	if err != nil {
+		t.Fatalf("NewDaemonServer: %v", err)
+	}
+	go func() { _ = ds.Serve() }()
+	t.Cleanup(func() { _ = ds.srv.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	st := &shm.DaemonState{Endpoint: ds.Endpoint, Token: "good-token-aaaa"}
+	refs := []string{"pass(tls/cert)", "pass(tls/key)", "plain value ", "pass(tls/missing)"}
+	got, err := client.ResolveViaDaemon(ctx, st, refs)
+
+	var unresolved *client.UnresolvedError
+	if !errors.As(err, &unresolved) || len(unresolved.Refs) != 1 || unresolved.Refs[0] != "pass(tls/missing)" {
+		t.Fatalf("err = %v, want only pass(tls/missing) unresolved", err)
+	}
+	want := map[string]string{
+		"pass(tls/cert)": pem,
+		"pass(tls/key)":  " key ",
+		"plain value ":   "plain value ",
+	}
+	for ref, w := range want {
+		if got[ref] != w {
+			t.Errorf("%s = %q, want %q", ref, got[ref], w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d values, want %d: %q", len(got), len(want), got)
+	}
+}
+
+func TestResolveReportsCacheExpiry(t *testing.T) {
+	exp := time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)
+	app := newTestAppFull(nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	app.PASS = &fakePassResolver{
+		secrets: map[string]string{"ci/token|": "t", "ci/plain|": "p"},
+		expires: map[string]time.Time{"ci/token|": exp},
+	}
+	ds, err := NewDaemonServer(app, "good-token-aaaa")
+	if err != nil {
+		t.Fatalf("NewDaemonServer: %v", err)
+	}
+	go func() { _ = ds.Serve() }()
+	t.Cleanup(func() { _ = ds.srv.Close() })
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	st := &shm.DaemonState{Endpoint: ds.Endpoint, Token: "good-token-aaaa"}
+	_, expires, err := client.ResolveWithExpiry(ctx, st, []string{"pass(ci/token)", "pass(ci/plain)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !expires["pass(ci/token)"].Equal(exp) || len(expires) != 1 {
+		t.Fatalf("expires = %v, want only pass(ci/token) at %v", expires, exp)
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/secretservice"
+
+	"github.com/spf13/viper"
+)
+
+// newSecretServiceBackend builds the org.freedesktop.secrets backend:
+// items are resolved like any reference, with the approval of the
+// process calling over D-Bus.
+func newSecretServiceBackend(resolveRef func(context.Context, string) (string, error)) *secretservice.Backend {
+	b := secretservice.NewBackend()
+	b.SetConfig(func() []secretservice.CollectionConfig {
+		var out []secretservice.CollectionConfig
+		if err := viper.UnmarshalKey("secret_service_collections", &out); err != nil {
+			log.Printf("secret_service_collections: %v", err)
+		}
+		return out
+	})
+	b.SetReferenceResolver(resolveRef)
+	b.SetCallerContext(withClientPID)
+	return b
+}
+
+// startSecretServiceBackend takes org.freedesktop.secrets on the
+// session bus when secret_service_backend is enabled.
+func startSecretServiceBackend(app *AppState) {
+	if !viper.GetBool("secret_service_backend") {
+		return
+	}
+	if err := app.secretServiceBackend.Start(); err != nil {
+		log.Printf("secret service backend: %v", err)
+		return
+	}
+	log.Printf("secret service backend: serving org.freedesktop.secrets on the session bus")
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -14,6 +16,7 @@ import (
 
 	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/ipc"
+	"github.com/it-atelier-gn/desktop-secrets/internal/keepass"
 	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
 )
 
@@ -49,19 +52,12 @@ func NewDaemonServer(app *AppState, token string) (*DaemonServer, error) {
 
 	mux.HandleFunc("/health", ds.auth(ds.handleHealth))
 	mux.HandleFunc("/render", ds.auth(ds.handleRender))
+	mux.HandleFunc("/resolve", ds.auth(ds.handleResolve))
 
 	ds.srv = &http.Server{
 		Handler:           mux,
 		ReadHeaderTimeout: 3 * time.Second,
-		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
-			pid, err := ipc.PeerPID(c)
-			if err != nil {
-				pid = 0
-			}
-			ctx = context.WithValue(ctx, ctxKeyClientPID, pid)
-			ctx = clientinfo.WithInfo(ctx, clientinfo.Lookup(pid))
-			return ctx
-		},
+		ConnContext:       withPeer,
 	}
 
 	ln, endpoint, err := ipc.Listen()
@@ -75,6 +71,22 @@ func NewDaemonServer(app *AppState, token string) (*DaemonServer, error) {
 	return ds, nil
 }
 
+// withPeer attaches the PID and process details of the process at the
+// other end of c, for approval and audit.
+func withPeer(ctx context.Context, c net.Conn) context.Context {
+	pid, err := ipc.PeerPID(c)
+	if err != nil {
+		pid = 0
+	}
+	return withClientPID(ctx, pid)
+}
+
+// withClientPID attaches pid and its process details to ctx.
+func withClientPID(ctx context.Context, pid int) context.Context {
+	ctx = context.WithValue(ctx, ctxKeyClientPID, pid)
+	return clientinfo.WithInfo(ctx, clientinfo.Lookup(pid))
+}
+
 func (ds *DaemonServer) auth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		got := r.Header.Get("X-DesktopSecrets-Token")
@@ -87,6 +99,11 @@ func (ds *DaemonServer) auth(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func (ds *DaemonServer) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	// Clients that hand out credentials with an expiry (kubectl exec
+	// credentials) derive it from how long resolved values are cached.
+	if ds.App != nil {
+		w.Header().Set("X-DesktopSecrets-TTL", strconv.Itoa(int(ds.App.UnlockTTL.Load()/time.Second)))
+	}
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte("OK"))
 }
@@ -107,7 +124,11 @@ func (ds *DaemonServer) handleRender(w http.ResponseWriter, r *http.Request) {
 	for i := range body {
 		body[i] = 0
 	}
-	rendered, errs := ResolveEnvLines(r.Context(), ds.App, lines)
+	ctx := r.Context()
+	if r.Header.Get("X-DesktopSecrets-Reload") == "keepass" {
+		ctx = keepass.WithReload(ctx)
+	}
+	rendered, errs := ResolveEnvLines(ctx, ds.App, lines)
 
 	if len(errs) > 0 {
 		w.Header().Set("X-EnvTray-Warnings", strconv.Itoa(len(errs)))
@@ -123,6 +144,57 @@ func (ds *DaemonServer) handleRender(w http.ResponseWriter, r *http.Request) {
 	runtime.GC()
 }
 
+// resolveRequest and resolveResponse are the body of /resolve. Each
+// reference is answered on its own, under "values" or under "errors",
+// so values keep their newlines and surrounding whitespace. "expires"
+// says when a value served from a cache stops being served.
+type resolveRequest struct {
+	Refs []string `json:"refs"`
+}
+
+type resolveResponse struct {
+	Values  map[string]string    `json:"values"`
+	Expires map[string]time.Time `json:"expires,omitempty"`
+	Errors  map[string]string    `json:"errors,omitempty"`
+}
+
+func (ds *DaemonServer) handleResolve(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, err := ioReadAllLimit(r.Body, 5<<20) // 5MB guard
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req resolveRequest
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Refs) == 0 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	memprotect.Wipe(body)
+
+	values, expires, errs := ResolveRefs(r.Context(), ds.App, req.Refs)
+	resp := resolveResponse{Values: values, Expires: expires}
+	if len(errs) > 0 {
+		w.Header().Set("X-EnvTray-Warnings", strconv.Itoa(len(errs)))
+		resp.Errors = make(map[string]string, len(errs))
+		for ref, err := range errs {
+			resp.Errors[ref] = errComment(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	clear(values)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Connection", "close")
+	_, _ = w.Write(buf.Bytes())
+	memprotect.Wipe(buf.Bytes())
+	runtime.GC()
+}
+
 func writeAndWipe(w http.ResponseWriter, lines []string) {
 	var total int
 	for _, l := range lines {
@@ -147,7 +219,11 @@ func (ds *DaemonServer) Serve() error {
 }
 
 func (ds *DaemonServer) Shutdown(ctx context.Context) error {
-	return ds.srv.Shutdown(ctx)
+	err := ds.srv.Shutdown(ctx)
+	if ds.App != nil {
+		ds.App.Close(ctx)
+	}
+	return err
 }
 
 // small helpers
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/ipc"
+	"github.com/it-atelier-gn/desktop-secrets/internal/sshagent"
+
+	"github.com/spf13/viper"
+)
+
+// sshAgentSocket is the name of the agent's socket in the runtime
+// directory (a named pipe of that name on Windows).
+const sshAgentSocket = "ssh-agent"
+
+// wireSSHAgent connects the agent to the daemon: keys are read with
+// the requesting process's approval like any reference, each signature
+// is gated per process, and keys read from KeePass are dropped when
+// their vault is.
+func wireSSHAgent(app *AppState, resolveRef func(context.Context, string) (string, error)) {
+	ag := app.SSHAgent
+	ag.SetConfig(func() []sshagent.KeyConfig {
+		var out []sshagent.KeyConfig
+		if err := viper.UnmarshalKey("ssh_agent_keys", &out); err != nil {
+			log.Printf("ssh_agent_keys: %v", err)
+		}
+		return out
+	})
+	ag.SetReferenceResolver(resolveRef)
+	ag.SetApprover(func(ctx context.Context, k sshagent.Key) error {
+		_, err := gate(ctx, app, "ssh:"+k.Fingerprint,
+			fmt.Sprintf("ssh-agent signature with %s (%s)", k.Comment, k.Fingerprint),
+			func(string) { ag.Evict(k.Ref) },
+			func() (string, error) { return "", nil })
+		return err
+	})
+	ag.SetValidator(func(ref string) bool {
+		if vault, ok := keepassVaultKey(ref); ok {
+			return app.KP.IsVaultUnlocked(vault)
+		}
+		return true
+	})
+}
+
+// keepassVaultKey returns the cache key of the vault a keepass(...)
+// reference reads from.
+func keepassVaultKey(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if !strings.HasPrefix(strings.ToLower(ref), "keepass(") {
+		return "", false
+	}
+	content, _, err := parseParenContent(ref[len("keepass"):])
+	if err != nil {
+		return "", false
+	}
+	sep := indexTopLevelPipe(content)
+	if sep < 0 {
+		return "", false
+	}
+	base, _, err := splitVaultAndSingleNested(strings.TrimSpace(content[:sep]))
+	if err != nil {
+		return "", false
+	}
+	return kpVaultKey(base), true
+}
+
+// startSSHAgent serves the agent on its socket when ssh_agent is
+// enabled.
+func startSSHAgent(app *AppState) {
+	if !viper.GetBool("ssh_agent") {
+		return
+	}
+	ln, endpoint, err := ipc.ListenNamed(sshAgentSocket)
+	if err != nil {
+		log.Printf("ssh-agent: %v", err)
+		return
+	}
+	app.sshAgentListener = ln
+	log.Printf("ssh-agent: listening on %s (set SSH_AUTH_SOCK to use it)", endpoint)
+	go func() {
+		err := app.SSHAgent.Serve(ln, func(c net.Conn) context.Context {
+			return withPeer(context.Background(), c)
+		})
+		if err != nil {
+			log.Printf("ssh-agent: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,22 @@
+package server
+
+import "testing"
+
+func TestKeepassVaultKey(t *testing.T) {
+	cases := map[string]string{
+		"keepass(&work|/SSH/github|attachment:id_ed25519)": "work",
+		"keepass(/home/a/vaults/ssh.kdbx|id_rsa)":          "ssh.kdbx",
+		"keepass(&work[user(master)]|/SSH/id)":             "work",
+	}
+	for ref, want := range cases {
+		got, ok := keepassVaultKey(ref)
+		if !ok || got != want {
+			t.Errorf("keepassVaultKey(%q) = %q, %v; want %q", ref, got, ok, want)
+		}
+	}
+	for _, ref := range []string{"awssm(ssh/deploy)", "keepass(no-pipe)"} {
+		if _, ok := keepassVaultKey(ref); ok {
+			t.Errorf("keepassVaultKey(%q) matched", ref)
+		}
+	}
+}
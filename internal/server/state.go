@@ -2,18 +2,27 @@ package server
 
 import (
 	"context"
+	"log"
+	"net"
 	"time"
 
+	"github.com/it-atelier-gn/desktop-secrets/internal/agefile"
 	"github.com/it-atelier-gn/desktop-secrets/internal/approval"
 	"github.com/it-atelier-gn/desktop-secrets/internal/audit"
 	"github.com/it-atelier-gn/desktop-secrets/internal/aws"
 	"github.com/it-atelier-gn/desktop-secrets/internal/azkv"
+	"github.com/it-atelier-gn/desktop-secrets/internal/bitwarden"
 	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
 	"github.com/it-atelier-gn/desktop-secrets/internal/gcpsm"
+	"github.com/it-atelier-gn/desktop-secrets/internal/k8s"
 	"github.com/it-atelier-gn/desktop-secrets/internal/keepass"
 	"github.com/it-atelier-gn/desktop-secrets/internal/keychain"
 	"github.com/it-atelier-gn/desktop-secrets/internal/onepassword"
+	"github.com/it-atelier-gn/desktop-secrets/internal/pass"
 	"github.com/it-atelier-gn/desktop-secrets/internal/prompt"
+	"github.com/it-atelier-gn/desktop-secrets/internal/secretservice"
+	"github.com/it-atelier-gn/desktop-secrets/internal/sops"
+	"github.com/it-atelier-gn/desktop-secrets/internal/sshagent"
 	"github.com/it-atelier-gn/desktop-secrets/internal/user"
 	"github.com/it-atelier-gn/desktop-secrets/internal/utils"
 	"github.com/it-atelier-gn/desktop-secrets/internal/vault"
@@ -51,8 +60,11 @@ type WincredResolver interface {
 }
 
 type AWSResolver interface {
-	ResolveSecret(ctx context.Context, secretID, field string) (string, error)
-	ResolveParameter(ctx context.Context, name, field string) (string, error)
+	ResolveSecret(ctx context.Context, secretID, field string, opt aws.Options) (string, error)
+	ResolveParameter(ctx context.Context, name, field string, opt aws.Options) (string, error)
+	ResolveParametersByPath(ctx context.Context, path string, opt aws.Options) (map[string]string, error)
+	AssumeRole(ctx context.Context, roleARN, field string, opt aws.AssumeOptions) (string, error)
+	SetReferenceResolver(fn func(ctx context.Context, value string) (string, error))
 	Evict(key string)
 	EvictAll()
 	CachedKeys() []cacheinfo.Entry
@@ -60,13 +72,15 @@ type AWSResolver interface {
 
 type AzureResolver interface {
 	ResolveSecret(ctx context.Context, ref, field string) (string, error)
+	ResolveCertificate(ctx context.Context, ref, part string) (string, error)
+	SetReferenceResolver(fn func(ctx context.Context, value string) (string, error))
 	Evict(key string)
 	EvictAll()
 	CachedKeys() []cacheinfo.Entry
 }
 
 type GCPResolver interface {
-	ResolveSecret(ctx context.Context, ref, field string) (string, error)
+	ResolveSecret(ctx context.Context, ref, field string, opt gcpsm.Options) (string, error)
 	Evict(key string)
 	EvictAll()
 	CachedKeys() []cacheinfo.Entry
@@ -77,14 +91,57 @@ type KeychainResolver interface {
 }
 
 type VaultResolver interface {
-	ResolveSecret(ctx context.Context, path, field string) (string, error)
+	ResolveSecret(ctx context.Context, path, field string, opt vault.ReadOptions) (string, error)
 	Evict(key string)
 	EvictAll()
 	CachedKeys() []cacheinfo.Entry
+	Close(ctx context.Context)
 }
 
 type OnePasswordResolver interface {
-	ResolveSecret(ctx context.Context, ref, field string) (string, error)
+	ResolveSecret(ctx context.Context, ref, field string, opt onepassword.Options) (string, error)
+	SetReferenceResolver(fn func(ctx context.Context, value string) (string, error))
+	Evict(key string)
+	EvictAll()
+	CachedKeys() []cacheinfo.Entry
+}
+
+type SopsResolver interface {
+	ResolveSecret(ctx context.Context, path, field, keyRef, ageKey string) (string, error)
+	Evict(key string)
+	EvictAll()
+	CachedKeys() []cacheinfo.Entry
+}
+
+type AgeResolver interface {
+	ResolveSecret(ctx context.Context, path, field, identityRef, identity string) (string, error)
+	Evict(key string)
+	EvictAll()
+	CachedKeys() []cacheinfo.Entry
+}
+
+type PassResolver interface {
+	ResolveSecret(ctx context.Context, entry, field string) (string, error)
+	Evict(key string)
+	EvictAll()
+	CachedKeys() []cacheinfo.Entry
+}
+
+type BitwardenResolver interface {
+	ResolveSecret(ctx context.Context, item, field string) (string, error)
+	Unlocked() bool
+	SetUnlockTTL(unlockTTL *utils.AtomicDuration)
+	Evict(key string)
+	EvictAll()
+	CachedKeys() []cacheinfo.Entry
+}
+
+type SecretServiceResolver interface {
+	Resolve(ctx context.Context, query, label string) (string, error)
+}
+
+type K8sResolver interface {
+	ResolveSecret(ctx context.Context, ref, key string) (string, error)
 	Evict(key string)
 	EvictAll()
 	CachedKeys() []cacheinfo.Entry
@@ -100,6 +157,13 @@ type AppState struct {
 	KEYCHAIN          KeychainResolver
 	VAULT             VaultResolver
 	ONEPASSWORD       OnePasswordResolver
+	SOPS              SopsResolver
+	AGE               AgeResolver
+	PASS              PassResolver
+	BW                BitwardenResolver
+	SECRETSERVICE     SecretServiceResolver
+	K8S               K8sResolver
+	SSHAgent          *sshagent.Agent
 	UnlockTTL         utils.AtomicDuration
 	ShouldExit        utils.AtomicBool
 	RetrievalApproval utils.AtomicBool
@@ -108,23 +172,72 @@ type AppState struct {
 	Audit             *audit.Logger
 
 	Server *DaemonServer
+
+	sshAgentListener     net.Listener
+	secretServiceBackend *secretservice.Backend
 }
 
 func NewAppState() *AppState {
 	ttl := time.Duration(viper.GetInt("ttl")) * time.Minute
 	store := approval.NewStore()
+	ageMgr := agefile.NewManager(ttl)
+	ageMgr.SetIdentityFile(func() string { return viper.GetString("age_identity_file") })
+	passMgr := pass.NewManager(ttl)
+	passMgr.SetStoreDir(func() string { return viper.GetString("pass_store_dir") })
+	vaultMgr := vault.NewManager(ttl)
+	vaultMgr.SetAuthConfig(func() []vault.AuthConfig {
+		var out []vault.AuthConfig
+		if err := viper.UnmarshalKey("vault_auth", &out); err != nil {
+			log.Printf("vault_auth: %v", err)
+		}
+		return out
+	})
+	vaultMgr.SetLeaseRenewal(func() bool { return viper.GetBool("vault_renew_leases") })
+	azkvMgr := azkv.NewManager(ttl)
+	azkvMgr.SetAuthConfig(func() []azkv.AuthConfig {
+		var out []azkv.AuthConfig
+		if err := viper.UnmarshalKey("azure_auth", &out); err != nil {
+			log.Printf("azure_auth: %v", err)
+		}
+		return out
+	})
+	k8sMgr := k8s.NewManager(ttl)
+	k8sMgr.SetKubeconfig(func() string { return viper.GetString("kubeconfig") })
+	gcpMgr := gcpsm.NewManager(ttl)
+	gcpMgr.SetAuthConfig(func() []gcpsm.AuthConfig {
+		var out []gcpsm.AuthConfig
+		if err := viper.UnmarshalKey("gcp_auth", &out); err != nil {
+			log.Printf("gcp_auth: %v", err)
+		}
+		return out
+	})
+	opMgr := onepassword.NewManager(ttl)
+	opMgr.SetConfig(func() onepassword.Config {
+		return onepassword.Config{
+			ServiceAccountToken: viper.GetString("op_service_account_token"),
+			ConnectHost:         viper.GetString("op_connect_host"),
+			ConnectToken:        viper.GetString("op_connect_token"),
+		}
+	})
 	a := &AppState{
-		KP:          keepass.NewKPManager(),
-		USER:        user.NewUserManager(),
-		WINCRED:     wincred.NewManager(),
-		AWS:         aws.NewManager(ttl),
-		AZKV:        azkv.NewManager(ttl),
-		GCPSM:       gcpsm.NewManager(ttl),
-		KEYCHAIN:    keychain.NewManager(),
-		VAULT:       vault.NewManager(ttl),
-		ONEPASSWORD: onepassword.NewManager(ttl),
-		UnlockTTL:   utils.AtomicDuration{},
-		Approvals:   store,
+		KP:            keepass.NewKPManager(),
+		USER:          user.NewUserManager(),
+		WINCRED:       wincred.NewManager(),
+		AWS:           aws.NewManager(ttl),
+		AZKV:          azkvMgr,
+		GCPSM:         gcpMgr,
+		KEYCHAIN:      keychain.NewManager(),
+		VAULT:         vaultMgr,
+		ONEPASSWORD:   opMgr,
+		SOPS:          sops.NewManager(ttl),
+		AGE:           ageMgr,
+		PASS:          passMgr,
+		BW:            bitwarden.NewManager(ttl),
+		SECRETSERVICE: secretservice.NewManager(),
+		K8S:           k8sMgr,
+		SSHAgent:      sshagent.New(ttl),
+		UnlockTTL:     utils.AtomicDuration{},
+		Approvals:     store,
 		Gate: approval.NewGateWithVerifier(store, nil,
 			buildVerifier(),
 			func() string { return viper.GetString("approval_factor_required") },
@@ -135,6 +248,19 @@ func NewAppState() *AppState {
 
 	a.USER.SetUnlockTTL(&a.UnlockTTL)
 	a.KP.SetUnlockTTL(&a.UnlockTTL)
+	a.BW.SetUnlockTTL(&a.UnlockTTL)
+	resolveRef := func(ctx context.Context, v string) (string, error) {
+		if !isProviderExpr(v) {
+			return v, nil
+		}
+		return parseAndResolve(ctx, a, a.UnlockTTL.Load(), v)
+	}
+	vaultMgr.SetReferenceResolver(resolveRef)
+	a.AWS.SetReferenceResolver(resolveRef)
+	a.AZKV.SetReferenceResolver(resolveRef)
+	a.ONEPASSWORD.SetReferenceResolver(resolveRef)
+	wireSSHAgent(a, resolveRef)
+	a.secretServiceBackend = newSecretServiceBackend(resolveRef)
 
 	prompt.ApprovalGrantProvider = func() int { return viper.GetInt("approval_grant_minutes") }
 	prompt.ApprovalGrantPersister = func(m int) {
@@ -153,3 +279,20 @@ func NewAppState() *AppState {
 
 	return a
 }
+
+// Close releases provider state that would otherwise outlive the
+// daemon, such as Vault tokens it logged in for.
+func (a *AppState) Close(ctx context.Context) {
+	if a.VAULT != nil {
+		a.VAULT.Close(ctx)
+	}
+	if a.sshAgentListener != nil {
+		_ = a.sshAgentListener.Close()
+	}
+	if a.SSHAgent != nil {
+		a.SSHAgent.EvictAll()
+	}
+	if a.secretServiceBackend != nil {
+		a.secretServiceBackend.Close()
+	}
+}
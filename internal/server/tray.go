@@ -126,7 +126,13 @@ func (app *AppState) cachedGroups() []cachedGroup {
 		{name: "GCP Secret Manager", evictAll: app.GCPSM.EvictAll},
 		{name: "HashiCorp Vault", evictAll: app.VAULT.EvictAll},
 		{name: "1Password", evictAll: app.ONEPASSWORD.EvictAll},
+		{name: "SOPS", evictAll: app.SOPS.EvictAll},
+		{name: "age", evictAll: app.AGE.EvictAll},
+		{name: "pass", evictAll: app.PASS.EvictAll},
+		{name: "Bitwarden", evictAll: app.BW.EvictAll},
+		{name: "Kubernetes", evictAll: app.K8S.EvictAll},
 		{name: "Prompt", evictAll: app.USER.EvictAll},
+		{name: "SSH agent", evictAll: app.SSHAgent.EvictAll},
 	}
 
 	for _, cv := range app.KP.CachedVaults() {
@@ -141,7 +147,7 @@ func (app *AppState) cachedGroups() []cachedGroup {
 		for _, e := range entries {
 			key := e.Key
 			groups[i].items = append(groups[i].items, cachedItem{
-				key: e.Key, expires: e.Expires,
+				key: e.Key, detail: e.Detail, expires: e.Expires,
 				evict: func() { evict(key) },
 			})
 		}
@@ -151,7 +157,13 @@ func (app *AppState) cachedGroups() []cachedGroup {
 	add(3, app.GCPSM.Evict, app.GCPSM.CachedKeys())
 	add(4, app.VAULT.Evict, app.VAULT.CachedKeys())
 	add(5, app.ONEPASSWORD.Evict, app.ONEPASSWORD.CachedKeys())
-	add(6, app.USER.Evict, app.USER.CachedKeys())
+	add(6, app.SOPS.Evict, app.SOPS.CachedKeys())
+	add(7, app.AGE.Evict, app.AGE.CachedKeys())
+	add(8, app.PASS.Evict, app.PASS.CachedKeys())
+	add(9, app.BW.Evict, app.BW.CachedKeys())
+	add(10, app.K8S.Evict, app.K8S.CachedKeys())
+	add(11, app.USER.Evict, app.USER.CachedKeys())
+	add(12, app.SSHAgent.Evict, app.SSHAgent.CachedKeys())
 
 	return groups
 }
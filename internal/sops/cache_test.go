@@ -0,0 +1,135 @@
+package sops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestResolveSecretCachesUntilFileChanges(t *testing.T) {
+	f := newFixture(t)
+	path := writeFile(t, "s.enc.yaml", f.yaml(t))
+
+	m := NewManager(time.Hour)
+	calls := 0
+	m.ageKeys = func() (string, error) {
+		calls++
+		return f.id.String(), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if got, err := m.ResolveSecret(t.Context(), path, "db.password", "", ""); err != nil || got != "s3cr3t" {
+			t.Fatalf("call %d: %q, %v", i, got, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 decryption, got %d", calls)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ResolveSecret(t.Context(), path, "db.password", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected modified file to be decrypted again, got %d decryptions", calls)
+	}
+}
+
+func TestResolveSecretExpires(t *testing.T) {
+	f := newFixture(t)
+	path := writeFile(t, "s.enc.yaml", f.yaml(t))
+
+	m := NewManager(time.Hour)
+	calls := 0
+	m.ageKeys = func() (string, error) {
+		calls++
+		return f.id.String(), nil
+	}
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	for k, e := range m.cache {
+		e.expires = time.Now().Add(-time.Second)
+		m.cache[k] = e
+	}
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected expired entry to be decrypted again, got %d", calls)
+	}
+}
+
+func TestCacheKeyedByAgeKeyRef(t *testing.T) {
+	f := newFixture(t)
+	path := writeFile(t, "s.enc.yaml", f.yaml(t))
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(time.Hour)
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "user(right)", f.id.String()); err != nil {
+		t.Fatal(err)
+	}
+	// The same file read with a key that cannot decrypt it must not be
+	// served from the entry cached for the first key.
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "user(wrong)", other.String()); err == nil {
+		t.Fatal("expected a different key reference to bypass the cache")
+	}
+
+	key := CacheKey(path, "user(right)")
+	if keys := m.CachedKeys(); len(keys) != 1 || keys[0].Key != key {
+		t.Fatalf("CachedKeys = %v, want [%s]", keys, key)
+	}
+	m.Evict(key)
+	if len(m.cache) != 0 {
+		t.Fatal("Evict by CacheKey left the entry cached")
+	}
+}
+
+func TestGPGDoesNotHoldCache(t *testing.T) {
+	f := newFixture(t)
+	body := fmt.Sprintf("password: %s\nsops:\n    pgp:\n        - fp: ABCD\n          enc: PGP-MESSAGE\n    lastmodified: %q\n    mac: %s\n",
+		encryptValue(t, f.key, "pgp-secret", "str", "password:"), testLastModified, macFor(t, f.key, "pgp-secret"))
+	path := writeFile(t, "s.yaml", body)
+
+	m := NewManager(time.Hour)
+	m.ageKeys = func() (string, error) { return "", nil }
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.runGPG = func(context.Context, []byte, ...string) ([]byte, error) {
+		close(started)
+		<-release
+		return f.key, nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.ResolveSecret(context.Background(), path, "password", "", "")
+		done <- err
+	}()
+	<-started
+
+	listed := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		m.Evict(path)
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CachedKeys blocked while gpg was running")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,554 @@
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// document is a parsed SOPS file: the tree with values still
+// encrypted, plus the data-key stanzas and MAC from its "sops"
+// metadata.
+type document struct {
+	tree mapping
+	age  []string // armored age ciphertexts of the data key
+	pgp  []string // armored PGP messages of the data key
+
+	mac          string // encrypted MAC over the plaintext values
+	lastModified string // additional data of the MAC
+	rules        encryptionRules
+}
+
+// mapping is a map in document order, the order the MAC is computed
+// in. Values are strings, numbers, bools, nil, []any or mappings.
+type mapping []mapItem
+
+type mapItem struct {
+	key   string
+	value any
+}
+
+// encryptionRules are the metadata options that decide which values
+// sops encrypted, and whether the MAC covers the others.
+type encryptionRules struct {
+	unencryptedSuffix string
+	encryptedSuffix   string
+	unencryptedRegex  *regexp.Regexp
+	encryptedRegex    *regexp.Regexp
+	macOnlyEncrypted  bool
+}
+
+// encrypted reports whether the value at path must be encrypted,
+// following sops: an unencrypted suffix or regex on any key of the
+// path exempts it, and with an encrypted suffix or regex only matching
+// paths are encrypted.
+func (r encryptionRules) encrypted(path []string) bool {
+	enc := true
+	if r.unencryptedSuffix != "" {
+		for _, k := range path {
+			if strings.HasSuffix(k, r.unencryptedSuffix) {
+				enc = false
+				break
+			}
+		}
+	}
+	if r.encryptedSuffix != "" {
+		enc = false
+		for _, k := range path {
+			if strings.HasSuffix(k, r.encryptedSuffix) {
+				enc = true
+				break
+			}
+		}
+	}
+	if r.unencryptedRegex != nil {
+		for _, k := range path {
+			if r.unencryptedRegex.MatchString(k) {
+				enc = false
+				break
+			}
+		}
+	}
+	if r.encryptedRegex != nil {
+		enc = false
+		for _, k := range path {
+			if r.encryptedRegex.MatchString(k) {
+				enc = true
+				break
+			}
+		}
+	}
+	return enc
+}
+
+// parseDocument parses a SOPS-encrypted YAML, JSON or dotenv file. The
+// format is chosen by extension the way the sops CLI does; JSON is
+// read as the subset of YAML it is, which keeps the key order.
+func parseDocument(name string, data []byte) (*document, error) {
+	var tree mapping
+	var err error
+	if strings.ToLower(filepath.Ext(name)) == ".env" {
+		tree, err = parseDotenv(data)
+	} else {
+		tree, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", filepath.Base(name), err)
+	}
+
+	var meta map[string]any
+	for i, it := range tree {
+		if it.key == "sops" {
+			meta, _ = plain(it.value).(map[string]any)
+			tree = append(tree[:i:i], tree[i+1:]...)
+			break
+		}
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("%s has no sops metadata; is it encrypted with sops?", filepath.Base(name))
+	}
+
+	doc := &document{tree: tree}
+	doc.age = stanzas(meta["age"])
+	doc.pgp = stanzas(meta["pgp"])
+	if len(doc.age) == 0 && len(doc.pgp) == 0 {
+		return nil, errors.New("no age or PGP recipients in sops metadata (KMS and key groups are not supported)")
+	}
+	doc.mac = metaString(meta["mac"])
+	if doc.mac == "" {
+		return nil, errors.New("no MAC in sops metadata")
+	}
+	switch v := meta["lastmodified"].(type) {
+	case time.Time:
+		doc.lastModified = v.UTC().Format(time.RFC3339)
+	default:
+		t, err := time.Parse(time.RFC3339, metaString(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid lastmodified in sops metadata: %w", err)
+		}
+		doc.lastModified = t.Format(time.RFC3339)
+	}
+
+	doc.rules.unencryptedSuffix = metaString(meta["unencrypted_suffix"])
+	doc.rules.encryptedSuffix = metaString(meta["encrypted_suffix"])
+	for _, re := range []struct {
+		name string
+		dst  **regexp.Regexp
+	}{
+		{"unencrypted_regex", &doc.rules.unencryptedRegex},
+		{"encrypted_regex", &doc.rules.encryptedRegex},
+	} {
+		if expr := metaString(meta[re.name]); expr != "" {
+			if *re.dst, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("invalid %s in sops metadata: %w", re.name, err)
+			}
+		}
+	}
+	doc.rules.macOnlyEncrypted = metaString(meta["mac_only_encrypted"]) == "true"
+	return doc, nil
+}
+
+// metaString renders a metadata value as text; dotenv metadata is all
+// text already.
+func metaString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// parseYAML parses a YAML (or JSON) document into a mapping.
+func parseYAML(data []byte) (mapping, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("empty document")
+	}
+	v, err := fromNode(root.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(mapping)
+	if !ok {
+		return nil, errors.New("document is not a mapping")
+	}
+	return m, nil
+}
+
+func fromNode(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return fromNode(n.Alias)
+	case yaml.MappingNode:
+		out := make(mapping, 0, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := fromNode(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, mapItem{key: n.Content[i].Value, value: v})
+		}
+		return out, nil
+	case yaml.SequenceNode:
+		out := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := fromNode(c)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case yaml.ScalarNode:
+		var v any
+		if err := n.Decode(&v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported YAML node on line %d", n.Line)
+}
+
+// plain turns mappings into maps, for metadata and decrypted trees.
+func plain(v any) any {
+	switch t := v.(type) {
+	case mapping:
+		out := make(map[string]any, len(t))
+		for _, it := range t {
+			out[it.key] = plain(it.value)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, c := range t {
+			out[i] = plain(c)
+		}
+		return out
+	default:
+		return t
+	}
+}
+
+// stanzas returns the "enc" field of each entry in a metadata key list.
+func stanzas(v any) []string {
+	list, _ := v.([]any)
+	var out []string
+	for _, e := range list {
+		m, _ := e.(map[string]any)
+		if enc, ok := m["enc"].(string); ok && enc != "" {
+			out = append(out, enc)
+		}
+	}
+	return out
+}
+
+// dotenvList matches the flattened metadata keys sops writes to dotenv
+// files, e.g. "sops_age__list_0__map_enc".
+var dotenvList = regexp.MustCompile(`^sops_(\w+?)__list_(\d+)__map_(\w+)$`)
+
+// parseDotenv reads a sops dotenv file into a flat tree. Metadata keys
+// are folded back into a "sops" map shaped like the YAML form, and the
+// escaped newlines sops writes in values are restored.
+func parseDotenv(data []byte) (mapping, error) {
+	var tree mapping
+	meta := map[string]any{}
+	lists := map[string]map[int]map[string]any{}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid dotenv line %q", line)
+		}
+		v = strings.ReplaceAll(v, `\n`, "\n")
+		if m := dotenvList.FindStringSubmatch(k); m != nil {
+			idx, _ := strconv.Atoi(m[2])
+			if lists[m[1]] == nil {
+				lists[m[1]] = map[int]map[string]any{}
+			}
+			if lists[m[1]][idx] == nil {
+				lists[m[1]][idx] = map[string]any{}
+			}
+			lists[m[1]][idx][m[3]] = v
+			continue
+		}
+		if rest, ok := strings.CutPrefix(k, "sops_"); ok {
+			meta[rest] = v
+			continue
+		}
+		tree = append(tree, mapItem{key: k, value: v})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, entries := range lists {
+		idx := make([]int, 0, len(entries))
+		for i := range entries {
+			idx = append(idx, i)
+		}
+		sort.Ints(idx)
+		list := make([]any, 0, len(idx))
+		for _, i := range idx {
+			list = append(list, entries[i])
+		}
+		meta[name] = list
+	}
+	if len(meta) > 0 {
+		tree = append(tree, mapItem{key: "sops", value: meta})
+	}
+	return tree, nil
+}
+
+// dataKey recovers the document's 32-byte data key, trying the age
+// stanzas with identities first and then the PGP stanzas through gpg.
+func (d *document) dataKey(ctx context.Context, identities []age.Identity, runGPG gpgRunner) ([]byte, error) {
+	var errs []error
+	if len(d.age) > 0 && len(identities) > 0 {
+		for _, enc := range d.age {
+			r, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), identities...)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("age: %w", err))
+				continue
+			}
+			key, err := io.ReadAll(r)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("age: %w", err))
+				continue
+			}
+			return key, nil
+		}
+	} else if len(d.age) > 0 {
+		errs = append(errs, errors.New("age: no identity configured"))
+	}
+	for _, enc := range d.pgp {
+		key, err := runGPG(ctx, []byte(enc), "--batch", "--quiet", "--decrypt")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pgp: %w", err))
+			continue
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("cannot decrypt data key: %w", errors.Join(errs...))
+}
+
+// decrypt returns the plaintext tree, checked as sops -d checks it:
+// every value the metadata says is encrypted must be, each one is
+// authenticated against its key path so it cannot be moved to another
+// key, and the file-level MAC over all plaintext values must match, so
+// values cannot be added, removed or replaced in the clear.
+func (d *document) decrypt(key []byte) (map[string]any, error) {
+	sum := sha512.New()
+	tree, err := decryptTree(d.tree, key, nil, d.rules, sum)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := decryptValue(d.mac, key, d.lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("MAC: %w", err)
+	}
+	want, _ := mac.(string)
+	got := fmt.Sprintf("%X", sum.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(want), []byte(got)) != 1 {
+		return nil, errors.New("MAC mismatch: the file was modified outside sops")
+	}
+	return tree.(map[string]any), nil
+}
+
+// decryptTree replaces every encrypted value in v with its plaintext
+// and adds the values the MAC covers to mac, in document order.
+func decryptTree(v any, key []byte, path []string, rules encryptionRules, mac hash.Hash) (any, error) {
+	switch t := v.(type) {
+	case mapping:
+		out := make(map[string]any, len(t))
+		for _, it := range t {
+			dv, err := decryptTree(it.value, key, append(path, it.key), rules, mac)
+			if err != nil {
+				return nil, err
+			}
+			out[it.key] = dv
+		}
+		return out, nil
+	case []any:
+		// List items share their parent's path, as in sops.
+		out := make([]any, len(t))
+		for i, child := range t {
+			dv, err := decryptTree(child, key, path, rules, mac)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = dv
+		}
+		return out, nil
+	}
+
+	encrypted := rules.encrypted(path)
+	dv := v
+	if encrypted {
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, "ENC[") {
+			return nil, fmt.Errorf("%s: value is not encrypted", strings.Join(path, "."))
+		}
+		var err error
+		if dv, err = decryptValue(s, key, strings.Join(path, ":")+":"); err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+	}
+	if encrypted || !rules.macOnlyEncrypted {
+		b, err := macBytes(dv)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+		mac.Write(b)
+	}
+	return dv, nil
+}
+
+// macBytes is a value as sops feeds it to the MAC.
+func macBytes(v any) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(t), nil
+	case int:
+		return []byte(strconv.Itoa(t)), nil
+	case int64:
+		return []byte(strconv.FormatInt(t, 10)), nil
+	case uint64:
+		return []byte(strconv.FormatUint(t, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(t, 'f', -1, 64)), nil
+	case bool:
+		if t {
+			return []byte("True"), nil
+		}
+		return []byte("False"), nil
+	}
+	return nil, fmt.Errorf("cannot authenticate a %T value", v)
+}
+
+var encValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// decryptValue decrypts a single "ENC[AES256_GCM,...]" value and
+// converts it back to the type sops recorded.
+func decryptValue(s string, key []byte, aad string) (any, error) {
+	m := encValue.FindStringSubmatch(s)
+	if m == nil {
+		return nil, errors.New("malformed ENC value")
+	}
+	data, err1 := base64.StdEncoding.DecodeString(m[1])
+	iv, err2 := base64.StdEncoding.DecodeString(m[2])
+	tag, err3 := base64.StdEncoding.DecodeString(m[3])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("malformed ENC value: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(aad))
+	if err != nil {
+		return nil, errors.New("decryption failed (wrong key or tampered value)")
+	}
+
+	switch m[4] {
+	case "str", "bytes", "comment":
+		return string(plain), nil
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	default:
+		return nil, fmt.Errorf("unknown value type %q", m[4])
+	}
+}
+
+// selectField walks a dotted path ("db.password", "hosts.0") into the
+// decrypted tree. Scalars are returned as text, anything else as JSON.
+// An empty path returns the only value of a single-key document, or
+// the whole document as JSON.
+func selectField(tree map[string]any, field string) (string, error) {
+	if field == "" {
+		if len(tree) == 1 {
+			for _, v := range tree {
+				return stringify(v)
+			}
+		}
+		return stringify(tree)
+	}
+	var cur any = tree
+	for _, seg := range strings.Split(field, ".") {
+		switch t := cur.(type) {
+		case map[string]any:
+			v, ok := t[seg]
+			if !ok {
+				return "", fmt.Errorf("sops: field %q not found", field)
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(t) {
+				return "", fmt.Errorf("sops: field %q not found", field)
+			}
+			cur = t[i]
+		default:
+			return "", fmt.Errorf("sops: field %q not found", field)
+		}
+	}
+	return stringify(cur)
+}
+
+func stringify(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case nil:
+		return "", nil
+	case map[string]any, []any:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return fmt.Sprintf("%v", t), nil
+	}
+}
@@ -0,0 +1,286 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+)
+
+type gpgRunner func(ctx context.Context, stdin []byte, args ...string) ([]byte, error)
+
+type cacheEntry struct {
+	sealed  *memprotect.Sealed
+	expires time.Time
+	modTime time.Time
+}
+
+type Manager struct {
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	ttl   time.Duration
+	// runGPG and ageKeys are injectable for tests.
+	runGPG  gpgRunner
+	ageKeys func() (string, error)
+}
+
+func NewManager(ttl time.Duration) *Manager {
+	return &Manager{
+		cache: make(map[string]cacheEntry),
+		ttl:   ttl,
+		runGPG: func(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+			cmd := exec.CommandContext(ctx, "gpg", args...)
+			cmd.Stdin = bytes.NewReader(stdin)
+			out, err := cmd.Output()
+			if err != nil {
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					return nil, fmt.Errorf("gpg: %s", strings.TrimSpace(string(ee.Stderr)))
+				}
+				return nil, fmt.Errorf("gpg: %w", err)
+			}
+			return out, nil
+		},
+		ageKeys: defaultAgeKeys,
+	}
+}
+
+func (m *Manager) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+// CacheKey is the key a file is cached and listed under, and the key
+// Evict takes: its absolute path, and the nested reference its age key
+// was resolved from when the reference names one.
+func CacheKey(path, keyRef string) string {
+	key := strings.TrimSpace(path)
+	if abs, err := filepath.Abs(key); err == nil {
+		key = abs
+	}
+	if keyRef = strings.TrimSpace(keyRef); keyRef != "" {
+		key += " (key " + keyRef + ")"
+	}
+	return key
+}
+
+// ResolveSecret decrypts the SOPS file at path and returns the value at
+// the dotted field path. ageKey, when non-empty, holds age identities
+// resolved from the nested reference keyRef and takes the place of the
+// usual SOPS_AGE_KEY / SOPS_AGE_KEY_FILE / keys.txt lookup. PGP stanzas
+// are decrypted with the local gpg.
+//
+// The decrypted document is cached per file and key reference until
+// the TTL expires or the file is modified. The cache lock is not held
+// while gpg runs, since it may wait on a pinentry prompt.
+func (m *Manager) ResolveSecret(ctx context.Context, path, field, keyRef, ageKey string) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", errors.New("empty sops path")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	cacheKey := CacheKey(abs, keyRef)
+
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	m.mu.Lock()
+	raw, ok := m.readCache(ctx, cacheKey, fi.ModTime())
+	m.mu.Unlock()
+	if ok {
+		return selectJSONField(raw, field)
+	}
+
+	raw, err = m.decryptFile(ctx, abs, ageKey)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	cacheinfo.NoteExpiry(ctx, m.storeCache(cacheKey, raw, fi.ModTime()))
+	m.mu.Unlock()
+	return selectJSONField(raw, field)
+}
+
+// decryptFile decrypts the SOPS file at abs and returns the document
+// as JSON.
+func (m *Manager) decryptFile(ctx context.Context, abs, ageKey string) (string, error) {
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	doc, err := parseDocument(abs, data)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+
+	var identities []age.Identity
+	if len(doc.age) > 0 {
+		if identities, err = m.identities(ageKey); err != nil {
+			return "", fmt.Errorf("sops: %w", err)
+		}
+	}
+	key, err := doc.dataKey(ctx, identities, m.runGPG)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	defer clear(key)
+
+	tree, err := doc.decrypt(key)
+	if err != nil {
+		return "", fmt.Errorf("sops: %w", err)
+	}
+	raw, err := json.Marshal(tree)
+	if err != nil {
+		return "", fmt.Errorf("sops: marshal document: %w", err)
+	}
+	return string(raw), nil
+}
+
+func (m *Manager) identities(ageKey string) ([]age.Identity, error) {
+	if strings.TrimSpace(ageKey) == "" {
+		var err error
+		if ageKey, err = m.ageKeys(); err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(ageKey) == "" {
+		return nil, nil
+	}
+	ids, err := age.ParseIdentities(strings.NewReader(ageKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse age identities: %w", err)
+	}
+	return ids, nil
+}
+
+// defaultAgeKeys finds age identities where the sops CLI looks for
+// them: $SOPS_AGE_KEY, $SOPS_AGE_KEY_FILE, then sops/age/keys.txt in
+// the user config directory. No identities is not an error; the file
+// may be PGP-only.
+func defaultAgeKeys() (string, error) {
+	if k := os.Getenv("SOPS_AGE_KEY"); k != "" {
+		return k, nil
+	}
+	path := os.Getenv("SOPS_AGE_KEY_FILE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", nil
+		}
+		path = filepath.Join(dir, "sops", "age", "keys.txt")
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Evict removes a single cache entry by key (see CacheKey).
+func (m *Manager) Evict(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cache[key]; !ok {
+		key = CacheKey(key, "")
+	}
+	if e, ok := m.cache[key]; ok {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+	}
+}
+
+func (m *Manager) EvictAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range m.cache {
+		e.sealed.Destroy()
+		delete(m.cache, k)
+	}
+}
+
+func (m *Manager) CachedKeys() []cacheinfo.Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]cacheinfo.Entry, 0, len(m.cache))
+	for k, e := range m.cache {
+		if now.Before(e.expires) {
+			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+func (m *Manager) readCache(ctx context.Context, key string, modTime time.Time) (string, bool) {
+	e, ok := m.cache[key]
+	if !ok {
+		return "", false
+	}
+	if !time.Now().Before(e.expires) || !e.modTime.Equal(modTime) {
+		e.sealed.Destroy()
+		delete(m.cache, key)
+		return "", false
+	}
+	pt, err := e.sealed.OpenString()
+	if err != nil {
+		return "", false
+	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
+	return pt, true
+}
+
+func (m *Manager) storeCache(key, raw string, modTime time.Time) time.Time {
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return time.Time{}
+	}
+	if old, ok := m.cache[key]; ok {
+		old.sealed.Destroy()
+	}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl), modTime: modTime}
+	m.cache[key] = entry
+
+	go func(k string, e cacheEntry, d time.Duration) {
+		<-time.After(d)
+		m.mu.Lock()
+		if cur, ok := m.cache[k]; ok && cur.sealed == e.sealed {
+			delete(m.cache, k)
+		}
+		m.mu.Unlock()
+		e.sealed.Destroy()
+	}(key, entry, m.ttl)
+	return entry.expires
+}
+
+func selectJSONField(raw, field string) (string, error) {
+	var tree map[string]any
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&tree); err != nil {
+		return "", fmt.Errorf("sops: cached document is not an object: %w", err)
+	}
+	return selectField(tree, field)
+}
@@ -0,0 +1,311 @@
+package sops
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// encryptValue encrypts plain the way sops does: AES-256-GCM with a 32-byte
+// IV and the key path as additional data.
+func encryptValue(t *testing.T, key []byte, plain, typ, aad string) string {
+	t.Helper()
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := gcm.Seal(nil, iv, []byte(plain), []byte(aad))
+	data, tag := out[:len(out)-gcm.Overhead()], out[len(out)-gcm.Overhead():]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ)
+}
+
+const testLastModified = "2024-01-01T00:00:00Z"
+
+// macFor is the MAC sops writes for the given plaintext values, in
+// document order: the SHA-512 of their concatenation, encrypted with
+// lastmodified as additional data.
+func macFor(t *testing.T, key []byte, values ...string) string {
+	t.Helper()
+	h := sha512.New()
+	for _, v := range values {
+		h.Write([]byte(v))
+	}
+	return encryptValue(t, key, fmt.Sprintf("%X", h.Sum(nil)), "str", testLastModified)
+}
+
+// ageStanza encrypts the data key to id's recipient as armored age.
+func ageStanza(t *testing.T, id *age.X25519Identity, key []byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+type fixture struct {
+	id  *age.X25519Identity
+	key []byte
+}
+
+func newFixture(t *testing.T) fixture {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return fixture{id: id, key: key}
+}
+
+func (f fixture) yaml(t *testing.T) string {
+	stanza := strings.ReplaceAll(strings.TrimSpace(ageStanza(t, f.id, f.key)), "\n", "\n            ")
+	return fmt.Sprintf(`db:
+    user: %s
+    password: %s
+    port: %s
+hosts:
+    - %s
+    - %s
+public_unencrypted: plain
+sops:
+    age:
+        - recipient: %s
+          enc: |
+            %s
+    lastmodified: "%s"
+    mac: %s
+    unencrypted_suffix: _unencrypted
+    version: 3.9.0
+`,
+		encryptValue(t, f.key, "alice", "str", "db:user:"),
+		encryptValue(t, f.key, "s3cr3t", "str", "db:password:"),
+		encryptValue(t, f.key, "5432", "int", "db:port:"),
+		encryptValue(t, f.key, "a.example", "str", "hosts:"),
+		encryptValue(t, f.key, "b.example", "str", "hosts:"),
+		f.id.Recipient(), stanza, testLastModified,
+		macFor(t, f.key, "alice", "s3cr3t", "5432", "a.example", "b.example", "plain"))
+}
+
+func (f fixture) dotenv(t *testing.T) string {
+	stanza := strings.ReplaceAll(ageStanza(t, f.id, f.key), "\n", `\n`)
+	return fmt.Sprintf("API_KEY=%s\nsops_age__list_0__map_recipient=%s\nsops_age__list_0__map_enc=%s\nsops_lastmodified=%s\nsops_mac=%s\nsops_version=3.9.0\n",
+		encryptValue(t, f.key, "k-123", "str", "API_KEY:"), f.id.Recipient(), stanza,
+		testLastModified, macFor(t, f.key, "k-123"))
+}
+
+func (f fixture) json(t *testing.T) string {
+	stanza := strings.ReplaceAll(ageStanza(t, f.id, f.key), "\n", `\n`)
+	return fmt.Sprintf(`{"token": %q, "sops": {"age": [{"recipient": %q, "enc": %q}], "lastmodified": %q, "mac": %q}}`,
+		encryptValue(t, f.key, "tok", "str", "token:"), f.id.Recipient().String(), strings.ReplaceAll(stanza, `\n`, "\n"),
+		testLastModified, macFor(t, f.key, "tok"))
+}
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDecryptFormats(t *testing.T) {
+	f := newFixture(t)
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return f.id.String(), nil }
+
+	cases := []struct {
+		name, file, body, field, want string
+	}{
+		{"yaml nested", "s.enc.yaml", f.yaml(t), "db.password", "s3cr3t"},
+		{"yaml int", "s.enc.yaml", f.yaml(t), "db.port", "5432"},
+		{"yaml list index", "s.enc.yaml", f.yaml(t), "hosts.1", "b.example"},
+		{"yaml unencrypted", "s.enc.yaml", f.yaml(t), "public_unencrypted", "plain"},
+		{"yaml subtree", "s.enc.yaml", f.yaml(t), "hosts", `["a.example","b.example"]`},
+		{"dotenv", "s.env", f.dotenv(t), "API_KEY", "k-123"},
+		{"dotenv single key", "s.env", f.dotenv(t), "", "k-123"},
+		{"json", "s.json", f.json(t), "token", "tok"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeFile(t, tc.file, tc.body)
+			got, err := m.ResolveSecret(t.Context(), path, tc.field, "", "")
+			if err != nil {
+				t.Fatalf("ResolveSecret: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAgeKeyFromReferenceOverridesDefault(t *testing.T) {
+	f := newFixture(t)
+	other, _ := age.GenerateX25519Identity()
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return other.String(), nil }
+	path := writeFile(t, "s.enc.yaml", f.yaml(t))
+
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err == nil {
+		t.Fatal("expected failure with the wrong default key")
+	}
+	got, err := m.ResolveSecret(t.Context(), path, "db.user", "", "# created: today\n"+f.id.String()+"\n")
+	if err != nil || got != "alice" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestPGPStanzaUsesGPG(t *testing.T) {
+	f := newFixture(t)
+	body := fmt.Sprintf("password: %s\nsops:\n    pgp:\n        - fp: ABCD\n          enc: PGP-MESSAGE\n    lastmodified: %q\n    mac: %s\n",
+		encryptValue(t, f.key, "pgp-secret", "str", "password:"), testLastModified, macFor(t, f.key, "pgp-secret"))
+	path := writeFile(t, "s.yaml", body)
+
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return "", nil }
+	var gotStdin string
+	m.runGPG = func(_ context.Context, stdin []byte, args ...string) ([]byte, error) {
+		gotStdin = string(stdin)
+		return f.key, nil
+	}
+	got, err := m.ResolveSecret(t.Context(), path, "password", "", "")
+	if err != nil || got != "pgp-secret" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if gotStdin != "PGP-MESSAGE" {
+		t.Fatalf("gpg stdin=%q", gotStdin)
+	}
+}
+
+func TestValueBoundToKeyPath(t *testing.T) {
+	f := newFixture(t)
+	// A value encrypted for db:password: pasted under another key must
+	// not decrypt.
+	body := strings.Replace(f.yaml(t), "    user: ", "    user: "+encryptValue(t, f.key, "x", "str", "db:password:")+"\n    olduser: ", 1)
+	path := writeFile(t, "s.enc.yaml", body)
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return f.id.String(), nil }
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err == nil {
+		t.Fatal("expected decryption failure for moved value")
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return "", nil }
+	plain := writeFile(t, "plain.yaml", "a: b\n")
+	if _, err := m.ResolveSecret(t.Context(), plain, "a", "", ""); err == nil || !strings.Contains(err.Error(), "no sops metadata") {
+		t.Fatalf("want missing metadata error, got %v", err)
+	}
+	f := newFixture(t)
+	enc := writeFile(t, "s.enc.yaml", f.yaml(t))
+	if _, err := m.ResolveSecret(t.Context(), enc, "db.user", "", ""); err == nil || !strings.Contains(err.Error(), "no identity") {
+		t.Fatalf("want no identity error, got %v", err)
+	}
+	m.ageKeys = func() (string, error) { return f.id.String(), nil }
+	if _, err := m.ResolveSecret(t.Context(), enc, "db.missing", "", ""); err == nil {
+		t.Fatal("want missing field error")
+	}
+	if _, err := m.ResolveSecret(t.Context(), "", "x", "", ""); err == nil {
+		t.Fatal("want empty path error")
+	}
+}
+
+func TestTamperedFileRejected(t *testing.T) {
+	f := newFixture(t)
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return f.id.String(), nil }
+	orig := f.yaml(t)
+
+	cases := map[string]string{
+		// A plaintext value where sops wrote ciphertext.
+		"plaintext injected": strings.Replace(orig, "    password: ENC[", "    password: hunter2\n    x: ENC[", 1),
+		// A value sops left in the clear, changed.
+		"value replaced": strings.Replace(orig, "public_unencrypted: plain", "public_unencrypted: evil", 1),
+		// A key added with a value encrypted under its own path.
+		"value added": strings.Replace(orig, "hosts:\n", "extra: "+encryptValue(t, f.key, "x", "str", "extra:")+"\nhosts:\n", 1),
+		// A value removed.
+		"value removed":        strings.Replace(orig, "    - "+strings.SplitN(strings.SplitN(orig, "    - ", 2)[1], "\n", 2)[0]+"\n", "", 1),
+		"mac missing":          strings.Replace(orig, "    mac: ", "    old_mac: ", 1),
+		"lastmodified changed": strings.Replace(orig, testLastModified, "2025-01-01T00:00:00Z", 1),
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			if body == orig {
+				t.Fatal("fixture not modified")
+			}
+			path := writeFile(t, "s.enc.yaml", body)
+			if got, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err == nil {
+				t.Fatalf("tampered file decrypted: %q", got)
+			}
+		})
+	}
+
+	// Keys under the unencrypted suffix may be plaintext, but are still
+	// covered by the MAC unless mac_only_encrypted is set.
+	path := writeFile(t, "s.enc.yaml", strings.Replace(orig, "unencrypted_suffix: _unencrypted", "unencrypted_suffix: _unencrypted\n    mac_only_encrypted: true", 1))
+	if _, err := m.ResolveSecret(t.Context(), path, "db.user", "", ""); err == nil {
+		t.Fatal("MAC over all values accepted as mac_only_encrypted")
+	}
+}
+
+func TestEncryptedRegex(t *testing.T) {
+	f := newFixture(t)
+	body := fmt.Sprintf(`db:
+    host: db.internal
+    password: %s
+sops:
+    age:
+        - enc: %q
+    lastmodified: %q
+    mac: %s
+    encrypted_regex: ^password$
+    mac_only_encrypted: true
+`, encryptValue(t, f.key, "s3cr3t", "str", "db:password:"), ageStanza(t, f.id, f.key),
+		testLastModified, macFor(t, f.key, "s3cr3t"))
+	m := NewManager(0)
+	m.ageKeys = func() (string, error) { return f.id.String(), nil }
+	path := writeFile(t, "s.enc.yaml", body)
+	for field, want := range map[string]string{"db.host": "db.internal", "db.password": "s3cr3t"} {
+		if got, err := m.ResolveSecret(t.Context(), path, field, "", ""); err != nil || got != want {
+			t.Fatalf("%s: got %q, %v", field, got, err)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+// Package sshagent serves private keys read through secret references
+// over the ssh-agent protocol.
+package sshagent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/cacheinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// KeyConfig is one ssh_agent_keys entry.
+type KeyConfig struct {
+	// Ref reads the private key (OpenSSH, PKCS#1, PKCS#8 or SEC 1
+	// PEM), e.g. keepass(&work|/SSH/github|attachment:id_ed25519).
+	Ref string `mapstructure:"ref"`
+	// Passphrase is a reference or literal for an encrypted key.
+	Passphrase string `mapstructure:"passphrase"`
+	Comment    string `mapstructure:"comment"`
+	// PublicKey is an optional authorized_keys line. With it the key
+	// is listed without reading Ref until a signature is asked for.
+	PublicKey string `mapstructure:"public_key"`
+}
+
+// Key identifies a configured key to the approver.
+type Key struct {
+	Ref         string
+	Comment     string
+	Fingerprint string
+}
+
+// loadedKey is a decrypted private key, sealed between signatures.
+type loadedKey struct {
+	sealed  *memprotect.Sealed // unencrypted OpenSSH PEM
+	expires time.Time
+}
+
+var errReadOnly = errors.New("keys are configured in ssh_agent_keys, not added to the agent")
+
+type Agent struct {
+	mu   sync.Mutex
+	keys map[string]*loadedKey // by Ref
+	// pubs remembers public keys read with a private key, so a key
+	// that was evicted is still listed without reading it again.
+	pubs map[string]ssh.PublicKey
+	ttl  time.Duration
+	// configs, resolve, approve and valid are injectable for tests
+	// and wired to the daemon by the server.
+	configs func() []KeyConfig
+	resolve func(ctx context.Context, ref string) (string, error)
+	approve func(ctx context.Context, k Key) error
+	valid   func(ref string) bool
+}
+
+func New(ttl time.Duration) *Agent {
+	return &Agent{
+		keys:    make(map[string]*loadedKey),
+		pubs:    make(map[string]ssh.PublicKey),
+		ttl:     ttl,
+		configs: func() []KeyConfig { return nil },
+		resolve: func(context.Context, string) (string, error) {
+			return "", errors.New("no reference resolver configured")
+		},
+		approve: func(context.Context, Key) error { return nil },
+		valid:   func(string) bool { return true },
+	}
+}
+
+func (a *Agent) SetTTL(ttl time.Duration) {
+	a.mu.Lock()
+	a.ttl = ttl
+	a.mu.Unlock()
+}
+
+// SetConfig sets the source of the configured keys, read on every
+// request so edits to the config take effect without a restart.
+func (a *Agent) SetConfig(fn func() []KeyConfig) { a.configs = fn }
+
+// SetReferenceResolver sets how key and passphrase references are
+// read. It is called with the requesting connection's context.
+func (a *Agent) SetReferenceResolver(fn func(ctx context.Context, ref string) (string, error)) {
+	a.resolve = fn
+}
+
+// SetApprover sets the check run before every signature.
+func (a *Agent) SetApprover(fn func(ctx context.Context, k Key) error) { a.approve = fn }
+
+// SetValidator sets the check that the source of a loaded key still
+// holds it (a KeePass vault that is still unlocked). A key whose source
+// is gone is dropped, and one not yet read is not listed.
+func (a *Agent) SetValidator(fn func(ref string) bool) { a.valid = fn }
+
+// Serve answers agent connections on ln until it is closed.
+// connContext supplies each connection's context, which carries the
+// peer process for approval.
+func (a *Agent) Serve(ln net.Listener, connContext func(net.Conn) context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go a.sweep(stop)
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer c.Close()
+			s := &session{a: a, ctx: connContext(c)}
+			if err := agent.ServeAgent(s, c); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("ssh-agent: %v", err)
+			}
+		}()
+	}
+}
+
+// sweep drops keys whose source went away, so evicting a KeePass vault
+// takes its keys with it rather than at their next use.
+func (a *Agent) sweep(stop <-chan struct{}) {
+	t := time.NewTicker(5 * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			a.dropInvalid()
+		}
+	}
+}
+
+func (a *Agent) dropInvalid() {
+	a.mu.Lock()
+	var refs []string
+	for ref := range a.keys {
+		refs = append(refs, ref)
+	}
+	a.mu.Unlock()
+	for _, ref := range refs {
+		a.mu.Lock()
+		k, ok := a.keys[ref]
+		expired := ok && time.Now().After(k.expires)
+		a.mu.Unlock()
+		if ok && (expired || !a.valid(ref)) {
+			a.Evict(ref)
+		}
+	}
+}
+
+// Evict drops the loaded private key for ref.
+func (a *Agent) Evict(ref string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if k, ok := a.keys[ref]; ok {
+		k.sealed.Destroy()
+		delete(a.keys, ref)
+	}
+}
+
+func (a *Agent) EvictAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, k := range a.keys {
+		k.sealed.Destroy()
+	}
+	a.keys = make(map[string]*loadedKey)
+}
+
+func (a *Agent) CachedKeys() []cacheinfo.Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]cacheinfo.Entry, 0, len(a.keys))
+	for ref, k := range a.keys {
+		var detail string
+		if pub, ok := a.pubs[ref]; ok {
+			detail = ssh.FingerprintSHA256(pub)
+		}
+		out = append(out, cacheinfo.Entry{Key: ref, Expires: k.expires, Detail: detail})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// publicKey returns the public half of cfg's key, reading the private
+// key only when neither the config nor an earlier read supplies it.
+func (a *Agent) publicKey(ctx context.Context, cfg KeyConfig) (ssh.PublicKey, error) {
+	if cfg.PublicKey != "" {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("public_key of %s: %w", cfg.Ref, err)
+		}
+		return pub, nil
+	}
+	a.mu.Lock()
+	pub, ok := a.pubs[cfg.Ref]
+	a.mu.Unlock()
+	if ok {
+		return pub, nil
+	}
+	signer, err := a.signer(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+// listable reports whether cfg's public key is known or can be read
+// without unlocking anything.
+func (a *Agent) listable(cfg KeyConfig) bool {
+	if cfg.PublicKey != "" {
+		return true
+	}
+	a.mu.Lock()
+	_, ok := a.pubs[cfg.Ref]
+	a.mu.Unlock()
+	if ok {
+		return true
+	}
+	return a.valid(cfg.Ref) && (cfg.Passphrase == "" || a.valid(cfg.Passphrase))
+}
+
+// signer returns a signer for cfg's key, reading and sealing it if it
+// is not loaded. The caller must not keep it beyond one signature.
+func (a *Agent) signer(ctx context.Context, cfg KeyConfig) (ssh.Signer, error) {
+	a.mu.Lock()
+	k, ok := a.keys[cfg.Ref]
+	if ok && time.Now().After(k.expires) {
+		ok = false
+	}
+	a.mu.Unlock()
+	if ok && !a.valid(cfg.Ref) {
+		ok = false
+	}
+	if !ok {
+		a.Evict(cfg.Ref)
+		var err error
+		if k, err = a.load(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	// Opened under the lock so a concurrent Evict cannot destroy it
+	// mid-read.
+	a.mu.Lock()
+	raw, err := k.sealed.Open()
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer memprotect.Wipe(raw)
+	return ssh.ParsePrivateKey(raw)
+}
+
+// load reads cfg's key, decrypts it and stores it sealed and
+// unencrypted.
+func (a *Agent) load(ctx context.Context, cfg KeyConfig) (*loadedKey, error) {
+	raw, err := a.resolve(ctx, cfg.Ref)
+	if err != nil {
+		return nil, err
+	}
+	var passphrase string
+	if cfg.Passphrase != "" {
+		if passphrase, err = a.resolve(ctx, cfg.Passphrase); err != nil {
+			return nil, fmt.Errorf("passphrase of %s: %w", cfg.Ref, err)
+		}
+	}
+	var priv any
+	if passphrase != "" {
+		priv, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(raw), []byte(passphrase))
+	} else {
+		priv, err = ssh.ParseRawPrivateKey([]byte(raw))
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%s is encrypted; set its passphrase", cfg.Ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Ref, err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Ref, err)
+	}
+	if cfg.PublicKey != "" {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.PublicKey))
+		if err == nil && !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+			return nil, fmt.Errorf("%s does not match its public_key", cfg.Ref)
+		}
+	}
+	block, err := ssh.MarshalPrivateKey(priv, cfg.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.Ref, err)
+	}
+	pemBytes := pem.EncodeToMemory(block)
+	memprotect.Wipe(block.Bytes)
+	sealed, err := memprotect.Seal(pemBytes)
+	memprotect.Wipe(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pubs[cfg.Ref] = signer.PublicKey()
+	if cur, ok := a.keys[cfg.Ref]; ok {
+		// A concurrent request loaded it first; others may be using it.
+		sealed.Destroy()
+		return cur, nil
+	}
+	k := &loadedKey{sealed: sealed, expires: time.Now().Add(a.ttl)}
+	a.keys[cfg.Ref] = k
+	return k, nil
+}
+
+// session is the agent one connection talks to.
+type session struct {
+	a   *Agent
+	ctx context.Context
+}
+
+// List reads a key without public_key to learn its public half, but
+// not from a locked source: ssh lists keys on every connection, and
+// that must not raise an unlock prompt.
+func (s *session) List() ([]*agent.Key, error) {
+	var out []*agent.Key
+	for _, cfg := range s.a.configs() {
+		if !s.a.listable(cfg) {
+			log.Printf("ssh-agent: %s not listed while its vault is locked; set its public_key to list it anyway", cfg.Ref)
+			continue
+		}
+		pub, err := s.a.publicKey(s.ctx, cfg)
+		if err != nil {
+			// One key that cannot be read must not hide the others.
+			log.Printf("ssh-agent: %v", err)
+			continue
+		}
+		out = append(out, &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: comment(cfg)})
+	}
+	return out, nil
+}
+
+func (s *session) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return s.SignWithFlags(key, data, 0)
+}
+
+func (s *session) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	want := key.Marshal()
+	for _, cfg := range s.a.configs() {
+		pub, err := s.a.publicKey(s.ctx, cfg)
+		if err != nil || !bytes.Equal(pub.Marshal(), want) {
+			continue
+		}
+		if err := s.a.approve(s.ctx, Key{Ref: cfg.Ref, Comment: comment(cfg), Fingerprint: ssh.FingerprintSHA256(pub)}); err != nil {
+			return nil, err
+		}
+		signer, err := s.a.signer(s.ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if alg := algorithm(flags); alg != "" {
+			as, ok := signer.(ssh.AlgorithmSigner)
+			if !ok {
+				return nil, fmt.Errorf("%s cannot sign with %s", cfg.Ref, alg)
+			}
+			return as.SignWithAlgorithm(rand.Reader, data, alg)
+		}
+		return signer.Sign(rand.Reader, data)
+	}
+	return nil, errors.New("key not found")
+}
+
+func algorithm(flags agent.SignatureFlags) string {
+	switch {
+	case flags&agent.SignatureFlagRsaSha512 != 0:
+		return ssh.KeyAlgoRSASHA512
+	case flags&agent.SignatureFlagRsaSha256 != 0:
+		return ssh.KeyAlgoRSASHA256
+	}
+	return ""
+}
+
+func comment(cfg KeyConfig) string {
+	if cfg.Comment != "" {
+		return cfg.Comment
+	}
+	return cfg.Ref
+}
+
+// ssh-add -d and -D drop loaded keys; they are read again on next use.
+func (s *session) Remove(key ssh.PublicKey) error {
+	want := key.Marshal()
+	for _, cfg := range s.a.configs() {
+		s.a.mu.Lock()
+		pub, ok := s.a.pubs[cfg.Ref]
+		s.a.mu.Unlock()
+		if ok && bytes.Equal(pub.Marshal(), want) {
+			s.a.Evict(cfg.Ref)
+			return nil
+		}
+	}
+	return errors.New("key not found")
+}
+
+func (s *session) RemoveAll() error {
+	s.a.EvictAll()
+	return nil
+}
+
+func (s *session) Add(agent.AddedKey) error { return errReadOnly }
+
+func (s *session) Lock([]byte) error { return errors.New("locking is not supported") }
+
+func (s *session) Unlock([]byte) error { return errors.New("locking is not supported") }
+
+func (s *session) Signers() ([]ssh.Signer, error) {
+	return nil, errors.New("signers are not exported")
+}
+
+func (s *session) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
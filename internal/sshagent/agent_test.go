@@ -0,0 +1,310 @@
+package sshagent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type testKey struct {
+	pem string
+	pub ssh.PublicKey
+}
+
+func newEd25519(t *testing.T, passphrase string) testKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{pem: string(pem.EncodeToMemory(block)), pub: pub}
+}
+
+// newTestAgent serves a over a pipe and returns a client for it.
+func newTestAgent(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+	srv, cli := net.Pipe()
+	go func() {
+		_ = agent.ServeAgent(&session{a: a, ctx: context.Background()}, srv)
+		srv.Close()
+	}()
+	t.Cleanup(func() { cli.Close() })
+	return agent.NewClient(cli)
+}
+
+func TestListAndSign(t *testing.T) {
+	key := newEd25519(t, "")
+	var reads, approvals atomic.Int32
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig {
+		return []KeyConfig{{Ref: "keepass(&work|/SSH/github|attachment:id_ed25519)", Comment: "github"}}
+	})
+	a.SetReferenceResolver(func(_ context.Context, ref string) (string, error) {
+		reads.Add(1)
+		return key.pem, nil
+	})
+	var approved Key
+	a.SetApprover(func(_ context.Context, k Key) error {
+		approvals.Add(1)
+		approved = k
+		return nil
+	})
+	c := newTestAgent(t, a)
+
+	keys, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "github" || string(keys[0].Blob) != string(key.pub.Marshal()) {
+		t.Fatalf("List = %v", keys)
+	}
+
+	for range 2 {
+		sig, err := c.Sign(key.pub, []byte("challenge"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.pub.Verify([]byte("challenge"), sig); err != nil {
+			t.Fatalf("signature does not verify: %v", err)
+		}
+	}
+	if reads.Load() != 1 {
+		t.Errorf("key read %d times, want once", reads.Load())
+	}
+	if approvals.Load() != 2 {
+		t.Errorf("approver ran %d times, want once per signature", approvals.Load())
+	}
+	if approved.Fingerprint != ssh.FingerprintSHA256(key.pub) || approved.Comment != "github" {
+		t.Errorf("approved %+v", approved)
+	}
+	if cached := a.CachedKeys(); len(cached) != 1 || cached[0].Detail != approved.Fingerprint {
+		t.Errorf("CachedKeys = %+v", cached)
+	}
+
+	// ssh-add -D drops the loaded key; the next signature reads it again.
+	if err := c.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.CachedKeys()) != 0 {
+		t.Fatal("RemoveAll kept the key loaded")
+	}
+	if _, err := c.Sign(key.pub, []byte("again")); err != nil {
+		t.Fatal(err)
+	}
+	if reads.Load() != 2 {
+		t.Errorf("key read %d times after RemoveAll, want 2", reads.Load())
+	}
+
+	if err := c.Add(agent.AddedKey{}); err == nil {
+		t.Error("Add succeeded")
+	}
+}
+
+func TestSignDenied(t *testing.T) {
+	key := newEd25519(t, "")
+	var reads atomic.Int32
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig {
+		return []KeyConfig{{Ref: "pass(ssh/id)", PublicKey: string(ssh.MarshalAuthorizedKey(key.pub))}}
+	})
+	a.SetReferenceResolver(func(context.Context, string) (string, error) {
+		reads.Add(1)
+		return key.pem, nil
+	})
+	a.SetApprover(func(context.Context, Key) error { return errors.New("denied") })
+	c := newTestAgent(t, a)
+
+	// With public_key configured, listing does not read the key.
+	keys, err := c.List()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "pass(ssh/id)" {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	if _, err := c.Sign(key.pub, []byte("x")); err == nil {
+		t.Fatal("Sign succeeded without approval")
+	}
+	if reads.Load() != 0 {
+		t.Errorf("key read %d times, want none", reads.Load())
+	}
+}
+
+func TestValidatorDropsKey(t *testing.T) {
+	key := newEd25519(t, "")
+	var reads atomic.Int32
+	var unlocked atomic.Bool
+	unlocked.Store(true)
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig { return []KeyConfig{{Ref: "keepass(&work|/SSH/id)"}} })
+	a.SetReferenceResolver(func(context.Context, string) (string, error) {
+		reads.Add(1)
+		return key.pem, nil
+	})
+	a.SetValidator(func(string) bool { return unlocked.Load() })
+	c := newTestAgent(t, a)
+
+	if _, err := c.Sign(key.pub, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	unlocked.Store(false)
+	a.dropInvalid()
+	if len(a.CachedKeys()) != 0 {
+		t.Fatal("key kept after its vault was evicted")
+	}
+	// Still listed from the remembered public key, without a read.
+	if keys, err := c.List(); err != nil || len(keys) != 1 {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	if reads.Load() != 1 {
+		t.Errorf("key read %d times, want once", reads.Load())
+	}
+}
+
+func TestListSkipsLockedKey(t *testing.T) {
+	key := newEd25519(t, "")
+	var reads atomic.Int32
+	var unlocked atomic.Bool
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig {
+		return []KeyConfig{
+			{Ref: "keepass(&work|/SSH/id)"},
+			{Ref: "keepass(&work|/SSH/deploy)", PublicKey: string(ssh.MarshalAuthorizedKey(key.pub))},
+		}
+	})
+	a.SetReferenceResolver(func(context.Context, string) (string, error) {
+		reads.Add(1)
+		return key.pem, nil
+	})
+	a.SetValidator(func(string) bool { return unlocked.Load() })
+	c := newTestAgent(t, a)
+
+	// A locked vault is not unlocked to list a key without public_key.
+	keys, err := c.List()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "keepass(&work|/SSH/deploy)" {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	if reads.Load() != 0 {
+		t.Fatalf("key read %d times while locked, want none", reads.Load())
+	}
+
+	unlocked.Store(true)
+	if keys, err := c.List(); err != nil || len(keys) != 2 {
+		t.Fatalf("List after unlock = %v, %v", keys, err)
+	}
+	if reads.Load() != 1 {
+		t.Errorf("key read %d times, want once", reads.Load())
+	}
+}
+
+func TestEncryptedKeyAndRSAFlags(t *testing.T) {
+	enc := newEd25519(t, "hunter2")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(rsaKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, _ := ssh.NewPublicKey(&rsaKey.PublicKey)
+	refs := map[string]string{
+		"age(keys.age|id_ed25519)": enc.pem,
+		"user(passphrase)":         "hunter2",
+		"awssm(ssh/deploy)":        string(pem.EncodeToMemory(block)),
+	}
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig {
+		return []KeyConfig{
+			{Ref: "age(keys.age|id_ed25519)", Passphrase: "user(passphrase)"},
+			{Ref: "awssm(ssh/deploy)"},
+		}
+	})
+	a.SetReferenceResolver(func(_ context.Context, ref string) (string, error) { return refs[ref], nil })
+	c := newTestAgent(t, a)
+
+	if sig, err := c.Sign(enc.pub, []byte("x")); err != nil || enc.pub.Verify([]byte("x"), sig) != nil {
+		t.Fatalf("encrypted key: %v", err)
+	}
+	sig, err := c.SignWithFlags(rsaPub, []byte("x"), agent.SignatureFlagRsaSha256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Format != ssh.KeyAlgoRSASHA256 || rsaPub.Verify([]byte("x"), sig) != nil {
+		t.Fatalf("rsa-sha2-256 signature: format %s", sig.Format)
+	}
+
+	// Without its passphrase the encrypted key is skipped, not fatal.
+	a.SetConfig(func() []KeyConfig {
+		return []KeyConfig{{Ref: "age(keys.age|id_ed25519)"}, {Ref: "awssm(ssh/deploy)"}}
+	})
+	a.EvictAll()
+	a.pubs = map[string]ssh.PublicKey{}
+	keys, err := c.List()
+	if err != nil || len(keys) != 1 || keys[0].Format != ssh.KeyAlgoRSA {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	key := newEd25519(t, "")
+	a := New(time.Hour)
+	a.SetConfig(func() []KeyConfig { return []KeyConfig{{Ref: "pass(ssh/id)"}} })
+	a.SetReferenceResolver(func(context.Context, string) (string, error) { return key.pem, nil })
+	type ctxKey struct{}
+	var approvedFor atomic.Value
+	a.SetApprover(func(ctx context.Context, _ Key) error {
+		approvedFor.Store(ctx.Value(ctxKey{}))
+		return nil
+	})
+
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- a.Serve(ln, func(net.Conn) context.Context {
+			return context.WithValue(context.Background(), ctxKey{}, "peer")
+		})
+	}()
+
+	conn, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.NewClient(conn).Sign(key.pub, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got, _ := approvedFor.Load().(string); got != "peer" {
+		t.Errorf("approver saw context value %q", got)
+	}
+
+	ln.Close()
+	if err := <-done; err != nil && !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("Serve: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+// Package tmpl renders arbitrary config-file templates written in Go
+// text/template syntax, with a `secret` function that looks up
+// provider references such as `secret "keepass(&work|db)"`.
+package tmpl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Resolver resolves a batch of provider references, returning a map
+// from each reference to its value.
+type Resolver func(refs []string) (map[string]string, error)
+
+// maxPasses bounds how many resolve round trips a single render may
+// take. A template only needs more than one when it branches on a
+// secret's value and the branch references further secrets.
+const maxPasses = 4
+
+// Render executes src and returns the output. References are collected
+// by a dry run in which `secret` returns "" and then resolved with one
+// resolve call; the template is executed again with the real values.
+// Should that run reach references the dry run didn't, those are
+// resolved in a further batch.
+func Render(name string, src []byte, resolve Resolver) ([]byte, error) {
+	values := map[string]string{}
+	for range maxPasses {
+		var missing []string
+		seen := map[string]bool{}
+		lookup := func(ref string) (string, error) {
+			ref = strings.TrimSpace(ref)
+			if ref == "" {
+				return "", errors.New("secret: empty reference")
+			}
+			if v, ok := values[ref]; ok {
+				return v, nil
+			}
+			if !seen[ref] {
+				seen[ref] = true
+				missing = append(missing, ref)
+			}
+			return "", nil
+		}
+
+		t, err := template.New(name).Option("missingkey=error").Funcs(funcMap(lookup)).Parse(string(src))
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := t.Execute(&out, nil); err != nil {
+			return nil, err
+		}
+		if len(missing) == 0 {
+			return out.Bytes(), nil
+		}
+
+		sort.Strings(missing)
+		got, err := resolve(missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range missing {
+			v, ok := got[ref]
+			if !ok {
+				return nil, fmt.Errorf("secret %q: no value returned", ref)
+			}
+			values[ref] = v
+		}
+	}
+	return nil, fmt.Errorf("template %s still references unresolved secrets after %d passes", name, maxPasses)
+}
+
+func funcMap(secret func(string) (string, error)) template.FuncMap {
+	return template.FuncMap{
+		"secret": secret,
+		"b64enc": b64enc,
+		"toJson": toJSON,
+		"indent": indent,
+	}
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// toJSON encodes v without HTML escaping, so secrets containing <, >
+// or & come out as written.
+func toJSON(v any) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// indent prefixes every line of s with n spaces, for embedding
+// multi-line values in YAML.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
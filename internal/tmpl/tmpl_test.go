@@ -0,0 +1,106 @@
+package tmpl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenderBatchesReferences(t *testing.T) {
+	src := `db:
+  password: {{ secret "keepass(&work|db)" }}
+  again: {{ secret " keepass(&work|db) " }}
+token: {{ secret "vault(secret/data/api|token)" | b64enc }}
+json: {{ secret "user(note)" | toJson }}
+cert: |
+{{ secret "keepass(&work|cert)" | indent 2 }}
+`
+	values := map[string]string{
+		"keepass(&work|db)":            "hunter2",
+		"vault(secret/data/api|token)": "tok",
+		"user(note)":                   `a "quoted" <note>`,
+		"keepass(&work|cert)":          "line1\nline2",
+	}
+	var batches [][]string
+	resolve := func(refs []string) (map[string]string, error) {
+		batches = append(batches, refs)
+		out := map[string]string{}
+		for _, r := range refs {
+			out[r] = values[r]
+		}
+		return out, nil
+	}
+
+	got, err := Render("app.yml.tpl", []byte(src), resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `db:
+  password: hunter2
+  again: hunter2
+token: dG9r
+json: "a \"quoted\" <note>"
+cert: |
+  line1
+  line2
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if len(batches) != 1 || len(batches[0]) != 4 {
+		t.Fatalf("want one batch of 4 references, got %v", batches)
+	}
+}
+
+func TestRenderResolvesBranchReferencesInLaterPass(t *testing.T) {
+	src := `{{ if eq (secret "user(mode)") "prod" }}{{ secret "user(prod)" }}{{ else }}dev{{ end }}`
+	values := map[string]string{"user(mode)": "prod", "user(prod)": "p-secret"}
+	var batches [][]string
+	resolve := func(refs []string) (map[string]string, error) {
+		batches = append(batches, refs)
+		out := map[string]string{}
+		for _, r := range refs {
+			out[r] = values[r]
+		}
+		return out, nil
+	}
+	got, err := Render("t", []byte(src), resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "p-secret" {
+		t.Fatalf("got %q", got)
+	}
+	if want := [][]string{{"user(mode)"}, {"user(prod)"}}; !reflect.DeepEqual(batches, want) {
+		t.Fatalf("batches=%v want %v", batches, want)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	failing := func([]string) (map[string]string, error) { return nil, errors.New("denied") }
+	if _, err := Render("t", []byte(`{{ secret "user(x)" }}`), failing); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("want resolver error, got %v", err)
+	}
+	empty := func([]string) (map[string]string, error) { return map[string]string{}, nil }
+	if _, err := Render("t", []byte(`{{ secret "user(x)" }}`), empty); err == nil {
+		t.Fatal("want error for missing value")
+	}
+	if _, err := Render("t", []byte(`{{ secret "" }}`), empty); err == nil {
+		t.Fatal("want error for empty reference")
+	}
+	if _, err := Render("t", []byte(`{{ secret`), empty); err == nil {
+		t.Fatal("want parse error")
+	}
+}
+
+func TestRenderWithoutSecretsSkipsResolver(t *testing.T) {
+	resolve := func([]string) (map[string]string, error) {
+		t.Fatal("resolver called")
+		return nil, nil
+	}
+	got, err := Render("t", []byte(`plain {{ "x" | b64enc }}`), resolve)
+	if err != nil || string(got) != "plain eA==" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
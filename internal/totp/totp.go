@@ -0,0 +1,144 @@
+// Package totp computes RFC 6238 time-based one-time codes from seeds
+// read through other providers.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Params describe how codes are generated for a seed.
+type Params struct {
+	Secret    []byte
+	Digits    int           // 6 to 10
+	Period    time.Duration // whole seconds
+	Algorithm string        // SHA1, SHA256 or SHA512
+}
+
+// Parse reads an otpauth://totp/ URI, as stored in KeePassXC's otp
+// attribute or a 1Password one-time password field, or a bare base32
+// seed. Parameters the URI leaves out default to 6 digits, 30 seconds
+// and SHA1.
+func Parse(s string) (Params, error) {
+	s = strings.TrimSpace(s)
+	p := Params{Digits: 6, Period: 30 * time.Second, Algorithm: "SHA1"}
+	seed := s
+	if strings.HasPrefix(strings.ToLower(s), "otpauth://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return Params{}, fmt.Errorf("totp: invalid otpauth URI")
+		}
+		if !strings.EqualFold(u.Host, "totp") {
+			return Params{}, fmt.Errorf("totp: otpauth type %q is not supported (only totp)", u.Host)
+		}
+		q := u.Query()
+		seed = q.Get("secret")
+		if err := p.Set("digits", q.Get("digits")); err != nil {
+			return Params{}, err
+		}
+		if err := p.Set("period", q.Get("period")); err != nil {
+			return Params{}, err
+		}
+		if err := p.Set("algorithm", q.Get("algorithm")); err != nil {
+			return Params{}, err
+		}
+	}
+	secret, err := decodeSeed(seed)
+	if err != nil {
+		return Params{}, err
+	}
+	p.Secret = secret
+	return p, nil
+}
+
+// Set overrides one parameter by name (digits, period or algorithm).
+// An empty value leaves it unchanged.
+func (p *Params) Set(name, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	switch name {
+	case "digits":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 6 || n > 10 {
+			return fmt.Errorf("totp: digits=%q must be 6 to 10", value)
+		}
+		p.Digits = n
+	case "period":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("totp: period=%q must be a positive number of seconds", value)
+		}
+		p.Period = time.Duration(n) * time.Second
+	case "algorithm":
+		alg := strings.ToUpper(strings.ReplaceAll(value, "-", ""))
+		if alg != "SHA1" && alg != "SHA256" && alg != "SHA512" {
+			return fmt.Errorf("totp: algorithm=%q must be SHA1, SHA256 or SHA512", value)
+		}
+		p.Algorithm = alg
+	default:
+		return fmt.Errorf("totp: unknown parameter %q", name)
+	}
+	return nil
+}
+
+// decodeSeed decodes a base32 seed, forgiving the spaces, lower case
+// and missing padding that seeds are often written with.
+func decodeSeed(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\t", "").Replace(s))
+	s = strings.TrimRight(s, "=")
+	if s == "" {
+		return nil, fmt.Errorf("totp: empty seed")
+	}
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("totp: seed is not base32")
+	}
+	return b, nil
+}
+
+// Code returns the code for the window containing t.
+func Code(p Params, t time.Time) string {
+	counter := uint64(t.Unix()) / uint64(p.Period/time.Second)
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	var h func() hash.Hash
+	switch p.Algorithm {
+	case "SHA256":
+		h = sha256.New
+	case "SHA512":
+		h = sha512.New
+	default:
+		h = sha1.New
+	}
+	mac := hmac.New(h, p.Secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint64(1)
+	for range p.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", p.Digits, uint64(bin)%mod)
+}
+
+// Remaining is how long the code for t stays valid.
+func Remaining(p Params, t time.Time) time.Duration {
+	period := int64(p.Period / time.Second)
+	next := (t.Unix()/period + 1) * period
+	return time.Unix(next, 0).Sub(t)
+}
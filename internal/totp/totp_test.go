@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B: 8-digit codes for the ASCII seeds
+// "12345678901234567890" repeated to the hash size.
+func TestCodeRFC6238(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	cases := []struct {
+		unix int64
+		alg  string
+		want string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111111, "SHA256", "67062674"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{20000000000, "SHA256", "77737706"},
+	}
+	for _, tc := range cases {
+		p := Params{Secret: []byte(seeds[tc.alg]), Digits: 8, Period: 30 * time.Second, Algorithm: tc.alg}
+		if got := Code(p, time.Unix(tc.unix, 0)); got != tc.want {
+			t.Errorf("%s at %d: got %s, want %s", tc.alg, tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	seed := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	p, err := Parse("otpauth://totp/ACME:alice?secret=" + seed + "&issuer=ACME&digits=8&period=60&algorithm=SHA256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Secret) != "12345678901234567890" || p.Digits != 8 || p.Period != time.Minute || p.Algorithm != "SHA256" {
+		t.Fatalf("Parse = %+v", p)
+	}
+
+	// Bare seeds: lower case, spaces and missing padding are fine.
+	bare := strings.ToLower(strings.TrimRight(seed, "="))
+	bare = bare[:4] + " " + bare[4:]
+	p, err = Parse(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(p.Secret) != "12345678901234567890" || p.Digits != 6 || p.Period != 30*time.Second || p.Algorithm != "SHA1" {
+		t.Fatalf("Parse(bare) = %+v", p)
+	}
+	if got := Code(p, time.Unix(59, 0)); got != "287082" {
+		t.Errorf("6-digit code = %s", got)
+	}
+
+	for _, bad := range []string{
+		"",
+		"not base32!",
+		"otpauth://hotp/x?secret=" + seed + "&counter=1",
+		"otpauth://totp/x?secret=" + seed + "&digits=4",
+		"otpauth://totp/x?secret=" + seed + "&algorithm=MD5",
+		"otpauth://totp/x?secret=" + seed + "&period=0",
+		"otpauth://totp/x",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	p := Params{Period: 30 * time.Second}
+	cases := map[int64]time.Duration{
+		0:  30 * time.Second,
+		29: time.Second,
+		45: 15 * time.Second,
+	}
+	for unix, want := range cases {
+		if got := Remaining(p, time.Unix(unix, 0)); got != want {
+			t.Errorf("Remaining at %d = %v, want %v", unix, got, want)
+		}
+	}
+}
@@ -83,8 +83,9 @@ func (m *UserManager) CachedKeys() []cacheinfo.Entry {
 func (m *UserManager) ResolvePassword(ctx context.Context, title string, ttl time.Duration) (string, error) {
 	m.mu.RLock()
 	if v, exists := m.password[title]; exists && time.Now().Before(v.expires) {
-		sealed := v.sealed
+		sealed, expires := v.sealed, v.expires
 		m.mu.RUnlock()
+		cacheinfo.NoteExpiry(ctx, expires)
 		return sealed.OpenString()
 	}
 	m.mu.RUnlock()
@@ -123,6 +124,7 @@ func (m *UserManager) ResolvePassword(ctx context.Context, title string, ttl tim
 	}
 	m.password[title] = p
 	m.mu.Unlock()
+	cacheinfo.NoteExpiry(ctx, p.expires)
 
 	go func(title string, p *passwordEntry, d time.Duration) {
 		<-time.After(d)
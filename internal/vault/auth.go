@@ -0,0 +1,348 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/prompt"
+)
+
+// AuthConfig is one entry of the vault_auth setting: how to log in to
+// the Vault at Address. Without a matching entry the ambient
+// VAULT_TOKEN or token helper is used.
+//
+// Token, RoleID, SecretID and Username may be literals or references
+// such as keepass(&ops|vault approle|secret-id), but not vault(...):
+// reading those needs the login they are part of.
+type AuthConfig struct {
+	Address      string `mapstructure:"address"`
+	Method       string `mapstructure:"method"` // token, approle, userpass, ldap or oidc
+	Mount        string `mapstructure:"mount"`  // defaults to the method name
+	Token        string `mapstructure:"token"`
+	RoleID       string `mapstructure:"role_id"`
+	SecretID     string `mapstructure:"secret_id"`
+	Username     string `mapstructure:"username"`
+	Role         string `mapstructure:"role"`          // OIDC role; empty for the mount's default
+	CallbackPort int    `mapstructure:"callback_port"` // OIDC redirect listener, default 8250
+}
+
+// session is the client in use and what we know about its token.
+type session struct {
+	cli    *vaultapi.Client
+	method string
+	// owned tokens were created by our own login and are revoked on
+	// Close; a token handed to us is left alone.
+	owned  bool
+	cancel context.CancelFunc
+}
+
+func (m *Manager) authFor(addr string) *AuthConfig {
+	addr = strings.TrimRight(addr, "/")
+	for _, c := range m.authConfig() {
+		if strings.TrimRight(strings.TrimSpace(c.Address), "/") == addr {
+			return &c
+		}
+	}
+	return nil
+}
+
+// login authenticates c according to cfg and starts renewing the
+// token if Vault allows it. It runs without m.mu held (see connect).
+func (m *Manager) login(ctx context.Context, c *vaultapi.Client, cfg *AuthConfig) (*session, error) {
+	method := strings.ToLower(strings.TrimSpace(cfg.Method))
+	mount := strings.Trim(cfg.Mount, "/")
+	if mount == "" {
+		mount = method
+	}
+	s := &session{cli: c, method: method, owned: true}
+
+	var sec *vaultapi.Secret
+	var err error
+	switch method {
+	case "token":
+		tok, err := m.resolveValue(ctx, cfg.Token, "token")
+		if err != nil {
+			return nil, err
+		}
+		c.SetToken(tok)
+		s.owned = false
+		self, err := c.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("token lookup: %w", err)
+		}
+		renewable, _ := self.TokenIsRenewable()
+		ttl, _ := self.TokenTTL()
+		m.startRenewal(s, renewable, ttl)
+		return s, nil
+
+	case "approle":
+		roleID, err := m.resolveValue(ctx, cfg.RoleID, "role_id")
+		if err != nil {
+			return nil, err
+		}
+		secretID, err := m.resolveValue(ctx, cfg.SecretID, "secret_id")
+		if err != nil {
+			return nil, err
+		}
+		c.ClearToken()
+		sec, err = c.Logical().WriteWithContext(ctx, "auth/"+mount+"/login", map[string]any{
+			"role_id": roleID, "secret_id": secretID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case "userpass", "ldap":
+		username, err := m.resolveValue(ctx, cfg.Username, "username")
+		if err != nil {
+			return nil, err
+		}
+		password, err := m.askPassword(ctx, fmt.Sprintf("Vault %s password for %s", method, username))
+		if err != nil {
+			return nil, err
+		}
+		c.ClearToken()
+		sec, err = c.Logical().WriteWithContext(ctx, "auth/"+mount+"/login/"+url.PathEscape(username), map[string]any{
+			"password": password,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	case "oidc":
+		c.ClearToken()
+		if sec, err = m.oidcLogin(ctx, c, mount, cfg); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown auth method %q (want token, approle, userpass, ldap or oidc)", cfg.Method)
+	}
+
+	if sec == nil || sec.Auth == nil || sec.Auth.ClientToken == "" {
+		return nil, errors.New("login returned no token")
+	}
+	c.SetToken(sec.Auth.ClientToken)
+	m.startRenewal(s, sec.Auth.Renewable, time.Duration(sec.Auth.LeaseDuration)*time.Second)
+	return s, nil
+}
+
+func (m *Manager) resolveValue(ctx context.Context, v, name string) (string, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return "", fmt.Errorf("%s not configured", name)
+	}
+	if strings.Contains(strings.ToLower(v), "vault(") {
+		return "", fmt.Errorf("%s: vault(...) references can't be used to log in to Vault", name)
+	}
+	m.mu.Lock()
+	resolve := m.resolveRef
+	m.mu.Unlock()
+	out, err := resolve(ctx, v)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// startRenewal renews the session's token at two thirds of its TTL
+// until renewal fails or the session is closed. A token that can no
+// longer be renewed drops the session so the next lookup logs in
+// again.
+func (m *Manager) startRenewal(s *session, renewable bool, ttl time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	if !renewable || ttl <= 0 {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ttl * 2 / 3):
+			}
+			sec, err := s.cli.Auth().Token().RenewSelfWithContext(ctx, 0)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil || sec == nil || sec.Auth == nil || sec.Auth.LeaseDuration <= 0 {
+				m.mu.Lock()
+				if m.sess == s {
+					m.resetSession()
+				}
+				m.mu.Unlock()
+				return
+			}
+			ttl = time.Duration(sec.Auth.LeaseDuration) * time.Second
+		}
+	}()
+}
+
+// resetSession forgets the client so the next lookup creates (and
+// logs in) a new one. The caller holds m.mu.
+func (m *Manager) resetSession() {
+	if m.sess != nil && m.sess.cancel != nil {
+		m.sess.cancel()
+	}
+	m.sess = nil
+	m.cli = nil
+	m.logical = nil
+}
+
+// dropIfInvalid is called when s was denied a read. It reports whether
+// s's token no longer looks itself up, in which case the token is
+// revoked if we logged in for it and the session is dropped so the
+// next connect logs in again. It runs without m.mu held.
+func (m *Manager) dropIfInvalid(ctx context.Context, s *session) bool {
+	if _, err := s.cli.Auth().Token().LookupSelfWithContext(ctx); err == nil {
+		return false
+	}
+	if s.owned {
+		rctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+		_ = s.cli.Auth().Token().RevokeSelfWithContext(rctx, "")
+		cancel()
+	}
+	m.mu.Lock()
+	if m.sess == s {
+		m.resetSession()
+	}
+	m.mu.Unlock()
+	return true
+}
+
+// Close revokes the leases of dynamic secrets, stops token renewal and
+// revokes a token the daemon logged in for. Tokens supplied by the
+// user are never revoked.
+func (m *Manager) Close(ctx context.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revokeLeases(ctx, func(*lease) bool { return true })
+	if s := m.sess; s != nil && s.owned {
+		_ = s.cli.Auth().Token().RevokeSelfWithContext(ctx, "")
+	}
+	m.resetSession()
+}
+
+// oidcLogin runs the browser flow the vault CLI uses: Vault hands out
+// an authorization URL, the identity provider redirects back to a
+// listener on localhost, and the code is exchanged for a token.
+func (m *Manager) oidcLogin(ctx context.Context, c *vaultapi.Client, mount string, cfg *AuthConfig) (*vaultapi.Secret, error) {
+	port := cfg.CallbackPort
+	if port == 0 {
+		port = 8250
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("oidc callback listener: %w", err)
+	}
+	defer ln.Close()
+	redirect := fmt.Sprintf("http://localhost:%d/oidc/callback", ln.Addr().(*net.TCPAddr).Port)
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+
+	sec, err := c.Logical().WriteWithContext(ctx, "auth/"+mount+"/oidc/auth_url", map[string]any{
+		"role": cfg.Role, "redirect_uri": redirect, "client_nonce": nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	authURL := ""
+	if sec != nil {
+		authURL, _ = sec.Data["auth_url"].(string)
+	}
+	if authURL == "" {
+		return nil, fmt.Errorf("no auth_url; is %s an allowed redirect URI for role %q?", redirect, cfg.Role)
+	}
+
+	got := make(chan url.Values, 1)
+	srv := &http.Server{
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/oidc/callback" {
+				http.NotFound(w, r)
+				return
+			}
+			select {
+			case got <- r.URL.Query():
+			default:
+			}
+			fmt.Fprintln(w, "Signed in to Vault. You can close this window.")
+		}),
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	if err := m.openBrowser(authURL); err != nil {
+		return nil, fmt.Errorf("open browser: %w (visit %s)", err, authURL)
+	}
+
+	var q url.Values
+	select {
+	case q = <-got:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for OIDC login: %w", ctx.Err())
+	}
+	if e := q.Get("error"); e != "" {
+		return nil, fmt.Errorf("oidc: %s %s", e, q.Get("error_description"))
+	}
+	return c.Logical().ReadWithDataWithContext(ctx, "auth/"+mount+"/oidc/callback", map[string][]string{
+		"state":        {q.Get("state")},
+		"code":         {q.Get("code")},
+		"id_token":     {q.Get("id_token")},
+		"client_nonce": {nonce},
+	})
+}
+
+func openBrowser(u string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	case "darwin":
+		cmd = exec.Command("open", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	return cmd.Start()
+}
+
+func promptPassword(ctx context.Context, title string) (string, error) {
+	opts := &prompt.UserOptions{Prompt: title}
+	if info := clientinfo.InfoFromContext(ctx); info.PID != 0 || info.ExePath != "" || info.Name != "" {
+		opts.ProcessDisplay = info.EffectiveDisplay()
+		opts.ProcessDetails = info.EffectiveTooltip()
+	}
+	result, err := prompt.PromptForPassword("Vault", prompt.StyleUser, nil, opts)
+	if err != nil {
+		return "", err
+	}
+	if result.Password == "" {
+		return "", errors.New("empty password")
+	}
+	return result.Password, nil
+}
+
+// isPermissionDenied reports a 403 from Vault, which for a token we
+// logged in with usually means it expired or was revoked.
+func isPermissionDenied(err error) bool {
+	var re *vaultapi.ResponseError
+	return errors.As(err, &re) && re.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,370 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// stubVault serves the login, token, lease and secret endpoints the
+// manager uses.
+type stubVault struct {
+	*httptest.Server
+	mu      sync.Mutex
+	tokens  map[string]bool
+	revoked []string
+	renewed int
+	logins  int
+	ttl     int // lease_duration handed out at login, seconds
+
+	leases        int // dynamic credentials issued so far
+	leaseTTL      int // their lease_duration, seconds
+	leaseRenewed  int
+	leasesRevoked []string
+}
+
+func newStubVault(t *testing.T) *stubVault {
+	t.Helper()
+	v := &stubVault{tokens: map[string]bool{"static-token": true}, ttl: 3600, leaseTTL: 3600}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serve))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *stubVault) reply(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (v *stubVault) login(w http.ResponseWriter, token string) {
+	v.logins++
+	v.tokens[token] = true
+	v.reply(w, 200, map[string]any{"auth": map[string]any{
+		"client_token": token, "renewable": true, "lease_duration": v.ttl,
+	}})
+}
+
+func (v *stubVault) serve(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	tok := r.Header.Get("X-Vault-Token")
+
+	switch p := strings.TrimPrefix(r.URL.Path, "/v1/"); p {
+	case "auth/approle/login":
+		if body["role_id"] != "role-1" || body["secret_id"] != "secret-1" {
+			v.reply(w, 400, map[string]any{"errors": []string{"invalid role or secret ID"}})
+			return
+		}
+		v.login(w, "approle-token")
+	case "auth/userpass/login/alice", "auth/corp-ldap/login/alice":
+		if body["password"] != "pw" {
+			v.reply(w, 400, map[string]any{"errors": []string{"invalid username or password"}})
+			return
+		}
+		v.login(w, "userpass-token")
+	case "auth/oidc/oidc/auth_url":
+		redirect := body["redirect_uri"].(string)
+		v.reply(w, 200, map[string]any{"data": map[string]any{
+			"auth_url": "https://idp.example/authorize?state=st1&redirect_uri=" + url.QueryEscape(redirect),
+		}})
+	case "auth/oidc/oidc/callback":
+		q := r.URL.Query()
+		if q.Get("state") != "st1" || q.Get("code") != "code1" || q.Get("client_nonce") == "" {
+			v.reply(w, 400, map[string]any{"errors": []string{"bad callback"}})
+			return
+		}
+		v.login(w, "oidc-token")
+	case "auth/token/lookup-self":
+		if !v.tokens[tok] {
+			v.reply(w, 403, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		v.reply(w, 200, map[string]any{"data": map[string]any{"renewable": false, "ttl": 0}})
+	case "auth/token/renew-self":
+		v.renewed++
+		v.reply(w, 200, map[string]any{"auth": map[string]any{
+			"client_token": tok, "renewable": true, "lease_duration": v.ttl,
+		}})
+	case "auth/token/revoke-self":
+		v.revoked = append(v.revoked, tok)
+		delete(v.tokens, tok)
+		w.WriteHeader(204)
+	case "secret/denied":
+		// Readable by no policy, whatever the token.
+		v.reply(w, 403, map[string]any{"errors": []string{"permission denied"}})
+	case "secret/app":
+		if !v.tokens[tok] {
+			v.reply(w, 403, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		v.reply(w, 200, map[string]any{"data": map[string]any{"password": "p-" + tok}})
+	case "database/creds/app":
+		v.leases++
+		v.reply(w, 200, map[string]any{
+			"lease_id":       fmt.Sprintf("database/creds/app/l%d", v.leases),
+			"lease_duration": v.leaseTTL,
+			"renewable":      true,
+			"data": map[string]any{
+				"username": fmt.Sprintf("u%d", v.leases),
+				"password": fmt.Sprintf("p%d", v.leases),
+			},
+		})
+	case "sys/leases/renew":
+		v.leaseRenewed++
+		v.reply(w, 200, map[string]any{
+			"lease_id": body["lease_id"], "lease_duration": v.leaseTTL, "renewable": true,
+		})
+	case "sys/leases/revoke":
+		v.leasesRevoked = append(v.leasesRevoked, body["lease_id"].(string))
+		w.WriteHeader(204)
+	default:
+		if !v.serveKV(w, r, p) {
+			v.reply(w, 404, map[string]any{"errors": []string{}})
+		}
+	}
+}
+
+func newAuthManager(v *stubVault, cfg AuthConfig) *Manager {
+	cfg.Address = v.URL + "/"
+	m := NewManager(time.Hour)
+	m.newClient = func() (*vaultapi.Client, error) {
+		c := vaultapi.DefaultConfig()
+		c.Address = v.URL
+		cli, err := vaultapi.NewClient(c)
+		if err != nil {
+			return nil, err
+		}
+		cli.SetToken("")
+		return cli, nil
+	}
+	m.SetAuthConfig(func() []AuthConfig { return []AuthConfig{{Address: "https://other", Method: "token"}, cfg} })
+	m.SetReferenceResolver(func(_ context.Context, v string) (string, error) {
+		if v == "keepass(&ops|approle)" {
+			return "secret-1", nil
+		}
+		if strings.HasPrefix(v, "keepass(") {
+			return "", errors.New("entry not found")
+		}
+		return v, nil
+	})
+	m.askPassword = func(context.Context, string) (string, error) { return "pw", nil }
+	return m
+}
+
+func TestLoginMethods(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  AuthConfig
+		want string
+	}{
+		{"token from reference", AuthConfig{Method: "token", Token: "static-token"}, "p-static-token"},
+		{"approle with secret id reference", AuthConfig{Method: "approle", RoleID: "role-1", SecretID: "keepass(&ops|approle)"}, "p-approle-token"},
+		{"userpass prompts", AuthConfig{Method: "userpass", Username: "alice"}, "p-userpass-token"},
+		{"ldap on custom mount", AuthConfig{Method: "ldap", Mount: "corp-ldap", Username: "alice"}, "p-userpass-token"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newStubVault(t)
+			m := newAuthManager(v, tc.cfg)
+			defer m.Close(context.Background())
+			got, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{})
+			if err != nil || got != tc.want {
+				t.Fatalf("got %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestLoginErrors(t *testing.T) {
+	v := newStubVault(t)
+	for _, cfg := range []AuthConfig{
+		{Method: "approle", RoleID: "role-1", SecretID: "keepass(&ops|missing)"},
+		{Method: "approle", RoleID: "role-1"},
+		{Method: "kerberos"},
+	} {
+		m := newAuthManager(v, cfg)
+		if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err == nil {
+			t.Errorf("%+v: expected login error", cfg)
+		}
+	}
+}
+
+func TestLoginRejectsVaultReferences(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "approle", RoleID: "role-1", SecretID: "vault(secret/approle|secret_id)"})
+	_, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "vault(...) references") {
+		t.Fatalf("err = %v, want vault(...) rejected", err)
+	}
+}
+
+func TestLoginRunsOutsideCacheLock(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "userpass", Username: "alice"})
+	defer m.Close(context.Background())
+	asked, release := make(chan struct{}), make(chan struct{})
+	var prompts int
+	m.askPassword = func(context.Context, string) (string, error) {
+		prompts++
+		close(asked)
+		<-release
+		return "pw", nil
+	}
+
+	results := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{})
+			results <- err
+		}()
+	}
+	<-asked
+
+	// The prompt is up: the tray must still be able to list and evict.
+	listed := make(chan struct{})
+	go func() {
+		m.CachedKeys()
+		m.EvictAll()
+		close(listed)
+	}()
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cache blocked while the login prompt was open")
+	}
+
+	close(release)
+	for range 2 {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prompts != 1 {
+		t.Fatalf("prompted %d times, want one login shared by both lookups", prompts)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "oidc", CallbackPort: freePort(t)})
+	m.openBrowser = func(authURL string) error {
+		u, _ := url.Parse(authURL)
+		redirect := u.Query().Get("redirect_uri")
+		go func() {
+			resp, err := http.Get(redirect + "?state=" + u.Query().Get("state") + "&code=code1")
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+	defer m.Close(context.Background())
+	got, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{})
+	if err != nil || got != "p-oidc-token" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestCloseRevokesOnlyOwnedTokens(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "approle", RoleID: "role-1", SecretID: "secret-1"})
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close(t.Context())
+
+	m = newAuthManager(v, AuthConfig{Method: "token", Token: "static-token"})
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close(t.Context())
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.revoked) != 1 || v.revoked[0] != "approle-token" {
+		t.Fatalf("revoked %v, want only the approle token", v.revoked)
+	}
+}
+
+func TestRenewalAndRelogin(t *testing.T) {
+	v := newStubVault(t)
+	v.ttl = 1
+	m := newAuthManager(v, AuthConfig{Method: "approle", RoleID: "role-1", SecretID: "secret-1"})
+	defer m.Close(context.Background())
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v.mu.Lock()
+		n := v.renewed
+		v.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("token was not renewed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Revoked behind our back: the next read logs in again.
+	v.mu.Lock()
+	delete(v.tokens, "approle-token")
+	v.mu.Unlock()
+	m.EvictAll()
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.logins != 2 {
+		t.Fatalf("expected a second login, got %d", v.logins)
+	}
+	if len(v.revoked) != 1 || v.revoked[0] != "approle-token" {
+		t.Fatalf("revoked %v, want the stale approle token", v.revoked)
+	}
+}
+
+func TestPolicyDenialKeepsSession(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "approle", RoleID: "role-1", SecretID: "secret-1"})
+	defer m.Close(context.Background())
+	for range 2 {
+		if _, err := m.ResolveSecret(t.Context(), "secret/denied", "password", ReadOptions{}); !isPermissionDenied(err) {
+			t.Fatalf("expected permission denied, got %v", err)
+		}
+	}
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.logins != 1 || len(v.revoked) != 0 {
+		t.Fatalf("a valid token was replaced: %d logins, revoked %v", v.logins, v.revoked)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
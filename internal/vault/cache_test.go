@@ -2,6 +2,7 @@ package vault
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,7 +15,10 @@ type fakeLogical struct {
 	err   error
 }
 
-func (f *fakeLogical) ReadWithContext(_ context.Context, _ string) (*vaultapi.Secret, error) {
+func (f *fakeLogical) ReadWithDataWithContext(_ context.Context, path string, _ map[string][]string) (*vaultapi.Secret, error) {
+	if strings.HasPrefix(path, "sys/internal/ui/mounts/") {
+		return nil, nil // mount unknown; the path is read as given
+	}
 	f.calls++
 	return f.resp, f.err
 }
@@ -33,7 +37,7 @@ func TestResolveSecretRoundTrip(t *testing.T) {
 	}
 	m := newManagerWithFake(time.Hour, fl)
 
-	got, err := m.ResolveSecret(context.Background(), "secret/data/myapp", "password")
+	got, err := m.ResolveSecret(context.Background(), "secret/data/myapp", "password", ReadOptions{})
 	if err != nil {
 		t.Fatalf("ResolveSecret: %v", err)
 	}
@@ -51,7 +55,7 @@ func TestResolveSecretCachesAcrossCalls(t *testing.T) {
 	m := newManagerWithFake(time.Hour, fl)
 
 	for i := 0; i < 3; i++ {
-		if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password"); err != nil {
+		if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password", ReadOptions{}); err != nil {
 			t.Fatalf("call %d: %v", i, err)
 		}
 	}
@@ -67,11 +71,11 @@ func TestExpiredEntryEvictedOnRead(t *testing.T) {
 		}},
 	}
 	m := newManagerWithFake(time.Millisecond, fl)
-	if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password"); err != nil {
+	if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password", ReadOptions{}); err != nil {
 		t.Fatal(err)
 	}
 	time.Sleep(10 * time.Millisecond)
-	if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password"); err != nil {
+	if _, err := m.ResolveSecret(context.Background(), "secret/data/x", "password", ReadOptions{}); err != nil {
 		t.Fatal(err)
 	}
 	if fl.calls != 2 {
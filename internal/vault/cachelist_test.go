@@ -7,8 +7,8 @@ import (
 
 func TestCachedKeysAndEvictAll(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("secret/data/b", "v1")
-	m.storeCache("secret/data/a", "v2")
+	m.storeCache("secret/data/b", "v1", "", m.ttl)
+	m.storeCache("secret/data/a", "v2", "", m.ttl)
 
 	keys := m.CachedKeys()
 	if len(keys) != 2 {
@@ -29,7 +29,7 @@ func TestCachedKeysAndEvictAll(t *testing.T) {
 
 func TestCachedKeysExcludesExpired(t *testing.T) {
 	m := NewManager(time.Hour)
-	m.storeCache("secret/data/x", "v")
+	m.storeCache("secret/data/x", "v", "", m.ttl)
 	for k, e := range m.cache {
 		e.expires = time.Now().Add(-time.Minute)
 		m.cache[k] = e
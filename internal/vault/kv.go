@@ -0,0 +1,174 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// ReadOptions qualify a read beyond its path.
+type ReadOptions struct {
+	// Version pins a KV v2 secret version; 0 reads the current one.
+	Version int
+	// Namespace is the Vault Enterprise namespace of the path,
+	// relative to VAULT_NAMESPACE if that is set.
+	Namespace string
+}
+
+// CacheKey is the key a read is cached and listed under, and the key
+// Evict takes.
+func CacheKey(path string, opt ReadOptions) string {
+	key := strings.TrimSpace(path)
+	if opt.Namespace != "" {
+		key = "[" + opt.Namespace + "] " + key
+	}
+	if opt.Version > 0 {
+		key += "?version=" + strconv.Itoa(opt.Version)
+	}
+	return key
+}
+
+// mount is a secrets engine mount as reported by sys/internal/ui/mounts.
+type mount struct {
+	path string // with trailing slash
+	kvV2 bool
+}
+
+// mountFor finds the mount holding path, asking Vault the way the vault
+// CLI does. ok is false when Vault would not say (older servers, or a
+// token without access to the endpoint); the path is then read as
+// given. The caller holds m.mu.
+func (m *Manager) mountFor(ctx context.Context, lg logical, ns, path string) (mount, bool) {
+	for _, mt := range m.mounts[ns] {
+		if strings.HasPrefix(path, mt.path) {
+			return mt, true
+		}
+	}
+	sec, err := lg.ReadWithDataWithContext(ctx, "sys/internal/ui/mounts/"+path, nil)
+	if err != nil || sec == nil {
+		return mount{}, false
+	}
+	p, _ := sec.Data["path"].(string)
+	if p == "" {
+		return mount{}, false
+	}
+	mt := mount{path: strings.TrimSuffix(p, "/") + "/"}
+	if typ, _ := sec.Data["type"].(string); typ == "kv" || typ == "generic" {
+		if o, ok := sec.Data["options"].(map[string]interface{}); ok {
+			mt.kvV2 = fmt.Sprint(o["version"]) == "2"
+		}
+	}
+	if m.mounts == nil {
+		m.mounts = make(map[string][]mount)
+	}
+	m.mounts[ns] = append(m.mounts[ns], mt)
+	return mt, true
+}
+
+// apiPath turns a path as written in a reference into the path to read.
+// On a KV v2 mount, `secret/app` becomes `secret/data/app`; paths that
+// already spell out `data/` are left alone.
+func apiPath(mt mount, path string) string {
+	if !mt.kvV2 {
+		return path
+	}
+	rel := strings.TrimPrefix(path, mt.path)
+	if strings.HasPrefix(rel, "data/") {
+		return path
+	}
+	return mt.path + "data/" + rel
+}
+
+// envelope is what gets cached for a path: the secret's data and, for
+// KV v2, its version metadata.
+type envelope struct {
+	Data     json.RawMessage        `json:"data"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// unwrap extracts the secret data and KV v2 metadata from a response,
+// failing for versions that were deleted or destroyed.
+func unwrap(sec *vaultapi.Secret, path string, kvV2 bool, version int) (map[string]interface{}, map[string]interface{}, error) {
+	md, _ := sec.Data["metadata"].(map[string]interface{})
+	inner, hasData := sec.Data["data"]
+	if !kvV2 && (md == nil || !hasData) {
+		// KV v1 or another engine; KV v2 responses are still
+		// recognised by their shape when the mount is unknown.
+		if v, ok := inner.(map[string]interface{}); ok && md == nil {
+			return v, nil, nil
+		}
+		return sec.Data, nil, nil
+	}
+	data, _ := inner.(map[string]interface{})
+	if data == nil {
+		v := version
+		if n, ok := md["version"].(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = int(i)
+			}
+		}
+		which := "current version"
+		if v > 0 {
+			which = fmt.Sprintf("version %d", v)
+		}
+		if d, _ := md["destroyed"].(bool); d {
+			return nil, nil, fmt.Errorf("vault: %s of %q was destroyed", which, path)
+		}
+		if t, _ := md["deletion_time"].(string); t != "" {
+			return nil, nil, fmt.Errorf("vault: %s of %q was deleted at %s (vault kv undelete restores it)", which, path, t)
+		}
+		return nil, nil, fmt.Errorf("vault: %s of %q has no data", which, path)
+	}
+	return data, md, nil
+}
+
+// selectValue returns field from a cached envelope. Fields are looked
+// up in the secret data (see selectField). Failing that, `metadata`
+// selects the KV v2 version metadata as JSON and `metadata.NAME` one
+// entry of it, e.g. `metadata.created_time` or
+// `metadata.custom_metadata.owner`; a data key of the same name wins.
+func selectValue(raw, field string) (string, error) {
+	var env envelope
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return "", fmt.Errorf("vault: bad cache entry: %w", err)
+	}
+	if field != "metadata" && !strings.HasPrefix(field, "metadata.") {
+		return selectField(string(env.Data), field)
+	}
+	var data map[string]interface{}
+	if json.Unmarshal(env.Data, &data) == nil {
+		if _, ok := data[field]; ok {
+			return selectField(string(env.Data), field)
+		}
+	}
+	if env.Metadata == nil {
+		return "", fmt.Errorf("vault: no metadata; %s is not a KV v2 secret", field)
+	}
+	var v interface{} = env.Metadata
+	if name, ok := strings.CutPrefix(field, "metadata."); ok {
+		for _, part := range strings.Split(name, ".") {
+			obj, isObj := v.(map[string]interface{})
+			if !isObj {
+				return "", fmt.Errorf("vault: metadata field %q not found", name)
+			}
+			if v, ok = obj[part]; !ok {
+				return "", fmt.Errorf("vault: metadata field %q not found", name)
+			}
+		}
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case nil:
+		return "", nil
+	}
+	return stringify(v), nil
+}
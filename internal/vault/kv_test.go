@@ -0,0 +1,150 @@
+package vault
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// serveKV answers for a KV v2 mount at kv2/ and a KV v1 mount at
+// kv/. kv2/app has four versions: 1 live, 2 deleted, 3 destroyed
+// and 4 current. The team-a namespace has its own kv2/app.
+func (v *stubVault) serveKV(w http.ResponseWriter, r *http.Request, p string) bool {
+	ns := r.Header.Get("X-Vault-Namespace")
+	if rest, ok := strings.CutPrefix(p, "sys/internal/ui/mounts/"); ok {
+		switch {
+		case strings.HasPrefix(rest, "kv2/"):
+			v.reply(w, 200, map[string]any{"data": map[string]any{
+				"path": "kv2/", "type": "kv", "options": map[string]any{"version": "2"},
+			}})
+		case strings.HasPrefix(rest, "kv/"):
+			v.reply(w, 200, map[string]any{"data": map[string]any{
+				"path": "kv/", "type": "kv", "options": nil,
+			}})
+		default:
+			v.reply(w, 403, map[string]any{"errors": []string{"permission denied"}})
+		}
+		return true
+	}
+	switch p {
+	case "kv/legacy":
+		v.reply(w, 200, map[string]any{"data": map[string]any{"key": "legacy"}})
+	case "kv2/data/app":
+		if ns == "team-a" {
+			v.reply(w, 200, map[string]any{"data": map[string]any{
+				"data":     map[string]any{"password": "team-a-pw"},
+				"metadata": map[string]any{"version": 1},
+			}})
+			return true
+		}
+		version := r.URL.Query().Get("version")
+		md := map[string]any{
+			"created_time": "2026-01-0" + version + "T00:00:00Z", "deletion_time": "", "destroyed": false,
+			"custom_metadata": map[string]any{"owner": "release"},
+		}
+		switch version {
+		case "1":
+			md["version"] = 1
+			v.reply(w, 200, map[string]any{"data": map[string]any{"data": map[string]any{"password": "v1"}, "metadata": md}})
+		case "2":
+			md["version"], md["deletion_time"] = 2, "2026-02-01T00:00:00Z"
+			v.reply(w, 404, map[string]any{"data": map[string]any{"data": nil, "metadata": md}})
+		case "3":
+			md["version"], md["destroyed"] = 3, true
+			v.reply(w, 404, map[string]any{"data": map[string]any{"data": nil, "metadata": md}})
+		case "", "4":
+			md["version"], md["created_time"] = 4, "2026-01-04T00:00:00Z"
+			v.reply(w, 200, map[string]any{"data": map[string]any{"data": map[string]any{"password": "v4"}, "metadata": md}})
+		default:
+			v.reply(w, 404, map[string]any{"errors": []string{}})
+		}
+	case "kv2/data/report":
+		v.reply(w, 200, map[string]any{"data": map[string]any{
+			"data":     map[string]any{"metadata": "own", "metadata.owner": "ops"},
+			"metadata": map[string]any{"version": 1},
+		}})
+	default:
+		return false
+	}
+	return true
+}
+
+func TestKVv2(t *testing.T) {
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "token", Token: "static-token"})
+	ctx := t.Context()
+
+	cases := []struct {
+		path, field string
+		opt         ReadOptions
+		want        string
+	}{
+		{"kv2/app", "password", ReadOptions{}, "v4"},
+		{"kv2/data/app", "password", ReadOptions{}, "v4"},
+		{"kv2/app", "password", ReadOptions{Version: 1}, "v1"},
+		{"kv2/app", "metadata.version", ReadOptions{Version: 1}, "1"},
+		{"kv2/app", "metadata.created_time", ReadOptions{}, "2026-01-04T00:00:00Z"},
+		{"kv2/app", "metadata.custom_metadata.owner", ReadOptions{}, "release"},
+		{"kv2/app", "metadata.custom_metadata", ReadOptions{}, `{"owner":"release"}`},
+		{"kv2/report", "metadata", ReadOptions{}, "own"},
+		{"kv2/report", "metadata.owner", ReadOptions{}, "ops"},
+		{"kv2/report", "metadata.version", ReadOptions{}, "1"},
+		{"kv2/app", "password", ReadOptions{Namespace: "team-a"}, "team-a-pw"},
+		{"kv/legacy", "key", ReadOptions{}, "legacy"},
+	}
+	for _, tc := range cases {
+		got, err := m.ResolveSecret(ctx, tc.path, tc.field, tc.opt)
+		if err != nil || got != tc.want {
+			t.Errorf("%s|%s %+v: got %q, %v; want %q", tc.path, tc.field, tc.opt, got, err, tc.want)
+		}
+	}
+
+	errs := []struct {
+		path, field string
+		opt         ReadOptions
+		want        string
+	}{
+		{"kv2/app", "password", ReadOptions{Version: 2}, "version 2 of \"kv2/app?version=2\" was deleted at 2026-02-01T00:00:00Z"},
+		{"kv2/app", "password", ReadOptions{Version: 3}, "version 3 of \"kv2/app?version=3\" was destroyed"},
+		{"kv/legacy", "key", ReadOptions{Version: 2}, "needs a KV v2 mount"},
+		{"kv/legacy", "metadata.version", ReadOptions{}, "not a KV v2 secret"},
+		{"kv2/app", "metadata.nope", ReadOptions{}, `metadata field "nope" not found`},
+	}
+	for _, tc := range errs {
+		_, err := m.ResolveSecret(ctx, tc.path, tc.field, tc.opt)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s|%s %+v: err %v, want %q", tc.path, tc.field, tc.opt, err, tc.want)
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, e := range m.CachedKeys() {
+		keys[e.Key] = true
+	}
+	for _, k := range []string{"kv2/app", "kv2/data/app", "kv2/app?version=1", "kv2/report", "[team-a] kv2/app", "kv/legacy"} {
+		if !keys[k] {
+			t.Errorf("%q not cached; have %v", k, keys)
+		}
+	}
+	m.Evict(CacheKey("kv2/app", ReadOptions{Version: 1}))
+	if keys := m.CachedKeys(); len(keys) != 5 {
+		t.Errorf("after Evict: %+v", keys)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	cases := []struct {
+		path string
+		opt  ReadOptions
+		want string
+	}{
+		{"kv2/app", ReadOptions{}, "kv2/app"},
+		{"kv2/app", ReadOptions{Version: 3}, "kv2/app?version=3"},
+		{"kv2/app", ReadOptions{Version: 3, Namespace: "team-a/dev"}, "[team-a/dev] kv2/app?version=3"},
+	}
+	for _, tc := range cases {
+		if got := CacheKey(tc.path, tc.opt); got != tc.want {
+			t.Errorf("CacheKey(%q, %+v) = %q, want %q", tc.path, tc.opt, got, tc.want)
+		}
+	}
+}
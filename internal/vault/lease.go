@@ -0,0 +1,239 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+	"github.com/it-atelier-gn/desktop-secrets/internal/memprotect"
+)
+
+// leaseSys is the part of the sys API used to renew and revoke leases.
+type leaseSys interface {
+	RenewWithContext(ctx context.Context, id string, increment int) (*vaultapi.Secret, error)
+	RevokeWithContext(ctx context.Context, id string) error
+}
+
+// lease is a dynamic secret handed out by Vault (database/creds/...,
+// aws/creds/...). It is tracked until it expires, is revoked, or the
+// daemon shuts down.
+type lease struct {
+	id        string
+	path      string
+	renewable bool
+	duration  time.Duration
+	expires   time.Time
+	sys       leaseSys // the client that obtained the lease
+	// clients are the tplenv run processes (PID to start time) the
+	// lease is kept alive for.
+	clients map[int]uint64
+	cancel  context.CancelFunc // stops renewal; nil when not renewing
+}
+
+func (l *lease) detail() string {
+	if l.cancel != nil {
+		return fmt.Sprintf("lease %s (renewed while tplenv run is active)", l.id)
+	}
+	return "lease " + l.id
+}
+
+// trackLease records the lease behind sec, read from path through cli.
+// The caller holds m.mu.
+func (m *Manager) trackLease(path string, sec *vaultapi.Secret, cli *vaultapi.Client) *lease {
+	m.pruneLeases()
+	d := time.Duration(sec.LeaseDuration) * time.Second
+	l := &lease{
+		id:        sec.LeaseID,
+		path:      path,
+		renewable: sec.Renewable,
+		duration:  d,
+		expires:   time.Now().Add(d),
+		clients:   make(map[int]uint64),
+	}
+	if cli != nil {
+		l.sys = cli.Sys()
+	}
+	m.leases[l.id] = l
+	return l
+}
+
+// pruneLeases forgets leases that have run out and are no longer
+// renewed. The caller holds m.mu.
+func (m *Manager) pruneLeases() {
+	now := time.Now()
+	for id, l := range m.leases {
+		if l.cancel == nil && l.duration > 0 && !now.Before(l.expires) {
+			delete(m.leases, id)
+		}
+	}
+}
+
+// watchLease keeps lease id alive for as long as the tplenv run
+// process behind ctx is running, if lease renewal is enabled. The
+// caller holds m.mu.
+func (m *Manager) watchLease(ctx context.Context, id string) {
+	l := m.leases[id]
+	if l == nil || !l.renewable || l.sys == nil || !m.renewLeases() {
+		return
+	}
+	info := clientinfo.InfoFromContext(ctx)
+	if info.PID == 0 || info.StartTime == 0 || !info.IsTplenvRun() {
+		return
+	}
+	l.clients[info.PID] = info.StartTime
+	if l.cancel != nil {
+		return
+	}
+	rctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	go m.renewLease(rctx, l)
+}
+
+// renewLease renews l at two thirds of its duration while any of its
+// clients is still running. Once they have all exited, or Vault
+// refuses the renewal, the lease is left to expire on its own.
+func (m *Manager) renewLease(ctx context.Context, l *lease) {
+	stop := func() {
+		m.mu.Lock()
+		if l.cancel != nil {
+			l.cancel()
+			l.cancel = nil
+		}
+		m.mu.Unlock()
+	}
+	for {
+		m.mu.Lock()
+		wait := l.duration * 2 / 3
+		clients := make(map[int]uint64, len(l.clients))
+		for pid, st := range l.clients {
+			clients[pid] = st
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		var dead []int
+		for pid, st := range clients {
+			if !m.alive(pid, st) {
+				dead = append(dead, pid)
+			}
+		}
+		m.mu.Lock()
+		for _, pid := range dead {
+			delete(l.clients, pid)
+		}
+		idle := len(l.clients) == 0
+		m.mu.Unlock()
+		if idle {
+			stop()
+			return
+		}
+
+		sec, err := l.sys.RenewWithContext(ctx, l.id, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil || sec == nil || sec.LeaseDuration <= 0 {
+			stop()
+			return
+		}
+		m.mu.Lock()
+		l.duration = time.Duration(sec.LeaseDuration) * time.Second
+		l.expires = time.Now().Add(l.duration)
+		m.mu.Unlock()
+	}
+}
+
+// revokeLeases revokes every tracked lease for which match returns
+// true. The caller holds m.mu.
+func (m *Manager) revokeLeases(ctx context.Context, match func(*lease) bool) {
+	for id, l := range m.leases {
+		if !match(l) {
+			continue
+		}
+		if l.cancel != nil {
+			l.cancel()
+			l.cancel = nil
+		}
+		if l.sys != nil {
+			_ = l.sys.RevokeWithContext(ctx, id)
+		}
+		delete(m.leases, id)
+	}
+}
+
+// processAlive reports whether pid is still the process that started
+// at start, so a recycled PID does not keep a lease alive.
+func processAlive(pid int, start uint64) bool {
+	return clientinfo.Lookup(pid).StartTime == start
+}
+
+type renderKey struct{}
+
+// render remembers the secrets read while resolving one request, so
+// every key taken from a dynamic secret comes from the same lease even
+// if the cache entry expires or is evicted part way through.
+type render struct {
+	mu    sync.Mutex
+	reads map[string]*memprotect.Sealed
+}
+
+// WithRender scopes ctx to a single render. Call done once the render
+// has finished to discard what was remembered.
+func WithRender(ctx context.Context) (_ context.Context, done func()) {
+	r := &render{reads: make(map[string]*memprotect.Sealed)}
+	return context.WithValue(ctx, renderKey{}, r), func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for k, s := range r.reads {
+			s.Destroy()
+			delete(r.reads, k)
+		}
+	}
+}
+
+func renderFrom(ctx context.Context) *render {
+	r, _ := ctx.Value(renderKey{}).(*render)
+	return r
+}
+
+func (r *render) get(path string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.reads[path]
+	if !ok {
+		return "", false
+	}
+	pt, err := s.OpenString()
+	if err != nil {
+		return "", false
+	}
+	return pt, true
+}
+
+func (r *render) put(path, raw string) {
+	if r == nil {
+		return
+	}
+	sealed, err := memprotect.SealString(raw)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.reads[path]; ok {
+		old.Destroy()
+	}
+	r.reads[path] = sealed
+}
@@ -0,0 +1,164 @@
+package vault
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/it-atelier-gn/desktop-secrets/internal/clientinfo"
+)
+
+const credsPath = "database/creds/app"
+
+func newLeaseManager(t *testing.T) (*stubVault, *Manager) {
+	t.Helper()
+	v := newStubVault(t)
+	m := newAuthManager(v, AuthConfig{Method: "token", Token: "static-token"})
+	return v, m
+}
+
+func resolveCreds(t *testing.T, ctx context.Context, m *Manager) string {
+	t.Helper()
+	u, err := m.ResolveSecret(ctx, credsPath, "username", ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.ResolveSecret(ctx, credsPath, "password", ReadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u + "/" + p
+}
+
+func (v *stubVault) counts() (leases, renewed int, revoked []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.leases, v.leaseRenewed, slices.Clone(v.leasesRevoked)
+}
+
+func TestDynamicSecretCacheBoundedByLease(t *testing.T) {
+	v, m := newLeaseManager(t)
+	v.leaseTTL = 60
+	defer m.Close(context.Background())
+
+	if got := resolveCreds(t, t.Context(), m); got != "u1/p1" {
+		t.Fatalf("got %q", got)
+	}
+	if n, _, _ := v.counts(); n != 1 {
+		t.Fatalf("issued %d leases, want 1", n)
+	}
+	keys := m.CachedKeys()
+	if len(keys) != 1 || keys[0].Key != credsPath {
+		t.Fatalf("CachedKeys = %+v", keys)
+	}
+	if keys[0].Expires.After(time.Now().Add(61 * time.Second)) {
+		t.Errorf("cache outlives the lease: %v", keys[0].Expires)
+	}
+	if keys[0].Detail != "lease database/creds/app/l1" {
+		t.Errorf("detail = %q", keys[0].Detail)
+	}
+}
+
+func TestRenderKeepsKeysOnOneLease(t *testing.T) {
+	v, m := newLeaseManager(t)
+	defer m.Close(context.Background())
+	m.SetTTL(0) // nothing survives in the cache
+
+	if got := resolveCreds(t, t.Context(), m); got != "u1/p2" {
+		t.Fatalf("without a render got %q, want credentials from two leases", got)
+	}
+
+	ctx, done := WithRender(t.Context())
+	if got := resolveCreds(t, ctx, m); got != "u3/p3" {
+		t.Fatalf("within a render got %q, want one lease", got)
+	}
+	done()
+	if n, _, _ := v.counts(); n != 3 {
+		t.Fatalf("issued %d leases, want 3", n)
+	}
+}
+
+func TestEvictAndCloseRevokeLeases(t *testing.T) {
+	v, m := newLeaseManager(t)
+
+	resolveCreds(t, t.Context(), m)
+	m.Evict(credsPath)
+	resolveCreds(t, t.Context(), m)
+	m.EvictAll()
+	resolveCreds(t, t.Context(), m)
+	if _, err := m.ResolveSecret(t.Context(), "secret/app", "password", ReadOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close(t.Context())
+
+	_, _, revoked := v.counts()
+	want := []string{"database/creds/app/l1", "database/creds/app/l2", "database/creds/app/l3"}
+	if !slices.Equal(revoked, want) {
+		t.Fatalf("revoked %v, want %v", revoked, want)
+	}
+}
+
+func TestLeaseRenewedWhileTplenvRunAlive(t *testing.T) {
+	v, m := newLeaseManager(t)
+	v.leaseTTL = 1
+	defer m.Close(context.Background())
+	var alive atomic.Bool
+	alive.Store(true)
+	m.alive = func(pid int, start uint64) bool { return pid == 4242 && start == 99 && alive.Load() }
+
+	tplenvRun := clientinfo.WithInfo(t.Context(), clientinfo.Info{
+		PID: 4242, StartTime: 99, Name: "tplenv", Cmdline: "tplenv run -- app",
+	})
+
+	// Renewal is off by default.
+	resolveCreds(t, tplenvRun, m)
+	m.EvictAll()
+
+	m.SetLeaseRenewal(func() bool { return true })
+	// Other clients never get their leases renewed.
+	getsec := clientinfo.WithInfo(t.Context(), clientinfo.Info{PID: 4242, StartTime: 99, Name: "getsec"})
+	resolveCreds(t, getsec, m)
+	time.Sleep(1200 * time.Millisecond)
+	if _, renewed, _ := v.counts(); renewed != 0 {
+		t.Fatalf("renewed %d times without tplenv run", renewed)
+	}
+
+	resolveCreds(t, tplenvRun, m)
+	waitFor(t, "lease renewal", func() bool {
+		_, renewed, _ := v.counts()
+		return renewed >= 2
+	})
+	renewing := func() bool {
+		for _, e := range m.CachedKeys() {
+			if strings.Contains(e.Detail, "renewed while tplenv run") {
+				return true
+			}
+		}
+		return false
+	}
+	if !renewing() {
+		t.Fatalf("renewed lease not listed: %+v", m.CachedKeys())
+	}
+
+	alive.Store(false)
+	waitFor(t, "renewal to stop", func() bool { return !renewing() })
+	_, before, _ := v.counts()
+	time.Sleep(1200 * time.Millisecond)
+	if _, after, _ := v.counts(); after != before {
+		t.Fatalf("renewed after the client exited: %d -> %d", before, after)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
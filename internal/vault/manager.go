@@ -3,8 +3,10 @@ package vault
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,25 +20,44 @@ import (
 type cacheEntry struct {
 	sealed  *memprotect.Sealed
 	expires time.Time
+	lease   string // ID of the lease behind a dynamic secret
 }
 
+// revokeTimeout bounds lease revocation when the tray evicts a secret.
+const revokeTimeout = 10 * time.Second
+
 type logical interface {
-	ReadWithContext(ctx context.Context, path string) (*vaultapi.Secret, error)
+	ReadWithDataWithContext(ctx context.Context, path string, data map[string][]string) (*vaultapi.Secret, error)
 }
 
 type Manager struct {
-	mu        sync.Mutex
+	mu sync.Mutex
+	// loginMu serializes connect, so concurrent lookups share one
+	// login. It is never taken while m.mu is held.
+	loginMu   sync.Mutex
 	cli       *vaultapi.Client
 	logical   logical
+	sess      *session
 	cache     map[string]cacheEntry
+	leases    map[string]*lease
+	mounts    map[string][]mount // by namespace
 	ttl       time.Duration
 	newClient func() (*vaultapi.Client, error)
+	// authConfig, resolveRef, askPassword, openBrowser, renewLeases
+	// and alive are injectable for tests.
+	authConfig  func() []AuthConfig
+	resolveRef  func(ctx context.Context, value string) (string, error)
+	askPassword func(ctx context.Context, title string) (string, error)
+	openBrowser func(url string) error
+	renewLeases func() bool
+	alive       func(pid int, start uint64) bool
 }
 
 func NewManager(ttl time.Duration) *Manager {
 	return &Manager{
-		cache: make(map[string]cacheEntry),
-		ttl:   ttl,
+		cache:  make(map[string]cacheEntry),
+		leases: make(map[string]*lease),
+		ttl:    ttl,
 		newClient: func() (*vaultapi.Client, error) {
 			cfg := vaultapi.DefaultConfig()
 			if err := cfg.Error; err != nil {
@@ -44,6 +65,12 @@ func NewManager(ttl time.Duration) *Manager {
 			}
 			return vaultapi.NewClient(cfg)
 		},
+		authConfig:  func() []AuthConfig { return nil },
+		resolveRef:  func(_ context.Context, v string) (string, error) { return v, nil },
+		askPassword: promptPassword,
+		openBrowser: openBrowser,
+		renewLeases: func() bool { return false },
+		alive:       processAlive,
 	}
 }
 
@@ -53,64 +80,191 @@ func (m *Manager) SetTTL(ttl time.Duration) {
 	m.mu.Unlock()
 }
 
-func (m *Manager) init() error {
-	if m.logical != nil {
+// SetAuthConfig sets where per-address login settings come from. fn
+// is consulted whenever a new client is created.
+func (m *Manager) SetAuthConfig(fn func() []AuthConfig) {
+	m.mu.Lock()
+	m.authConfig = fn
+	m.mu.Unlock()
+}
+
+// SetReferenceResolver sets how credential settings that are
+// references (keepass(...), user(...)) are resolved.
+func (m *Manager) SetReferenceResolver(fn func(ctx context.Context, value string) (string, error)) {
+	m.mu.Lock()
+	m.resolveRef = fn
+	m.mu.Unlock()
+}
+
+// SetLeaseRenewal sets whether leases of dynamic secrets read for
+// tplenv run are renewed until the process exits.
+func (m *Manager) SetLeaseRenewal(fn func() bool) {
+	m.mu.Lock()
+	m.renewLeases = fn
+	m.mu.Unlock()
+}
+
+// connect creates the client and logs in unless a session exists.
+// Logging in may prompt or wait on a browser, so it runs outside m.mu:
+// cached reads and the tray's cache view don't wait for it.
+func (m *Manager) connect(ctx context.Context) error {
+	m.loginMu.Lock()
+	defer m.loginMu.Unlock()
+
+	m.mu.Lock()
+	ready, newClient := m.logical != nil, m.newClient
+	m.mu.Unlock()
+	if ready {
 		return nil
 	}
-	c, err := m.newClient()
+	c, err := newClient()
 	if err != nil {
 		return fmt.Errorf("Vault client not configured: %w", err)
 	}
+	m.mu.Lock()
+	cfg := m.authFor(c.Address())
+	m.mu.Unlock()
+	s := &session{cli: c}
+	if cfg != nil {
+		if s, err = m.login(ctx, c, cfg); err != nil {
+			return fmt.Errorf("vault: %s login to %s: %w", cfg.Method, c.Address(), err)
+		}
+	}
+
+	m.mu.Lock()
+	m.sess = s
 	m.cli = c
 	m.logical = c.Logical()
+	m.mu.Unlock()
 	return nil
 }
 
-// ResolveSecret reads a secret at `path` from Vault. For KV v2 mounts, callers must
-// include the `data/` segment in the path (e.g. `secret/data/myapp`). `field` selects
-// a top-level key from the returned data map; for KV v2 the value is auto-unwrapped
-// from the `data.data` payload.
-func (m *Manager) ResolveSecret(ctx context.Context, path, field string) (string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	path = strings.TrimSpace(path)
+// ResolveSecret reads a secret at `path` from Vault. On KV v2 mounts,
+// found through sys/internal/ui/mounts, the `data/` segment is added
+// when missing, so `secret/myapp` and `secret/data/myapp` read the same
+// secret; opt.Version pins a version. `field` selects a top-level key
+// from the secret data, or version metadata via `metadata.NAME` (see
+// selectValue).
+//
+// Dynamic secrets (database/creds/..., aws/creds/...) are cached no
+// longer than their lease, and within one render (see WithRender)
+// every field comes from the same lease.
+func (m *Manager) ResolveSecret(ctx context.Context, path, field string, opt ReadOptions) (string, error) {
+	path = strings.Trim(strings.TrimSpace(path), "/")
 	if path == "" {
 		return "", fmt.Errorf("empty vault path")
 	}
+	opt.Namespace = strings.Trim(strings.TrimSpace(opt.Namespace), "/")
+	if opt.Version < 0 {
+		return "", fmt.Errorf("vault: invalid version %d", opt.Version)
+	}
+	key := CacheKey(path, opt)
 
-	if raw, ok := m.readCache(path); ok {
-		return selectField(raw, field)
+	rd := renderFrom(ctx)
+	if raw, ok := rd.get(key); ok {
+		return selectValue(raw, field)
+	}
+	m.mu.Lock()
+	if raw, ok := m.readCache(ctx, key); ok {
+		rd.put(key, raw)
+		m.watchLease(ctx, m.cache[key].lease)
+		m.mu.Unlock()
+		return selectValue(raw, field)
 	}
+	m.mu.Unlock()
 
-	if err := m.init(); err != nil {
+	if err := m.connect(ctx); err != nil {
 		return "", err
 	}
 
-	sec, err := m.logical.ReadWithContext(ctx, path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sec, kvV2, cli, err := m.read(ctx, path, opt)
+	if s := m.sess; err != nil && isPermissionDenied(err) && s != nil && s.method != "" {
+		// Our login token may have expired or been revoked; if so, log
+		// in once more. A valid token the policy denies is kept.
+		m.mu.Unlock()
+		var cerr error
+		relogin := m.dropIfInvalid(ctx, s)
+		if relogin {
+			cerr = m.connect(ctx)
+		}
+		m.mu.Lock()
+		if cerr != nil {
+			return "", cerr
+		}
+		if relogin {
+			sec, kvV2, cli, err = m.read(ctx, path, opt)
+		}
+	}
 	if err != nil {
-		return "", fmt.Errorf("vault: read %q: %w", path, err)
+		return "", fmt.Errorf("vault: read %q: %w", key, err)
 	}
 	if sec == nil {
-		return "", fmt.Errorf("vault: path %q not found", path)
+		return "", fmt.Errorf("vault: path %q not found", key)
 	}
 
-	data := sec.Data
-	// KV v2 responses wrap the actual secret under data.data
-	if inner, ok := sec.Data["data"].(map[string]interface{}); ok {
-		data = inner
+	data, md, err := unwrap(sec, key, kvV2, opt.Version)
+	if err != nil {
+		return "", err
 	}
-
-	raw, err := json.Marshal(data)
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("vault: marshal response: %w", err)
+	}
+	raw, err := json.Marshal(envelope{Data: dataJSON, Metadata: md})
 	if err != nil {
 		return "", fmt.Errorf("vault: marshal response: %w", err)
 	}
 
-	m.storeCache(path, string(raw))
-	return selectField(string(raw), field)
+	ttl, leaseID := m.ttl, ""
+	if sec.LeaseID != "" {
+		l := m.trackLease(key, sec, cli)
+		leaseID = l.id
+		if l.duration > 0 && l.duration < ttl {
+			ttl = l.duration
+		}
+	}
+	cacheinfo.NoteExpiry(ctx, m.storeCache(key, string(raw), leaseID, ttl))
+	rd.put(key, string(raw))
+	m.watchLease(ctx, leaseID)
+	return selectValue(string(raw), field)
 }
 
-// Evict removes a single cache entry by key (the Vault secret path).
+// read fetches path as ResolveSecret describes, returning the client
+// it used. The caller holds m.mu.
+func (m *Manager) read(ctx context.Context, path string, opt ReadOptions) (*vaultapi.Secret, bool, *vaultapi.Client, error) {
+	cli, lg := m.cli, m.logical
+	if lg == nil {
+		// The session was dropped while we logged in.
+		return nil, false, nil, errors.New("no Vault session; try again")
+	}
+	if opt.Namespace != "" {
+		if cli == nil {
+			return nil, false, nil, fmt.Errorf("namespace %q needs a Vault client", opt.Namespace)
+		}
+		ns := opt.Namespace
+		if base := strings.Trim(cli.Namespace(), "/"); base != "" {
+			ns = base + "/" + ns
+		}
+		cli = cli.WithNamespace(ns)
+		lg = cli.Logical()
+	}
+
+	mt, known := m.mountFor(ctx, lg, opt.Namespace, path)
+	if known && opt.Version > 0 && !mt.kvV2 {
+		return nil, false, nil, fmt.Errorf("version=%d needs a KV v2 mount; %s is not one", opt.Version, mt.path)
+	}
+	var query map[string][]string
+	if opt.Version > 0 {
+		query = map[string][]string{"version": {strconv.Itoa(opt.Version)}}
+	}
+	sec, err := lg.ReadWithDataWithContext(ctx, apiPath(mt, path), query)
+	return sec, known && mt.kvV2, cli, err
+}
+
+// Evict removes a single cache entry by key (see CacheKey) and
+// revokes the leases read from it.
 func (m *Manager) Evict(key string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -118,6 +272,9 @@ func (m *Manager) Evict(key string) {
 		e.sealed.Destroy()
 		delete(m.cache, key)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	m.revokeLeases(ctx, func(l *lease) bool { return l.path == key })
 }
 
 func (m *Manager) EvictAll() {
@@ -127,23 +284,45 @@ func (m *Manager) EvictAll() {
 		e.sealed.Destroy()
 		delete(m.cache, k)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	defer cancel()
+	m.revokeLeases(ctx, func(*lease) bool { return true })
 }
 
 func (m *Manager) CachedKeys() []cacheinfo.Entry {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.pruneLeases()
 	now := time.Now()
 	out := make([]cacheinfo.Entry, 0, len(m.cache))
+	shown := make(map[string]bool)
 	for k, e := range m.cache {
 		if now.Before(e.expires) {
-			out = append(out, cacheinfo.Entry{Key: k, Expires: e.expires})
+			entry := cacheinfo.Entry{Key: k, Expires: e.expires}
+			if l := m.leases[e.lease]; l != nil {
+				entry.Detail = l.detail()
+				shown[l.id] = true
+			}
+			out = append(out, entry)
 		}
 	}
-	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	// Leases still renewed for a running process after their cache
+	// entry is gone.
+	for id, l := range m.leases {
+		if !shown[id] && l.cancel != nil {
+			out = append(out, cacheinfo.Entry{Key: l.path, Expires: l.expires, Detail: l.detail()})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Detail < out[j].Detail
+	})
 	return out
 }
 
-func (m *Manager) readCache(key string) (string, bool) {
+func (m *Manager) readCache(ctx context.Context, key string) (string, bool) {
 	e, ok := m.cache[key]
 	if !ok {
 		return "", false
@@ -157,18 +336,19 @@ func (m *Manager) readCache(key string) (string, bool) {
 	if err != nil {
 		return "", false
 	}
+	cacheinfo.NoteExpiry(ctx, e.expires)
 	return pt, true
 }
 
-func (m *Manager) storeCache(key, raw string) {
+func (m *Manager) storeCache(key, raw, leaseID string, ttl time.Duration) time.Time {
 	sealed, err := memprotect.SealString(raw)
 	if err != nil {
-		return
+		return time.Time{}
 	}
 	if old, ok := m.cache[key]; ok {
 		old.sealed.Destroy()
 	}
-	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(m.ttl)}
+	entry := cacheEntry{sealed: sealed, expires: time.Now().Add(ttl), lease: leaseID}
 	m.cache[key] = entry
 
 	go func(k string, e cacheEntry, d time.Duration) {
@@ -179,7 +359,8 @@ func (m *Manager) storeCache(key, raw string) {
 		}
 		m.mu.Unlock()
 		e.sealed.Destroy()
-	}(key, entry, m.ttl)
+	}(key, entry, ttl)
+	return entry.expires
 }
 
 // selectField returns the requested field from a JSON-encoded map. If field is